@@ -7,9 +7,9 @@ import (
 	"github.com/mongodb/mongo-tools/common/db"
 	"github.com/mongodb/mongo-tools/common/json"
 	commonopts "github.com/mongodb/mongo-tools/common/options"
+	"github.com/mongodb/mongo-tools/common/util"
 	"gopkg.in/mgo.v2/bson"
 	"io"
-	"os"
 	"strings"
 )
 
@@ -20,8 +20,18 @@ type BSONDump struct {
 	Out             io.Writer
 }
 
+// init opens the input file as a BSON stream, decrypting it with the
+// --encryptionKeyFile if given, and decompressing it on the fly if it
+// carries a known compression extension (e.g. ".gz")
 func (bd *BSONDump) init() (*db.BSONSource, error) {
-	file, err := os.Open(bd.FileName)
+	var key *util.EncryptionKey
+	if bd.BSONDumpOptions.EncryptionKeyFile != "" {
+		var err error
+		if key, err = util.LoadEncryptionKeyFile(bd.BSONDumpOptions.EncryptionKeyFile); err != nil {
+			return nil, err
+		}
+	}
+	file, err := util.OpenDecrypted(bd.FileName, key)
 	if err != nil {
 		return nil, fmt.Errorf("Couldn't open BSON file: %v", err)
 	}
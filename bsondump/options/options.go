@@ -4,6 +4,8 @@ type BSONDumpOptions struct {
 	Type       string `long:"type" default:"json" description:"type of output: json, debug"`
 	ObjCheck   bool   `long:"objcheck" description:"validate bson during processing"`
 	NoObjCheck bool   `long:"noobjcheck" description:"don't validate bson during processing"`
+
+	EncryptionKeyFile string `long:"encryptionKeyFile" description:"decrypt a file dumped with mongodump --encryptionKeyFile, using the key or passphrase held in the given file"`
 }
 
 func (self *BSONDumpOptions) Name() string {
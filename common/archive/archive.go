@@ -0,0 +1,118 @@
+// Package archive implements the single-file archive format written by
+// mongodump and read by mongorestore.
+//
+// An archive starts with a magic number followed by a prelude: a BSON
+// document describing the tool that wrote it and every namespace it
+// contains, along with that namespace's metadata. The rest of the archive
+// is a sequence of chunks, each made of a BSON ChunkHeader naming the
+// namespace it belongs to followed by ChunkHeader.Length bytes of raw BSON
+// data. Chunks from different namespaces are freely interleaved, so that
+// collections dumped in parallel can share one output stream. A chunk
+// header with EOF set marks the end of a namespace's data, and the archive
+// ends with a four byte terminator.
+package archive
+
+import (
+	"encoding/binary"
+	"fmt"
+	"gopkg.in/mgo.v2/bson"
+	"io"
+)
+
+const (
+	// MagicNumber is the first four bytes of every archive
+	MagicNumber uint32 = 0x8199e26d
+
+	// FormatVersion is the version of the archive format written by this package
+	FormatVersion = "0.1"
+
+	// terminator marks the end of the chunk stream in place of a chunk header
+	terminator uint32 = 0xffffffff
+)
+
+// Prelude is the header of an archive. It lists every namespace in the
+// archive before any data is written, so that readers can plan a restore
+// without scanning the whole stream.
+type Prelude struct {
+	FormatVersion string `bson:"formatVersion"`
+	ToolVersion   string `bson:"toolVersion"`
+
+	// ConcurrentCollections is the most namespaces that can have data
+	// in flight at once. Readers must consume at least this many
+	// namespaces in parallel to avoid stalling the stream.
+	ConcurrentCollections int `bson:"concurrentCollections"`
+
+	Namespaces []*NamespaceMetadata `bson:"namespaces"`
+}
+
+// NamespaceMetadata describes a single namespace stored in an archive.
+type NamespaceMetadata struct {
+	Database   string `bson:"db"`
+	Collection string `bson:"collection"`
+
+	// Metadata holds the same JSON document mongodump writes to
+	// .metadata.json files, and is empty if there is none.
+	Metadata string `bson:"metadata,omitempty"`
+
+	// Size is an estimate of the namespace's document count
+	Size int64 `bson:"size"`
+}
+
+// Key returns the namespace in the same "db.collection" form as intents.Intent.Key
+func (nsm *NamespaceMetadata) Key() string {
+	return nsm.Database + "." + nsm.Collection
+}
+
+// ChunkHeader precedes every block of data in the body of an archive.
+type ChunkHeader struct {
+	Database   string `bson:"db"`
+	Collection string `bson:"collection"`
+	Length     int32  `bson:"length"`
+	EOF        bool   `bson:"EOF"`
+}
+
+// Key returns the namespace in the same "db.collection" form as intents.Intent.Key
+func (header *ChunkHeader) Key() string {
+	return header.Database + "." + header.Collection
+}
+
+// writeBSON marshals doc and writes it to out
+func writeBSON(out io.Writer, doc interface{}) error {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(raw)
+	return err
+}
+
+// readBSONOrTerminator reads a single BSON document from in into doc.
+// It returns false if the terminator was read instead of a document.
+func readBSONOrTerminator(in io.Reader, doc interface{}) (bool, error) {
+	sizeBytes := make([]byte, 4)
+	if _, err := io.ReadFull(in, sizeBytes); err != nil {
+		if err == io.EOF {
+			return false, io.ErrUnexpectedEOF
+		}
+		return false, err
+	}
+	size := binary.LittleEndian.Uint32(sizeBytes)
+	if size == terminator {
+		return false, nil
+	}
+	if size < 5 || size > 16*1024*1024 {
+		return false, fmt.Errorf("invalid BSON size: %v bytes", size)
+	}
+	raw := make([]byte, size)
+	copy(raw, sizeBytes)
+	if _, err := io.ReadFull(in, raw[4:]); err != nil {
+		if err == io.EOF {
+			return false, io.ErrUnexpectedEOF
+		}
+		return false, err
+	}
+	if err := bson.Unmarshal(raw, doc); err != nil {
+		return false, err
+	}
+	return true, nil
+}
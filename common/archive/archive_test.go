@@ -0,0 +1,145 @@
+package archive
+
+import (
+	"bytes"
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func rawDocs(docs ...bson.M) []byte {
+	out := []byte{}
+	for _, doc := range docs {
+		raw, err := bson.Marshal(doc)
+		So(err, ShouldBeNil)
+		out = append(out, raw...)
+	}
+	return out
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+
+	testutil.VerifyTestType(t, testutil.UNIT_TEST_TYPE)
+
+	Convey("With an archive holding interleaved namespaces", t, func() {
+		archiveBytes := &bytes.Buffer{}
+		mux := NewMultiplexer(archiveBytes)
+		So(mux.WritePrelude(&Prelude{
+			ToolVersion:           "test",
+			ConcurrentCollections: 2,
+			Namespaces: []*NamespaceMetadata{
+				&NamespaceMetadata{Database: "db", Collection: "a", Metadata: `{"indexes":[]}`},
+				&NamespaceMetadata{Database: "db", Collection: "b"},
+				&NamespaceMetadata{Database: "db", Collection: "skipped"},
+			},
+		}), ShouldBeNil)
+
+		a1, a2 := rawDocs(bson.M{"x": 1}), rawDocs(bson.M{"x": 2}, bson.M{"x": 3})
+		b1 := rawDocs(bson.M{"y": "hello"})
+		inA := mux.Open("db", "a")
+		inB := mux.Open("db", "b")
+		inSkipped := mux.Open("db", "skipped")
+		_, err := inB.Write(b1)
+		So(err, ShouldBeNil)
+		_, err = inA.Write(a1)
+		So(err, ShouldBeNil)
+		_, err = inSkipped.Write(a1)
+		So(err, ShouldBeNil)
+		_, err = inA.Write(a2)
+		So(err, ShouldBeNil)
+		So(inSkipped.Close(), ShouldBeNil)
+		So(inA.Close(), ShouldBeNil)
+		So(inB.Close(), ShouldBeNil)
+		So(mux.Close(), ShouldBeNil)
+
+		Convey("a demultiplexer should read the prelude back", func() {
+			demux := NewDemultiplexer(bytes.NewReader(archiveBytes.Bytes()))
+			prelude, err := demux.ReadPrelude()
+			So(err, ShouldBeNil)
+			So(prelude.FormatVersion, ShouldEqual, FormatVersion)
+			So(prelude.ConcurrentCollections, ShouldEqual, 2)
+			So(len(prelude.Namespaces), ShouldEqual, 3)
+			So(prelude.Namespaces[0].Key(), ShouldEqual, "db.a")
+			So(prelude.Namespaces[0].Metadata, ShouldEqual, `{"indexes":[]}`)
+
+			Convey("and route each namespace's data to its own output", func() {
+				pipeA := NewPipe()
+				bufferB := NewBuffer()
+				demux.Open("db.a", pipeA)
+				demux.Open("db.b", bufferB)
+				demux.NamespaceChan = make(chan string, 3)
+				result := make(chan error)
+				go func() {
+					result <- demux.Run()
+				}()
+
+				dataA, err := ioutil.ReadAll(pipeA)
+				So(err, ShouldBeNil)
+				So(dataA, ShouldResemble, append(a1, a2...))
+				So(<-result, ShouldBeNil)
+
+				readerB, err := bufferB.Open()
+				So(err, ShouldBeNil)
+				dataB, err := ioutil.ReadAll(readerB)
+				So(err, ShouldBeNil)
+				So(dataB, ShouldResemble, b1)
+
+				Convey("announcing namespaces in the order they appear", func() {
+					So(<-demux.NamespaceChan, ShouldEqual, "db.b")
+					So(<-demux.NamespaceChan, ShouldEqual, "db.a")
+					_, open := <-demux.NamespaceChan
+					So(open, ShouldBeFalse)
+				})
+			})
+		})
+
+		Convey("a spool should hold a namespace's data until it is read", func() {
+			demux := NewDemultiplexer(bytes.NewReader(archiveBytes.Bytes()))
+			_, err := demux.ReadPrelude()
+			So(err, ShouldBeNil)
+			spoolA, err := NewSpool()
+			So(err, ShouldBeNil)
+			demux.Open("db.a", spoolA)
+			So(demux.Run(), ShouldBeNil)
+
+			readerA, err := spoolA.Open()
+			So(err, ShouldBeNil)
+			dataA, err := ioutil.ReadAll(readerA)
+			So(err, ShouldBeNil)
+			So(dataA, ShouldResemble, append(a1, a2...))
+			So(readerA.Close(), ShouldBeNil)
+			_, err = os.Stat(spoolA.file.Name())
+			So(os.IsNotExist(err), ShouldBeTrue)
+			So(spoolA.Remove(), ShouldBeNil)
+		})
+
+		Convey("a truncated archive should be reported to every reader", func() {
+			truncated := archiveBytes.Bytes()[:archiveBytes.Len()-20]
+			demux := NewDemultiplexer(bytes.NewReader(truncated))
+			_, err := demux.ReadPrelude()
+			So(err, ShouldBeNil)
+			pipeA := NewPipe()
+			demux.Open("db.a", pipeA)
+			bufferB := NewBuffer()
+			demux.Open("db.b", bufferB)
+			result := make(chan error)
+			go func() {
+				result <- demux.Run()
+			}()
+			_, err = ioutil.ReadAll(pipeA)
+			So(err, ShouldBeNil)
+			_, err = bufferB.Open()
+			So(err, ShouldNotBeNil)
+			So(<-result, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Reading something that is not an archive should fail", t, func() {
+		demux := NewDemultiplexer(bytes.NewReader(rawDocs(bson.M{"not": "an archive"})))
+		_, err := demux.ReadPrelude()
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,284 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/mongodb/mongo-tools/common/log"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// DemuxOut receives the data of a single namespace from a Demultiplexer.
+type DemuxOut interface {
+	io.Writer
+	// End is called once all of the namespace's data has been written,
+	// or with a non-nil error if the archive could not be read.
+	End(err error)
+}
+
+// Demultiplexer reads an archive and routes the data of each namespace
+// to the DemuxOut registered for it. Data for namespaces without a
+// registered output is discarded.
+type Demultiplexer struct {
+	in   io.Reader
+	outs map[string]DemuxOut
+
+	// NamespaceChan, if set, receives the key of every registered namespace
+	// as the first chunk of its data is encountered. It is closed when
+	// Run returns. Sends block, which is what allows a consumer to schedule
+	// namespaces in the order the archive delivers them.
+	NamespaceChan chan string
+
+	seen  map[string]bool
+	ended map[string]bool
+}
+
+// NewDemultiplexer returns a Demultiplexer reading the archive from in.
+func NewDemultiplexer(in io.Reader) *Demultiplexer {
+	return &Demultiplexer{
+		in:    in,
+		outs:  map[string]DemuxOut{},
+		seen:  map[string]bool{},
+		ended: map[string]bool{},
+	}
+}
+
+// ReadPrelude checks the magic number and reads the archive's prelude.
+// It must be called before Run.
+func (demux *Demultiplexer) ReadPrelude() (*Prelude, error) {
+	var magic uint32
+	if err := binary.Read(demux.in, binary.LittleEndian, &magic); err != nil {
+		return nil, fmt.Errorf("error reading archive magic number: %v", err)
+	}
+	if magic != MagicNumber {
+		return nil, fmt.Errorf("stream or file does not appear to be a mongodump archive")
+	}
+	prelude := &Prelude{}
+	ok, err := readBSONOrTerminator(demux.in, prelude)
+	if err != nil {
+		return nil, fmt.Errorf("error reading archive prelude: %v", err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("archive is missing its prelude")
+	}
+	if prelude.FormatVersion != FormatVersion {
+		return nil, fmt.Errorf("unsupported archive format version %v", prelude.FormatVersion)
+	}
+	return prelude, nil
+}
+
+// Open registers out as the destination of the given namespace's data.
+// All outputs must be registered before Run is called.
+func (demux *Demultiplexer) Open(ns string, out DemuxOut) {
+	demux.outs[ns] = out
+}
+
+// Run reads the body of the archive until its terminator, routing each
+// chunk to its output. Every registered output is ended before Run returns,
+// with the returned error if the archive could not be fully read.
+func (demux *Demultiplexer) Run() (err error) {
+	defer func() {
+		for ns, out := range demux.outs {
+			if !demux.ended[ns] {
+				out.End(err)
+			}
+		}
+		if demux.NamespaceChan != nil {
+			close(demux.NamespaceChan)
+		}
+	}()
+
+	for {
+		header := &ChunkHeader{}
+		ok, err := readBSONOrTerminator(demux.in, header)
+		if err != nil {
+			return fmt.Errorf("error reading archive chunk header: %v", err)
+		}
+		if !ok {
+			return nil
+		}
+		ns := header.Key()
+		out := demux.outs[ns]
+		if out != nil && !demux.seen[ns] {
+			demux.seen[ns] = true
+			if demux.NamespaceChan != nil {
+				demux.NamespaceChan <- ns
+			}
+		}
+
+		if header.EOF {
+			if out != nil && !demux.ended[ns] {
+				demux.ended[ns] = true
+				out.End(nil)
+			}
+			continue
+		}
+
+		if header.Length < 0 {
+			return fmt.Errorf("invalid chunk length %v for %v", header.Length, ns)
+		}
+		chunk := &io.LimitedReader{R: demux.in, N: int64(header.Length)}
+		var dest io.Writer = ioutil.Discard
+		if out != nil && !demux.ended[ns] {
+			dest = out
+		}
+		_, err = io.Copy(dest, chunk)
+		if err == io.ErrClosedPipe {
+			// the consumer went away early; drop the rest of its data
+			log.Logf(log.DebugLow, "archive reader for %v closed, discarding remaining data", ns)
+			demux.ended[ns] = true
+			_, err = io.Copy(ioutil.Discard, chunk)
+		}
+		if err != nil {
+			return fmt.Errorf("error reading archive chunk for %v: %v", ns, err)
+		}
+		if chunk.N > 0 {
+			return fmt.Errorf("archive ended in the middle of a chunk for %v", ns)
+		}
+	}
+}
+
+// Pipe is a DemuxOut that hands data straight to a single reader,
+// blocking the Demultiplexer until the reader has consumed it.
+type Pipe struct {
+	reader *io.PipeReader
+	writer *io.PipeWriter
+}
+
+// NewPipe returns an initialized Pipe
+func NewPipe() *Pipe {
+	reader, writer := io.Pipe()
+	return &Pipe{reader, writer}
+}
+
+// Write is called by the Demultiplexer
+func (pipe *Pipe) Write(p []byte) (int, error) {
+	return pipe.writer.Write(p)
+}
+
+// End is called by the Demultiplexer
+func (pipe *Pipe) End(err error) {
+	pipe.writer.CloseWithError(err)
+}
+
+// Read reads the namespace's data. It returns io.EOF once all
+// of the data has been read.
+func (pipe *Pipe) Read(p []byte) (int, error) {
+	return pipe.reader.Read(p)
+}
+
+// Close tells the Demultiplexer that no more data will be read.
+func (pipe *Pipe) Close() error {
+	return pipe.reader.Close()
+}
+
+// Buffer is a DemuxOut that holds a namespace's data in memory, so that
+// it can be read at any time and any number of times. It should only be
+// used for namespaces that are known to be small.
+type Buffer struct {
+	data bytes.Buffer
+	done chan struct{}
+	err  error
+	once sync.Once
+}
+
+// NewBuffer returns an initialized Buffer
+func NewBuffer() *Buffer {
+	return &Buffer{done: make(chan struct{})}
+}
+
+// Write is called by the Demultiplexer
+func (buffer *Buffer) Write(p []byte) (int, error) {
+	return buffer.data.Write(p)
+}
+
+// End is called by the Demultiplexer
+func (buffer *Buffer) End(err error) {
+	buffer.once.Do(func() {
+		buffer.err = err
+		close(buffer.done)
+	})
+}
+
+// Open waits until the Demultiplexer has read all of the namespace's
+// data and returns a reader over it.
+func (buffer *Buffer) Open() (io.ReadCloser, error) {
+	<-buffer.done
+	if buffer.err != nil {
+		return nil, buffer.err
+	}
+	return ioutil.NopCloser(bytes.NewReader(buffer.data.Bytes())), nil
+}
+
+// Spool is a DemuxOut that writes a namespace's data to a temporary file,
+// so that a large namespace, such as the oplog, can be read after the rest
+// of the archive without holding up the Demultiplexer or filling memory.
+type Spool struct {
+	file       *os.File
+	done       chan struct{}
+	err        error
+	once       sync.Once
+	removeOnce sync.Once
+}
+
+// NewSpool returns a Spool writing to a new temporary file
+func NewSpool() (*Spool, error) {
+	file, err := ioutil.TempFile("", "mongorestore_spool")
+	if err != nil {
+		return nil, fmt.Errorf("error creating temporary file: %v", err)
+	}
+	return &Spool{file: file, done: make(chan struct{})}, nil
+}
+
+// Write is called by the Demultiplexer
+func (spool *Spool) Write(p []byte) (int, error) {
+	return spool.file.Write(p)
+}
+
+// End is called by the Demultiplexer
+func (spool *Spool) End(err error) {
+	spool.once.Do(func() {
+		spool.err = err
+		close(spool.done)
+	})
+}
+
+// Open waits until the Demultiplexer has read all of the namespace's
+// data and returns a reader over it. The temporary file is removed
+// when the reader is closed.
+func (spool *Spool) Open() (io.ReadCloser, error) {
+	<-spool.done
+	if spool.err != nil {
+		spool.Remove()
+		return nil, spool.err
+	}
+	if _, err := spool.file.Seek(0, 0); err != nil {
+		spool.Remove()
+		return nil, fmt.Errorf("error reading temporary file: %v", err)
+	}
+	return spool, nil
+}
+
+// Read reads the spooled data once the Spool has been opened
+func (spool *Spool) Read(p []byte) (int, error) {
+	return spool.file.Read(p)
+}
+
+// Close removes the temporary file
+func (spool *Spool) Close() error {
+	return spool.Remove()
+}
+
+// Remove closes and removes the temporary file. It may be called
+// more than once, and whether or not the Spool was ever opened.
+func (spool *Spool) Remove() error {
+	var err error
+	spool.removeOnce.Do(func() {
+		spool.file.Close()
+		err = os.Remove(spool.file.Name())
+	})
+	return err
+}
@@ -0,0 +1,123 @@
+package archive
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Multiplexer writes the data of many namespaces into a single archive.
+// Writers returned by Open may be used concurrently; each Write is emitted
+// as one chunk, so callers should buffer their output to keep chunks large.
+type Multiplexer struct {
+	out  io.Writer
+	lock sync.Mutex
+	err  error
+}
+
+// NewMultiplexer returns a Multiplexer writing the archive to out.
+func NewMultiplexer(out io.Writer) *Multiplexer {
+	return &Multiplexer{out: out}
+}
+
+// WritePrelude writes the magic number and prelude. It must be
+// called exactly once, before any namespace data is written.
+func (mux *Multiplexer) WritePrelude(prelude *Prelude) error {
+	mux.lock.Lock()
+	defer mux.lock.Unlock()
+	if prelude.FormatVersion == "" {
+		prelude.FormatVersion = FormatVersion
+	}
+	if err := binary.Write(mux.out, binary.LittleEndian, MagicNumber); err != nil {
+		return mux.fail(fmt.Errorf("error writing archive magic number: %v", err))
+	}
+	if err := writeBSON(mux.out, prelude); err != nil {
+		return mux.fail(fmt.Errorf("error writing archive prelude: %v", err))
+	}
+	return nil
+}
+
+// Open returns a WriteCloser for the data of the given namespace.
+// Closing it marks the end of the namespace's data in the archive.
+func (mux *Multiplexer) Open(db, collection string) io.WriteCloser {
+	return &MuxIn{mux: mux, db: db, collection: collection}
+}
+
+// Close writes the archive terminator. All writers returned by Open
+// must be closed first.
+func (mux *Multiplexer) Close() error {
+	mux.lock.Lock()
+	defer mux.lock.Unlock()
+	if mux.err != nil {
+		return mux.err
+	}
+	if err := binary.Write(mux.out, binary.LittleEndian, terminator); err != nil {
+		return mux.fail(fmt.Errorf("error writing archive terminator: %v", err))
+	}
+	return nil
+}
+
+// writeChunk writes a single chunk header and its data
+func (mux *Multiplexer) writeChunk(header *ChunkHeader, data []byte) error {
+	mux.lock.Lock()
+	defer mux.lock.Unlock()
+	// a failed write leaves the stream in an unknown state,
+	// so every later write has to fail too
+	if mux.err != nil {
+		return mux.err
+	}
+	if err := writeBSON(mux.out, header); err != nil {
+		return mux.fail(fmt.Errorf("error writing archive chunk header: %v", err))
+	}
+	if _, err := mux.out.Write(data); err != nil {
+		return mux.fail(fmt.Errorf("error writing archive chunk: %v", err))
+	}
+	return nil
+}
+
+func (mux *Multiplexer) fail(err error) error {
+	mux.err = err
+	return err
+}
+
+// MuxIn is the writer for a single namespace of a Multiplexer.
+type MuxIn struct {
+	mux        *Multiplexer
+	db         string
+	collection string
+	closed     bool
+}
+
+// Write emits p as one chunk of the namespace's data.
+func (muxIn *MuxIn) Write(p []byte) (int, error) {
+	if muxIn.closed {
+		return 0, fmt.Errorf("write to closed archive namespace %v.%v", muxIn.db, muxIn.collection)
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	header := &ChunkHeader{
+		Database:   muxIn.db,
+		Collection: muxIn.collection,
+		Length:     int32(len(p)),
+	}
+	if err := muxIn.mux.writeChunk(header, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close marks the end of the namespace's data. Closing more than once is a no-op.
+func (muxIn *MuxIn) Close() error {
+	if muxIn.closed {
+		return nil
+	}
+	muxIn.closed = true
+	header := &ChunkHeader{
+		Database:   muxIn.db,
+		Collection: muxIn.collection,
+		EOF:        true,
+	}
+	return muxIn.mux.writeChunk(header, nil)
+}
@@ -96,6 +96,71 @@ func ConvertJSONValueToBSON(x interface{}) (interface{}, error) {
 	}
 }
 
+// specialKeys are the keys that begin the documents
+// extended JSON uses to represent BSON types
+var specialKeys = map[string]bool{
+	"$date": true, "$oid": true, "$numberLong": true, "$numberInt": true,
+	"$timestamp": true, "$undefined": true, "$maxKey": true, "$minKey": true,
+	"$regex": true, "$binary": true,
+}
+
+// ConvertNestedJSONValueToBSON is like ConvertJSONValueToBSON, for values
+// decoded by json.UnmarshalBsonDNested in which every document is a bson.D.
+// The order of the keys of every document is kept.
+func ConvertNestedJSONValueToBSON(x interface{}) (interface{}, error) {
+	switch v := x.(type) {
+	case bson.D:
+		if len(v) > 0 && len(v) <= 2 && specialKeys[v[0].Name] {
+			bsonValue, err := ParseSpecialKeys(nestedBsonDToMap(v).(map[string]interface{}))
+			if err != nil {
+				return nil, err
+			}
+			if _, isDoc := bsonValue.(map[string]interface{}); !isDoc {
+				return bsonValue, nil
+			}
+		}
+		doc := make(bson.D, 0, len(v))
+		for _, elem := range v {
+			bsonValue, err := ConvertNestedJSONValueToBSON(elem.Value)
+			if err != nil {
+				return nil, err
+			}
+			doc = append(doc, bson.DocElem{elem.Name, bsonValue})
+		}
+		return doc, nil
+	case []interface{}:
+		for i, jsonValue := range v {
+			bsonValue, err := ConvertNestedJSONValueToBSON(jsonValue)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = bsonValue
+		}
+		return v, nil
+	default:
+		return ConvertJSONValueToBSON(v)
+	}
+}
+
+// nestedBsonDToMap turns every bson.D within x into a map
+func nestedBsonDToMap(x interface{}) interface{} {
+	switch v := x.(type) {
+	case bson.D:
+		doc := map[string]interface{}{}
+		for _, elem := range v {
+			doc[elem.Name] = nestedBsonDToMap(elem.Value)
+		}
+		return doc
+	case []interface{}:
+		array := make([]interface{}, len(v))
+		for i, value := range v {
+			array[i] = nestedBsonDToMap(value)
+		}
+		return array
+	}
+	return x
+}
+
 func convertKeys(v bson.M) (bson.M, error) {
 	for key, value := range v {
 		jsonValue, err := ConvertBSONValueToJSON(value)
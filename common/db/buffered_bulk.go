@@ -22,6 +22,12 @@ type BufferedBulkInserter struct {
 
 	byteCount int
 	docCount  int
+
+	// set by ReportDocumentErrors
+	reportErrors  bool
+	writeCommands bool
+	writeConcern  bson.M
+	docs          []bson.Raw
 }
 
 // NewBufferedBulkInserter returns an initialized BufferedBulkInserter
@@ -38,6 +44,19 @@ func NewBufferedBulkInserter(collection *mgo.Collection, docLimit int,
 	return bb
 }
 
+// ReportDocumentErrors makes the inserter write past documents that fail
+// to be inserted, and return them with their errors as a *BulkWriteError.
+// Documents are sent with the "insert" command if the server supports
+// write commands, and one by one otherwise.
+func (bb *BufferedBulkInserter) ReportDocumentErrors(writeCommands bool, writeConcern bson.M) {
+	bb.reportErrors = true
+	bb.writeCommands = writeCommands
+	bb.writeConcern = writeConcern
+	if bb.docLimit > MaxWriteBatchSize || bb.docLimit < 1 {
+		bb.docLimit = MaxWriteBatchSize
+	}
+}
+
 // throw away the old bulk and init a new one
 func (bb *BufferedBulkInserter) resetBulk() {
 	bb.bulk = bb.collection.Bulk()
@@ -46,6 +65,7 @@ func (bb *BufferedBulkInserter) resetBulk() {
 	}
 	bb.byteCount = 0
 	bb.docCount = 0
+	bb.docs = nil
 }
 
 // Insert buffers a document for bulk insertion. If the buffer is full, the bulk
@@ -55,17 +75,30 @@ func (bb *BufferedBulkInserter) Insert(doc interface{}) error {
 	if err != nil {
 		return fmt.Errorf("bson encoding error: %v", err)
 	}
-	// flush if we are full
-	if bb.docCount >= bb.docLimit || bb.byteCount+len(rawBytes) > MaxMessageSize {
-		if err := bb.Flush(); err != nil {
-			return fmt.Errorf("error writing bulk insert: %v", err)
+	// flush if we are full; the insert command must fit in a document
+	maxSize := MaxMessageSize
+	if bb.reportErrors {
+		maxSize = MaxBSONSize
+	}
+	var flushErr error
+	if bb.docCount >= bb.docLimit || bb.byteCount+len(rawBytes) > maxSize {
+		// errors of single documents leave the buffer flushed, so this
+		// document is still buffered before they are returned
+		if flushErr = bb.Flush(); flushErr != nil {
+			if _, ok := flushErr.(*BulkWriteError); !ok {
+				return fmt.Errorf("error writing bulk insert: %v", flushErr)
+			}
 		}
 	}
 	// buffer the document
 	bb.docCount++
 	bb.byteCount += len(rawBytes)
-	bb.bulk.Insert(bson.Raw{Data: rawBytes})
-	return nil
+	if bb.reportErrors {
+		bb.docs = append(bb.docs, bson.Raw{Data: rawBytes})
+	} else {
+		bb.bulk.Insert(bson.Raw{Data: rawBytes})
+	}
+	return flushErr
 }
 
 // Flush sends all buffered documents in one bulk insert
@@ -74,9 +107,40 @@ func (bb *BufferedBulkInserter) Flush() error {
 	if bb.docCount == 0 {
 		return nil
 	}
+	if bb.reportErrors {
+		return bb.flushReportingErrors()
+	}
 	if _, err := bb.bulk.Run(); err != nil {
 		return err
 	}
 	bb.resetBulk()
 	return nil
 }
+
+func (bb *BufferedBulkInserter) flushReportingErrors() error {
+	docs := bb.docs
+	bb.resetBulk()
+	if bb.writeCommands {
+		command := bson.D{
+			{"insert", bb.collection.Name},
+			{"documents", docs},
+			{"ordered", false},
+			{"writeConcern", bb.writeConcern},
+		}
+		_, err := runWriteCommand(bb.collection.Database, command, docs)
+		return err
+	}
+	bulkErr := &BulkWriteError{}
+	for _, doc := range docs {
+		err := legacyDocumentError(bb.collection.Insert(doc), doc)
+		if docErr, ok := err.(*BulkWriteError); ok {
+			bulkErr.Errors = append(bulkErr.Errors, docErr.Errors...)
+		} else if err != nil {
+			return err
+		}
+	}
+	if len(bulkErr.Errors) > 0 {
+		return bulkErr
+	}
+	return nil
+}
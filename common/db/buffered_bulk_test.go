@@ -96,6 +96,32 @@ func TestBufferedBulkInserterInserts(t *testing.T) {
 			})
 		})
 
+		Convey("using a test collection reporting document errors with a doc limit of 2", func() {
+			testCol := session.DB("tools-test").C("bulk4")
+			writeCommands, err := provider.SupportsWriteCommands()
+			So(err, ShouldBeNil)
+			bufBulk = NewBufferedBulkInserter(testCol, 2, false)
+			bufBulk.ReportDocumentErrors(writeCommands, bson.M{"w": 1})
+
+			Convey("a duplicate key flushed by the insert of a valid document should keep the valid one", func() {
+				So(bufBulk.Insert(bson.M{"_id": 1}), ShouldBeNil)
+				So(bufBulk.Insert(bson.M{"_id": 1}), ShouldBeNil)
+				err := bufBulk.Insert(bson.M{"_id": 2})
+				So(err, ShouldNotBeNil)
+				bulkErr, ok := err.(*BulkWriteError)
+				So(ok, ShouldBeTrue)
+				So(len(bulkErr.Errors), ShouldEqual, 1)
+				So(bufBulk.Flush(), ShouldBeNil)
+
+				count, err := testCol.Count()
+				So(err, ShouldBeNil)
+				So(count, ShouldEqual, 2)
+				count, err = testCol.Find(bson.M{"_id": 2}).Count()
+				So(err, ShouldBeNil)
+				So(count, ShouldEqual, 1)
+			})
+		})
+
 		Reset(func() {
 			session.DB("tools-test").DropDatabase()
 		})
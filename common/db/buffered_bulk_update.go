@@ -0,0 +1,168 @@
+package db
+
+import (
+	"fmt"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// MaxWriteBatchSize is the most operations a server
+	// accepts in a single write command
+	MaxWriteBatchSize = 1000
+)
+
+// BulkUpdateResult counts what a BufferedBulkUpdater did to a collection
+type BulkUpdateResult struct {
+	// documents that matched a selector, whether or not they were modified
+	Matched int
+	// documents that matched and were changed by the update
+	Modified int
+	// documents inserted because nothing matched an upsert
+	Upserted int
+}
+
+// Add adds the counts of other to the result
+func (result *BulkUpdateResult) Add(other BulkUpdateResult) {
+	result.Matched += other.Matched
+	result.Modified += other.Modified
+	result.Upserted += other.Upserted
+}
+
+// BufferedBulkUpdater is the BufferedBulkInserter of updates. On servers
+// that support write commands, updates are buffered and sent in batches
+// with the "update" command; on older servers they are sent one by one.
+// Must be flushed at the end to ensure that all updates are written.
+type BufferedBulkUpdater struct {
+	collection    *mgo.Collection
+	writeCommands bool
+	writeConcern  bson.M
+	docLimit      int
+	reportErrors  bool
+
+	updates   []bson.D
+	docs      []bson.Raw
+	byteCount int
+
+	// Result holds the counts of all updates flushed so far
+	Result BulkUpdateResult
+}
+
+// NewBufferedBulkUpdater returns an initialized BufferedBulkUpdater for
+// writing. The write concern is only used with write commands, the
+// session's safety applies otherwise.
+func NewBufferedBulkUpdater(collection *mgo.Collection, docLimit int,
+	writeCommands bool, writeConcern bson.M) *BufferedBulkUpdater {
+
+	if docLimit > MaxWriteBatchSize || docLimit < 1 {
+		docLimit = MaxWriteBatchSize
+	}
+	return &BufferedBulkUpdater{
+		collection:    collection,
+		writeCommands: writeCommands,
+		writeConcern:  writeConcern,
+		docLimit:      docLimit,
+	}
+}
+
+// ReportDocumentErrors makes the updater carry on past updates that fail,
+// and return the documents they were made from with their errors as a
+// *BulkWriteError. Updates then apply in no particular order.
+func (bu *BufferedBulkUpdater) ReportDocumentErrors() {
+	bu.reportErrors = true
+}
+
+// Update buffers an update of the document matching selector, which
+// inserts the update as a new document if upsert is true and nothing
+// matches. doc is the document the update was made from, for reporting
+// errors. If the buffer is full, the batch is sent first, returning
+// any errors that occur.
+func (bu *BufferedBulkUpdater) Update(selector, update interface{}, upsert bool, doc bson.Raw) error {
+	if !bu.writeCommands {
+		return bu.updateLegacy(selector, update, upsert, doc)
+	}
+	op := bson.D{{"q", selector}, {"u", update}, {"upsert", upsert}}
+	rawBytes, err := bson.Marshal(op)
+	if err != nil {
+		return fmt.Errorf("bson encoding error: %v", err)
+	}
+	// flush if we are full, keeping the command under the maximum document size
+	var flushErr error
+	if len(bu.updates) >= bu.docLimit || bu.byteCount+len(rawBytes) > MaxBSONSize {
+		// errors of single documents leave the buffer flushed, so this
+		// update is still buffered before they are returned
+		if flushErr = bu.Flush(); flushErr != nil {
+			if _, ok := flushErr.(*BulkWriteError); !ok {
+				return fmt.Errorf("error writing bulk update: %v", flushErr)
+			}
+		}
+	}
+	bu.updates = append(bu.updates, op)
+	bu.docs = append(bu.docs, doc)
+	bu.byteCount += len(rawBytes)
+	return flushErr
+}
+
+// Flush sends all buffered updates in one "update" command
+// then resets the buffer
+func (bu *BufferedBulkUpdater) Flush() error {
+	if len(bu.updates) == 0 {
+		return nil
+	}
+	command := bson.D{
+		{"update", bu.collection.Name},
+		{"updates", bu.updates},
+		{"ordered", !bu.reportErrors},
+		{"writeConcern", bu.writeConcern},
+	}
+	var docs []bson.Raw
+	if bu.reportErrors {
+		docs = bu.docs
+	}
+	bu.updates, bu.docs, bu.byteCount = nil, nil, 0
+	result, err := runWriteCommand(bu.collection.Database, command, docs)
+	if result != nil {
+		// n counts the upserted documents along with the matched ones
+		bu.Result.Matched += result.N - len(result.Upserted)
+		bu.Result.Modified += result.NModified
+		bu.Result.Upserted += len(result.Upserted)
+	}
+	return err
+}
+
+// updateLegacy sends a single update to a server without write commands,
+// which cannot tell apart matched documents from modified ones
+func (bu *BufferedBulkUpdater) updateLegacy(selector, update interface{}, upsert bool, doc bson.Raw) error {
+	if upsert {
+		info, err := bu.collection.Upsert(selector, update)
+		if err != nil {
+			return bu.legacyError(err, doc)
+		}
+		if info != nil {
+			if info.Updated > 0 {
+				bu.Result.Matched += info.Updated
+				bu.Result.Modified += info.Updated
+			} else {
+				bu.Result.Upserted++
+			}
+		}
+		return nil
+	}
+	err := bu.collection.Update(selector, update)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return bu.legacyError(err, doc)
+	}
+	bu.Result.Matched++
+	bu.Result.Modified++
+	return nil
+}
+
+func (bu *BufferedBulkUpdater) legacyError(err error, doc bson.Raw) error {
+	if bu.reportErrors {
+		return legacyDocumentError(err, doc)
+	}
+	return err
+}
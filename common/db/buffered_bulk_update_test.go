@@ -0,0 +1,96 @@
+package db
+
+import (
+	"github.com/mongodb/mongo-tools/common/options"
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"testing"
+)
+
+func TestBufferedBulkUpdaterUpdates(t *testing.T) {
+
+	testutil.VerifyTestType(t, "db")
+
+	Convey("With a valid session", t, func() {
+		opts := options.ToolOptions{
+			Connection: &options.Connection{},
+			SSL:        &options.SSL{},
+			Auth:       &options.Auth{},
+		}
+		provider, err := InitSessionProvider(opts)
+		So(err, ShouldBeNil)
+		session, err := provider.GetSession()
+		So(err, ShouldBeNil)
+		writeCommands, err := provider.SupportsWriteCommands()
+		So(err, ShouldBeNil)
+
+		Convey("using a test collection holding 5 documents", func() {
+			testCol := session.DB("tools-test").C("bulkupdate")
+			for i := 0; i < 5; i++ {
+				So(testCol.Insert(bson.M{"_id": i, "x": 0}), ShouldBeNil)
+			}
+			updater := NewBufferedBulkUpdater(testCol, 3, writeCommands, bson.M{"w": 1})
+
+			Convey("upserting 10 documents, half of them changed, should count each kind", func() {
+				for i := 0; i < 10; i++ {
+					So(updater.Update(bson.M{"_id": i}, bson.M{"_id": i, "x": i % 2}, true, bson.Raw{}), ShouldBeNil)
+				}
+				So(updater.Flush(), ShouldBeNil)
+
+				count, err := testCol.Count()
+				So(err, ShouldBeNil)
+				So(count, ShouldEqual, 10)
+				So(updater.Result.Upserted, ShouldEqual, 5)
+				So(updater.Result.Matched, ShouldEqual, 5)
+				if writeCommands {
+					So(updater.Result.Modified, ShouldEqual, 2)
+				}
+			})
+
+			Convey("updating without upserts should leave unmatched documents out", func() {
+				for i := 0; i < 10; i++ {
+					So(updater.Update(bson.M{"_id": i}, bson.M{"_id": i, "x": 1}, false, bson.Raw{}), ShouldBeNil)
+				}
+				So(updater.Flush(), ShouldBeNil)
+
+				count, err := testCol.Count()
+				So(err, ShouldBeNil)
+				So(count, ShouldEqual, 5)
+				So(updater.Result.Upserted, ShouldEqual, 0)
+				So(updater.Result.Matched, ShouldEqual, 5)
+			})
+		})
+
+		Convey("using a test collection with a unique index and reporting document errors", func() {
+			testCol := session.DB("tools-test").C("bulkupdate2")
+			So(testCol.EnsureIndex(mgo.Index{Key: []string{"x"}, Unique: true}), ShouldBeNil)
+			updater := NewBufferedBulkUpdater(testCol, 2, writeCommands, bson.M{"w": 1})
+			updater.ReportDocumentErrors()
+
+			Convey("a duplicate key flushed by the update of a valid document should keep the valid one", func() {
+				So(updater.Update(bson.M{"_id": 1}, bson.M{"_id": 1, "x": 1}, true, bson.Raw{}), ShouldBeNil)
+				err := updater.Update(bson.M{"_id": 2}, bson.M{"_id": 2, "x": 1}, true, bson.Raw{})
+				if writeCommands {
+					So(err, ShouldBeNil)
+					err = updater.Update(bson.M{"_id": 3}, bson.M{"_id": 3, "x": 3}, true, bson.Raw{})
+				}
+				So(err, ShouldNotBeNil)
+				_, ok := err.(*BulkWriteError)
+				So(ok, ShouldBeTrue)
+				So(updater.Flush(), ShouldBeNil)
+
+				if writeCommands {
+					count, err := testCol.Find(bson.M{"_id": 3}).Count()
+					So(err, ShouldBeNil)
+					So(count, ShouldEqual, 1)
+				}
+			})
+		})
+
+		Reset(func() {
+			session.DB("tools-test").DropDatabase()
+		})
+	})
+}
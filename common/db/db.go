@@ -27,6 +27,9 @@ type SessionProvider struct {
 
 	// the master session to use for connection pooling
 	masterSession *mgo.Session
+
+	// which members of a replica set to read from, nil for the default
+	readPreference *options.ReadPreference
 }
 
 func (self *SessionProvider) RunCommand(dbToUse string,
@@ -46,14 +49,14 @@ func (self *SessionProvider) RunCommand(dbToUse string,
 func (self *SessionProvider) GetSession() (*mgo.Session, error) {
 	//The master session is initialized
 	if self.masterSession != nil {
-		return self.masterSession.Copy(), nil
+		return self.copySession()
 	}
 
 	self.masterSessionLock.Lock()
 	defer self.masterSessionLock.Unlock()
 
 	if self.masterSession != nil {
-		return self.masterSession.Copy(), nil
+		return self.copySession()
 	}
 
 	// initialize the provider's master session
@@ -62,15 +65,29 @@ func (self *SessionProvider) GetSession() (*mgo.Session, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error connecting to db server: %v", err)
 	}
+	if err = applyReadPreference(self.masterSession, self.readPreference); err != nil {
+		return nil, err
+	}
 	// copy the provider's master session, for connection pooling
-	return self.masterSession.Copy(), nil
+	return self.copySession()
+}
+
+// copySession copies the master session, making sure the copy reads
+// from a member allowed by the read preference
+func (self *SessionProvider) copySession() (*mgo.Session, error) {
+	session := self.masterSession.Copy()
+	if err := checkReadPreference(session, self.readPreference); err != nil {
+		session.Close()
+		return nil, err
+	}
+	return session, nil
 }
 
 //NewSessionProvider constructs a session provider but does not attempt to
 //create the initial session.
 func NewSessionProvider(opts options.ToolOptions) *SessionProvider {
 	// create the provider
-	provider := &SessionProvider{}
+	provider := &SessionProvider{readPreference: opts.ReadPreference}
 
 	// create the connector for dialing the database
 	provider.connector = getConnector(opts)
@@ -89,7 +106,7 @@ func InitSessionProvider(opts options.ToolOptions) (*SessionProvider,
 	error) {
 
 	// create the provider
-	provider := &SessionProvider{}
+	provider := &SessionProvider{readPreference: opts.ReadPreference}
 
 	// create the connector for dialing the database
 	provider.connector = getConnector(opts)
@@ -105,6 +122,9 @@ func InitSessionProvider(opts options.ToolOptions) (*SessionProvider,
 	if err != nil {
 		return nil, fmt.Errorf("error connecting to db server: %v", err)
 	}
+	if err = applyReadPreference(provider.masterSession, provider.readPreference); err != nil {
+		return nil, err
+	}
 
 	return provider, nil
 }
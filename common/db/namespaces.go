@@ -94,13 +94,21 @@ func getCollectionsPre28(database *mgo.Database) ([]bson.D, error) {
 }
 
 func GetCollectionOptions(coll *mgo.Collection) (*bson.D, error) {
+	// servers before 3.0 return the collections in an array,
+	// later ones in the first batch of a cursor
 	var cmdResult struct {
 		Collections []bson.D
+		Cursor      struct {
+			FirstBatch []bson.D `bson:"firstBatch"`
+		}
 	}
-	err := coll.Database.Run(bson.M{"listCollections": 1}, &cmdResult)
+	err := coll.Database.Run(bson.D{
+		{"listCollections", 1},
+		{"filter", bson.M{"name": coll.Name}},
+	}, &cmdResult)
 	switch {
 	case err == nil:
-		for _, collectionInfo := range cmdResult.Collections {
+		for _, collectionInfo := range append(cmdResult.Collections, cmdResult.Cursor.FirstBatch...) {
 			name, err := bsonutil.FindValueByKey("name", &collectionInfo)
 			if err != nil {
 				continue
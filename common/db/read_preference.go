@@ -0,0 +1,139 @@
+package db
+
+import (
+	"fmt"
+	"github.com/mongodb/mongo-tools/common/options"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"time"
+)
+
+// ReadPreferenceTimeout is how long to wait for a replica set member
+// matching the read preference before giving up
+var ReadPreferenceTimeout = time.Second * 30
+
+// Replica set member states, as reported by replSetGetStatus
+const (
+	memberStatePrimary   = 1
+	memberStateSecondary = 2
+)
+
+// replSetStatus holds the part of the replSetGetStatus
+// output used to measure how far behind a secondary is
+type replSetStatus struct {
+	Members []replSetMember `bson:"members"`
+}
+
+type replSetMember struct {
+	Name       string    `bson:"name"`
+	State      int       `bson:"state"`
+	OptimeDate time.Time `bson:"optimeDate"`
+	Self       bool      `bson:"self"`
+}
+
+// applyReadPreference configures a session to read from the members
+// allowed by the read preference. Sessions copied from it inherit this.
+func applyReadPreference(session *mgo.Session, pref *options.ReadPreference) error {
+	if pref == nil || pref.IsPrimary() {
+		return nil
+	}
+	tagSets, err := pref.TagSets()
+	if err != nil {
+		return err
+	}
+	// monotonic sessions read from secondaries when there are any,
+	// and stick to the member they first read from
+	session.SetMode(mgo.Monotonic, true)
+	if len(tagSets) > 0 {
+		session.SelectServers(tagSets...)
+	}
+	return nil
+}
+
+// checkReadPreference binds the session to a member and makes sure it
+// satisfies the parts of the read preference the driver does not enforce:
+// that only secondaries are read from, and how stale they may be.
+func checkReadPreference(session *mgo.Session, pref *options.ReadPreference) error {
+	if pref == nil || pref.IsPrimary() {
+		return nil
+	}
+	if pref.Mode != options.ReadSecondary && len(pref.Tags) == 0 && pref.MaxStalenessSeconds == 0 {
+		return nil
+	}
+
+	// the driver waits forever for a member with the requested tags
+	isMaster := bson.M{}
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Run("isMaster", &isMaster)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("error checking read preference: %v", err)
+		}
+	case <-time.After(ReadPreferenceTimeout):
+		return fmt.Errorf("no replica set member matching the read preference was found after %v",
+			ReadPreferenceTimeout)
+	}
+
+	if isMaster["msg"] == "isdbgrid" {
+		// mongos applies the read preference on the shards itself
+		return nil
+	}
+	isSecondary := isMaster["secondary"] == true
+	if pref.Mode == options.ReadSecondary && !isSecondary {
+		return fmt.Errorf("--readPreference=%v, but no secondary is available; %v is not a secondary",
+			pref.Mode, isMaster["me"])
+	}
+	if pref.MaxStalenessSeconds == 0 || !isSecondary {
+		return nil
+	}
+
+	status := &replSetStatus{}
+	if err := session.DB("admin").Run("replSetGetStatus", status); err != nil {
+		return fmt.Errorf("error getting replica set status to check staleness: %v", err)
+	}
+	lag, err := secondaryLag(status)
+	if err != nil {
+		return err
+	}
+	maxStaleness := time.Duration(pref.MaxStalenessSeconds) * time.Second
+	if lag > maxStaleness {
+		return fmt.Errorf("secondary %v is %v behind, more than --maxStalenessSeconds=%v allows",
+			isMaster["me"], lag, pref.MaxStalenessSeconds)
+	}
+	return nil
+}
+
+// secondaryLag returns how far the member that reported the status is
+// behind the primary, or behind the freshest secondary if there is no primary
+func secondaryLag(status *replSetStatus) (time.Duration, error) {
+	var self, primary, freshest time.Time
+	foundSelf, foundPrimary := false, false
+	for _, member := range status.Members {
+		if member.Self {
+			self = member.OptimeDate
+			foundSelf = true
+		}
+		switch member.State {
+		case memberStatePrimary:
+			primary = member.OptimeDate
+			foundPrimary = true
+		case memberStateSecondary:
+			if member.OptimeDate.After(freshest) {
+				freshest = member.OptimeDate
+			}
+		}
+	}
+	if !foundSelf {
+		return 0, fmt.Errorf("replica set status does not describe the member it came from")
+	}
+	if foundPrimary {
+		freshest = primary
+	}
+	if lag := freshest.Sub(self); lag > 0 {
+		return lag, nil
+	}
+	return 0, nil
+}
@@ -0,0 +1,69 @@
+package db
+
+import (
+	"github.com/mongodb/mongo-tools/common/options"
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+	"testing"
+	"time"
+)
+
+func TestReadPreferenceOptions(t *testing.T) {
+
+	testutil.VerifyTestType(t, testutil.UNIT_TEST_TYPE)
+
+	Convey("Tag sets should be parsed from name:value pairs", t, func() {
+		pref := &options.ReadPreference{
+			Mode: options.ReadSecondary,
+			Tags: []string{"dc:east,use:analytics", ""},
+		}
+		So(pref.Validate(), ShouldBeNil)
+		tagSets, err := pref.TagSets()
+		So(err, ShouldBeNil)
+		So(tagSets, ShouldResemble, []bson.D{{{"dc", "east"}, {"use", "analytics"}}, {}})
+	})
+
+	Convey("Invalid read preferences should be rejected", t, func() {
+		for _, pref := range []*options.ReadPreference{
+			{Mode: "secondaryOnly"},
+			{Mode: options.ReadSecondary, Tags: []string{"dc"}},
+			{Mode: options.ReadPrimary, Tags: []string{"dc:east"}},
+			{MaxStalenessSeconds: 90},
+			{Mode: options.ReadSecondaryPreferred, MaxStalenessSeconds: -1},
+			{Mode: options.ReadNearest},
+		} {
+			So(pref.Validate(), ShouldNotBeNil)
+		}
+	})
+}
+
+func TestSecondaryLag(t *testing.T) {
+
+	testutil.VerifyTestType(t, testutil.UNIT_TEST_TYPE)
+
+	now := time.Now()
+	member := func(state int, behind time.Duration, self bool) replSetMember {
+		return replSetMember{State: state, OptimeDate: now.Add(-behind), Self: self}
+	}
+
+	Convey("A secondary's lag should be measured against the primary", t, func() {
+		lag, err := secondaryLag(&replSetStatus{Members: []replSetMember{
+			member(memberStateSecondary, time.Minute, true),
+			member(memberStatePrimary, 10*time.Second, false),
+			member(memberStateSecondary, 0, false),
+		}})
+		So(err, ShouldBeNil)
+		So(lag, ShouldEqual, 50*time.Second)
+	})
+
+	Convey("Without a primary, lag should be measured against the freshest secondary", t, func() {
+		lag, err := secondaryLag(&replSetStatus{Members: []replSetMember{
+			member(memberStateSecondary, 5*time.Second, false),
+			member(memberStateSecondary, 20*time.Second, true),
+			member(8, 0, false),
+		}})
+		So(err, ShouldBeNil)
+		So(lag, ShouldEqual, 15*time.Second)
+	})
+}
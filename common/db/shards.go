@@ -0,0 +1,35 @@
+package db
+
+import (
+	"fmt"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ConfigShard is a shard of a sharded cluster, as listed in config.shards.
+// Host is a connection string, "<replica set>/<host>,<host>..." for shards
+// that are replica sets.
+type ConfigShard struct {
+	Id   string `bson:"_id"`
+	Host string `bson:"host"`
+}
+
+// IsMongos returns true if the session is connected to a mongos
+func IsMongos(session *mgo.Session) (bool, error) {
+	result := bson.M{}
+	if err := session.Run("isMaster", &result); err != nil {
+		return false, fmt.Errorf("error running isMaster: %v", err)
+	}
+	return result["msg"] == "isdbgrid", nil
+}
+
+// GetShards returns the shards of the cluster that
+// the session is connected to through a mongos
+func GetShards(session *mgo.Session) ([]ConfigShard, error) {
+	shards := []ConfigShard{}
+	err := session.DB("config").C("shards").Find(bson.M{}).Sort("_id").All(&shards)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config.shards: %v", err)
+	}
+	return shards, nil
+}
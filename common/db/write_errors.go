@@ -0,0 +1,92 @@
+package db
+
+import (
+	"fmt"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// DocumentError is the failure to write a single document of a bulk write
+type DocumentError struct {
+	Doc    bson.Raw
+	Code   int
+	ErrMsg string
+}
+
+func (err DocumentError) Error() string {
+	return fmt.Sprintf("%v (code %v)", err.ErrMsg, err.Code)
+}
+
+// BulkWriteError is returned by bulk writers that report document errors
+// when some documents failed to be written. The other documents of the
+// batch were written all the same.
+type BulkWriteError struct {
+	Errors []DocumentError
+}
+
+func (err *BulkWriteError) Error() string {
+	return fmt.Sprintf("%v documents failed to be written, the first with: %v",
+		len(err.Errors), err.Errors[0])
+}
+
+// writeCommandResult is the reply to the insert and update write commands
+type writeCommandResult struct {
+	N         int `bson:"n"`
+	NModified int `bson:"nModified"`
+	Upserted  []struct {
+		Index int `bson:"index"`
+	} `bson:"upserted"`
+	WriteErrors []struct {
+		Index  int    `bson:"index"`
+		Code   int    `bson:"code"`
+		ErrMsg string `bson:"errmsg"`
+	} `bson:"writeErrors"`
+	WriteConcernError *struct {
+		Code   int    `bson:"code"`
+		ErrMsg string `bson:"errmsg"`
+	} `bson:"writeConcernError"`
+}
+
+// runWriteCommand runs an insert or update command. When docs is given,
+// write errors are returned as a *BulkWriteError naming the document at the
+// index of each failed operation; otherwise only the first one is returned.
+func runWriteCommand(database *mgo.Database, command bson.D, docs []bson.Raw) (*writeCommandResult, error) {
+	result := &writeCommandResult{}
+	if err := database.Run(command, result); err != nil {
+		return nil, err
+	}
+	if result.WriteConcernError != nil {
+		return result, fmt.Errorf("write concern error: %v (code %v)",
+			result.WriteConcernError.ErrMsg, result.WriteConcernError.Code)
+	}
+	if len(result.WriteErrors) == 0 {
+		return result, nil
+	}
+	if docs == nil {
+		writeError := result.WriteErrors[0]
+		return result, fmt.Errorf("error writing document %v of batch: %v (code %v)",
+			writeError.Index, writeError.ErrMsg, writeError.Code)
+	}
+	bulkErr := &BulkWriteError{}
+	for _, writeError := range result.WriteErrors {
+		if writeError.Index < 0 || writeError.Index >= len(docs) {
+			return result, fmt.Errorf("server reported an error on document %v of a batch of %v: %v",
+				writeError.Index, len(docs), writeError.ErrMsg)
+		}
+		bulkErr.Errors = append(bulkErr.Errors, DocumentError{
+			Doc:    docs[writeError.Index],
+			Code:   writeError.Code,
+			ErrMsg: writeError.ErrMsg,
+		})
+	}
+	return result, bulkErr
+}
+
+// legacyDocumentError turns the error of a single legacy write into a
+// *BulkWriteError if the document was at fault, returning other errors as is
+func legacyDocumentError(err error, doc bson.Raw) error {
+	if lastError, ok := err.(*mgo.LastError); ok {
+		return &BulkWriteError{[]DocumentError{{doc, lastError.Code, lastError.Err}}}
+	}
+	return err
+}
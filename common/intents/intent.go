@@ -16,6 +16,10 @@ type Intent struct {
 	BSONPath     string
 	MetadataPath string
 
+	// BSONParts lists, in order, the part files of a collection that was
+	// dumped in parallel _id ranges. BSONPath is then the parts folder.
+	BSONParts []string
+
 	// File/collection size, for some prioritizer implementations.
 	// Units don't matter as long as they are consistent for a given use case.
 	Size int64
@@ -137,6 +141,7 @@ func (manager *Manager) Put(intent *Intent) {
 		// merge new intent into old intent
 		if existing.BSONPath == "" {
 			existing.BSONPath = intent.BSONPath
+			existing.BSONParts = intent.BSONParts
 		}
 		if existing.Size == 0 {
 			existing.Size = intent.Size
@@ -155,6 +160,11 @@ func (manager *Manager) Put(intent *Intent) {
 // Pop returns the next available intent from the manager. If the manager is
 // empty, it returns nil. Pop is thread safe.
 func (manager *Manager) Pop() *Intent {
+	// a streaming prioritizer blocks until the stream reaches the next
+	// namespace, which must not keep other workers from calling Finish
+	if streaming, ok := manager.prioritizer.(*streamingPrioritizer); ok {
+		return streaming.Get()
+	}
 	manager.priotitizerLock.Lock()
 	defer manager.priotitizerLock.Unlock()
 
@@ -192,6 +202,26 @@ func (manager *Manager) Roles() *Intent {
 	return manager.rolesIntent
 }
 
+// Intents returns the intents that have been added to the manager, in the
+// order they were discovered. Special-case intents stored outside of the
+// queue are not included. Must be called before Finalize.
+func (manager *Manager) Intents() []*Intent {
+	intentList := make([]*Intent, len(manager.intentsByDiscoveryOrder))
+	copy(intentList, manager.intentsByDiscoveryOrder)
+	return intentList
+}
+
+// FinalizeStreaming is like Finalize, but hands out intents in the order their
+// namespace keys are received from the order channel, for use when all
+// intents are read from a single stream. Pop blocks until the next key
+// is received, and the channel must be closed when the stream ends.
+func (manager *Manager) FinalizeStreaming(order <-chan string) {
+	log.Log(log.DebugHigh, "finalizing intent manager with streaming prioritizer")
+	manager.prioritizer = NewStreamingPrioritizer(manager.intentsByDiscoveryOrder, order)
+	manager.intents = nil
+	manager.intentsByDiscoveryOrder = nil
+}
+
 // Finalize processes the intents for prioritization. Currently only two
 // kinds of prioritizers are supported. No more "Put" operations may be done
 // after finalize is called.
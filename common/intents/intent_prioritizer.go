@@ -3,6 +3,7 @@ package intents
 import (
 	"container/heap"
 	"sort"
+	"sync"
 )
 
 type PriorityType int
@@ -225,3 +226,71 @@ func (dbh *DBHeap) Pop() interface{} {
 	*dbh = old[0 : n-1]
 	return toPop
 }
+
+//===== Streaming =====
+
+// streamingPrioritizer returns intents in the order that their data appears
+// in a single input stream, such as a mongodump archive. Since the stream
+// can only be read front to back, any other order could leave the stream
+// blocked on a collection that no worker is reading. Get blocks until the
+// stream reaches the next known namespace. Once the stream is exhausted,
+// any intents it never mentioned are returned in discovery order.
+// It does its own locking, so that workers can call Finish while
+// another is blocked in Get.
+type streamingPrioritizer struct {
+	order     <-chan string
+	lock      sync.Mutex
+	intents   map[string]*Intent
+	remaining []*Intent
+}
+
+// NewStreamingPrioritizer returns a prioritizer that orders the given intents
+// by the namespace keys received over the order channel.
+func NewStreamingPrioritizer(intentList []*Intent, order <-chan string) *streamingPrioritizer {
+	prioritizer := &streamingPrioritizer{
+		order:   order,
+		intents: map[string]*Intent{},
+	}
+	for _, intent := range intentList {
+		prioritizer.intents[intent.Key()] = intent
+	}
+	prioritizer.remaining = intentList
+	return prioritizer
+}
+
+func (sp *streamingPrioritizer) Get() *Intent {
+	for key := range sp.order {
+		if intent := sp.take(key); intent != nil {
+			return intent
+		}
+	}
+	// the stream is done, so hand out whatever it never reached
+	sp.lock.Lock()
+	defer sp.lock.Unlock()
+	for len(sp.remaining) > 0 {
+		var intent *Intent
+		intent, sp.remaining = sp.remaining[0], sp.remaining[1:]
+		if _, ok := sp.intents[intent.Key()]; ok {
+			delete(sp.intents, intent.Key())
+			return intent
+		}
+	}
+	return nil
+}
+
+// take removes and returns the intent for the given key, if it is still queued
+func (sp *streamingPrioritizer) take(key string) *Intent {
+	sp.lock.Lock()
+	defer sp.lock.Unlock()
+	intent, ok := sp.intents[key]
+	if !ok {
+		return nil
+	}
+	delete(sp.intents, key)
+	return intent
+}
+
+func (sp *streamingPrioritizer) Finish(*Intent) {
+	// no-op
+	return
+}
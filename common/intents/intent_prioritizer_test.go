@@ -5,6 +5,7 @@ import (
 	"github.com/mongodb/mongo-tools/common/testutil"
 	. "github.com/smartystreets/goconvey/convey"
 	"testing"
+	"time"
 )
 
 func TestLegacyPrioritizer(t *testing.T) {
@@ -32,6 +33,64 @@ func TestLegacyPrioritizer(t *testing.T) {
 	})
 }
 
+func TestStreamingPrioritizer(t *testing.T) {
+
+	testutil.VerifyTestType(t, testutil.UNIT_TEST_TYPE)
+
+	Convey("With a streamingPrioritizer fed by an ordering channel", t, func() {
+		testList := []*Intent{
+			&Intent{DB: "db", C: "1"},
+			&Intent{DB: "db", C: "2"},
+			&Intent{DB: "db", C: "3"},
+		}
+		order := make(chan string, 4)
+		streaming := NewStreamingPrioritizer(testList, order)
+		So(streaming, ShouldNotBeNil)
+
+		Convey("intents should come out in the order the stream names them", func() {
+			order <- "db.3"
+			order <- "other.unknown"
+			order <- "db.1"
+			close(order)
+			it0 := streaming.Get()
+			it1 := streaming.Get()
+			So(it0.C, ShouldEqual, "3")
+			So(it1.C, ShouldEqual, "1")
+
+			Convey("followed by any the stream never named", func() {
+				it2 := streaming.Get()
+				So(it2.C, ShouldEqual, "2")
+				So(streaming.Get(), ShouldBeNil)
+			})
+		})
+
+		Convey("a manager waiting on the stream should not hold up Finish", func() {
+			manager := NewIntentManager()
+			for _, intent := range testList {
+				manager.Put(intent)
+			}
+			order := make(chan string)
+			manager.FinalizeStreaming(order)
+			popped := make(chan *Intent)
+			go func() {
+				popped <- manager.Pop()
+			}()
+			finished := make(chan struct{})
+			go func() {
+				manager.Finish(testList[0])
+				close(finished)
+			}()
+			select {
+			case <-finished:
+			case <-time.After(10 * time.Second):
+				So("Finish is blocked by Pop", ShouldBeEmpty)
+			}
+			order <- "db.2"
+			So((<-popped).C, ShouldEqual, "2")
+		})
+	})
+}
+
 //TODO test the hell out of the heap
 
 func TestBasicDBHeapBehavior(t *testing.T) {
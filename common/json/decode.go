@@ -174,6 +174,21 @@ func (d *decodeState) unmarshalMap() (out map[string]interface{}, err error) {
 	return out, d.savedError
 }
 
+// UnmarshalBsonDNested is like UnmarshalBsonD, but also decodes every
+// nested object, including those in arrays, into a bson.D, so that the
+// order of their keys is kept.
+func UnmarshalBsonDNested(data []byte) (bson.D, error) {
+	var d decodeState
+	err := checkValid(data, &d.scan)
+	if err != nil {
+		return nil, err
+	}
+
+	d.init(data)
+	d.nestedBsonD = true
+	return d.unmarshalBsonD()
+}
+
 func (d *decodeState) unmarshalBsonD() (out bson.D, err error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -271,6 +286,8 @@ type decodeState struct {
 	savedError error
 	tempstr    string // scratch space to avoid some allocations
 	useNumber  bool
+	// decode nested objects into bson.D instead of maps
+	nestedBsonD bool
 }
 
 // errPhase is used for errors that should not happen unless
@@ -977,6 +994,9 @@ func (d *decodeState) valueInterface() interface{} {
 	case scanBeginArray:
 		return d.arrayInterface()
 	case scanBeginObject:
+		if d.nestedBsonD {
+			return d.bsonDInterface()
+		}
 		return d.objectInterface()
 	case scanBeginLiteral:
 		return d.literalInterface()
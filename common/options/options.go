@@ -5,7 +5,9 @@ package options
 import (
 	"fmt"
 	"github.com/jessevdk/go-flags"
+	"gopkg.in/mgo.v2/bson"
 	"os"
+	"strings"
 )
 
 const (
@@ -34,6 +36,10 @@ type ToolOptions struct {
 	*Namespace
 	*Kerberos
 
+	// only set for tools that offer read preference options,
+	// see AddReadPreferenceOptions
+	*ReadPreference
+
 	//Force direct connection to the server and disable the
 	//drivers automatic repl set discovery logic.
 	Direct bool
@@ -87,6 +93,21 @@ type SSL struct {
 	SSLFipsMode         bool   `long:"sslFIPSMode" description:"Use FIPS mode of the installed openssl library"`
 }
 
+// Read preference modes
+const (
+	ReadPrimary            = "primary"
+	ReadSecondary          = "secondary"
+	ReadSecondaryPreferred = "secondaryPreferred"
+	ReadNearest            = "nearest"
+)
+
+// Struct holding read preference options
+type ReadPreference struct {
+	Mode                string   `long:"readPreference" description:"Read from the primary (default), or from a secondary or secondaryPreferred replica set member"`
+	Tags                []string `long:"readPreferenceTags" description:"Only read from members having all of the given tags, e.g. 'dc:east,use:analytics'; repeat to allow members matching any of several tag sets"`
+	MaxStalenessSeconds int      `long:"maxStalenessSeconds" description:"Refuse to read from a secondary lagging more than this many seconds behind the primary"`
+}
+
 // Struct holding auth-related options
 type Auth struct {
 	Username  string `short:"u" long:"username" description:"Specify a user name for authentication"`
@@ -162,6 +183,12 @@ func (self *ToolOptions) GetAuthenticationDatabase() string {
 	return ""
 }
 
+// Add the read preference options to the tool. Only tools that
+// never write to the server should offer them.
+func (self *ToolOptions) AddReadPreferenceOptions() {
+	self.ReadPreference = &ReadPreference{}
+}
+
 // Add extra command line options into the tool options
 func (self *ToolOptions) AddOptions(opts ExtraOptions) {
 	self.Extra = append(self.Extra, opts)
@@ -217,6 +244,11 @@ func registerCommonOptions(self *ToolOptions) error {
 	if _, err := self.parser.AddGroup("namespace options", "", self.Namespace); err != nil {
 		return err
 	}
+	if self.ReadPreference != nil {
+		if _, err := self.parser.AddGroup("read preference options", "", self.ReadPreference); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -265,5 +297,58 @@ func (self *ToolOptions) Validate() error {
 		return fmt.Errorf("--dbpath is not allowed when --host is specified")
 	}
 
+	if self.ReadPreference != nil {
+		return self.ReadPreference.Validate()
+	}
 	return nil
 }
+
+// IsPrimary returns true if reads go to the primary, as they do by default
+func (self *ReadPreference) IsPrimary() bool {
+	return self.Mode == "" || self.Mode == ReadPrimary
+}
+
+// Validate checks the read preference mode, tags and maximum staleness
+func (self *ReadPreference) Validate() error {
+	switch self.Mode {
+	case "", ReadPrimary, ReadSecondary, ReadSecondaryPreferred:
+	case ReadNearest:
+		// the driver cannot pick members by latency, and would read
+		// from a secondary whenever there is one, as secondaryPreferred
+		return fmt.Errorf("--readPreference=%v is not supported, use %v or %v instead",
+			ReadNearest, ReadSecondaryPreferred, ReadPrimary)
+	default:
+		return fmt.Errorf("invalid --readPreference '%v', must be one of %v, %v or %v",
+			self.Mode, ReadPrimary, ReadSecondary, ReadSecondaryPreferred)
+	}
+	switch {
+	case self.IsPrimary() && len(self.Tags) > 0:
+		return fmt.Errorf("--readPreferenceTags can not be used when reading from the primary")
+	case self.IsPrimary() && self.MaxStalenessSeconds != 0:
+		return fmt.Errorf("--maxStalenessSeconds can not be used when reading from the primary")
+	case self.MaxStalenessSeconds < 0:
+		return fmt.Errorf("--maxStalenessSeconds must not be negative")
+	}
+	_, err := self.TagSets()
+	return err
+}
+
+// TagSets parses --readPreferenceTags, given as comma separated name:value
+// pairs. An empty tag set matches every member.
+func (self *ReadPreference) TagSets() ([]bson.D, error) {
+	tagSets := []bson.D{}
+	for _, tags := range self.Tags {
+		tagSet := bson.D{}
+		if tags != "" {
+			for _, tag := range strings.Split(tags, ",") {
+				pair := strings.SplitN(tag, ":", 2)
+				if len(pair) != 2 || pair[0] == "" {
+					return nil, fmt.Errorf("invalid tag '%v' in --readPreferenceTags, expected name:value", tag)
+				}
+				tagSet = append(tagSet, bson.DocElem{pair[0], pair[1]})
+			}
+		}
+		tagSets = append(tagSets, tagSet)
+	}
+	return tagSets, nil
+}
@@ -22,7 +22,8 @@ type ProgressBar struct {
 	// Name is an identifier printed along with the bar
 	Name string
 	// Max is the maximum value the counter addressed at CounterPtr
-	// is expected to reach (ie, the total)
+	// is expected to reach (ie, the total), or 0 if it is not known,
+	// in which case only the count is written
 	Max int
 	// BarLength is the number of characters used to print the bar
 	BarLength int
@@ -33,8 +34,13 @@ type ProgressBar struct {
 	Writer io.Writer
 	// WaitTime is the time to wait between writing the bar
 	WaitTime time.Duration
+	// ShowRate adds how fast the counter increased since
+	// the bar was last written, per second
+	ShowRate bool
 
-	stopChan chan struct{}
+	stopChan  chan struct{}
+	lastCount int
+	lastTime  time.Time
 }
 
 // Start starts the ProgressBar goroutine. Once Start is called, a bar will
@@ -74,14 +80,26 @@ func (pb *ProgressBar) Stop() {
 // computes all necessary values renders to the bar's Writer
 func (pb *ProgressBar) renderToWriter() {
 	currentCount := *pb.CounterPtr
-	percent := float64(currentCount) / float64(pb.Max)
-	fmt.Fprintf(pb.Writer, "%v %v\t%d/%d (%2.1f%%)",
-		drawBar(pb.BarLength, percent),
-		pb.Name,
-		currentCount,
-		pb.Max,
-		percent*100,
-	)
+	if pb.Max <= 0 {
+		fmt.Fprintf(pb.Writer, "%v\t%d", pb.Name, currentCount)
+	} else {
+		percent := float64(currentCount) / float64(pb.Max)
+		fmt.Fprintf(pb.Writer, "%v %v\t%d/%d (%2.1f%%)",
+			drawBar(pb.BarLength, percent),
+			pb.Name,
+			currentCount,
+			pb.Max,
+			percent*100,
+		)
+	}
+	if pb.ShowRate {
+		now := time.Now()
+		if !pb.lastTime.IsZero() && now.After(pb.lastTime) {
+			rate := float64(currentCount-pb.lastCount) / now.Sub(pb.lastTime).Seconds()
+			fmt.Fprintf(pb.Writer, "\t%.0f/s", rate)
+		}
+		pb.lastCount, pb.lastTime = currentCount, now
+	}
 }
 
 // the main concurrent loop
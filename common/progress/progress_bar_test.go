@@ -89,6 +89,47 @@ func TestBarConcurrency(t *testing.T) {
 	})
 }
 
+func TestBarRate(t *testing.T) {
+	Convey("With a ProgressBar showing its rate", t, func() {
+		localCounter := 0
+		writeBuffer := &bytes.Buffer{}
+		pbar := &ProgressBar{
+			Name:       "TEST",
+			Max:        1000,
+			CounterPtr: &localCounter,
+			Writer:     writeBuffer,
+			ShowRate:   true,
+		}
+
+		Convey("the rate should only be written once there is a previous count", func() {
+			pbar.renderToWriter()
+			So(writeBuffer.String(), ShouldNotContainSubstring, "/s")
+			localCounter = 500
+			time.Sleep(10 * time.Millisecond)
+			pbar.renderToWriter()
+			So(writeBuffer.String(), ShouldContainSubstring, "/s")
+		})
+	})
+}
+
+func TestBarWithoutTotal(t *testing.T) {
+	Convey("With a ProgressBar whose total is not known", t, func() {
+		localCounter := 1500
+		writeBuffer := &bytes.Buffer{}
+		pbar := &ProgressBar{
+			Name:       "TEST",
+			CounterPtr: &localCounter,
+			Writer:     writeBuffer,
+			BarLength:  10,
+		}
+
+		Convey("only the count should be written", func() {
+			pbar.renderToWriter()
+			So(writeBuffer.String(), ShouldEqual, "TEST\t1500")
+		})
+	})
+}
+
 func TestBarDrawing(t *testing.T) {
 	Convey("Drawing some test bars and checking their character counts", t, func() {
 		Convey("20 wide @ 50%", func() {
@@ -0,0 +1,114 @@
+package repository
+
+// Chunk boundaries are found with a gear hash, a rolling hash over roughly
+// the last 64 bytes, so the same content splits the same way wherever it is
+// in a stream. A boundary is cut where the top bits of the hash are zero,
+// which gives chunks of about a megabyte past the minimum size.
+const (
+	MinChunkSize = 256 * 1024
+	MaxChunkSize = 4 * 1024 * 1024
+
+	boundaryMask = uint64(0xfffff) << 44
+)
+
+// gearTable maps every byte to a pseudo-random value. It must never change,
+// or data would no longer split into the chunks earlier snapshots stored.
+var gearTable [256]uint64
+
+func init() {
+	// splitmix64
+	state := uint64(0x6d6f6e676f64756d)
+	for i := range gearTable {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gearTable[i] = z ^ (z >> 31)
+	}
+}
+
+// ChunkWriter splits everything written to it into content-defined
+// chunks and stores them in a repository.
+type ChunkWriter struct {
+	repo    *Repository
+	buf     []byte
+	hash    uint64
+	onClose func(*ChunkWriter) error
+
+	// Chunks lists the hashes of the chunks written, in order
+	Chunks []string
+	// Size is the number of bytes written
+	Size int64
+	// NewChunks and NewBytes count the chunks that were not already stored
+	NewChunks int
+	NewBytes  int64
+}
+
+// NewChunkWriter returns a writer that stores its chunks in the repository
+func (repo *Repository) NewChunkWriter() *ChunkWriter {
+	return &ChunkWriter{repo: repo}
+}
+
+func (writer *ChunkWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		cut := writer.findBoundary(p)
+		if cut < 0 {
+			writer.buf = append(writer.buf, p...)
+			return written + len(p), nil
+		}
+		writer.buf = append(writer.buf, p[:cut]...)
+		if err := writer.flush(); err != nil {
+			return written, err
+		}
+		written += cut
+		p = p[cut:]
+	}
+	return written, nil
+}
+
+// findBoundary rolls the hash over p, returning how much of p completes
+// the current chunk, or -1 if the chunk continues past the end of p
+func (writer *ChunkWriter) findBoundary(p []byte) int {
+	length := len(writer.buf)
+	for i, b := range p {
+		length++
+		writer.hash = (writer.hash << 1) + gearTable[b]
+		if (length >= MinChunkSize && writer.hash&boundaryMask == 0) || length >= MaxChunkSize {
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// flush stores the current chunk
+func (writer *ChunkWriter) flush() error {
+	hash, isNew, err := writer.repo.PutChunk(writer.buf)
+	if err != nil {
+		return err
+	}
+	writer.Chunks = append(writer.Chunks, hash)
+	writer.Size += int64(len(writer.buf))
+	if isNew {
+		writer.NewChunks++
+		writer.NewBytes += int64(len(writer.buf))
+	}
+	writer.buf = writer.buf[:0]
+	writer.hash = 0
+	return nil
+}
+
+// Close stores the last chunk
+func (writer *ChunkWriter) Close() error {
+	if len(writer.buf) > 0 {
+		if err := writer.flush(); err != nil {
+			return err
+		}
+	}
+	if writer.onClose != nil {
+		onClose := writer.onClose
+		writer.onClose = nil
+		return onClose(writer)
+	}
+	return nil
+}
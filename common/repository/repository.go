@@ -0,0 +1,136 @@
+// Package repository implements a content-addressed store for dumps. The
+// BSON of every collection is split into content-defined chunks, and each
+// chunk is stored once under its SHA-256, so that successive snapshots of
+// mostly unchanged data only take up space for the chunks that changed.
+//
+// A repository is laid out as
+//
+//	<repository>/chunks/<first two hex digits of the hash>/<hash>
+//	<repository>/snapshots/<snapshot id>.json
+package repository
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	chunksDir    = "chunks"
+	snapshotsDir = "snapshots"
+)
+
+// Repository is a folder of deduplicated chunks and the snapshots made of them
+type Repository struct {
+	dir string
+}
+
+// Create opens the repository in dir, creating it if it does not exist
+func Create(dir string) (*Repository, error) {
+	for _, sub := range []string{chunksDir, snapshotsDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, fmt.Errorf("error creating repository in %v: %v", dir, err)
+		}
+	}
+	return &Repository{dir: dir}, nil
+}
+
+// Open opens the existing repository in dir
+func Open(dir string) (*Repository, error) {
+	for _, sub := range []string{chunksDir, snapshotsDir} {
+		info, err := os.Stat(filepath.Join(dir, sub))
+		if err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("%v is not a dump repository", dir)
+		}
+	}
+	return &Repository{dir: dir}, nil
+}
+
+func (repo *Repository) chunkPath(hash string) string {
+	return filepath.Join(repo.dir, chunksDir, hash[:2], hash)
+}
+
+// PutChunk stores a chunk unless the repository already has it, and returns
+// its hash and whether it was new. Chunks are written to a temporary file
+// and renamed into place, so concurrent writers of the same chunk are safe.
+func (repo *Repository) PutChunk(data []byte) (string, bool, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	path := repo.chunkPath(hash)
+	if _, err := os.Stat(path); err == nil {
+		return hash, false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", false, fmt.Errorf("error creating chunk folder: %v", err)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), hash+".tmp")
+	if err != nil {
+		return "", false, fmt.Errorf("error creating chunk %v: %v", hash, err)
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", false, fmt.Errorf("error writing chunk %v: %v", hash, err)
+	}
+	return hash, true, nil
+}
+
+// ReadChunk returns the contents of a chunk, after checking them against its hash
+func (repo *Repository) ReadChunk(hash string) ([]byte, error) {
+	if len(hash) != sha256.Size*2 {
+		return nil, fmt.Errorf("invalid chunk hash '%v'", hash)
+	}
+	data, err := ioutil.ReadFile(repo.chunkPath(hash))
+	if err != nil {
+		return nil, fmt.Errorf("error reading chunk %v: %v", hash, err)
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != hash {
+		return nil, fmt.Errorf("chunk %v is corrupt", hash)
+	}
+	return data, nil
+}
+
+// NewReader returns a reader over the given chunks, one after the other
+func (repo *Repository) NewReader(chunks []string) io.ReadCloser {
+	return &chunkReader{repo: repo, chunks: chunks}
+}
+
+// chunkReader reads a stream that was split into chunks
+type chunkReader struct {
+	repo    *Repository
+	chunks  []string
+	current *bytes.Reader
+}
+
+func (reader *chunkReader) Read(p []byte) (int, error) {
+	for reader.current == nil || reader.current.Len() == 0 {
+		if len(reader.chunks) == 0 {
+			return 0, io.EOF
+		}
+		data, err := reader.repo.ReadChunk(reader.chunks[0])
+		if err != nil {
+			return 0, err
+		}
+		reader.current = bytes.NewReader(data)
+		reader.chunks = reader.chunks[1:]
+	}
+	return reader.current.Read(p)
+}
+
+func (reader *chunkReader) Close() error {
+	reader.current = nil
+	reader.chunks = nil
+	return nil
+}
@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"bytes"
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func TestRepository(t *testing.T) {
+
+	testutil.VerifyTestType(t, testutil.UNIT_TEST_TYPE)
+
+	Convey("With a repository and a snapshot of 8MB of data", t, func() {
+		dir, err := ioutil.TempDir("", "repository_test")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(dir)
+		})
+		repo, err := Create(dir)
+		So(err, ShouldBeNil)
+
+		data := make([]byte, 8*1024*1024)
+		rand.New(rand.NewSource(42)).Read(data)
+		first := repo.NewSnapshot()
+		first.ID = "first"
+		writer := first.NewIntentWriter("db", "c")
+		// write in uneven pieces, as documents would be
+		for written := 0; written < len(data); written += 1000 {
+			end := written + 1000
+			if end > len(data) {
+				end = len(data)
+			}
+			_, err = writer.Write(data[written:end])
+			So(err, ShouldBeNil)
+		}
+		So(writer.Close(), ShouldBeNil)
+		first.SetMetadata("db", "c", `{"indexes":[]}`)
+		So(first.Write(), ShouldBeNil)
+
+		Convey("chunks should respect the size bounds", func() {
+			So(len(writer.Chunks), ShouldBeGreaterThan, 1)
+			So(writer.NewChunks, ShouldEqual, len(writer.Chunks))
+			So(writer.Size, ShouldEqual, len(data))
+		})
+
+		Convey("the snapshot should read back as written", func() {
+			snapshot, err := repo.ReadSnapshot(LatestSnapshot)
+			So(err, ShouldBeNil)
+			intent := snapshot.Intent("db", "c")
+			So(intent, ShouldNotBeNil)
+			So(intent.Metadata, ShouldEqual, `{"indexes":[]}`)
+			read, err := ioutil.ReadAll(snapshot.NewReader(intent))
+			So(err, ShouldBeNil)
+			So(bytes.Equal(read, data), ShouldBeTrue)
+		})
+
+		Convey("a snapshot of slightly changed data should reuse most chunks", func() {
+			changed := append([]byte("a few new bytes"), data...)
+			changed[len(changed)/2] ^= 0xff
+			writer := repo.NewSnapshot().NewIntentWriter("db", "c")
+			_, err := writer.Write(changed)
+			So(err, ShouldBeNil)
+			So(writer.Close(), ShouldBeNil)
+			So(writer.NewChunks, ShouldBeLessThanOrEqualTo, 3)
+			So(writer.NewBytes, ShouldBeLessThan, len(changed)/2)
+		})
+
+		Convey("snapshots taken within the same second should have their own ids", func() {
+			ids := map[string]bool{}
+			for i := 0; i < 100; i++ {
+				ids[repo.NewSnapshot().ID] = true
+			}
+			So(len(ids), ShouldEqual, 100)
+		})
+
+		Convey("snapshots should not be overwritten", func() {
+			again := repo.NewSnapshot()
+			again.ID = "first"
+			So(again.Write(), ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,205 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LatestSnapshot can be given to ReadSnapshot in place of an id
+const LatestSnapshot = "latest"
+
+// snapshotIDFormat makes snapshot ids sort in the order they were taken.
+// They are precise to the nanosecond, so that dumps into the same
+// repository within the same second do not collide.
+const snapshotIDFormat = "20060102T150405.000000000Z"
+
+// lastSnapshotTime is when the last snapshot started by this process
+// was taken, so that no two of them are given the same id
+var (
+	lastSnapshotTime time.Time
+	snapshotTimeLock sync.Mutex
+)
+
+// SnapshotIntent is the dump of a single collection within a snapshot.
+// Metadata holds the contents of the collection's metadata.json file.
+type SnapshotIntent struct {
+	DB         string   `json:"db"`
+	Collection string   `json:"collection"`
+	Size       int64    `json:"size"`
+	Chunks     []string `json:"chunks"`
+	Metadata   string   `json:"metadata,omitempty"`
+}
+
+// Key returns the namespace of the intent
+func (intent *SnapshotIntent) Key() string {
+	return intent.DB + "." + intent.Collection
+}
+
+// Snapshot is the index of a single dump stored in a repository
+type Snapshot struct {
+	ID      string            `json:"id"`
+	Created time.Time         `json:"created"`
+	Intents []*SnapshotIntent `json:"intents"`
+
+	repo        *Repository
+	lock        sync.Mutex
+	byNamespace map[string]*SnapshotIntent
+}
+
+// NewSnapshot starts a new snapshot, identified by the time it was taken
+func (repo *Repository) NewSnapshot() *Snapshot {
+	snapshotTimeLock.Lock()
+	created := time.Now().UTC()
+	if !created.After(lastSnapshotTime) {
+		created = lastSnapshotTime.Add(time.Nanosecond)
+	}
+	lastSnapshotTime = created
+	snapshotTimeLock.Unlock()
+	return &Snapshot{
+		ID:          created.Format(snapshotIDFormat),
+		Created:     created,
+		Intents:     []*SnapshotIntent{},
+		repo:        repo,
+		byNamespace: map[string]*SnapshotIntent{},
+	}
+}
+
+// ReadSnapshot reads the snapshot with the given id, or the
+// most recent snapshot if id is LatestSnapshot
+func (repo *Repository) ReadSnapshot(id string) (*Snapshot, error) {
+	if id == LatestSnapshot {
+		ids, err := repo.SnapshotIDs()
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			return nil, fmt.Errorf("repository %v has no snapshots", repo.dir)
+		}
+		id = ids[len(ids)-1]
+	}
+	jsonBytes, err := ioutil.ReadFile(repo.snapshotPath(id))
+	if err != nil {
+		return nil, fmt.Errorf("error reading snapshot %v: %v", id, err)
+	}
+	snapshot := &Snapshot{}
+	if err = json.Unmarshal(jsonBytes, snapshot); err != nil {
+		return nil, fmt.Errorf("error parsing snapshot %v: %v", id, err)
+	}
+	snapshot.repo = repo
+	snapshot.byNamespace = map[string]*SnapshotIntent{}
+	for _, intent := range snapshot.Intents {
+		snapshot.byNamespace[intent.Key()] = intent
+	}
+	return snapshot, nil
+}
+
+// SnapshotIDs lists the ids of the snapshots in the repository, oldest first
+func (repo *Repository) SnapshotIDs() ([]string, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(repo.dir, snapshotsDir))
+	if err != nil {
+		return nil, fmt.Errorf("error listing snapshots: %v", err)
+	}
+	ids := []string{}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".json") {
+			ids = append(ids, strings.TrimSuffix(entry.Name(), ".json"))
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (repo *Repository) snapshotPath(id string) string {
+	return filepath.Join(repo.dir, snapshotsDir, id+".json")
+}
+
+// Intent returns the snapshot's intent for a namespace, or nil
+func (snapshot *Snapshot) Intent(db, collection string) *SnapshotIntent {
+	snapshot.lock.Lock()
+	defer snapshot.lock.Unlock()
+	return snapshot.byNamespace[db+"."+collection]
+}
+
+// intent returns the snapshot's intent for a namespace, adding it if
+// needed. The caller must hold the lock.
+func (snapshot *Snapshot) intent(db, collection string) *SnapshotIntent {
+	key := db + "." + collection
+	intent, ok := snapshot.byNamespace[key]
+	if !ok {
+		intent = &SnapshotIntent{DB: db, Collection: collection, Chunks: []string{}}
+		snapshot.byNamespace[key] = intent
+		snapshot.Intents = append(snapshot.Intents, intent)
+	}
+	return intent
+}
+
+// NewIntentWriter returns a writer for a collection's BSON, which records
+// the collection's chunks in the snapshot once closed
+func (snapshot *Snapshot) NewIntentWriter(db, collection string) *ChunkWriter {
+	writer := snapshot.repo.NewChunkWriter()
+	writer.onClose = func(writer *ChunkWriter) error {
+		snapshot.lock.Lock()
+		defer snapshot.lock.Unlock()
+		intent := snapshot.intent(db, collection)
+		intent.Chunks = writer.Chunks
+		intent.Size = writer.Size
+		return nil
+	}
+	return writer
+}
+
+// SetMetadata records the metadata.json contents of a collection
+func (snapshot *Snapshot) SetMetadata(db, collection, metadata string) {
+	snapshot.lock.Lock()
+	defer snapshot.lock.Unlock()
+	snapshot.intent(db, collection).Metadata = metadata
+}
+
+// NewReader returns a reader over the BSON of an intent of the snapshot
+func (snapshot *Snapshot) NewReader(intent *SnapshotIntent) io.ReadCloser {
+	return snapshot.repo.NewReader(intent.Chunks)
+}
+
+// Write saves the snapshot's index to the repository. Snapshots are never
+// overwritten, so that the chunks they refer to can be trusted to be there.
+func (snapshot *Snapshot) Write() error {
+	snapshot.lock.Lock()
+	defer snapshot.lock.Unlock()
+	sort.Sort(snapshotIntentsByNamespace(snapshot.Intents))
+	jsonBytes, err := json.MarshalIndent(snapshot, "", "\t")
+	if err != nil {
+		return fmt.Errorf("error marshalling snapshot %v: %v", snapshot.ID, err)
+	}
+	path := snapshot.repo.snapshotPath(snapshot.ID)
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("error creating snapshot %v: %v", snapshot.ID, err)
+	}
+	_, err = out.Write(jsonBytes)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return fmt.Errorf("error writing snapshot %v: %v", snapshot.ID, err)
+	}
+	return nil
+}
+
+type snapshotIntentsByNamespace []*SnapshotIntent
+
+func (intents snapshotIntentsByNamespace) Len() int { return len(intents) }
+func (intents snapshotIntentsByNamespace) Swap(i, j int) {
+	intents[i], intents[j] = intents[j], intents[i]
+}
+func (intents snapshotIntentsByNamespace) Less(i, j int) bool {
+	return intents[i].Key() < intents[j].Key()
+}
@@ -0,0 +1,153 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// CompressionCodec describes a streaming compression format that the tools
+// can write and read back. Compressed files are identified on disk by the
+// codec's Extension, which is appended to the uncompressed file name, and
+// in streams by the Magic bytes every compressed stream starts with.
+type CompressionCodec struct {
+	Name      string
+	Extension string
+	Magic     []byte
+	NewWriter func(io.Writer) (io.WriteCloser, error)
+	NewReader func(io.Reader) (io.ReadCloser, error)
+}
+
+// CompressionCodecs holds every codec the tools know about. New codecs
+// only need to be appended here to be usable by mongodump, mongorestore
+// and bsondump.
+var CompressionCodecs = []*CompressionCodec{
+	&CompressionCodec{
+		Name:      "gzip",
+		Extension: ".gz",
+		Magic:     []byte{0x1f, 0x8b},
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriter(w), nil
+		},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	},
+}
+
+// GetCompressionCodec returns the codec registered under the given name.
+func GetCompressionCodec(name string) (*CompressionCodec, error) {
+	for _, codec := range CompressionCodecs {
+		if codec.Name == name {
+			return codec, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown compression codec '%v'", name)
+}
+
+// CompressionCodecForFile returns the codec matching the extension of the
+// given file name along with the file name stripped of that extension. If
+// the file is not compressed, the returned codec is nil and the file name
+// is returned unchanged.
+func CompressionCodecForFile(filename string) (*CompressionCodec, string) {
+	for _, codec := range CompressionCodecs {
+		if strings.HasSuffix(filename, codec.Extension) {
+			return codec, strings.TrimSuffix(filename, codec.Extension)
+		}
+	}
+	return nil, filename
+}
+
+// DetectCompressionCodec peeks at the start of the given stream and returns
+// the codec it was compressed with, or nil if it does not start with the
+// magic bytes of any known codec. No data is consumed from the stream.
+func DetectCompressionCodec(r *bufio.Reader) (*CompressionCodec, error) {
+	for _, codec := range CompressionCodecs {
+		head, err := r.Peek(len(codec.Magic))
+		if err == io.EOF {
+			// too short to be compressed with this codec
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Equal(head, codec.Magic) {
+			return codec, nil
+		}
+	}
+	return nil, nil
+}
+
+// WrapWriteCloser returns a WriteCloser that compresses everything written
+// to it into w. Closing it flushes the compressor and then closes w.
+func (codec *CompressionCodec) WrapWriteCloser(w io.WriteCloser) (io.WriteCloser, error) {
+	compressor, err := codec.NewWriter(w)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing %v compression: %v", codec.Name, err)
+	}
+	return &wrappedWriteCloser{compressor, w}, nil
+}
+
+// WrapReadCloser returns a ReadCloser that decompresses the contents of r.
+// Closing it closes both the decompressor and r.
+func (codec *CompressionCodec) WrapReadCloser(r io.ReadCloser) (io.ReadCloser, error) {
+	decompressor, err := codec.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing %v decompression: %v", codec.Name, err)
+	}
+	return &wrappedReadCloser{decompressor, r}, nil
+}
+
+// OpenDecompressed opens the file at the given path for reading. If the
+// file's extension matches a known codec, its contents are transparently
+// decompressed.
+func OpenDecompressed(path string) (io.ReadCloser, error) {
+	return OpenDecrypted(path, nil)
+}
+
+// wrappedWriteCloser closes an outer writer before the one it writes to
+type wrappedWriteCloser struct {
+	io.WriteCloser
+	inner io.Closer
+}
+
+func (wwc *wrappedWriteCloser) Close() error {
+	err := wwc.WriteCloser.Close()
+	innerErr := wwc.inner.Close()
+	if err != nil {
+		return err
+	}
+	return innerErr
+}
+
+// wrappedReadCloser closes an outer reader before the one it reads from
+type wrappedReadCloser struct {
+	io.ReadCloser
+	inner io.Closer
+}
+
+func (wrc *wrappedReadCloser) Close() error {
+	err := wrc.ReadCloser.Close()
+	innerErr := wrc.inner.Close()
+	if err != nil {
+		return err
+	}
+	return innerErr
+}
+
+// NopWriteCloser returns a WriteCloser whose Close method does nothing,
+// for handing streams like stdout to code that closes its output.
+func NopWriteCloser(w io.Writer) io.WriteCloser {
+	return nopWriteCloser{w}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,100 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"testing"
+)
+
+type closeRecorder struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (cr *closeRecorder) Close() error {
+	cr.closed = true
+	return nil
+}
+
+func TestCompressionCodecs(t *testing.T) {
+
+	testutil.VerifyTestType(t, "unit")
+
+	Convey("With the gzip codec", t, func() {
+		codec, err := GetCompressionCodec("gzip")
+		So(err, ShouldBeNil)
+		So(codec.Extension, ShouldEqual, ".gz")
+
+		Convey("data written through the codec should read back unchanged", func() {
+			out := &closeRecorder{}
+			writer, err := codec.WrapWriteCloser(out)
+			So(err, ShouldBeNil)
+			_, err = writer.Write([]byte("some bson bytes"))
+			So(err, ShouldBeNil)
+			So(writer.Close(), ShouldBeNil)
+			So(out.closed, ShouldBeTrue)
+			So(out.String(), ShouldNotEqual, "some bson bytes")
+
+			in := &closeRecorder{Buffer: *bytes.NewBuffer(out.Bytes())}
+			reader, err := codec.WrapReadCloser(in)
+			So(err, ShouldBeNil)
+			data, err := ioutil.ReadAll(reader)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "some bson bytes")
+			So(reader.Close(), ShouldBeNil)
+			So(in.closed, ShouldBeTrue)
+		})
+	})
+
+	Convey("Looking up codecs", t, func() {
+		Convey("by an unknown name should fail", func() {
+			_, err := GetCompressionCodec("lzma")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("by the extension of a compressed file should strip it", func() {
+			codec, name := CompressionCodecForFile("coll.metadata.json.gz")
+			So(codec, ShouldNotBeNil)
+			So(codec.Name, ShouldEqual, "gzip")
+			So(name, ShouldEqual, "coll.metadata.json")
+		})
+
+		Convey("by the extension of an uncompressed file should return nil", func() {
+			codec, name := CompressionCodecForFile("coll.bson")
+			So(codec, ShouldBeNil)
+			So(name, ShouldEqual, "coll.bson")
+		})
+	})
+	Convey("Detecting the codec of a stream", t, func() {
+		Convey("should recognize gzipped data without consuming it", func() {
+			out := &closeRecorder{}
+			codec, _ := GetCompressionCodec("gzip")
+			writer, err := codec.WrapWriteCloser(out)
+			So(err, ShouldBeNil)
+			_, err = writer.Write([]byte("archive"))
+			So(err, ShouldBeNil)
+			So(writer.Close(), ShouldBeNil)
+			compressed := out.Bytes()
+
+			in := bufio.NewReader(bytes.NewReader(compressed))
+			detected, err := DetectCompressionCodec(in)
+			So(err, ShouldBeNil)
+			So(detected, ShouldEqual, codec)
+			rest, err := ioutil.ReadAll(in)
+			So(err, ShouldBeNil)
+			So(rest, ShouldResemble, compressed)
+		})
+
+		Convey("should return nil for uncompressed or short streams", func() {
+			detected, err := DetectCompressionCodec(bufio.NewReader(bytes.NewReader([]byte{0x6d, 0xe2, 0x99, 0x81})))
+			So(err, ShouldBeNil)
+			So(detected, ShouldBeNil)
+			detected, err = DetectCompressionCodec(bufio.NewReader(bytes.NewReader([]byte{0x1f})))
+			So(err, ShouldBeNil)
+			So(detected, ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,438 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// Encrypted streams start with a header holding EncryptionMagic, the
+// format version, how the key was derived, the salt of the passphrase,
+// the random nonce prefix of the stream and a check value of the key:
+//
+//	magic[8] version[1] kdf[1] salt[16] noncePrefix[7] keyCheck[8]
+//
+// The plaintext follows in chunks of EncryptionChunkSize bytes, each sealed
+// with AES-256-GCM under the nonce noncePrefix || counter[4] || final[1] and
+// with the header as additional data. Only the last chunk is sealed as
+// final, so chunks cannot be dropped, reordered or appended to undetected.
+const (
+	EncryptionChunkSize = 64 * 1024
+
+	encryptionVersion     = 1
+	encryptionKeySize     = 32
+	encryptionSaltSize    = 16
+	encryptionPrefixSize  = 7
+	encryptionCheckSize   = 8
+	encryptionHeaderSize  = 8 + 2 + encryptionSaltSize + encryptionPrefixSize + encryptionCheckSize
+	encryptionPBKDF2Iters = 100000
+
+	// how the stream's key was derived from the key file
+	kdfRawKey     = 0
+	kdfPassphrase = 1
+)
+
+// EncryptionMagic starts every encrypted stream
+var EncryptionMagic = []byte("MTOOLENC")
+
+// EncryptionKey encrypts and decrypts streams with the key or passphrase
+// of a key file. Keys derived from a passphrase are salted per dump, so
+// reading back streams of different dumps derives a key for each salt.
+type EncryptionKey struct {
+	key        []byte
+	passphrase []byte
+
+	lock    sync.Mutex
+	salt    []byte
+	derived map[string][]byte
+}
+
+// LoadEncryptionKeyFile reads the key file at the given path. A file
+// holding 64 hex digits is used as a 256-bit key; anything else is used
+// as a passphrase, without its trailing newline.
+func LoadEncryptionKeyFile(path string) (*EncryptionKey, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading encryption key file: %v", err)
+	}
+	return NewEncryptionKey(contents)
+}
+
+// NewEncryptionKey returns the key for the given key file contents. Raw
+// keys are only taken in hex, so that no passphrase is ever used as a key
+// without being derived, whatever its length.
+func NewEncryptionKey(contents []byte) (*EncryptionKey, error) {
+	trimmed := bytes.TrimRight(contents, "\r\n")
+	if len(trimmed) == 0 {
+		return nil, fmt.Errorf("encryption key file is empty")
+	}
+	if len(trimmed) == 2*encryptionKeySize {
+		if key, err := hex.DecodeString(string(trimmed)); err == nil {
+			return &EncryptionKey{key: key}, nil
+		}
+	}
+	return &EncryptionKey{passphrase: trimmed, derived: map[string][]byte{}}, nil
+}
+
+// streamKey returns the key to seal a stream with, given the stream's salt
+func (ek *EncryptionKey) streamKey(salt []byte) []byte {
+	if ek.passphrase == nil {
+		return ek.key
+	}
+	ek.lock.Lock()
+	defer ek.lock.Unlock()
+	key, ok := ek.derived[string(salt)]
+	if !ok {
+		key = pbkdf2SHA256(ek.passphrase, salt, encryptionPBKDF2Iters, encryptionKeySize)
+		ek.derived[string(salt)] = key
+	}
+	return key
+}
+
+// writeSalt returns the salt of every stream this key encrypts
+func (ek *EncryptionKey) writeSalt() ([]byte, error) {
+	ek.lock.Lock()
+	defer ek.lock.Unlock()
+	if ek.salt == nil {
+		salt := make([]byte, encryptionSaltSize)
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			return nil, fmt.Errorf("error generating encryption salt: %v", err)
+		}
+		ek.salt = salt
+	}
+	return ek.salt, nil
+}
+
+// keyCheck returns a value that tells whether a stream's key is the right one
+func keyCheck(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("mongo-tools encryption key check"))
+	return mac.Sum(nil)[:encryptionCheckSize]
+}
+
+// pbkdf2SHA256 derives a key from a passphrase as in RFC 2898
+func pbkdf2SHA256(passphrase, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, passphrase)
+	key := []byte{}
+	counter := make([]byte, 4)
+	for block := uint32(1); len(key) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(counter, block)
+		prf.Write(counter)
+		u := prf.Sum(nil)
+		t := append([]byte{}, u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
+
+// encryptionStream is the cipher and nonce state of one encrypted stream
+type encryptionStream struct {
+	aead    cipher.AEAD
+	header  []byte
+	nonce   []byte
+	counter uint32
+}
+
+func newEncryptionStream(key, header []byte) (*encryptionStream, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing encryption: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing encryption: %v", err)
+	}
+	prefixStart := 8 + 2 + encryptionSaltSize
+	nonce := make([]byte, aead.NonceSize())
+	copy(nonce, header[prefixStart:prefixStart+encryptionPrefixSize])
+	return &encryptionStream{aead: aead, header: header, nonce: nonce}, nil
+}
+
+// nextNonce returns the nonce of the next chunk of the stream
+func (stream *encryptionStream) nextNonce(final bool) ([]byte, error) {
+	if stream.counter == ^uint32(0) {
+		return nil, fmt.Errorf("encrypted stream is too long")
+	}
+	binary.BigEndian.PutUint32(stream.nonce[encryptionPrefixSize:], stream.counter)
+	stream.nonce[len(stream.nonce)-1] = 0
+	if final {
+		stream.nonce[len(stream.nonce)-1] = 1
+	}
+	stream.counter++
+	return stream.nonce, nil
+}
+
+// WrapWriteCloser returns a WriteCloser that encrypts everything written
+// to it into w. Closing it seals the final chunk and then closes w.
+func (ek *EncryptionKey) WrapWriteCloser(w io.WriteCloser) (io.WriteCloser, error) {
+	kdf := byte(kdfRawKey)
+	salt := make([]byte, encryptionSaltSize)
+	if ek.passphrase != nil {
+		var err error
+		if salt, err = ek.writeSalt(); err != nil {
+			return nil, err
+		}
+		kdf = kdfPassphrase
+	}
+	key := ek.streamKey(salt)
+	prefix := make([]byte, encryptionPrefixSize)
+	if _, err := io.ReadFull(rand.Reader, prefix); err != nil {
+		return nil, fmt.Errorf("error generating encryption nonce: %v", err)
+	}
+
+	header := make([]byte, 0, encryptionHeaderSize)
+	header = append(header, EncryptionMagic...)
+	header = append(header, encryptionVersion, kdf)
+	header = append(header, salt...)
+	header = append(header, prefix...)
+	header = append(header, keyCheck(key)...)
+	stream, err := newEncryptionStream(key, header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(header); err != nil {
+		return nil, fmt.Errorf("error writing encryption header: %v", err)
+	}
+	return &encryptingWriter{
+		out:    w,
+		stream: stream,
+		buf:    make([]byte, 0, EncryptionChunkSize),
+	}, nil
+}
+
+// encryptingWriter buffers a chunk of plaintext at a time, sealing a full
+// chunk only once more data follows it, so that the last chunk written is
+// always the one sealed as final
+type encryptingWriter struct {
+	out    io.WriteCloser
+	stream *encryptionStream
+	buf    []byte
+	sealed []byte
+	closed bool
+}
+
+func (ew *encryptingWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if len(ew.buf) == EncryptionChunkSize {
+			if err := ew.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(ew.buf[len(ew.buf):cap(ew.buf)], p)
+		ew.buf = ew.buf[:len(ew.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (ew *encryptingWriter) seal(final bool) error {
+	nonce, err := ew.stream.nextNonce(final)
+	if err != nil {
+		return err
+	}
+	ew.sealed = ew.stream.aead.Seal(ew.sealed[:0], nonce, ew.buf, ew.stream.header)
+	ew.buf = ew.buf[:0]
+	_, err = ew.out.Write(ew.sealed)
+	return err
+}
+
+func (ew *encryptingWriter) Close() error {
+	if ew.closed {
+		return nil
+	}
+	ew.closed = true
+	err := ew.seal(true)
+	closeErr := ew.out.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// IsEncrypted peeks at the start of the given stream and returns whether it
+// starts with the header of an encrypted stream. No data is consumed.
+func IsEncrypted(r *bufio.Reader) (bool, error) {
+	head, err := r.Peek(len(EncryptionMagic))
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(head, EncryptionMagic), nil
+}
+
+// WrapReadCloser returns a ReadCloser that decrypts the contents of r,
+// failing if they were modified, truncated or encrypted with another key.
+// Closing it closes r.
+func (ek *EncryptionKey) WrapReadCloser(r io.ReadCloser) (io.ReadCloser, error) {
+	in := bufio.NewReader(r)
+	header := make([]byte, encryptionHeaderSize)
+	if _, err := io.ReadFull(in, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("data is not encrypted")
+		}
+		return nil, fmt.Errorf("error reading encryption header: %v", err)
+	}
+	if !bytes.Equal(header[:len(EncryptionMagic)], EncryptionMagic) {
+		return nil, fmt.Errorf("data is not encrypted")
+	}
+	if version := header[len(EncryptionMagic)]; version != encryptionVersion {
+		return nil, fmt.Errorf("unsupported encryption format version %v", version)
+	}
+	saltStart := len(EncryptionMagic) + 2
+	salt := header[saltStart : saltStart+encryptionSaltSize]
+	switch kdf := header[len(EncryptionMagic)+1]; {
+	case kdf == kdfRawKey && ek.passphrase != nil:
+		return nil, fmt.Errorf("data was encrypted with a key, but the key file holds a passphrase")
+	case kdf == kdfPassphrase && ek.passphrase == nil:
+		return nil, fmt.Errorf("data was encrypted with a passphrase, but the key file holds a key")
+	case kdf != kdfRawKey && kdf != kdfPassphrase:
+		return nil, fmt.Errorf("unknown encryption key derivation %v", kdf)
+	}
+	key := ek.streamKey(salt)
+	if !hmac.Equal(header[encryptionHeaderSize-encryptionCheckSize:], keyCheck(key)) {
+		return nil, fmt.Errorf("data was encrypted with a different key")
+	}
+	stream, err := newEncryptionStream(key, header)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptingReader{
+		in:     in,
+		closer: r,
+		stream: stream,
+		record: make([]byte, EncryptionChunkSize+stream.aead.Overhead()),
+	}, nil
+}
+
+// decryptingReader opens one sealed chunk at a time. A chunk is
+// the final one when the stream ends right after it.
+type decryptingReader struct {
+	in     *bufio.Reader
+	closer io.Closer
+	stream *encryptionStream
+	record []byte
+	plain  []byte
+	buf    []byte
+	done   bool
+}
+
+func (dr *decryptingReader) Read(p []byte) (int, error) {
+	for len(dr.buf) == 0 {
+		if dr.done {
+			return 0, io.EOF
+		}
+		if err := dr.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, dr.buf)
+	dr.buf = dr.buf[n:]
+	return n, nil
+}
+
+func (dr *decryptingReader) open() error {
+	n, err := io.ReadFull(dr.in, dr.record)
+	final := false
+	switch err {
+	case nil:
+		if _, peekErr := dr.in.Peek(1); peekErr == io.EOF {
+			final = true
+		} else if peekErr != nil {
+			return fmt.Errorf("error reading encrypted data: %v", peekErr)
+		}
+	case io.ErrUnexpectedEOF:
+		final = true
+	case io.EOF:
+		return fmt.Errorf("encrypted data is truncated")
+	default:
+		return fmt.Errorf("error reading encrypted data: %v", err)
+	}
+	nonce, err := dr.stream.nextNonce(final)
+	if err != nil {
+		return err
+	}
+	dr.plain, err = dr.stream.aead.Open(dr.plain[:0], nonce, dr.record[:n], dr.stream.header)
+	if err != nil {
+		return fmt.Errorf("encrypted data failed authentication, it was modified or truncated")
+	}
+	dr.buf = dr.plain
+	dr.done = final
+	return nil
+}
+
+func (dr *decryptingReader) Close() error {
+	return dr.closer.Close()
+}
+
+// OpenDecrypted opens the file at the given path for reading like
+// OpenDecompressed, first decrypting its contents with key. Encrypted files
+// cannot be opened without a key, and given a key, files that are not
+// encrypted are refused, as they could have been swapped in for encrypted ones.
+func OpenDecrypted(path string, key *EncryptionKey) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return WrapDecrypted(file, path, key)
+}
+
+// WrapDecrypted is OpenDecrypted for a file that is already open, such as
+// a member of a tar or zip file, whose name tells how it is compressed.
+// Closing the returned reader closes file.
+func WrapDecrypted(file io.ReadCloser, path string, key *EncryptionKey) (io.ReadCloser, error) {
+	var err error
+	var in io.ReadCloser = file
+	if key != nil {
+		if in, err = key.WrapReadCloser(file); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("error decrypting %v: %v", path, err)
+		}
+	} else {
+		buffered := bufio.NewReader(file)
+		encrypted, err := IsEncrypted(buffered)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if encrypted {
+			file.Close()
+			return nil, fmt.Errorf("%v is encrypted, an --encryptionKeyFile is needed to read it", path)
+		}
+		in = &wrappedReadCloser{ioutil.NopCloser(buffered), file}
+	}
+	codec, _ := CompressionCodecForFile(path)
+	if codec == nil {
+		return in, nil
+	}
+	reader, err := codec.WrapReadCloser(in)
+	if err != nil {
+		in.Close()
+		return nil, err
+	}
+	return reader, nil
+}
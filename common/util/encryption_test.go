@@ -0,0 +1,148 @@
+package util
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryption(t *testing.T) {
+
+	testutil.VerifyTestType(t, "unit")
+
+	// more than two chunks, so that the stream has a middle
+	plaintext := bytes.Repeat([]byte("some bson bytes "), EncryptionChunkSize/8)
+
+	encrypt := func(key *EncryptionKey, data []byte) []byte {
+		out := &closeRecorder{}
+		writer, err := key.WrapWriteCloser(out)
+		So(err, ShouldBeNil)
+		_, err = writer.Write(data)
+		So(err, ShouldBeNil)
+		So(writer.Close(), ShouldBeNil)
+		So(out.closed, ShouldBeTrue)
+		return out.Bytes()
+	}
+	decrypt := func(key *EncryptionKey, data []byte) ([]byte, error) {
+		reader, err := key.WrapReadCloser(&closeRecorder{Buffer: *bytes.NewBuffer(data)})
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return ioutil.ReadAll(reader)
+	}
+
+	Convey("With a 256-bit key given in hex", t, func() {
+		key, err := NewEncryptionKey([]byte(hex.EncodeToString(bytes.Repeat([]byte{7}, 32)) + "\n"))
+		So(err, ShouldBeNil)
+		So(key.passphrase, ShouldBeNil)
+		sealed := encrypt(key, plaintext)
+
+		Convey("data should read back unchanged", func() {
+			So(bytes.Contains(sealed, []byte("some bson bytes")), ShouldBeFalse)
+			data, err := decrypt(key, sealed)
+			So(err, ShouldBeNil)
+			So(bytes.Equal(data, plaintext), ShouldBeTrue)
+		})
+
+		Convey("empty and chunk sized streams should read back unchanged", func() {
+			for _, size := range []int{0, EncryptionChunkSize, 2 * EncryptionChunkSize} {
+				data, err := decrypt(key, encrypt(key, plaintext[:size]))
+				So(err, ShouldBeNil)
+				So(bytes.Equal(data, plaintext[:size]), ShouldBeTrue)
+			}
+		})
+
+		Convey("modified, truncated or extended data should fail to read", func() {
+			flipped := append([]byte{}, sealed...)
+			flipped[len(flipped)/2] ^= 1
+			_, err := decrypt(key, flipped)
+			So(err, ShouldNotBeNil)
+
+			chunkRecord := EncryptionChunkSize + 16
+			_, err = decrypt(key, sealed[:encryptionHeaderSize+chunkRecord])
+			So(err, ShouldNotBeNil)
+			_, err = decrypt(key, sealed[:len(sealed)-1])
+			So(err, ShouldNotBeNil)
+			_, err = decrypt(key, append(append([]byte{}, sealed...), 0))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("another key should be rejected up front", func() {
+			other, err := NewEncryptionKey([]byte(hex.EncodeToString(bytes.Repeat([]byte{8}, 32))))
+			So(err, ShouldBeNil)
+			_, err = other.WrapReadCloser(&closeRecorder{Buffer: *bytes.NewBuffer(sealed)})
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("With a passphrase", t, func() {
+		key, err := NewEncryptionKey([]byte("correct horse battery staple\n"))
+		So(err, ShouldBeNil)
+		So(string(key.passphrase), ShouldEqual, "correct horse battery staple")
+
+		Convey("data should read back with a key loaded from the same passphrase", func() {
+			again, err := NewEncryptionKey([]byte("correct horse battery staple"))
+			So(err, ShouldBeNil)
+			data, err := decrypt(again, encrypt(key, plaintext))
+			So(err, ShouldBeNil)
+			So(bytes.Equal(data, plaintext), ShouldBeTrue)
+		})
+
+		Convey("a passphrase of 32 characters should still be derived, with or without a newline", func() {
+			passphrase := "abcdefghijklmnopqrstuvwxyz012345"
+			bare, err := NewEncryptionKey([]byte(passphrase))
+			So(err, ShouldBeNil)
+			So(string(bare.passphrase), ShouldEqual, passphrase)
+			withNewline, err := NewEncryptionKey([]byte(passphrase + "\n"))
+			So(err, ShouldBeNil)
+			data, err := decrypt(withNewline, encrypt(bare, plaintext))
+			So(err, ShouldBeNil)
+			So(bytes.Equal(data, plaintext), ShouldBeTrue)
+		})
+
+		Convey("keys should be derived with PBKDF2-HMAC-SHA256", func() {
+			derived := pbkdf2SHA256([]byte("password"), []byte("salt"), 1, 32)
+			So(hex.EncodeToString(derived), ShouldEqual,
+				"120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b")
+		})
+	})
+
+	Convey("Opening encrypted files", t, func() {
+		dir, err := ioutil.TempDir("", "encryption_test")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(dir)
+		})
+		key, err := NewEncryptionKey([]byte(hex.EncodeToString(bytes.Repeat([]byte{7}, 32))))
+		So(err, ShouldBeNil)
+		encryptedPath := filepath.Join(dir, "coll.bson")
+		So(ioutil.WriteFile(encryptedPath, encrypt(key, plaintext), 0644), ShouldBeNil)
+		plainPath := filepath.Join(dir, "plain.bson")
+		So(ioutil.WriteFile(plainPath, plaintext, 0644), ShouldBeNil)
+
+		Convey("should require the key", func() {
+			_, err := OpenDecompressed(encryptedPath)
+			So(err, ShouldNotBeNil)
+			in, err := OpenDecrypted(encryptedPath, key)
+			So(err, ShouldBeNil)
+			data, err := ioutil.ReadAll(in)
+			So(err, ShouldBeNil)
+			So(in.Close(), ShouldBeNil)
+			So(bytes.Equal(data, plaintext), ShouldBeTrue)
+		})
+
+		Convey("should refuse files that are not encrypted when given a key", func() {
+			_, err := OpenDecrypted(plainPath, key)
+			So(err, ShouldNotBeNil)
+			in, err := OpenDecompressed(plainPath)
+			So(err, ShouldBeNil)
+			So(in.Close(), ShouldBeNil)
+		})
+	})
+}
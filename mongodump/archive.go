@@ -0,0 +1,98 @@
+package mongodump
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/log"
+	commonopts "github.com/mongodb/mongo-tools/common/options"
+	"github.com/mongodb/mongo-tools/common/util"
+	"os"
+)
+
+// openArchive opens the --archive destination and writes the archive's
+// prelude, which lists every intent along with its metadata. Since the
+// prelude must precede all data, this has to happen after every intent is
+// created but before any of them are dumped.
+func (dump *MongoDump) openArchive() error {
+	var err error
+	if dump.OutputOptions.Archive == "-" {
+		log.Log(log.Always, "writing archive to stdout")
+		dump.archiveOut = util.NopWriteCloser(os.Stdout)
+	} else {
+		log.Logf(log.Always, "writing archive to %v", dump.OutputOptions.Archive)
+		dump.archiveOut, err = os.Create(dump.OutputOptions.Archive)
+		if err != nil {
+			return fmt.Errorf("error creating archive file `%v`: %v", dump.OutputOptions.Archive, err)
+		}
+	}
+	// with --gzip and --encryptionKeyFile, the archive
+	// is compressed and encrypted as a whole
+	if dump.encryption != nil {
+		dump.archiveOut, err = dump.encryption.WrapWriteCloser(dump.archiveOut)
+		if err != nil {
+			return err
+		}
+	}
+	if dump.compression != nil {
+		dump.archiveOut, err = dump.compression.WrapWriteCloser(dump.archiveOut)
+		if err != nil {
+			return err
+		}
+	}
+
+	prelude, err := dump.archivePrelude()
+	if err != nil {
+		return err
+	}
+	dump.archive = archive.NewMultiplexer(dump.archiveOut)
+	return dump.archive.WritePrelude(prelude)
+}
+
+// archivePrelude builds the prelude describing every namespace that
+// will be written to the archive.
+func (dump *MongoDump) archivePrelude() (*archive.Prelude, error) {
+	prelude := &archive.Prelude{
+		ToolVersion:           commonopts.VersionStr,
+		ConcurrentCollections: dump.OutputOptions.JobThreads,
+	}
+	for _, intent := range dump.manager.Intents() {
+		nsMetadata := &archive.NamespaceMetadata{
+			Database:   intent.DB,
+			Collection: intent.C,
+			Size:       intent.Size,
+		}
+		if !intent.IsSystemIndexes() {
+			log.Logf(log.DebugLow, "reading metadata for %v", intent.Key())
+			metadata := &bytes.Buffer{}
+			if _, err := dump.dumpMetadataToWriter(intent.DB, intent.C, metadata); err != nil {
+				return nil, err
+			}
+			nsMetadata.Metadata = metadata.String()
+		}
+		prelude.Namespaces = append(prelude.Namespaces, nsMetadata)
+	}
+	if dump.OutputOptions.Oplog {
+		prelude.Namespaces = append(prelude.Namespaces,
+			&archive.NamespaceMetadata{Collection: "oplog"})
+	}
+	if dump.OutputOptions.DumpDBUsersAndRoles && dump.ToolOptions.DB != "admin" {
+		prelude.Namespaces = append(prelude.Namespaces,
+			&archive.NamespaceMetadata{Database: dump.ToolOptions.DB, Collection: "$admin.system.users"},
+			&archive.NamespaceMetadata{Database: dump.ToolOptions.DB, Collection: "$admin.system.roles"},
+		)
+	}
+	return prelude, nil
+}
+
+// closeArchive writes the archive terminator and closes the destination,
+// flushing any compressed output.
+func (dump *MongoDump) closeArchive() error {
+	if err := dump.archive.Close(); err != nil {
+		return err
+	}
+	if err := dump.archiveOut.Close(); err != nil {
+		return fmt.Errorf("error closing archive: %v", err)
+	}
+	return nil
+}
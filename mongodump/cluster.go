@@ -0,0 +1,280 @@
+package mongodump
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/log"
+	commonopts "github.com/mongodb/mongo-tools/common/options"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ClusterDumpFilename is the name of the file describing a --cluster dump,
+// written to the root of the dump folder once every shard is dumped
+const ClusterDumpFilename = "cluster_dump.json"
+
+// BalancerStopTimeout is how long --stopBalancer waits for
+// a balancing round in progress to finish
+var BalancerStopTimeout = 10 * time.Minute
+
+// ClusterShard describes the dump of a single shard. Dir is relative
+// to the dump folder, and the oplog window is the range of the shard's
+// oplog captured in its oplog.bson.
+type ClusterShard struct {
+	Id         string              `json:"id"`
+	Host       string              `json:"host"`
+	Dir        string              `json:"dir"`
+	OplogStart bson.MongoTimestamp `json:"oplogStart"`
+	OplogEnd   bson.MongoTimestamp `json:"oplogEnd"`
+}
+
+// ClusterDump describes a --cluster dump. PointInTime is taken once all
+// shards have finished dumping their data, and every shard's oplog is
+// captured past it, even on idle shards whose last entry is much older, so
+// restoring every shard with --oplogReplay up to PointInTime brings the
+// whole cluster to a near-consistent state, within the clock skew between
+// the shards and the host mongodump runs on.
+type ClusterDump struct {
+	Shards          []*ClusterShard     `json:"shards"`
+	ConfigDir       string              `json:"configDir"`
+	PointInTime     bson.MongoTimestamp `json:"pointInTime"`
+	BalancerStopped bool                `json:"balancerStopped"`
+}
+
+// shardSync holds back the end of every shard's oplog capture until all
+// of the shards have dumped their data, or until one of them has failed.
+// Once all have arrived, it takes the cluster's point in time, the next
+// whole second, and releases them after that second has begun.
+type shardSync struct {
+	arrived   chan struct{}
+	release   chan struct{}
+	abort     chan struct{}
+	abortOnce sync.Once
+
+	// pointInTime is set before release is closed
+	pointInTime bson.MongoTimestamp
+}
+
+func newShardSync(shards int) *shardSync {
+	barrier := &shardSync{
+		arrived: make(chan struct{}, shards),
+		release: make(chan struct{}),
+		abort:   make(chan struct{}),
+	}
+	go func() {
+		for i := 0; i < shards; i++ {
+			select {
+			case <-barrier.arrived:
+			case <-barrier.abort:
+				return
+			}
+		}
+		// every shard's data was dumped before now, and every
+		// oplog capture ends after the point in time
+		pointInTime := time.Now().Truncate(time.Second).Add(time.Second)
+		time.Sleep(pointInTime.Sub(time.Now()))
+		barrier.pointInTime = bson.MongoTimestamp(pointInTime.Unix() << 32)
+		close(barrier.release)
+	}()
+	return barrier
+}
+
+// wait marks the calling shard's data as dumped, and waits for every other shard
+func (barrier *shardSync) wait() error {
+	barrier.arrived <- struct{}{}
+	select {
+	case <-barrier.release:
+		return nil
+	case <-barrier.abort:
+		return fmt.Errorf("stopped capturing oplog, the dump of another shard failed")
+	}
+}
+
+// fail releases every waiting shard with an error
+func (barrier *shardSync) fail() {
+	barrier.abortOnce.Do(func() {
+		close(barrier.abort)
+	})
+}
+
+// DumpCluster dumps a sharded cluster through the mongos it is connected
+// to. The config database is dumped through the mongos, then every shard
+// is dumped directly and in parallel along with its oplog.
+func (dump *MongoDump) DumpCluster() error {
+	session, err := dump.sessionProvider.GetSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	isMongos, err := db.IsMongos(session)
+	if err != nil {
+		return err
+	}
+	if !isMongos {
+		return fmt.Errorf("--cluster requires a connection to a mongos")
+	}
+	shards, err := db.GetShards(session)
+	if err != nil {
+		return err
+	}
+	if len(shards) == 0 {
+		return fmt.Errorf("the cluster has no shards")
+	}
+
+	cluster := &ClusterDump{ConfigDir: "config"}
+	if dump.OutputOptions.StopBalancer {
+		restartBalancer, err := stopBalancer(session)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := restartBalancer(); err != nil {
+				log.Logf(log.Always, "error restarting the balancer: %v", err)
+			}
+		}()
+		cluster.BalancerStopped = true
+	}
+
+	log.Logf(log.Always, "dumping config database through mongos")
+	configDump := dump.clusterMemberDump("", filepath.Join(dump.OutputOptions.Out, cluster.ConfigDir))
+	configDump.ToolOptions.Namespace = &commonopts.Namespace{DB: "config"}
+	if err = configDump.Init(); err != nil {
+		return err
+	}
+	if err = configDump.Dump(); err != nil {
+		return fmt.Errorf("error dumping config database: %v", err)
+	}
+
+	barrier := newShardSync(len(shards))
+	results := make(chan error, len(shards))
+	for _, shard := range shards {
+		entry := &ClusterShard{Id: shard.Id, Host: shard.Host, Dir: filepath.ToSlash(filepath.Join("shards", shard.Id))}
+		cluster.Shards = append(cluster.Shards, entry)
+		go func(entry *ClusterShard) {
+			log.Logf(log.Always, "dumping shard %v from %v", entry.Id, entry.Host)
+			shardDump := dump.clusterMemberDump(entry.Host, filepath.Join(dump.OutputOptions.Out, entry.Dir))
+			shardDump.OutputOptions.Oplog = true
+			shardDump.shardSync = barrier
+			err := shardDump.Init()
+			if err == nil {
+				err = shardDump.Dump()
+			}
+			if err != nil {
+				barrier.fail()
+				err = fmt.Errorf("error dumping shard %v: %v", entry.Id, err)
+			}
+			results <- err
+		}(entry)
+	}
+	for i := 0; i < len(shards); i++ {
+		if shardErr := <-results; shardErr != nil && err == nil {
+			err = shardErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range cluster.Shards {
+		oplogMeta, err := ReadOplogMetadata(filepath.Join(dump.OutputOptions.Out, entry.Dir), dump.encryption)
+		if err != nil {
+			return fmt.Errorf("error reading oplog window of shard %v: %v", entry.Id, err)
+		}
+		entry.OplogStart, entry.OplogEnd = oplogMeta.Start, oplogMeta.End
+	}
+	cluster.PointInTime = barrier.pointInTime
+	log.Logf(log.Always, "shards can be restored to a common point in time of %v", cluster.PointInTime)
+	return dump.writeClusterDump(cluster)
+}
+
+// clusterMemberDump returns a dump of one member of the cluster to the
+// given folder, connecting to host, or to the mongos if host is empty.
+func (dump *MongoDump) clusterMemberDump(host, out string) *MongoDump {
+	toolOptions := *dump.ToolOptions
+	if host != "" {
+		connection := *dump.ToolOptions.Connection
+		connection.Host = host
+		connection.Port = ""
+		toolOptions.Connection = &connection
+	}
+	outputOptions := *dump.OutputOptions
+	outputOptions.Out = out
+	outputOptions.Cluster = false
+	outputOptions.StopBalancer = false
+	return &MongoDump{
+		ToolOptions:   &toolOptions,
+		InputOptions:  dump.InputOptions,
+		OutputOptions: &outputOptions,
+		// every member is throttled together
+		throttle:   dump.throttle,
+		encryption: dump.encryption,
+	}
+}
+
+// writeClusterDump writes the description of the cluster dump
+func (dump *MongoDump) writeClusterDump(cluster *ClusterDump) error {
+	jsonBytes, err := json.MarshalIndent(cluster, "", "\t")
+	if err != nil {
+		return fmt.Errorf("error marshalling cluster dump: %v", err)
+	}
+	path := filepath.Join(dump.OutputOptions.Out, ClusterDumpFilename)
+	if err = ioutil.WriteFile(path, jsonBytes, 0644); err != nil {
+		return fmt.Errorf("error writing %v: %v", path, err)
+	}
+	return nil
+}
+
+// stopBalancer stops the balancer of the cluster and waits for a balancing
+// round in progress to finish, returning a function that starts it again.
+// A balancer that was stopped already is left stopped.
+func stopBalancer(session *mgo.Session) (func() error, error) {
+	settings := session.DB("config").C("settings")
+	current := struct {
+		Stopped bool `bson:"stopped"`
+	}{}
+	err := settings.FindId("balancer").One(&current)
+	if err != nil && err != mgo.ErrNotFound {
+		return nil, fmt.Errorf("error reading balancer settings: %v", err)
+	}
+	if current.Stopped {
+		log.Logf(log.Always, "the balancer is already stopped")
+		return func() error { return nil }, nil
+	}
+
+	log.Logf(log.Always, "stopping the balancer")
+	if _, err = settings.UpsertId("balancer", bson.M{"$set": bson.M{"stopped": true}}); err != nil {
+		return nil, fmt.Errorf("error stopping the balancer: %v", err)
+	}
+	restart := func() error {
+		log.Logf(log.Always, "restarting the balancer")
+		return settings.UpdateId("balancer", bson.M{"$set": bson.M{"stopped": false}})
+	}
+
+	deadline := time.Now().Add(BalancerStopTimeout)
+	for {
+		lock := struct {
+			State int `bson:"state"`
+		}{}
+		err = session.DB("config").C("locks").FindId("balancer").One(&lock)
+		if err == mgo.ErrNotFound || (err == nil && lock.State == 0) {
+			return restart, nil
+		}
+		if err == nil && time.Now().After(deadline) {
+			err = fmt.Errorf("timed out after %v", BalancerStopTimeout)
+		}
+		if err != nil {
+			err = fmt.Errorf("error waiting for the balancing round in progress to finish: %v", err)
+			if restartErr := restart(); restartErr != nil {
+				return nil, fmt.Errorf("%v; the balancer is still stopped, error restarting it: %v", err, restartErr)
+			}
+			return nil, err
+		}
+		log.Logf(log.Info, "waiting for the balancing round in progress to finish")
+		time.Sleep(time.Second)
+	}
+}
@@ -0,0 +1,64 @@
+package mongodump
+
+import (
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+	"testing"
+	"time"
+)
+
+func TestShardSync(t *testing.T) {
+
+	testutil.VerifyTestType(t, testutil.UNIT_TEST_TYPE)
+
+	Convey("With the dumps of three shards", t, func() {
+		barrier := newShardSync(3)
+		results := make(chan error, 3)
+
+		Convey("no shard should be released until all have arrived", func() {
+			for i := 0; i < 2; i++ {
+				go func() { results <- barrier.wait() }()
+			}
+			select {
+			case <-results:
+				t.Fatal("a shard was released before every shard arrived")
+			case <-time.After(200 * time.Millisecond):
+			}
+			go func() { results <- barrier.wait() }()
+			for i := 0; i < 3; i++ {
+				So(<-results, ShouldBeNil)
+			}
+		})
+
+		Convey("a failed shard should release the others with an error", func() {
+			for i := 0; i < 2; i++ {
+				go func() { results <- barrier.wait() }()
+			}
+			barrier.fail()
+			barrier.fail()
+			for i := 0; i < 2; i++ {
+				So(<-results, ShouldNotBeNil)
+			}
+		})
+	})
+
+	Convey("The cluster's point in time should follow the data of every shard, even an idle one", t, func() {
+		start := time.Now()
+		// the idle shard's last oplog entry is an hour old
+		idleOplogEnd := bson.MongoTimestamp(start.Add(-time.Hour).Unix() << 32)
+		barrier := newShardSync(3)
+		results := make(chan error, 3)
+		for i := 0; i < 3; i++ {
+			go func() { results <- barrier.wait() }()
+		}
+		for i := 0; i < 3; i++ {
+			So(<-results, ShouldBeNil)
+		}
+		released := time.Now()
+		So(barrier.pointInTime, ShouldBeGreaterThan, bson.MongoTimestamp(start.Unix()<<32))
+		So(barrier.pointInTime, ShouldBeGreaterThan, idleOplogEnd)
+		// every shard's capture starts once the point in time has passed
+		So(barrier.pointInTime, ShouldBeLessThanOrEqualTo, bson.MongoTimestamp(released.Unix()<<32))
+	})
+}
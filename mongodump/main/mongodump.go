@@ -17,6 +17,7 @@ func main() {
 	opts.AddOptions(inputOpts)
 	outputOpts := &options.OutputOptions{}
 	opts.AddOptions(outputOpts)
+	opts.AddReadPreferenceOptions()
 
 	_, err := opts.Parse()
 	if err != nil {
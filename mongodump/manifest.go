@@ -0,0 +1,288 @@
+package mongodump
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"gopkg.in/mgo.v2/bson"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ManifestFilename is the name of the checkpoint manifest that
+// mongodump keeps in the root of the dump folder
+const ManifestFilename = "dump_manifest.json"
+
+// Statuses of an intent in the manifest
+const (
+	ManifestPending    = "pending"
+	ManifestInProgress = "in progress"
+	ManifestComplete   = "complete"
+)
+
+// ManifestEntry records the progress of dumping a single intent.
+// Documents and Bytes are only known once the intent is complete,
+// with Bytes counting the BSON written before any compression.
+type ManifestEntry struct {
+	Namespace string `json:"ns"`
+	Status    string `json:"status"`
+	Documents int64  `json:"documents"`
+	Bytes     int64  `json:"bytes"`
+}
+
+// ManifestOptions are the options that decide what the files of a dump
+// hold and how they are written, which a resumed dump must be taken with
+// again. Query and redaction files are recorded by the SHA-256 of their
+// contents, so that a copy may be given but not a changed file.
+type ManifestOptions struct {
+	Gzip          bool   `json:"gzip"`
+	Encrypted     bool   `json:"encrypted"`
+	Query         string `json:"query,omitempty"`
+	QueryFile     string `json:"queryFile,omitempty"`
+	RedactionFile string `json:"redactionFile,omitempty"`
+}
+
+// differences returns the flags whose values differ between two sets of options
+func (options ManifestOptions) differences(other ManifestOptions) []string {
+	flags := []string{}
+	if options.Gzip != other.Gzip {
+		flags = append(flags, "--gzip")
+	}
+	if options.Encrypted != other.Encrypted {
+		flags = append(flags, "--encryptionKeyFile")
+	}
+	if options.Query != other.Query {
+		flags = append(flags, "--query")
+	}
+	if options.QueryFile != other.QueryFile {
+		flags = append(flags, "--queryFile")
+	}
+	if options.RedactionFile != other.RedactionFile {
+		flags = append(flags, "--redactionFile")
+	}
+	return flags
+}
+
+// Manifest is a checkpoint of a dump in progress. It is rewritten every
+// time an intent changes status, so that an interrupted dump can be
+// resumed with --resume by only dumping the intents that are not complete.
+type Manifest struct {
+	// OplogStart is the oplog timestamp captured at the start of an
+	// --oplog dump, which a resumed dump must keep capturing from
+	OplogStart bson.MongoTimestamp `json:"oplogStart,omitempty"`
+
+	// Options are those the dump was started with
+	Options ManifestOptions `json:"options"`
+
+	Intents []*ManifestEntry `json:"intents"`
+
+	path    string
+	lock    sync.Mutex
+	entries map[string]*ManifestEntry
+}
+
+// NewManifest returns an empty manifest for the given dump folder
+func NewManifest(dumpDir string) *Manifest {
+	return &Manifest{
+		Intents: []*ManifestEntry{},
+		path:    filepath.Join(dumpDir, ManifestFilename),
+		entries: map[string]*ManifestEntry{},
+	}
+}
+
+// ReadManifest reads the manifest left in the given dump folder
+func ReadManifest(dumpDir string) (*Manifest, error) {
+	manifest := NewManifest(dumpDir)
+	jsonBytes, err := ioutil.ReadFile(manifest.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no %v found in %v, cannot resume", ManifestFilename, dumpDir)
+		}
+		return nil, fmt.Errorf("error reading manifest: %v", err)
+	}
+	if err = json.Unmarshal(jsonBytes, manifest); err != nil {
+		return nil, fmt.Errorf("error parsing manifest %v: %v", manifest.path, err)
+	}
+	for _, entry := range manifest.Intents {
+		manifest.entries[entry.Namespace] = entry
+	}
+	return manifest, nil
+}
+
+// IsComplete returns whether the given intent was fully dumped
+func (manifest *Manifest) IsComplete(intent *intents.Intent) bool {
+	manifest.lock.Lock()
+	defer manifest.lock.Unlock()
+	entry := manifest.entries[intent.Key()]
+	return entry != nil && entry.Status == ManifestComplete
+}
+
+// Put adds the given intents to the manifest as pending, unless they are
+// already complete, and writes the manifest.
+func (manifest *Manifest) Put(intentList []*intents.Intent) error {
+	manifest.lock.Lock()
+	defer manifest.lock.Unlock()
+	for _, intent := range intentList {
+		entry := manifest.entry(intent.Key())
+		if entry.Status != ManifestComplete {
+			entry.Status = ManifestPending
+		}
+	}
+	return manifest.flush()
+}
+
+// SetOplogStart records the oplog start timestamp and writes the manifest.
+func (manifest *Manifest) SetOplogStart(ts bson.MongoTimestamp) error {
+	manifest.lock.Lock()
+	defer manifest.lock.Unlock()
+	manifest.OplogStart = ts
+	return manifest.flush()
+}
+
+// Start marks the given intent as being dumped and writes the manifest.
+func (manifest *Manifest) Start(intent *intents.Intent) error {
+	manifest.lock.Lock()
+	defer manifest.lock.Unlock()
+	entry := manifest.entry(intent.Key())
+	entry.Status = ManifestInProgress
+	entry.Documents = 0
+	entry.Bytes = 0
+	return manifest.flush()
+}
+
+// Complete marks the given intent as fully dumped and writes the manifest.
+func (manifest *Manifest) Complete(intent *intents.Intent, documents, bytes int64) error {
+	manifest.lock.Lock()
+	defer manifest.lock.Unlock()
+	entry := manifest.entry(intent.Key())
+	entry.Status = ManifestComplete
+	entry.Documents = documents
+	entry.Bytes = bytes
+	return manifest.flush()
+}
+
+// entry returns the entry for the given namespace, adding it if it
+// is new. The caller must hold the lock.
+func (manifest *Manifest) entry(ns string) *ManifestEntry {
+	entry, ok := manifest.entries[ns]
+	if !ok {
+		entry = &ManifestEntry{Namespace: ns, Status: ManifestPending}
+		manifest.entries[ns] = entry
+		manifest.Intents = append(manifest.Intents, entry)
+	}
+	return entry
+}
+
+// flush writes the manifest to disk. It writes to a temporary file first,
+// so a dump killed mid-write never leaves a truncated manifest behind.
+// The caller must hold the lock.
+func (manifest *Manifest) flush() error {
+	jsonBytes, err := json.MarshalIndent(manifest, "", "\t")
+	if err != nil {
+		return fmt.Errorf("error marshalling manifest: %v", err)
+	}
+	tmpPath := manifest.path + ".tmp"
+	if err = ioutil.WriteFile(tmpPath, jsonBytes, 0644); err != nil {
+		return fmt.Errorf("error writing manifest: %v", err)
+	}
+	if err = os.Rename(tmpPath, manifest.path); err != nil {
+		return fmt.Errorf("error writing manifest: %v", err)
+	}
+	return nil
+}
+
+// manifestOptions returns the options of the dump to record in its manifest
+func (dump *MongoDump) manifestOptions() (ManifestOptions, error) {
+	options := ManifestOptions{
+		Gzip:      dump.OutputOptions.Gzip,
+		Encrypted: dump.OutputOptions.EncryptionKeyFile != "",
+		Query:     dump.InputOptions.Query,
+	}
+	var err error
+	if dump.InputOptions.QueryFile != "" {
+		if options.QueryFile, err = hashFile(dump.InputOptions.QueryFile); err != nil {
+			return options, fmt.Errorf("error reading query file: %v", err)
+		}
+	}
+	if dump.OutputOptions.RedactionFile != "" {
+		if options.RedactionFile, err = hashFile(dump.OutputOptions.RedactionFile); err != nil {
+			return options, fmt.Errorf("error reading redaction file: %v", err)
+		}
+	}
+	return options, nil
+}
+
+// hashFile returns the hex SHA-256 of the file at the given path
+func hashFile(path string) (string, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(contents)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// prepareManifest sets up the checkpoint manifest for a dump to a folder.
+// With --resume, intents the manifest records as complete are dropped from
+// the intent manager, so only the unfinished ones are planned and dumped,
+// and the dump must be taken with the options the manifest records.
+func (dump *MongoDump) prepareManifest() error {
+	if err := os.MkdirAll(dump.OutputOptions.Out, DumpDefaultPermissions); err != nil {
+		return fmt.Errorf("error creating directory `%v`: %v", dump.OutputOptions.Out, err)
+	}
+	options, err := dump.manifestOptions()
+	if err != nil {
+		return err
+	}
+	if !dump.OutputOptions.Resume {
+		dump.manifest = NewManifest(dump.OutputOptions.Out)
+		dump.manifest.Options = options
+		return dump.manifest.Put(dump.manager.Intents())
+	}
+
+	manifest, err := ReadManifest(dump.OutputOptions.Out)
+	if err != nil {
+		return err
+	}
+	if flags := manifest.Options.differences(options); len(flags) > 0 {
+		return fmt.Errorf("cannot resume, the interrupted dump was taken with different %v; "+
+			"resume it with the same options, or start a new dump",
+			strings.Join(flags, ", "))
+	}
+	remaining := intents.NewIntentManager()
+	for _, intent := range dump.manager.Intents() {
+		if manifest.IsComplete(intent) {
+			log.Logf(log.Info, "skipping %v, it was already dumped", intent.Key())
+			if dump.report != nil {
+				if err = dump.reportResumedIntent(intent, manifest.entries[intent.Key()]); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		remaining.Put(intent)
+	}
+	dump.manager = remaining
+	dump.manifest = manifest
+	log.Logf(log.Always, "resuming dump, %v collections left to dump", len(remaining.Intents()))
+	return dump.manifest.Put(dump.manager.Intents())
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	io.Writer
+	count int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.Writer.Write(p)
+	cw.count += int64(n)
+	return n, err
+}
@@ -0,0 +1,111 @@
+package mongodump
+
+import (
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/testutil"
+	"github.com/mongodb/mongo-tools/mongodump/options"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+
+	testutil.VerifyTestType(t, testutil.UNIT_TEST_TYPE)
+
+	Convey("With a manifest tracking three intents", t, func() {
+		dumpDir, err := ioutil.TempDir("", "mongodump_manifest_test")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(dumpDir)
+		})
+		done := &intents.Intent{DB: "db", C: "done"}
+		started := &intents.Intent{DB: "db", C: "started"}
+		untouched := &intents.Intent{DB: "db", C: "untouched"}
+
+		manifest := NewManifest(dumpDir)
+		So(manifest.Put([]*intents.Intent{done, started, untouched}), ShouldBeNil)
+		So(manifest.SetOplogStart(bson.MongoTimestamp(42)), ShouldBeNil)
+		So(manifest.Start(done), ShouldBeNil)
+		So(manifest.Complete(done, 10, 1024), ShouldBeNil)
+		So(manifest.Start(started), ShouldBeNil)
+
+		Convey("reading it back should show each intent's progress", func() {
+			read, err := ReadManifest(dumpDir)
+			So(err, ShouldBeNil)
+			So(read.OplogStart, ShouldEqual, bson.MongoTimestamp(42))
+			So(len(read.Intents), ShouldEqual, 3)
+			So(read.Intents[0].Status, ShouldEqual, ManifestComplete)
+			So(read.Intents[0].Documents, ShouldEqual, 10)
+			So(read.Intents[0].Bytes, ShouldEqual, 1024)
+			So(read.Intents[1].Status, ShouldEqual, ManifestInProgress)
+			So(read.Intents[2].Status, ShouldEqual, ManifestPending)
+
+			So(read.IsComplete(done), ShouldBeTrue)
+			So(read.IsComplete(started), ShouldBeFalse)
+			So(read.IsComplete(untouched), ShouldBeFalse)
+
+			Convey("and putting the intents again should reset unfinished ones to pending", func() {
+				So(read.Put([]*intents.Intent{started, untouched}), ShouldBeNil)
+				So(read.Intents[0].Status, ShouldEqual, ManifestComplete)
+				So(read.Intents[1].Status, ShouldEqual, ManifestPending)
+			})
+		})
+	})
+
+	Convey("Reading a folder without a manifest should fail", t, func() {
+		_, err := ReadManifest(os.TempDir() + "/mongodump_no_such_dump")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestResumeOptions(t *testing.T) {
+
+	testutil.VerifyTestType(t, testutil.UNIT_TEST_TYPE)
+
+	Convey("With a dump started with --gzip and a query file", t, func() {
+		dumpDir, err := ioutil.TempDir("", "mongodump_resume_test")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(dumpDir)
+		})
+		queryFile := filepath.Join(dumpDir, "queries.json")
+		So(ioutil.WriteFile(queryFile, []byte(`{"db.c": {"query": {"a": 1}}}`), 0644), ShouldBeNil)
+		newDump := func(resume bool) *MongoDump {
+			return &MongoDump{
+				InputOptions:  &options.InputOptions{QueryFile: queryFile},
+				OutputOptions: &options.OutputOptions{Out: dumpDir, Gzip: true, Resume: resume},
+				manager:       intents.NewIntentManager(),
+			}
+		}
+		So(newDump(false).prepareManifest(), ShouldBeNil)
+
+		Convey("resuming it with the same options should be allowed", func() {
+			So(newDump(true).prepareManifest(), ShouldBeNil)
+		})
+
+		Convey("resuming it without --gzip should be refused", func() {
+			dump := newDump(true)
+			dump.OutputOptions.Gzip = false
+			err := dump.prepareManifest()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "--gzip")
+		})
+
+		Convey("resuming it with --encryptionKeyFile should be refused", func() {
+			dump := newDump(true)
+			dump.OutputOptions.EncryptionKeyFile = "key"
+			So(dump.prepareManifest(), ShouldNotBeNil)
+		})
+
+		Convey("resuming it with a changed query file should be refused", func() {
+			So(ioutil.WriteFile(queryFile, []byte(`{"db.c": {"query": {"a": 2}}}`), 0644), ShouldBeNil)
+			err := newDump(true).prepareManifest()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "--queryFile")
+		})
+	})
+}
@@ -7,16 +7,33 @@ import (
 	"github.com/mongodb/mongo-tools/common/bsonutil"
 	"github.com/mongodb/mongo-tools/common/db"
 	"github.com/mongodb/mongo-tools/common/log"
+	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 	"io"
 )
 
+// MetadataVersion is the version of the metadata format mongodump writes.
+// Version 2 added the version, type and uuid fields, and keeps the options
+// in the order and with the BSON types that listCollections reports them in,
+// as extended JSON.
+const MetadataVersion = 2
+
+// Collection types reported by listCollections
+const (
+	CollectionType = "collection"
+	ViewType       = "view"
+)
+
 // Metadata consists of two parts, a collection's options and
 // its indexes. Options include properties like "capped" etc.
 // Metadata JSON is read in by mongorestore to properly recreate
-// collections with the proper options and indexes.
+// collections with the proper options and indexes. The options of a view
+// hold its definition, "viewOn" and "pipeline", and views have no indexes.
 type Metadata struct {
-	Options bson.M        `json:"options,omitempty"`
+	Version int           `json:"version"`
+	Type    string        `json:"type,omitempty"`
+	UUID    string        `json:"uuid,omitempty"`
+	Options interface{}   `json:"options,omitempty"`
 	Indexes []interface{} `json:"indexes"`
 }
 
@@ -27,13 +44,14 @@ type IndexDocumentFromDB struct {
 }
 
 // This helper gets the metadata for a collection and writes it
-// in readable JSON format
-func (dump *MongoDump) dumpMetadataToWriter(dbName, c string, writer io.Writer) error {
+// in readable JSON format. It returns the number of indexes written.
+func (dump *MongoDump) dumpMetadataToWriter(dbName, c string, writer io.Writer) (int, error) {
 	// make a buffered writer for nicer disk i/o
 	w := bufio.NewWriter(writer)
 
 	nsID := fmt.Sprintf("%v.%v", dbName, c)
 	meta := Metadata{
+		Version: MetadataVersion,
 		// We have to initialize Indexes to an empty slice, not nil, so that an empty
 		// array is marshalled into json instead of null. That is, {indexes:[]} is okay
 		// but {indexes:null} will cause assertions in our legacy C++ mongotools
@@ -49,7 +67,7 @@ func (dump *MongoDump) dumpMetadataToWriter(dbName, c string, writer io.Writer)
 
 	session, err := dump.sessionProvider.GetSession()
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer session.Close()
 	collection := session.DB(dbName).C(c)
@@ -59,16 +77,24 @@ func (dump *MongoDump) dumpMetadataToWriter(dbName, c string, writer io.Writer)
 		//The collection wasn't found, which means it was probably deleted
 		// between now and the time that collections were listed. Skip it.
 		log.Logf(log.DebugLow, "Warning: no metadata found for collection: `%v`: %v", nsID, err)
-		return nil
+		return 0, nil
 	}
-	meta.Options = bson.M{}
+	meta.Type, meta.UUID = collectionTypeAndUUID(collectionInfo)
 	if opts, err := bsonutil.FindValueByKey("options", collectionInfo); err == nil {
 		if optsD, ok := opts.(bson.D); ok {
-			meta.Options = optsD.Map()
+			// keep the order of options such as view pipelines, and their
+			// types, by writing them as ordered extended JSON
+			if meta.Options, err = bsonutil.ConvertBSONValueToJSON(optsD); err != nil {
+				return 0, fmt.Errorf("error converting options of `%v`: %v", nsID, err)
+			}
 		} else {
-			return fmt.Errorf("Collection options contains invalid data: %v", opts)
+			return 0, fmt.Errorf("Collection options contains invalid data: %v", opts)
 		}
 	}
+	if meta.Type == ViewType {
+		// views have no indexes of their own
+		return len(meta.Indexes), writeMetadata(w, nsID, meta)
+	}
 
 	// Second, we read the collection's index information by either calling
 	// listIndexes (pre-2.7 systems) or querying system.indexes.
@@ -80,17 +106,22 @@ func (dump *MongoDump) dumpMetadataToWriter(dbName, c string, writer io.Writer)
 	//get the indexes
 	indexes, err := db.GetIndexes(collection)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	for _, index := range indexes {
 		convertedIndex, err := bsonutil.ConvertBSONValueToJSON(index)
 		if err != nil {
-			return fmt.Errorf("error converting index (%#v): %v", index, err)
+			return 0, fmt.Errorf("error converting index (%#v): %v", index, err)
 		}
 		meta.Indexes = append(meta.Indexes, convertedIndex)
 	}
 
 	// Finally, we send the results to the writer as JSON bytes
+	return len(meta.Indexes), writeMetadata(w, nsID, meta)
+}
+
+// writeMetadata writes a collection's metadata as JSON to a buffered writer
+func writeMetadata(w *bufio.Writer, nsID string, meta Metadata) error {
 	jsonBytes, err := json.Marshal(meta)
 	if err != nil {
 		return fmt.Errorf("error marshalling metadata json for collection `%v`: %v", nsID, err)
@@ -105,3 +136,49 @@ func (dump *MongoDump) dumpMetadataToWriter(dbName, c string, writer io.Writer)
 	}
 	return nil
 }
+
+// collectionTypeAndUUID returns the type of a collection from its
+// listCollections info, and its UUID if the server reports one
+func collectionTypeAndUUID(collectionInfo *bson.D) (string, string) {
+	collectionType := CollectionType
+	if value, err := bsonutil.FindValueByKey("type", collectionInfo); err == nil {
+		if typeStr, ok := value.(string); ok && typeStr != "" {
+			collectionType = typeStr
+		}
+	}
+	var uuid string
+	if value, err := bsonutil.FindValueByKey("info", collectionInfo); err == nil {
+		if info, ok := value.(bson.D); ok {
+			if id, err := bsonutil.FindValueByKey("uuid", &info); err == nil {
+				if binary, ok := id.(bson.Binary); ok && len(binary.Data) == 16 {
+					uuid = formatUUID(binary.Data)
+				}
+			}
+		}
+	}
+	return collectionType, uuid
+}
+
+// formatUUID formats 16 bytes as a canonical UUID string
+func formatUUID(data []byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", data[0:4], data[4:6], data[6:8], data[8:10], data[10:16])
+}
+
+// isView returns whether the given collection is a view
+func (dump *MongoDump) isView(dbName, c string) (bool, error) {
+	session, err := dump.sessionProvider.GetSession()
+	if err != nil {
+		return false, err
+	}
+	defer session.Close()
+	collectionInfo, err := db.GetCollectionOptions(session.DB(dbName).C(c))
+	if err == mgo.ErrNotFound || (err == nil && collectionInfo == nil) {
+		// the collection was dropped since it was listed
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error reading options of %v.%v: %v", dbName, c, err)
+	}
+	collectionType, _ := collectionTypeAndUUID(collectionInfo)
+	return collectionType == ViewType, nil
+}
@@ -2,7 +2,10 @@ package mongodump
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/sha256"
 	"fmt"
+	"github.com/mongodb/mongo-tools/common/archive"
 	"github.com/mongodb/mongo-tools/common/auth"
 	"github.com/mongodb/mongo-tools/common/bsonutil"
 	"github.com/mongodb/mongo-tools/common/db"
@@ -11,6 +14,8 @@ import (
 	"github.com/mongodb/mongo-tools/common/log"
 	commonopts "github.com/mongodb/mongo-tools/common/options"
 	"github.com/mongodb/mongo-tools/common/progress"
+	"github.com/mongodb/mongo-tools/common/repository"
+	"github.com/mongodb/mongo-tools/common/util"
 	"github.com/mongodb/mongo-tools/mongodump/options"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
@@ -44,6 +49,34 @@ type MongoDump struct {
 	oplogCollection string
 	authVersion     int
 	progressManager *progress.Manager
+	compression     *util.CompressionCodec
+	encryption      *util.EncryptionKey
+	archive         *archive.Multiplexer
+	archiveOut      io.WriteCloser
+	manifest        *Manifest
+
+	// namespaceQueries are read from --queryFile, and intentQueries
+	// holds the one matched by each intent, keyed by namespace
+	namespaceQueries []*NamespaceQuery
+	intentQueries    map[string]*NamespaceQuery
+
+	// redaction is read from --redactionFile, and redactors holds
+	// the redactor matched by each intent, keyed by namespace
+	redaction *RedactionConfig
+	redactors map[string]*Redactor
+
+	// report summarizes a dump to a folder in dump_report.json
+	report *DumpReport
+
+	// throttle limits the rate at which the dump reads documents
+	throttle *Throttle
+
+	// snapshot is the snapshot being written to a --repository
+	snapshot *repository.Snapshot
+
+	// shardSync coordinates the oplog capture of the dump of a
+	// shard with the dumps of the other shards of a --cluster dump
+	shardSync *shardSync
 }
 
 // ValidateOptions checks for any incompatible sets of options
@@ -51,6 +84,12 @@ func (dump *MongoDump) ValidateOptions() error {
 	if err := dump.ToolOptions.Validate(); err != nil {
 		return err
 	}
+	if dump.InputOptions.Config != "" {
+		if dump.InputOptions.QueryFile != "" && dump.InputOptions.QueryFile != dump.InputOptions.Config {
+			return fmt.Errorf("cannot use both --queryFile and --config, they are the same option")
+		}
+		dump.InputOptions.QueryFile = dump.InputOptions.Config
+	}
 	switch {
 	case dump.OutputOptions.Out == "-" && dump.ToolOptions.Namespace.Collection == "":
 		return fmt.Errorf("can only dump a single collection to stdout")
@@ -74,8 +113,70 @@ func (dump *MongoDump) ValidateOptions() error {
 		return fmt.Errorf("--db is required when --excludeCollectionsWithPrefix is specified")
 	case dump.OutputOptions.Repair && dump.InputOptions.Query != "":
 		return fmt.Errorf("cannot run a query with --repair enabled")
+	case dump.OutputOptions.RedactionFile != "" && dump.OutputOptions.Oplog:
+		return fmt.Errorf("cannot use --redactionFile together with --oplog, oplog entries are not redacted")
+	case dump.OutputOptions.RedactionFile != "" && dump.OutputOptions.IncrementalFrom != "":
+		return fmt.Errorf("cannot use --redactionFile together with --incrementalFrom, oplog entries are not redacted")
+	case dump.InputOptions.QueryFile != "" && dump.InputOptions.Query != "":
+		return fmt.Errorf("cannot use --query together with --queryFile")
+	case dump.InputOptions.QueryFile != "" && dump.OutputOptions.Repair:
+		return fmt.Errorf("cannot use --queryFile with --repair enabled")
+	case dump.InputOptions.QueryFile != "" && dump.OutputOptions.IncrementalFrom != "":
+		return fmt.Errorf("cannot use --queryFile together with --incrementalFrom")
+	case dump.OutputOptions.Archive != "" && dump.OutputOptions.Out == "-":
+		return fmt.Errorf("cannot use --archive together with --out=-")
+	case dump.OutputOptions.IncrementalFrom != "" && dump.ToolOptions.Namespace.DB != "":
+		return fmt.Errorf("--incrementalFrom is only supported on full dumps")
+	case dump.OutputOptions.IncrementalFrom != "" && dump.OutputOptions.Archive != "":
+		return fmt.Errorf("cannot use --archive together with --incrementalFrom")
+	case dump.OutputOptions.IncrementalFrom != "" && dump.OutputOptions.Repair:
+		return fmt.Errorf("cannot use --repair together with --incrementalFrom")
+	case dump.OutputOptions.Resume && dump.OutputOptions.Archive != "":
+		return fmt.Errorf("cannot use --resume together with --archive")
+	case dump.OutputOptions.Resume && dump.OutputOptions.Out == "-":
+		return fmt.Errorf("cannot use --resume when dumping to stdout")
+	case dump.OutputOptions.Resume && dump.OutputOptions.IncrementalFrom != "":
+		return fmt.Errorf("cannot use --resume together with --incrementalFrom")
 	case dump.OutputOptions.JobThreads < 1:
 		return fmt.Errorf("number of processing threads must be >= 1")
+	case dump.OutputOptions.NumParallelRanges < 1:
+		return fmt.Errorf("number of parallel ranges must be >= 1")
+	case dump.OutputOptions.NumParallelRanges > 1 && dump.OutputOptions.Archive != "":
+		return fmt.Errorf("cannot use --numParallelRanges together with --archive")
+	case dump.OutputOptions.Repository != "" && (dump.OutputOptions.Archive != "" || dump.OutputOptions.Out == "-"):
+		return fmt.Errorf("cannot use --repository together with --archive or --out=-")
+	case dump.OutputOptions.Repository != "" && dump.OutputOptions.Gzip:
+		return fmt.Errorf("cannot use --gzip together with --repository")
+	case dump.OutputOptions.Repository != "" && dump.OutputOptions.Oplog:
+		return fmt.Errorf("cannot use --oplog together with --repository")
+	case dump.OutputOptions.Repository != "" && dump.OutputOptions.DumpDBUsersAndRoles:
+		return fmt.Errorf("cannot use --dumpDbUsersAndRoles together with --repository")
+	case dump.OutputOptions.Repository != "" && dump.OutputOptions.Resume:
+		return fmt.Errorf("cannot use --resume together with --repository")
+	case dump.OutputOptions.Repository != "" && dump.OutputOptions.IncrementalFrom != "":
+		return fmt.Errorf("cannot use --incrementalFrom together with --repository")
+	case dump.OutputOptions.Repository != "" && dump.OutputOptions.Cluster:
+		return fmt.Errorf("cannot use --cluster together with --repository")
+	case dump.OutputOptions.Repository != "" && dump.OutputOptions.NumParallelRanges > 1:
+		return fmt.Errorf("cannot use --numParallelRanges together with --repository")
+	case dump.OutputOptions.Repository != "" && dump.OutputOptions.EncryptionKeyFile != "":
+		return fmt.Errorf("cannot use --encryptionKeyFile together with --repository, encrypted chunks cannot be deduplicated")
+	case dump.OutputOptions.StopBalancer && !dump.OutputOptions.Cluster:
+		return fmt.Errorf("--stopBalancer can only be used with --cluster")
+	case dump.OutputOptions.Cluster && dump.ToolOptions.Namespace.DB != "":
+		return fmt.Errorf("--cluster is only supported on full dumps")
+	case dump.OutputOptions.Cluster && (dump.OutputOptions.Archive != "" || dump.OutputOptions.Out == "-"):
+		return fmt.Errorf("--cluster can only dump to a folder")
+	case dump.OutputOptions.Cluster && dump.OutputOptions.Resume:
+		return fmt.Errorf("cannot use --resume together with --cluster")
+	case dump.OutputOptions.Cluster && dump.OutputOptions.IncrementalFrom != "":
+		return fmt.Errorf("cannot use --incrementalFrom together with --cluster")
+	case dump.OutputOptions.Cluster && dump.OutputOptions.RedactionFile != "":
+		return fmt.Errorf("cannot use --redactionFile together with --cluster, oplog entries are not redacted")
+	case dump.OutputOptions.MaxBytesPerSec < 0:
+		return fmt.Errorf("--maxBytesPerSec must not be negative")
+	case dump.OutputOptions.MaxDocsPerSec < 0:
+		return fmt.Errorf("--maxDocsPerSec must not be negative")
 	}
 	return nil
 }
@@ -88,6 +189,18 @@ func (dump *MongoDump) Init() error {
 	if dump.OutputOptions.Out == "-" {
 		dump.useStdout = true
 	}
+	if dump.OutputOptions.Gzip {
+		dump.compression, err = util.GetCompressionCodec("gzip")
+		if err != nil {
+			return err
+		}
+	}
+	if dump.OutputOptions.EncryptionKeyFile != "" && dump.encryption == nil {
+		dump.encryption, err = util.LoadEncryptionKeyFile(dump.OutputOptions.EncryptionKeyFile)
+		if err != nil {
+			return err
+		}
+	}
 	dump.sessionProvider = db.NewSessionProvider(*dump.ToolOptions)
 	dump.manager = intents.NewIntentManager()
 	dump.progressManager = progress.NewProgressBarManager(ProgressBarWaitTime)
@@ -102,6 +215,20 @@ func (dump *MongoDump) Dump() error {
 		return fmt.Errorf("Bad Option: %v", err)
 	}
 
+	stopThrottle, err := dump.startThrottle()
+	if err != nil {
+		return err
+	}
+	defer stopThrottle()
+
+	if dump.OutputOptions.IncrementalFrom != "" {
+		return dump.DumpIncremental()
+	}
+
+	if dump.OutputOptions.Cluster {
+		return dump.DumpCluster()
+	}
+
 	if dump.InputOptions.Query != "" {
 		// parse JSON then convert extended JSON values
 		var asJSON interface{}
@@ -121,6 +248,18 @@ func (dump *MongoDump) Dump() error {
 		dump.query = bson.M(asMap)
 	}
 
+	if dump.InputOptions.QueryFile != "" {
+		if err = dump.loadQueryFile(); err != nil {
+			return err
+		}
+	}
+
+	if dump.OutputOptions.RedactionFile != "" {
+		if err = dump.loadRedactionFile(); err != nil {
+			return err
+		}
+	}
+
 	if dump.OutputOptions.DumpDBUsersAndRoles {
 		//first make sure this is possible with the connected database
 		dump.authVersion, err = auth.GetAuthVersion(dump.sessionProvider)
@@ -147,6 +286,25 @@ func (dump *MongoDump) Dump() error {
 		return err
 	}
 
+	// dumps to a folder keep a manifest of their progress, so they can be
+	// resumed, and end with a report of what they captured
+	if dump.OutputOptions.Repository != "" {
+		repo, err := repository.Create(dump.OutputOptions.Repository)
+		if err != nil {
+			return err
+		}
+		dump.snapshot = repo.NewSnapshot()
+		log.Logf(log.Always, "dumping into snapshot %v of repository %v",
+			dump.snapshot.ID, dump.OutputOptions.Repository)
+	} else if !dump.useStdout && dump.OutputOptions.Archive == "" {
+		if err = dump.prepareReport(); err != nil {
+			return err
+		}
+		if err = dump.prepareManifest(); err != nil {
+			return err
+		}
+	}
+
 	var oplogStart bson.MongoTimestamp
 
 	// If oplog capturing is enabled, we first check the most recent
@@ -158,13 +316,43 @@ func (dump *MongoDump) Dump() error {
 		if err != nil {
 			return fmt.Errorf("error finding oplog: %v", err)
 		}
-		log.Logf(log.Info, "getting most recent oplog timestamp")
-		oplogStart, err = dump.getOplogStartTime()
-		if err != nil {
-			return fmt.Errorf("error getting oplog start: %v", err)
+		if dump.OutputOptions.Resume {
+			// collections dumped before the interruption are only consistent
+			// with the oplog captured from the original starting point
+			oplogStart = dump.manifest.OplogStart
+			if oplogStart == 0 {
+				return fmt.Errorf("cannot resume with --oplog, the interrupted dump was not taken with --oplog")
+			}
+			log.Logf(log.Info, "resuming oplog capture from %v", oplogStart)
+			exists, err := dump.checkOplogTimestampExists(oplogStart)
+			if err != nil {
+				return fmt.Errorf("unable to check oplog for overflow: %v", err)
+			}
+			if !exists {
+				return fmt.Errorf("oplog overflow: the oplog has rolled over since the " +
+					"interrupted dump started; a new full dump is required")
+			}
+		} else {
+			log.Logf(log.Info, "getting most recent oplog timestamp")
+			oplogStart, err = dump.getOplogStartTime()
+			if err != nil {
+				return fmt.Errorf("error getting oplog start: %v", err)
+			}
+			if dump.manifest != nil {
+				if err = dump.manifest.SetOplogStart(oplogStart); err != nil {
+					return err
+				}
+			}
 		}
 	}
 
+	if dump.OutputOptions.Archive != "" {
+		if err = dump.openArchive(); err != nil {
+			return err
+		}
+		defer dump.archiveOut.Close()
+	}
+
 	// kick off the progress bar manager and begin dumping intents
 	dump.progressManager.Start()
 	defer dump.progressManager.Stop()
@@ -189,46 +377,24 @@ func (dump *MongoDump) Dump() error {
 		}
 		log.Logf(log.DebugHigh, "oplog entry %v still exists", oplogStart)
 
-		// dump oplog in root of the dump folder
-		oplogFilepath := filepath.Join(dump.OutputOptions.Out, "oplog.bson")
-		oplogOut, err := os.Create(oplogFilepath)
-		if err != nil {
-			return fmt.Errorf("error creating bson file `%v`: %v", oplogFilepath, err)
+		// the oplog of every shard of a cluster is captured until after
+		// all of them have dumped their data
+		if dump.shardSync != nil {
+			if err = dump.shardSync.wait(); err != nil {
+				return err
+			}
 		}
 
-		log.Logf(log.Always, "writing captured oplog to %v", oplogFilepath)
-		//TODO encapsulate this logic
-		session, err := dump.sessionProvider.GetSession()
+		// only dump oplog entries up to the most recent one as of now,
+		// so the end of the captured range can be recorded exactly
+		oplogEnd, err := dump.getOplogStartTime()
 		if err != nil {
-			return err
+			return fmt.Errorf("error getting oplog end: %v", err)
 		}
-		defer session.Close()
-		session.SetSocketTimeout(0)
-		session.SetPrefetch(1.0) //mimic exhaust cursor
-		queryObj := bson.M{"ts": bson.M{"$gt": oplogStart}}
-		oplogQuery := session.DB("local").C(dump.oplogCollection).Find(queryObj).LogReplay()
+		err = dump.DumpOplogBetweenTimestamps(oplogStart, oplogEnd)
 		if err != nil {
 			return err
 		}
-		err = dump.dumpQueryToWriter(
-			oplogQuery, &intents.Intent{DB: "local", C: dump.oplogCollection}, oplogOut)
-		if err != nil {
-			return err
-		}
-
-		// check the oplog for a rollover one last time, to avoid a race condition
-		// wherein the oplog rolls over in the time after our first check, but before
-		// we copy it.
-		log.Logf(log.DebugLow, "checking again if oplog entry %v still exists", oplogStart)
-		exists, err = dump.checkOplogTimestampExists(oplogStart)
-		if !exists {
-			return fmt.Errorf(
-				"oplog overflow: mongodump was unable to capture all new oplog entries during execution")
-		}
-		if err != nil {
-			return fmt.Errorf("unable to check oplog for overflow: %v", err)
-		}
-		log.Logf(log.DebugHigh, "oplog entry %v still exists", oplogStart)
 	}
 
 	if dump.OutputOptions.DumpDBUsersAndRoles {
@@ -243,6 +409,27 @@ func (dump *MongoDump) Dump() error {
 		}
 	}
 
+	if dump.archive != nil {
+		if err = dump.closeArchive(); err != nil {
+			return err
+		}
+	}
+
+	if dump.snapshot != nil {
+		if err = dump.snapshot.Write(); err != nil {
+			return err
+		}
+		log.Logf(log.Always, "wrote snapshot %v", dump.snapshot.ID)
+	}
+
+	if dump.report != nil {
+		log.Logf(log.Always, "writing dump report to %v",
+			filepath.Join(dump.OutputOptions.Out, ReportFilename))
+		if err = dump.report.Write(); err != nil {
+			return err
+		}
+	}
+
 	log.Logf(log.Info, "done")
 
 	return err
@@ -307,89 +494,282 @@ func (dump *MongoDump) DumpIntent(intent *intents.Intent) error {
 	// in mgo, setting prefetch = 1.0 causes the driver to make requests for
 	// more results as soon as results are returned. This effectively
 	// duplicates the behavior of an exhaust cursor.
-	session.SetPrefetch(1.0)
+	if dump.throttle == nil {
+		// fetching the next batch early only piles up
+		// documents in memory when the dump is throttled
+		session.SetPrefetch(1.0)
+	}
+
+	startTime := time.Now()
+	if dump.manifest != nil {
+		if err = dump.manifest.Start(intent); err != nil {
+			return err
+		}
+	}
 
-	var findQuery *mgo.Query
+	var dumped int
+	var dumpedBytes int64
 	switch {
-	case len(dump.query) > 0:
-		findQuery = session.DB(intent.DB).C(intent.C).Find(dump.query)
-	case dump.InputOptions.TableScan:
-		// ---forceTablesScan runs the query without snapshot enabled
-		findQuery = session.DB(intent.DB).C(intent.C).Find(nil)
+	case intent.BSONPath == "":
+		// views have no data to dump, but archives still
+		// carry an empty stream for every namespace
+		log.Logf(log.Always, "not dumping data of view %v", intent.Key())
+		if dump.archive != nil {
+			if err = dump.archive.Open(intent.DB, intent.C).Close(); err != nil {
+				return err
+			}
+		}
 	default:
-		findQuery = session.DB(intent.DB).C(intent.C).Find(nil).Snapshot()
+		ranges, err := dump.splitIntent(session, intent)
+		if err != nil {
+			return err
+		}
+		if len(ranges) > 1 {
+			dumped, dumpedBytes, err = dump.dumpIntentRanges(intent, ranges)
+		} else {
+			dumped, dumpedBytes, err = dump.dumpIntentData(session, intent)
+		}
+		if err != nil {
+			return err
+		}
+	}
 
+	// snapshots keep the metadata of a collection in their index
+	if dump.snapshot != nil && !intent.IsSystemIndexes() {
+		metaOut := &bytes.Buffer{}
+		if _, err = dump.dumpMetadataToWriter(intent.DB, intent.C, metaOut); err != nil {
+			return err
+		}
+		dump.snapshot.SetMetadata(intent.DB, intent.C, metaOut.String())
+	}
+
+	// metadata is not written separately for stdout or snapshots,
+	// and archives already carry it in their prelude
+	if dump.useStdout || dump.archive != nil || dump.snapshot != nil {
+		log.Logf(log.Always, "done dumping %v", intent.Key())
+		return nil
 	}
 
-	if dump.useStdout {
-		log.Logf(log.Always, "writing %v to stdout", intent.Key())
-		return dump.dumpQueryToWriter(findQuery, intent, os.Stdout)
+	// don't dump metatdata for SystemIndexes collection
+	if intent.IsSystemIndexes() {
+		dump.reportIntent(intent, dumped, dumpedBytes, 0, startTime)
+		if dump.manifest != nil {
+			return dump.manifest.Complete(intent, int64(dumped), dumpedBytes)
+		}
+		return nil
 	}
 
-	dbFolder := filepath.Join(dump.OutputOptions.Out, intent.DB)
-	if err = os.MkdirAll(dbFolder, DumpDefaultPermissions); err != nil {
-		return fmt.Errorf("error creating folder `%v` for dump: %v", dbFolder, err)
+	metadataFilepath := intent.MetadataPath
+	metaOut, err := dump.createOutputFile(metadataFilepath)
+	if err != nil {
+		return fmt.Errorf("error creating metadata.json file `%v`: %v", metadataFilepath, err)
 	}
-	outFilepath := filepath.Join(dbFolder, fmt.Sprintf("%v.bson", intent.C))
-	out, err := os.Create(outFilepath)
+	defer metaOut.Close()
+
+	log.Logf(log.Always, "writing %v metadata to %v", intent.Key(), metadataFilepath)
+	indexes, err := dump.dumpMetadataToWriter(intent.DB, intent.C, metaOut)
 	if err != nil {
-		return fmt.Errorf("error creating bson file `%v`: %v", outFilepath, err)
+		return err
+	}
+	if err = metaOut.Close(); err != nil {
+		return fmt.Errorf("error closing metadata.json file `%v`: %v", metadataFilepath, err)
+	}
+	dump.reportIntent(intent, dumped, dumpedBytes, indexes, startTime)
+
+	if dump.manifest != nil {
+		if err = dump.manifest.Complete(intent, int64(dumped), dumpedBytes); err != nil {
+			return err
+		}
+	}
+
+	log.Logf(log.Always, "done dumping %v", intent.Key())
+	return nil
+}
+
+// dumpIntentData writes all of the intent's documents to its output with
+// a single cursor, returning the number of documents and bytes written.
+func (dump *MongoDump) dumpIntentData(session *mgo.Session, intent *intents.Intent) (int, int64, error) {
+	var err error
+	var out io.WriteCloser
+	var outName string
+	switch {
+	case dump.useStdout:
+		outName = "stdout"
+		out = util.NopWriteCloser(os.Stdout)
+		if dump.encryption != nil {
+			if out, err = dump.encryption.WrapWriteCloser(out); err != nil {
+				return 0, 0, err
+			}
+		}
+		if dump.compression != nil {
+			if out, err = dump.compression.WrapWriteCloser(out); err != nil {
+				return 0, 0, err
+			}
+		}
+	case dump.archive != nil:
+		outName = "archive"
+		out = dump.archive.Open(intent.DB, intent.C)
+	case dump.snapshot != nil:
+		outName = "snapshot " + dump.snapshot.ID
+		chunks := dump.snapshot.NewIntentWriter(intent.DB, intent.C)
+		defer func() {
+			log.Logf(log.Info, "	stored %v new of %v chunks of %v, %v new bytes",
+				chunks.NewChunks, len(chunks.Chunks), intent.Key(), chunks.NewBytes)
+		}()
+		out = chunks
+	default:
+		dbFolder := filepath.Dir(intent.BSONPath)
+		if err = os.MkdirAll(dbFolder, DumpDefaultPermissions); err != nil {
+			return 0, 0, fmt.Errorf("error creating folder `%v` for dump: %v", dbFolder, err)
+		}
+		// a previous, interrupted dump may have left parts behind
+		if err = os.RemoveAll(dump.partsPath(intent)); err != nil {
+			return 0, 0, fmt.Errorf("error removing stale parts of %v: %v", intent.Key(), err)
+		}
+		outName = intent.BSONPath
+		out, err = dump.createOutputFile(outName)
+		if err != nil {
+			return 0, 0, fmt.Errorf("error creating bson file `%v`: %v", outName, err)
+		}
 	}
 	defer out.Close()
 
+	counter := &countingWriter{Writer: out}
+	var dumped int
 	if !dump.OutputOptions.Repair {
-		log.Logf(log.Always, "writing %v to %v", intent.Key(), outFilepath)
-		if err = dump.dumpQueryToWriter(findQuery, intent, out); err != nil {
-			return err
+		log.Logf(log.Always, "writing %v to %v", intent.Key(), outName)
+		findQuery := dump.findQuery(session, intent, nil)
+		if dumped, err = dump.dumpQueryToWriter(findQuery, intent, dump.redactors[intent.Key()], counter); err != nil {
+			return 0, 0, err
 		}
 	} else {
 		// handle repairs as a special case, since we cannot count them
-		log.Logf(log.Always, "writing repair of %v to %v", intent.Key(), outFilepath)
+		log.Logf(log.Always, "writing repair of %v to %v", intent.Key(), outName)
 		repairIter := session.DB(intent.DB).C(intent.C).Repair()
 		repairCounter := 0
-		if err := dump.dumpIterToWriter(repairIter, out, &repairCounter); err != nil {
+		if err := dump.dumpIterToWriter(repairIter, dump.redactors[intent.Key()], counter, &repairCounter); err != nil {
 			if strings.Index(err.Error(), "no such cmd: repairCursor") > 0 {
 				// return a more helpful error message for early server versions
-				return fmt.Errorf(
+				return 0, 0, fmt.Errorf(
 					"error: --repair flag cannot be used on mongodb versions before 2.7.8.")
 			}
-			return fmt.Errorf("repair error: %v", err)
+			return 0, 0, fmt.Errorf("repair error: %v", err)
 		}
 		log.Logf(log.Always,
 			"\trepair cursor found %v documents in %v", repairCounter, intent.Key())
+		dumped = repairCounter
+	}
+	// close explicitly so that compressed output is fully flushed to disk
+	if err = out.Close(); err != nil {
+		return 0, 0, fmt.Errorf("error closing bson output for %v: %v", intent.Key(), err)
 	}
+	return dumped, counter.count, nil
+}
 
-	// don't dump metatdata for SystemIndexes collection
-	if intent.IsSystemIndexes() {
-		return nil
+// findQuery builds the query for dumping the intent's collection, restricted
+// to the documents matching filter if it is not nil. The collection is queried
+// with --query, or with the query, projection, sort and limit that the intent
+// matched in --queryFile.
+func (dump *MongoDump) findQuery(session *mgo.Session, intent *intents.Intent, filter bson.M) *mgo.Query {
+	query := dump.query
+	nsQuery := dump.intentQueries[intent.Key()]
+	if nsQuery != nil {
+		query = nsQuery.Query
+	}
+	var selector interface{}
+	switch {
+	case len(query) > 0 && filter != nil:
+		selector = bson.M{"$and": []bson.M{query, filter}}
+	case len(query) > 0:
+		selector = query
+	case filter != nil:
+		selector = filter
+	}
+	findQuery := session.DB(intent.DB).C(intent.C).Find(selector)
+	sorted := false
+	if nsQuery != nil {
+		if len(nsQuery.Projection) > 0 {
+			findQuery = findQuery.Select(nsQuery.Projection)
+		}
+		if len(nsQuery.Sort) > 0 {
+			findQuery = findQuery.Sort(nsQuery.Sort...)
+			sorted = true
+		}
+		if nsQuery.Limit > 0 {
+			findQuery = findQuery.Limit(nsQuery.Limit)
+		}
+	}
+	if len(query) > 0 || sorted || dump.InputOptions.TableScan {
+		// ---forceTablesScan runs the query without snapshot enabled
+		return findQuery
 	}
+	return findQuery.Snapshot()
+}
 
-	metadataFilepath := filepath.Join(dbFolder, fmt.Sprintf("%v.metadata.json", intent.C))
-	metaOut, err := os.Create(metadataFilepath)
-	if err != nil {
-		return fmt.Errorf("error creating metadata.json file `%v`: %v", outFilepath, err)
+// withExtension appends the given file extension to a base file name, followed
+// by the extension of the compression codec if compression is enabled.
+func (dump *MongoDump) withExtension(base, ext string) string {
+	if dump.compression != nil {
+		return base + ext + dump.compression.Extension
 	}
-	defer metaOut.Close()
+	return base + ext
+}
 
-	log.Logf(log.Always, "writing %v metadata to %v", intent.Key(), metadataFilepath)
-	if err = dump.dumpMetadataToWriter(intent.DB, intent.C, metaOut); err != nil {
-		return err
+// createOutputFile creates the file at the given path for writing dump output.
+// If compression or encryption is enabled, everything written to the returned
+// WriteCloser is compressed and then encrypted, and it must be closed to flush
+// the compressed and encrypted streams.
+func (dump *MongoDump) createOutputFile(path string) (io.WriteCloser, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	var out io.WriteCloser = file
+	if dump.report != nil {
+		// checksum the file as it is written, for the dump report
+		out = &checksumFile{file: file, hash: sha256.New(), report: dump.report}
+	}
+	if dump.encryption != nil {
+		if out, err = dump.encryption.WrapWriteCloser(out); err != nil {
+			file.Close()
+			return nil, err
+		}
 	}
+	if dump.compression == nil {
+		return out, nil
+	}
+	compressedOut, err := dump.compression.WrapWriteCloser(out)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return compressedOut, nil
+}
 
-	log.Logf(log.Always, "done dumping %v", intent.Key())
-	return nil
+// reportIntent adds a dumped intent to the dump report, if there is one
+func (dump *MongoDump) reportIntent(intent *intents.Intent, documents int, bytes int64, indexes int, startTime time.Time) {
+	if dump.report == nil {
+		return
+	}
+	dump.report.AddIntent(intent, dump.partsPath(intent), &ReportIntent{
+		Documents:      int64(documents),
+		Bytes:          bytes,
+		Indexes:        indexes,
+		ElapsedSeconds: time.Now().Sub(startTime).Seconds(),
+	})
 }
 
-// dumpQueryToWriter takes an mgo Query, its intent, and a writer, performs the query,
-// and writes the raw bson results to the writer.
+// dumpQueryToWriter takes an mgo Query, its intent, a redactor, and a writer, performs
+// the query, and writes the raw bson results to the writer, redacted if the redactor
+// is not nil. It returns the number of documents written.
 func (dump *MongoDump) dumpQueryToWriter(
-	query *mgo.Query, intent *intents.Intent, writer io.Writer) (err error) {
+	query *mgo.Query, intent *intents.Intent, redactor *Redactor, writer io.Writer) (int, error) {
 
 	dumpCounter := 0
 
 	total, err := query.Count()
 	if err != nil {
-		return fmt.Errorf("error reading from db: %v", err)
+		return 0, fmt.Errorf("error reading from db: %v", err)
 	}
 	log.Logf(log.Info, "\t%v documents", total)
 
@@ -407,13 +787,15 @@ func (dump *MongoDump) dumpQueryToWriter(
 	// this allows disk i/o to not block reads from the db,
 	// which gives a slight speedup on benchmarks
 	iter := query.Iter()
-	return dump.dumpIterToWriter(iter, writer, &dumpCounter)
+	err = dump.dumpIterToWriter(iter, redactor, writer, &dumpCounter)
+	return dumpCounter, err
 }
 
-// dumpIterToWriter takes an mgo iterator, a writer, and a pointer to
-// a counter, and dumps the iterator's contents to the writer.
+// dumpIterToWriter takes an mgo iterator, a redactor, a writer, and a pointer
+// to a counter, and dumps the iterator's contents to the writer, redacting
+// each document first if the redactor is not nil.
 func (dump *MongoDump) dumpIterToWriter(
-	iter *mgo.Iter, writer io.Writer, counterPtr *int) error {
+	iter *mgo.Iter, redactor *Redactor, writer io.Writer, counterPtr *int) error {
 
 	buffChan := make(chan []byte)
 	go func() {
@@ -451,6 +833,15 @@ func (dump *MongoDump) dumpIterToWriter(
 			}
 			break
 		}
+		if dump.throttle != nil {
+			dump.throttle.Wait(len(buff))
+		}
+		if redactor != nil {
+			var err error
+			if buff, err = redactor.Redact(buff); err != nil {
+				return err
+			}
+		}
 		_, err := w.Write(buff)
 		if err != nil {
 			return fmt.Errorf("error writing to file: %v", err)
@@ -477,29 +868,47 @@ func (dump *MongoDump) DumpUsersAndRolesForDB(db string) error {
 	dbQuery := bson.M{"db": db}
 	outDir := filepath.Join(dump.OutputOptions.Out, db)
 
-	usersFile, err := os.Create(filepath.Join(outDir, "$admin.system.users.bson"))
+	usersFile, err := dump.createUsersOrRolesOutput(db, outDir, "$admin.system.users")
 	if err != nil {
 		return fmt.Errorf("error creating file for db users: %v", err)
 	}
+	defer usersFile.Close()
 
 	usersQuery := session.DB("admin").C("system.users").Find(dbQuery)
-	err = dump.dumpQueryToWriter(
-		usersQuery, &intents.Intent{DB: "system", C: "users"}, usersFile)
+	_, err = dump.dumpQueryToWriter(
+		usersQuery, &intents.Intent{DB: "system", C: "users"}, nil, usersFile)
 	if err != nil {
 		return fmt.Errorf("error dumping db users: %v", err)
 	}
+	if err = usersFile.Close(); err != nil {
+		return fmt.Errorf("error closing file for db users: %v", err)
+	}
 
-	rolesFile, err := os.Create(filepath.Join(outDir, "$admin.system.roles.bson"))
+	rolesFile, err := dump.createUsersOrRolesOutput(db, outDir, "$admin.system.roles")
 	if err != nil {
 		return fmt.Errorf("error creating file for db roles: %v", err)
 	}
+	defer rolesFile.Close()
 
 	rolesQuery := session.DB("admin").C("system.roles").Find(dbQuery)
-	err = dump.dumpQueryToWriter(
-		rolesQuery, &intents.Intent{DB: "system", C: "roles"}, rolesFile)
+	_, err = dump.dumpQueryToWriter(
+		rolesQuery, &intents.Intent{DB: "system", C: "roles"}, nil, rolesFile)
 	if err != nil {
 		return fmt.Errorf("error dumping db roles: %v", err)
 	}
+	if err = rolesFile.Close(); err != nil {
+		return fmt.Errorf("error closing file for db roles: %v", err)
+	}
 
 	return nil
 }
+
+// createUsersOrRolesOutput returns the output for one of the special users
+// or roles collections of a database, either in the archive or as a file
+// in the database's dump folder.
+func (dump *MongoDump) createUsersOrRolesOutput(db, outDir, colName string) (io.WriteCloser, error) {
+	if dump.archive != nil {
+		return dump.archive.Open(db, colName), nil
+	}
+	return dump.createOutputFile(filepath.Join(outDir, dump.withExtension(colName, ".bson")))
+}
@@ -258,6 +258,30 @@ func TestMongoDumpValidateOptions(t *testing.T) {
 			So(err.Error(), ShouldContainSubstring, "cannot dump using a query without a specified collection")
 		})
 
+		Convey("we can only dump incrementally for a full dump", func() {
+			md.OutputOptions.IncrementalFrom = "dump"
+
+			err := md.Init()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "--incrementalFrom is only supported on full dumps")
+		})
+
+		Convey("--config is the same option as --queryFile", func() {
+			md.InputOptions.Config = "queries.yaml"
+			md.InputOptions.Query = "{_id:\"\"}"
+			md.ToolOptions.Namespace.Collection = "some_collection"
+
+			err := md.Init()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "cannot use --query together with --queryFile")
+			So(md.InputOptions.QueryFile, ShouldEqual, "queries.yaml")
+
+			md.InputOptions.QueryFile = "other.yaml"
+			err = md.Init()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "cannot use both --queryFile and --config")
+		})
+
 	})
 }
 
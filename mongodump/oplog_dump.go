@@ -2,9 +2,17 @@ package mongodump
 
 import (
 	"fmt"
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/json"
 	"github.com/mongodb/mongo-tools/common/log"
 	"github.com/mongodb/mongo-tools/common/util"
 	"gopkg.in/mgo.v2/bson"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 )
 
 //TODO move this to common if any of the other tools need it
@@ -18,6 +26,15 @@ type Oplog struct {
 	Query     bson.M              `bson:"o2"`
 }
 
+// OplogMetadata records the range of oplog entries captured in a dump's
+// oplog.bson: every entry after Start, up to and including End. It is
+// written to oplog.metadata.json in the root of the dump folder, so that
+// an incremental dump can pick up where the previous dump left off.
+type OplogMetadata struct {
+	Start bson.MongoTimestamp
+	End   bson.MongoTimestamp
+}
+
 // determineOplogCollectionName uses a command to infer
 // the name of the oplog collection in the connected db
 func (dump *MongoDump) determineOplogCollectionName() error {
@@ -26,6 +43,10 @@ func (dump *MongoDump) determineOplogCollectionName() error {
 	if err != nil {
 		return fmt.Errorf("error running command: %v", err)
 	}
+	if masterDoc["msg"] == "isdbgrid" {
+		return fmt.Errorf("cannot capture the oplog through a mongos; " +
+			"use --cluster to dump every shard with its own oplog")
+	}
 	if _, ok := masterDoc["hosts"]; ok {
 		log.Logf(log.DebugLow, "determined cluster to be a replica set")
 		log.Logf(log.DebugHigh, "oplog located in local.oplog.rs")
@@ -77,3 +98,251 @@ func (dump *MongoDump) checkOplogTimestampExists(ts bson.MongoTimestamp) (bool,
 	}
 	return true, nil
 }
+
+// DumpOplogBetweenTimestamps writes every oplog entry after start, up to
+// and including end, to the oplog output of the dump. For dumps written
+// to a folder, the captured range is recorded in oplog.metadata.json.
+func (dump *MongoDump) DumpOplogBetweenTimestamps(start, end bson.MongoTimestamp) error {
+	// dump oplog in root of the dump folder
+	var oplogOut io.WriteCloser
+	var err error
+	oplogFilepath := filepath.Join(dump.OutputOptions.Out, dump.withExtension("oplog", ".bson"))
+	if dump.archive != nil {
+		oplogFilepath = "archive"
+		oplogOut = dump.archive.Open("", "oplog")
+	} else {
+		oplogOut, err = dump.createOutputFile(oplogFilepath)
+		if err != nil {
+			return fmt.Errorf("error creating bson file `%v`: %v", oplogFilepath, err)
+		}
+	}
+	defer oplogOut.Close()
+
+	log.Logf(log.Always, "writing captured oplog to %v", oplogFilepath)
+	//TODO encapsulate this logic
+	session, err := dump.sessionProvider.GetSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	session.SetSocketTimeout(0)
+	session.SetPrefetch(1.0) //mimic exhaust cursor
+	queryObj := bson.M{"ts": bson.M{"$gt": start, "$lte": end}}
+	oplogQuery := session.DB("local").C(dump.oplogCollection).Find(queryObj).LogReplay()
+	_, err = dump.dumpQueryToWriter(
+		oplogQuery, &intents.Intent{DB: "local", C: dump.oplogCollection}, nil, oplogOut)
+	if err != nil {
+		return err
+	}
+	if err = oplogOut.Close(); err != nil {
+		return fmt.Errorf("error closing bson file `%v`: %v", oplogFilepath, err)
+	}
+
+	// check the oplog for a rollover one last time, to avoid a race condition
+	// wherein the oplog rolls over in the time after our first check, but before
+	// we copy it.
+	log.Logf(log.DebugLow, "checking again if oplog entry %v still exists", start)
+	exists, err := dump.checkOplogTimestampExists(start)
+	if !exists {
+		return fmt.Errorf(
+			"oplog overflow: mongodump was unable to capture all new oplog entries during execution")
+	}
+	if err != nil {
+		return fmt.Errorf("unable to check oplog for overflow: %v", err)
+	}
+	log.Logf(log.DebugHigh, "oplog entry %v still exists", start)
+
+	if dump.archive != nil {
+		return nil
+	}
+	if dump.report != nil {
+		dump.report.SetOplogRange(start, end)
+	}
+	return dump.writeOplogMetadata(&OplogMetadata{Start: start, End: end})
+}
+
+// DumpIncremental dumps only the oplog entries written since the dump
+// in the --incrementalFrom folder was taken. The new dump records its
+// own range, so incrementals can be chained one after another.
+func (dump *MongoDump) DumpIncremental() error {
+	previous, err := ReadOplogMetadata(dump.OutputOptions.IncrementalFrom, dump.encryption)
+	if err != nil {
+		return fmt.Errorf("error reading previous dump: %v", err)
+	}
+	log.Logf(log.Always, "dumping oplog entries since %v from previous dump %v",
+		previous.End, dump.OutputOptions.IncrementalFrom)
+
+	err = dump.determineOplogCollectionName()
+	if err != nil {
+		return fmt.Errorf("error finding oplog: %v", err)
+	}
+
+	// the last entry of the previous dump must still be in the oplog,
+	// or we cannot know that no entries were lost in between
+	log.Logf(log.DebugLow, "checking if oplog entry %v still exists", previous.End)
+	exists, err := dump.checkOplogTimestampExists(previous.End)
+	if err != nil {
+		return fmt.Errorf("unable to check oplog for overflow: %v", err)
+	}
+	if !exists {
+		return fmt.Errorf("oplog overflow: the oplog has rolled over since the dump in %v "+
+			"was taken; a new full dump is required", dump.OutputOptions.IncrementalFrom)
+	}
+
+	log.Logf(log.Info, "getting most recent oplog timestamp")
+	end, err := dump.getOplogStartTime()
+	if err != nil {
+		return fmt.Errorf("error getting oplog end: %v", err)
+	}
+	if end < previous.End {
+		// nothing has been written since, but keep the chain unbroken
+		end = previous.End
+	}
+
+	if err = os.MkdirAll(dump.OutputOptions.Out, DumpDefaultPermissions); err != nil {
+		return fmt.Errorf("error creating directory `%v`: %v", dump.OutputOptions.Out, err)
+	}
+
+	dump.progressManager.Start()
+	defer dump.progressManager.Stop()
+
+	if err = dump.prepareReport(); err != nil {
+		return err
+	}
+	err = dump.DumpOplogBetweenTimestamps(previous.End, end)
+	if err != nil {
+		return err
+	}
+	log.Logf(log.Always, "writing dump report to %v",
+		filepath.Join(dump.OutputOptions.Out, ReportFilename))
+	if err = dump.report.Write(); err != nil {
+		return err
+	}
+	log.Logf(log.Info, "done")
+	return nil
+}
+
+// writeOplogMetadata writes oplog.metadata.json to the root of the dump folder
+func (dump *MongoDump) writeOplogMetadata(meta *OplogMetadata) error {
+	startJSON, err := bsonutil.ConvertBSONValueToJSON(meta.Start)
+	if err != nil {
+		return err
+	}
+	endJSON, err := bsonutil.ConvertBSONValueToJSON(meta.End)
+	if err != nil {
+		return err
+	}
+	jsonBytes, err := json.Marshal(map[string]interface{}{
+		"start": startJSON,
+		"end":   endJSON,
+	})
+	if err != nil {
+		return fmt.Errorf("error marshalling oplog metadata: %v", err)
+	}
+
+	metadataFilepath := filepath.Join(dump.OutputOptions.Out, dump.withExtension("oplog.metadata", ".json"))
+	out, err := dump.createOutputFile(metadataFilepath)
+	if err != nil {
+		return fmt.Errorf("error creating oplog metadata file `%v`: %v", metadataFilepath, err)
+	}
+	defer out.Close()
+	if _, err = out.Write(jsonBytes); err != nil {
+		return fmt.Errorf("error writing oplog metadata file `%v`: %v", metadataFilepath, err)
+	}
+	if err = out.Close(); err != nil {
+		return fmt.Errorf("error closing oplog metadata file `%v`: %v", metadataFilepath, err)
+	}
+	return nil
+}
+
+// ReadOplogMetadata returns the range of oplog entries captured by the dump
+// in the given folder. Dumps written before oplog.metadata.json existed only
+// record their end implicitly, as the timestamp of the last entry in oplog.bson.
+// The key decrypts the dump's files if it was encrypted.
+func ReadOplogMetadata(dumpDir string, key *util.EncryptionKey) (*OplogMetadata, error) {
+	metadataPath, err := findDumpFile(dumpDir, "oplog.metadata.json")
+	if err == nil {
+		return readOplogMetadataFile(metadataPath, key)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	oplogPath, err := findDumpFile(dumpDir, "oplog.bson")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no oplog found in %v; it must have been dumped "+
+				"with --oplog or --incrementalFrom", dumpDir)
+		}
+		return nil, err
+	}
+	log.Logf(log.DebugLow, "no oplog metadata in %v, reading last entry of %v", dumpDir, oplogPath)
+	oplogFile, err := util.OpenDecrypted(oplogPath, key)
+	if err != nil {
+		return nil, err
+	}
+	bsonSource := db.NewDecodedBSONSource(db.NewBSONSource(oplogFile))
+	defer bsonSource.Close()
+	meta := &OplogMetadata{}
+	entry := Oplog{}
+	for bsonSource.Next(&entry) {
+		meta.End = entry.Timestamp
+	}
+	if err = bsonSource.Err(); err != nil {
+		return nil, fmt.Errorf("error reading %v: %v", oplogPath, err)
+	}
+	if meta.End == 0 {
+		return nil, fmt.Errorf("cannot tell where the oplog in %v ends, as it is empty", dumpDir)
+	}
+	return meta, nil
+}
+
+func readOplogMetadataFile(path string, key *util.EncryptionKey) (*OplogMetadata, error) {
+	file, err := util.OpenDecrypted(path, key)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseOplogMetadata(file, path)
+}
+
+// ParseOplogMetadata reads the decrypted and decompressed contents of
+// an oplog.metadata.json file, where path names the file in errors
+func ParseOplogMetadata(in io.Reader, path string) (*OplogMetadata, error) {
+	jsonBytes, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %v: %v", path, err)
+	}
+	doc := map[string]interface{}{}
+	if err = json.Unmarshal(jsonBytes, &doc); err != nil {
+		return nil, fmt.Errorf("error parsing %v: %v", path, err)
+	}
+	if err = bsonutil.ConvertJSONDocumentToBSON(doc); err != nil {
+		return nil, fmt.Errorf("error parsing %v: %v", path, err)
+	}
+	meta := &OplogMetadata{}
+	var ok bool
+	if meta.Start, ok = doc["start"].(bson.MongoTimestamp); !ok {
+		return nil, fmt.Errorf("%v is missing a valid start timestamp", path)
+	}
+	if meta.End, ok = doc["end"].(bson.MongoTimestamp); !ok {
+		return nil, fmt.Errorf("%v is missing a valid end timestamp", path)
+	}
+	return meta, nil
+}
+
+// findDumpFile returns the path of the named file in the given folder,
+// accounting for it having been compressed with any known codec.
+func findDumpFile(dir, name string) (string, error) {
+	path := filepath.Join(dir, name)
+	_, err := os.Stat(path)
+	if err == nil {
+		return path, nil
+	}
+	for _, codec := range util.CompressionCodecs {
+		if _, codecErr := os.Stat(path + codec.Extension); codecErr == nil {
+			return path + codec.Extension, nil
+		}
+	}
+	return "", err
+}
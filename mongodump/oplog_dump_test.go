@@ -0,0 +1,69 @@
+package mongodump
+
+import (
+	"github.com/mongodb/mongo-tools/common/testutil"
+	"github.com/mongodb/mongo-tools/common/util"
+	"github.com/mongodb/mongo-tools/mongodump/options"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOplogMetadata(t *testing.T) {
+
+	testutil.VerifyTestType(t, testutil.UNIT_TEST_TYPE)
+
+	Convey("With a temporary dump folder", t, func() {
+		dumpDir, err := ioutil.TempDir("", "mongodump_oplog_test")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(dumpDir)
+		})
+		start := bson.MongoTimestamp(1414088866<<32 | 1)
+		end := bson.MongoTimestamp(1414089000<<32 | 7)
+
+		Convey("recorded oplog metadata should read back unchanged", func() {
+			md := &MongoDump{OutputOptions: &options.OutputOptions{Out: dumpDir}}
+			So(md.writeOplogMetadata(&OplogMetadata{Start: start, End: end}), ShouldBeNil)
+			meta, err := ReadOplogMetadata(dumpDir, nil)
+			So(err, ShouldBeNil)
+			So(meta.Start, ShouldEqual, start)
+			So(meta.End, ShouldEqual, end)
+		})
+
+		Convey("compressed oplog metadata should be found as well", func() {
+			codec, err := util.GetCompressionCodec("gzip")
+			So(err, ShouldBeNil)
+			md := &MongoDump{
+				OutputOptions: &options.OutputOptions{Out: dumpDir},
+				compression:   codec,
+			}
+			So(md.writeOplogMetadata(&OplogMetadata{Start: start, End: end}), ShouldBeNil)
+			meta, err := ReadOplogMetadata(dumpDir, nil)
+			So(err, ShouldBeNil)
+			So(meta.End, ShouldEqual, end)
+		})
+
+		Convey("without metadata, the last entry of oplog.bson should be the end", func() {
+			raw := []byte{}
+			for _, ts := range []bson.MongoTimestamp{start, end} {
+				entry, err := bson.Marshal(Oplog{Timestamp: ts, Operation: "n"})
+				So(err, ShouldBeNil)
+				raw = append(raw, entry...)
+			}
+			err := ioutil.WriteFile(filepath.Join(dumpDir, "oplog.bson"), raw, 0644)
+			So(err, ShouldBeNil)
+			meta, err := ReadOplogMetadata(dumpDir, nil)
+			So(err, ShouldBeNil)
+			So(meta.End, ShouldEqual, end)
+		})
+
+		Convey("a folder without any oplog should be an error", func() {
+			_, err := ReadOplogMetadata(dumpDir, nil)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
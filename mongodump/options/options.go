@@ -4,6 +4,8 @@ package options
 
 type InputOptions struct {
 	Query     string `long:"query" short:"q" description:"query filter, as a JSON string, e.g., '{x:{$gt:1}}'"`
+	QueryFile string `long:"queryFile" description:"path to a JSON or YAML (.yaml, .yml) file mapping namespaces, which may contain wildcards, to the query, projection, sort and limit to dump them with"`
+	Config    string `long:"config" description:"alternative flag for --queryFile"`
 	TableScan bool   `long:"forceTableScan" description:"force a table scan"`
 	//SlaveOk
 }
@@ -15,11 +17,25 @@ func (self *InputOptions) Name() string {
 type OutputOptions struct {
 	Out                        string   `long:"out" short:"o" description:"output directory or - for stdout" default:"dump"`
 	Repair                     bool     `long:"repair" description:"try to recover a crashed database"`
+	Gzip                       bool     `long:"gzip" description:"compress collection and metadata output with gzip"`
+	Archive                    string   `long:"archive" optional:"true" optional-value:"-" description:"dump everything into a single archive at the given path (--archive=<file>), or to stdout if no path is given"`
+	Repository                 string   `long:"repository" description:"dump into a new snapshot of the deduplicating repository at the given path, storing only the chunks of data it does not already have"`
+	EncryptionKeyFile          string   `long:"encryptionKeyFile" description:"encrypt every file of the dump with AES-256-GCM, using the 256-bit key (64 hex digits) or the passphrase held in the given file"`
+	Resume                     bool     `long:"resume" description:"resume an interrupted dump into the output directory, skipping collections its manifest records as complete; it must be given the same --gzip, --encryptionKeyFile, --query, --queryFile and --redactionFile"`
 	Oplog                      bool     `long:"oplog" description:"Use oplog for point-in-time snapshotting"`
+	IncrementalFrom            string   `long:"incrementalFrom" description:"dump only the oplog entries written since the dump in the given directory, which must have been taken with --oplog or --incrementalFrom"`
+	RedactionFile              string   `long:"redactionFile" description:"path to a JSON file of per-namespace rules for dropping, hashing, replacing, truncating or faking fields of the dumped documents"`
+	Cluster                    bool     `long:"cluster" description:"when connected to a mongos, dump the config database, then every shard directly and in parallel, each with its own oplog, for a near-consistent point in time across the cluster"`
+	StopBalancer               bool     `long:"stopBalancer" description:"stop the balancer of the cluster while dumping with --cluster, and start it again once done"`
 	DumpDBUsersAndRoles        bool     `long:"dumpDbUsersAndRoles" description:"Dump user and role definitions for the given database"`
 	ExcludedCollections        []string `long:"excludeCollection" description:"Collections to exclude from the dump"`
 	ExcludedCollectionPrefixes []string `long:"excludeCollectionsWithPrefix" description:"Exclude all collections from the dump that have the given prefix"`
 	JobThreads                 int      `long:"numParallelCollections" short:"j" description:"Number of collections to dump in parallel" default:"4"`
+	NumParallelRanges          int      `long:"numParallelRanges" description:"Number of _id ranges to split collections larger than --rangeThresholdMB into, and dump in parallel" default:"1"`
+	RangeThresholdMB           int      `long:"rangeThresholdMB" description:"Size in megabytes above which --numParallelRanges splits a collection" default:"1024"`
+	MaxBytesPerSec             int64    `long:"maxBytesPerSec" description:"limit the rate at which the whole dump reads documents from the server, in bytes per second"`
+	MaxDocsPerSec              int64    `long:"maxDocsPerSec" description:"limit the rate at which the whole dump reads documents from the server, in documents per second"`
+	ThrottleFile               string   `long:"throttleFile" description:"path to a JSON file with maxBytesPerSec and maxDocsPerSec limits, re-read whenever it changes or mongodump receives SIGHUP"`
 	MaxProcs                   int      `long:"numCPUThreads" description:"GOMAXPROCS for testing"` // TODO: hide this option
 }
 
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/mongodb/mongo-tools/common/intents"
 	"github.com/mongodb/mongo-tools/common/log"
+	"gopkg.in/mgo.v2/bson"
 	"os"
 	"path/filepath"
 	"strings"
@@ -40,12 +41,17 @@ func (dump *MongoDump) CreateIntentForCollection(dbName, colName string) error {
 		log.Logf(log.DebugLow, "skipping dump of %v.%v, it is excluded", dbName, colName)
 		return nil
 	}
+	if colName == "system.views" {
+		// views are recreated from their own metadata
+		log.Logf(log.DebugLow, "skipping dump of %v.%v", dbName, colName)
+		return nil
+	}
 
 	intent := &intents.Intent{
 		DB:           dbName,
 		C:            colName,
-		BSONPath:     dump.outputPath(dbName, colName) + ".bson",
-		MetadataPath: dump.outputPath(dbName, colName) + ".metadata.json",
+		BSONPath:     dump.withExtension(dump.outputPath(dbName, colName), ".bson"),
+		MetadataPath: dump.withExtension(dump.outputPath(dbName, colName), ".metadata.json"),
 	}
 
 	// add stdout flags if we're using stdout
@@ -54,6 +60,21 @@ func (dump *MongoDump) CreateIntentForCollection(dbName, colName string) error {
 		intent.MetadataPath = "-"
 	}
 
+	// views have no data of their own, only metadata holding their definition
+	isView, err := dump.isView(dbName, colName)
+	if err != nil {
+		return err
+	}
+	if isView {
+		if dump.useStdout {
+			return fmt.Errorf("cannot dump view %v.%v to stdout", dbName, colName)
+		}
+		intent.BSONPath = ""
+		dump.manager.Put(intent)
+		log.Logf(log.DebugLow, "enqueued view '%v'", intent.Key())
+		return nil
+	}
+
 	// get a document count for scheduling purposes
 	session, err := dump.sessionProvider.GetSession()
 	if err != nil {
@@ -61,7 +82,25 @@ func (dump *MongoDump) CreateIntentForCollection(dbName, colName string) error {
 	}
 	defer session.Close()
 
-	count, err := session.DB(dbName).C(colName).Count()
+	// collections matched in --queryFile are dumped, and so counted, with their
+	// query; index definitions are always dumped whole so they can be restored
+	var countQuery bson.M
+	var limit int
+	nsQuery := MatchNamespaceQuery(dump.namespaceQueries, intent.Key())
+	if nsQuery != nil && !intent.IsSystemIndexes() {
+		log.Logf(log.DebugLow, "dumping %v with the query for '%v' in %v",
+			intent.Key(), nsQuery.Pattern, dump.InputOptions.QueryFile)
+		dump.intentQueries[intent.Key()] = nsQuery
+		countQuery = nsQuery.Query
+		limit = nsQuery.Limit
+	}
+	if dump.redaction != nil && !intent.IsSystemIndexes() {
+		if redactor := dump.redaction.RedactorFor(intent.Key()); redactor != nil {
+			log.Logf(log.DebugLow, "redacting documents of %v", intent.Key())
+			dump.redactors[intent.Key()] = redactor
+		}
+	}
+	count, err := session.DB(dbName).C(colName).Find(countQuery).Limit(limit).Count()
 	if err != nil {
 		return fmt.Errorf("error counting %v: %v", intent.Key(), err)
 	}
@@ -77,10 +116,12 @@ func (dump *MongoDump) CreateIntentForCollection(dbName, colName string) error {
 // and builds dump intents for each collection.
 func (dump *MongoDump) CreateIntentsForDatabase(dbName string) error {
 	// we must ensure folders for empty databases are still created, for legacy purposes
-	dbFolder := filepath.Join(dump.OutputOptions.Out, dbName)
-	err := os.MkdirAll(dbFolder, DumpDefaultPermissions)
-	if err != nil {
-		return fmt.Errorf("error creating directory `%v`: %v", dbFolder, err)
+	if dump.OutputOptions.Archive == "" && dump.OutputOptions.Repository == "" {
+		dbFolder := filepath.Join(dump.OutputOptions.Out, dbName)
+		err := os.MkdirAll(dbFolder, DumpDefaultPermissions)
+		if err != nil {
+			return fmt.Errorf("error creating directory `%v`: %v", dbFolder, err)
+		}
 	}
 
 	cols, err := dump.sessionProvider.CollectionNames(dbName)
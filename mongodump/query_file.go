@@ -0,0 +1,254 @@
+package mongodump
+
+import (
+	"bytes"
+	encjson "encoding/json"
+	"fmt"
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/json"
+	"gopkg.in/mgo.v2/bson"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// NamespaceQuery restricts what is dumped of the collections whose
+// namespace matches Pattern, as read from a --queryFile. Sort holds field
+// names in the form taken by mgo's Query.Sort, with a "-" prefix for
+// descending order.
+type NamespaceQuery struct {
+	Pattern    string
+	Query      bson.M
+	Projection bson.M
+	Sort       []string
+	Limit      int
+}
+
+// ParseQueryFile parses the contents of a JSON --queryFile. The file is
+// an object mapping namespace patterns, which may use shell wildcards (e.g.
+// "app.*" or "*.users"), to the query to dump the matching collections with:
+//
+//	{
+//	  "app.users":  {"query": {"deleted": false}, "projection": {"password": 0}},
+//	  "app.events": {"query": {"ts": {"$gte": {"$date": "2014-10-01T00:00:00Z"}}},
+//	                 "sort": ["-ts"], "limit": 100000},
+//	  "logs.*":     {"limit": 1000}
+//	}
+//
+// Queries and projections may use extended JSON. Patterns are returned in
+// the order they appear in the file, which is the order they are matched in.
+func ParseQueryFile(data []byte) ([]*NamespaceQuery, error) {
+	doc, err := json.UnmarshalBsonD(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing query file as json: %v", err)
+	}
+	queries := []*NamespaceQuery{}
+	for _, elem := range doc {
+		if _, err := path.Match(elem.Name, ""); err != nil {
+			return nil, fmt.Errorf("invalid namespace pattern '%v' in query file: %v", elem.Name, err)
+		}
+		spec, ok := elem.Value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("value for '%v' in query file must be an object", elem.Name)
+		}
+		nsQuery, err := parseNamespaceQuery(elem.Name, spec)
+		if err != nil {
+			return nil, fmt.Errorf("error in query file for '%v': %v", elem.Name, err)
+		}
+		queries = append(queries, nsQuery)
+	}
+	return queries, nil
+}
+
+// ParseYAMLQueryFile parses a --queryFile written in YAML, which has the
+// same layout as the JSON form, for instance:
+//
+//	app.users:
+//	  query: {deleted: false}
+//	  projection: {password: 0}
+//	app.events:
+//	  query: {ts: {$gte: {$date: "2014-10-01T00:00:00Z"}}}
+//	  sort: [-ts]
+//	  limit: 100000
+//
+// It is converted to JSON, so that queries may use extended JSON
+// in the same way.
+func ParseYAMLQueryFile(data []byte) ([]*NamespaceQuery, error) {
+	doc := yaml.MapSlice{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("error parsing query file as yaml: %v", err)
+	}
+	// the document is written out key by key to keep the patterns in order
+	jsonData := &bytes.Buffer{}
+	jsonData.WriteString("{")
+	for i, item := range doc {
+		pattern, ok := item.Key.(string)
+		if !ok {
+			return nil, fmt.Errorf("namespace pattern '%v' in query file must be a string", item.Key)
+		}
+		value, err := yamlToJSONValue(item.Value)
+		if err != nil {
+			return nil, fmt.Errorf("error in query file for '%v': %v", pattern, err)
+		}
+		key, _ := encjson.Marshal(pattern)
+		valueJSON, err := encjson.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("error in query file for '%v': %v", pattern, err)
+		}
+		if i > 0 {
+			jsonData.WriteString(",")
+		}
+		jsonData.Write(key)
+		jsonData.WriteString(":")
+		jsonData.Write(valueJSON)
+	}
+	jsonData.WriteString("}")
+	return ParseQueryFile(jsonData.Bytes())
+}
+
+// yamlToJSONValue converts the mappings of a decoded YAML value, whose
+// keys may be of any type, into objects that can be written as JSON.
+// Query field order is not kept, as queries are read into bson.M.
+func yamlToJSONValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case yaml.MapSlice:
+		// mappings within a yaml.MapSlice are decoded as MapSlices too
+		object := map[string]interface{}{}
+		for _, item := range v {
+			name, ok := item.Key.(string)
+			if !ok {
+				return nil, fmt.Errorf("field name '%v' must be a string", item.Key)
+			}
+			converted, err := yamlToJSONValue(item.Value)
+			if err != nil {
+				return nil, err
+			}
+			object[name] = converted
+		}
+		return object, nil
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			converted, err := yamlToJSONValue(item)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = converted
+		}
+		return list, nil
+	}
+	return value, nil
+}
+
+// parseNamespaceQuery converts the query, projection, sort and
+// limit of a single query file entry.
+func parseNamespaceQuery(pattern string, spec map[string]interface{}) (*NamespaceQuery, error) {
+	nsQuery := &NamespaceQuery{Pattern: pattern}
+	for key, value := range spec {
+		var err error
+		switch key {
+		case "query":
+			nsQuery.Query, err = convertQueryDocument(value)
+		case "projection":
+			nsQuery.Projection, err = convertQueryDocument(value)
+		case "sort":
+			nsQuery.Sort, err = convertSortFields(value)
+		case "limit":
+			nsQuery.Limit, err = convertLimit(value)
+		default:
+			err = fmt.Errorf("unknown field '%v'", key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nsQuery, nil
+}
+
+// convertQueryDocument converts an extended JSON object to bson
+func convertQueryDocument(value interface{}) (bson.M, error) {
+	converted, err := bsonutil.ConvertJSONValueToBSON(value)
+	if err != nil {
+		return nil, fmt.Errorf("error converting to bson: %v", err)
+	}
+	asMap, ok := converted.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("query and projection must be objects")
+	}
+	return bson.M(asMap), nil
+}
+
+// convertSortFields reads a sort given as a list of field names
+func convertSortFields(value interface{}) ([]string, error) {
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("sort must be a list of field names, e.g. [\"-ts\", \"_id\"]")
+	}
+	fields := []string{}
+	for _, field := range list {
+		name, ok := field.(string)
+		if !ok || name == "" || name == "-" {
+			return nil, fmt.Errorf("invalid sort field '%v'", field)
+		}
+		fields = append(fields, name)
+	}
+	return fields, nil
+}
+
+// convertLimit reads a non-negative limit
+func convertLimit(value interface{}) (int, error) {
+	var limit int
+	switch v := value.(type) {
+	case int:
+		limit = v
+	case int32:
+		limit = int(v)
+	case int64:
+		limit = int(v)
+	case float64:
+		if v != float64(int(v)) {
+			return 0, fmt.Errorf("limit must be a whole number")
+		}
+		limit = int(v)
+	default:
+		return 0, fmt.Errorf("limit must be a number")
+	}
+	if limit < 0 {
+		return 0, fmt.Errorf("limit must not be negative")
+	}
+	return limit, nil
+}
+
+// MatchNamespaceQuery returns the first of the given queries whose
+// pattern matches the namespace, or nil if none does.
+func MatchNamespaceQuery(queries []*NamespaceQuery, ns string) *NamespaceQuery {
+	for _, nsQuery := range queries {
+		// patterns were validated when parsed
+		if matched, _ := path.Match(nsQuery.Pattern, ns); matched {
+			return nsQuery
+		}
+	}
+	return nil
+}
+
+// loadQueryFile reads the namespace queries of --queryFile, as YAML
+// if its extension is .yaml or .yml and as JSON otherwise
+func (dump *MongoDump) loadQueryFile() error {
+	data, err := ioutil.ReadFile(dump.InputOptions.QueryFile)
+	if err != nil {
+		return fmt.Errorf("error reading query file: %v", err)
+	}
+	switch strings.ToLower(filepath.Ext(dump.InputOptions.QueryFile)) {
+	case ".yaml", ".yml":
+		dump.namespaceQueries, err = ParseYAMLQueryFile(data)
+	default:
+		dump.namespaceQueries, err = ParseQueryFile(data)
+	}
+	if err != nil {
+		return err
+	}
+	dump.intentQueries = map[string]*NamespaceQuery{}
+	return nil
+}
@@ -0,0 +1,107 @@
+package mongodump
+
+import (
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestParseQueryFile(t *testing.T) {
+
+	testutil.VerifyTestType(t, testutil.UNIT_TEST_TYPE)
+
+	Convey("With a query file for several namespaces", t, func() {
+		queries, err := ParseQueryFile([]byte(`{
+			"app.users": {"query": {"deleted": false}, "projection": {"password": 0}},
+			"app.events": {"query": {"ts": {"$gte": {"$date": "2014-10-01T00:00:00Z"}}},
+				"sort": ["-ts", "_id"], "limit": 1000},
+			"app.*": {"limit": 10}
+		}`))
+		So(err, ShouldBeNil)
+
+		Convey("the patterns should be kept in file order", func() {
+			So(len(queries), ShouldEqual, 3)
+			So(queries[0].Pattern, ShouldEqual, "app.users")
+			So(queries[1].Pattern, ShouldEqual, "app.events")
+			So(queries[2].Pattern, ShouldEqual, "app.*")
+		})
+
+		Convey("queries, projections, sorts and limits should be converted", func() {
+			So(queries[0].Query["deleted"], ShouldEqual, false)
+			So(queries[0].Projection["password"], ShouldEqual, 0)
+			So(queries[1].Sort, ShouldResemble, []string{"-ts", "_id"})
+			So(queries[1].Limit, ShouldEqual, 1000)
+			tsQuery, ok := queries[1].Query["ts"].(map[string]interface{})
+			So(ok, ShouldBeTrue)
+			_, ok = tsQuery["$gte"].(time.Time)
+			So(ok, ShouldBeTrue)
+		})
+
+		Convey("namespaces should match the first pattern that fits them", func() {
+			So(MatchNamespaceQuery(queries, "app.users"), ShouldEqual, queries[0])
+			So(MatchNamespaceQuery(queries, "app.events"), ShouldEqual, queries[1])
+			So(MatchNamespaceQuery(queries, "app.sessions"), ShouldEqual, queries[2])
+			So(MatchNamespaceQuery(queries, "other.users"), ShouldBeNil)
+		})
+	})
+
+	Convey("With the same query file written in YAML", t, func() {
+		queries, err := ParseYAMLQueryFile([]byte(`
+app.users:
+  query: {deleted: false}
+  projection: {password: 0}
+app.events:
+  query: {ts: {$gte: {$date: "2014-10-01T00:00:00Z"}}}
+  sort: [-ts, _id]
+  limit: 1000
+"app.*":
+  limit: 10
+`))
+		So(err, ShouldBeNil)
+
+		Convey("it should be read as the JSON one is", func() {
+			So(len(queries), ShouldEqual, 3)
+			So(queries[0].Pattern, ShouldEqual, "app.users")
+			So(queries[1].Pattern, ShouldEqual, "app.events")
+			So(queries[2].Pattern, ShouldEqual, "app.*")
+			So(queries[0].Query["deleted"], ShouldEqual, false)
+			So(queries[0].Projection["password"], ShouldEqual, 0)
+			So(queries[1].Sort, ShouldResemble, []string{"-ts", "_id"})
+			So(queries[1].Limit, ShouldEqual, 1000)
+			So(queries[2].Limit, ShouldEqual, 10)
+			tsQuery, ok := queries[1].Query["ts"].(map[string]interface{})
+			So(ok, ShouldBeTrue)
+			_, ok = tsQuery["$gte"].(time.Time)
+			So(ok, ShouldBeTrue)
+		})
+	})
+
+	Convey("Invalid YAML query files should be rejected", t, func() {
+		for _, data := range []string{
+			"app.users: [",
+			"- app.users",
+			"app.users: 5",
+			"app.users: {limit: -1}",
+			"1: {limit: 1}",
+		} {
+			_, err := ParseYAMLQueryFile([]byte(data))
+			So(err, ShouldNotBeNil)
+		}
+	})
+
+	Convey("Invalid query files should be rejected", t, func() {
+		for _, data := range []string{
+			`not json`,
+			`{"app.users": 5}`,
+			`{"app.users": {"query": 5}}`,
+			`{"app.users": {"sort": {"ts": 1}}}`,
+			`{"app.users": {"limit": -1}}`,
+			`{"app.users": {"filter": {}}}`,
+			`{"app.[users": {}}`,
+		} {
+			_, err := ParseQueryFile([]byte(data))
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
@@ -0,0 +1,261 @@
+package mongodump
+
+import (
+	"fmt"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// partsPath returns the folder that the numbered part files of a
+// collection dumped in _id ranges are written to
+func (dump *MongoDump) partsPath(intent *intents.Intent) string {
+	return dump.outputPath(intent.DB, intent.C) + ".parts"
+}
+
+// splitIntent returns the _id ranges to dump the intent's collection in,
+// as query filters, or nil if the collection should be dumped with a
+// single cursor. Collections are only split when --numParallelRanges is
+// set and they are larger than --rangeThresholdMB, and are not dumped
+// with a sort or limit from --queryFile.
+func (dump *MongoDump) splitIntent(session *mgo.Session, intent *intents.Intent) ([]bson.M, error) {
+	numRanges := dump.OutputOptions.NumParallelRanges
+	if numRanges < 2 || dump.useStdout || dump.archive != nil ||
+		dump.OutputOptions.Repair || intent.IsSystemIndexes() {
+		return nil, nil
+	}
+	// sorted and limited queries must be run with a single cursor
+	if nsQuery := dump.intentQueries[intent.Key()]; nsQuery != nil &&
+		(len(nsQuery.Sort) > 0 || nsQuery.Limit > 0) {
+		return nil, nil
+	}
+
+	stats := struct {
+		Size int64 `bson:"size"`
+	}{}
+	err := session.DB(intent.DB).Run(bson.D{{"collStats", intent.C}}, &stats)
+	if err != nil {
+		return nil, fmt.Errorf("error getting size of %v: %v", intent.Key(), err)
+	}
+	if stats.Size < int64(dump.OutputOptions.RangeThresholdMB)*1024*1024 {
+		return nil, nil
+	}
+
+	collection := session.DB(intent.DB).C(intent.C)
+	splitKeys, err := splitVectorKeys(session, intent, stats.Size/int64(numRanges))
+	if err != nil {
+		log.Logf(log.DebugLow, "splitVector failed for %v, sampling _id values instead: %v",
+			intent.Key(), err)
+		splitKeys, err = sampleSplitKeys(collection, intent.Size, numRanges)
+		if err != nil {
+			return nil, fmt.Errorf("error finding _id ranges of %v: %v", intent.Key(), err)
+		}
+	}
+	splitKeys = pickSplitKeys(splitKeys, numRanges)
+	if len(splitKeys) == 0 {
+		return nil, nil
+	}
+
+	// range queries only match _ids of the same type as their bounds,
+	// so every _id in the collection has to be of that type
+	bounds := []interface{}{}
+	for _, sort := range []string{"_id", "-_id"} {
+		doc := bson.M{}
+		err = collection.Find(nil).Sort(sort).Select(bson.M{"_id": 1}).One(&doc)
+		if err != nil {
+			return nil, fmt.Errorf("error finding _id range of %v: %v", intent.Key(), err)
+		}
+		bounds = append(bounds, doc["_id"])
+	}
+	bracket := idTypeBracket(bounds[0])
+	for _, id := range append(bounds, splitKeys...) {
+		if bracket == "" || idTypeBracket(id) != bracket {
+			log.Logf(log.Info, "not splitting %v, its _id values are not all of one type", intent.Key())
+			return nil, nil
+		}
+	}
+	return rangeFilters(splitKeys), nil
+}
+
+// splitVectorKeys asks the server for the _id values that divide the
+// collection into chunks of roughly the given size.
+func splitVectorKeys(session *mgo.Session, intent *intents.Intent, chunkSize int64) ([]interface{}, error) {
+	result := struct {
+		SplitKeys []bson.M `bson:"splitKeys"`
+	}{}
+	err := session.DB("admin").Run(bson.D{
+		{"splitVector", intent.Key()},
+		{"keyPattern", bson.M{"_id": 1}},
+		{"maxChunkSizeBytes", chunkSize},
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]interface{}, 0, len(result.SplitKeys))
+	for _, key := range result.SplitKeys {
+		keys = append(keys, key["_id"])
+	}
+	return keys, nil
+}
+
+// sampleSplitKeys finds the _id values that divide the collection into
+// ranges of equal document counts by skipping along the _id index.
+func sampleSplitKeys(collection *mgo.Collection, count int64, numRanges int) ([]interface{}, error) {
+	keys := []interface{}{}
+	for i := 1; i < numRanges; i++ {
+		doc := bson.M{}
+		skip := int(count * int64(i) / int64(numRanges))
+		err := collection.Find(nil).Sort("_id").Select(bson.M{"_id": 1}).Skip(skip).One(&doc)
+		if err == mgo.ErrNotFound {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, doc["_id"])
+	}
+	return keys, nil
+}
+
+// pickSplitKeys chooses at most numRanges-1 evenly spaced keys from
+// the given sorted split keys, dropping any duplicates.
+func pickSplitKeys(keys []interface{}, numRanges int) []interface{} {
+	picked := []interface{}{}
+	if len(keys) == 0 {
+		return picked
+	}
+	wanted := numRanges - 1
+	if wanted > len(keys) {
+		wanted = len(keys)
+	}
+	for i := 1; i <= wanted; i++ {
+		key := keys[i*len(keys)/(wanted+1)]
+		if len(picked) > 0 && reflect.DeepEqual(picked[len(picked)-1], key) {
+			continue
+		}
+		picked = append(picked, key)
+	}
+	return picked
+}
+
+// rangeFilters turns sorted split keys into the query filters of the
+// ranges between them, with the first and last ranges left unbounded.
+func rangeFilters(splitKeys []interface{}) []bson.M {
+	filters := []bson.M{}
+	for i := 0; i <= len(splitKeys); i++ {
+		idRange := bson.M{}
+		if i > 0 {
+			idRange["$gte"] = splitKeys[i-1]
+		}
+		if i < len(splitKeys) {
+			idRange["$lt"] = splitKeys[i]
+		}
+		filters = append(filters, bson.M{"_id": idRange})
+	}
+	return filters
+}
+
+// idTypeBracket returns the name of the group of types that the given _id
+// compares against in range queries, or "" if ranges of it are not supported.
+func idTypeBracket(id interface{}) string {
+	switch id.(type) {
+	case int, int32, int64, float64:
+		return "number"
+	case string:
+		return "string"
+	case bson.ObjectId:
+		return "objectId"
+	case time.Time:
+		return "date"
+	}
+	return ""
+}
+
+// dumpIntentRanges dumps each of the given ranges of the intent's collection
+// concurrently, into numbered part files in the collection's parts folder.
+// It returns the total number of documents and bytes written.
+func (dump *MongoDump) dumpIntentRanges(intent *intents.Intent, ranges []bson.M) (int, int64, error) {
+	partsDir := dump.partsPath(intent)
+	if err := os.RemoveAll(partsDir); err != nil {
+		return 0, 0, fmt.Errorf("error removing stale parts of %v: %v", intent.Key(), err)
+	}
+	if err := os.MkdirAll(partsDir, DumpDefaultPermissions); err != nil {
+		return 0, 0, fmt.Errorf("error creating folder `%v` for dump: %v", partsDir, err)
+	}
+	// a previous, interrupted dump may have left a whole bson file behind
+	if err := os.Remove(intent.BSONPath); err != nil && !os.IsNotExist(err) {
+		return 0, 0, fmt.Errorf("error removing stale bson file of %v: %v", intent.Key(), err)
+	}
+	log.Logf(log.Always, "writing %v to %v in %v parts", intent.Key(), partsDir, len(ranges))
+
+	type partResult struct {
+		dumped int
+		bytes  int64
+		err    error
+	}
+	resultChan := make(chan partResult, len(ranges))
+	for i, filter := range ranges {
+		go func(part int, filter bson.M) {
+			dumped, bytes, err := dump.dumpRange(intent, part, filter)
+			resultChan <- partResult{dumped, bytes, err}
+		}(i, filter)
+	}
+
+	var dumped int
+	var dumpedBytes int64
+	var err error
+	for i := 0; i < len(ranges); i++ {
+		result := <-resultChan
+		if result.err != nil && err == nil {
+			err = result.err
+		}
+		dumped += result.dumped
+		dumpedBytes += result.bytes
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	return dumped, dumpedBytes, nil
+}
+
+// dumpRange writes the documents of one range of the intent's
+// collection to its numbered part file.
+func (dump *MongoDump) dumpRange(intent *intents.Intent, part int, filter bson.M) (int, int64, error) {
+	session, err := dump.sessionProvider.GetSession()
+	if err != nil {
+		return 0, 0, err
+	}
+	session.SetSocketTimeout(0)
+	if dump.throttle == nil {
+		// as in DumpIntent, prefetching only piles up
+		// documents in memory when the dump is throttled
+		session.SetPrefetch(1.0)
+	}
+	defer session.Close()
+
+	partPath := filepath.Join(dump.partsPath(intent), dump.withExtension(strconv.Itoa(part), ".bson"))
+	out, err := dump.createOutputFile(partPath)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error creating bson file `%v`: %v", partPath, err)
+	}
+	defer out.Close()
+
+	// the part's own intent only names its progress bar
+	partIntent := &intents.Intent{DB: intent.DB, C: fmt.Sprintf("%v (part %v)", intent.C, part)}
+	counter := &countingWriter{Writer: out}
+	findQuery := dump.findQuery(session, intent, filter)
+	dumped, err := dump.dumpQueryToWriter(findQuery, partIntent, dump.redactors[intent.Key()], counter)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err = out.Close(); err != nil {
+		return 0, 0, fmt.Errorf("error closing bson file `%v`: %v", partPath, err)
+	}
+	return dumped, counter.count, nil
+}
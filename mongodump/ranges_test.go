@@ -0,0 +1,46 @@
+package mongodump
+
+import (
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+	"testing"
+)
+
+func TestRangeSplitting(t *testing.T) {
+
+	testutil.VerifyTestType(t, testutil.UNIT_TEST_TYPE)
+
+	Convey("With a sorted list of split keys", t, func() {
+		keys := []interface{}{10, 20, 30, 40, 50, 60, 70}
+
+		Convey("picking keys for fewer ranges should space them evenly", func() {
+			So(pickSplitKeys(keys, 4), ShouldResemble, []interface{}{20, 40, 60})
+		})
+
+		Convey("picking keys for more ranges than there are keys should use them all", func() {
+			So(pickSplitKeys(keys, 20), ShouldResemble, keys)
+		})
+
+		Convey("duplicate keys should only be picked once", func() {
+			So(pickSplitKeys([]interface{}{5, 5, 5}, 4), ShouldResemble, []interface{}{5})
+		})
+	})
+
+	Convey("Range filters should cover everything around the split keys", t, func() {
+		filters := rangeFilters([]interface{}{"m", "t"})
+		So(filters, ShouldResemble, []bson.M{
+			bson.M{"_id": bson.M{"$lt": "m"}},
+			bson.M{"_id": bson.M{"$gte": "m", "$lt": "t"}},
+			bson.M{"_id": bson.M{"$gte": "t"}},
+		})
+	})
+
+	Convey("_id types should be grouped the way range queries compare them", t, func() {
+		So(idTypeBracket(1), ShouldEqual, idTypeBracket(2.5))
+		So(idTypeBracket(int64(1)), ShouldEqual, "number")
+		So(idTypeBracket(bson.NewObjectId()), ShouldEqual, "objectId")
+		So(idTypeBracket("a"), ShouldNotEqual, idTypeBracket(1))
+		So(idTypeBracket(bson.M{"a": 1}), ShouldEqual, "")
+	})
+}
@@ -0,0 +1,310 @@
+package mongodump
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/json"
+	"gopkg.in/mgo.v2/bson"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Redaction actions
+const (
+	RedactDrop      = "drop"
+	RedactHash      = "hash"
+	RedactReplace   = "replace"
+	RedactTruncate  = "truncate"
+	RedactFakeEmail = "fakeEmail"
+	RedactFakePhone = "fakePhone"
+)
+
+// RedactionRule describes how to redact the field at a dotted path.
+// Arrays along the path are traversed, so "addresses.phone" redacts the
+// phone of every address; a numeric path element only selects one index.
+type RedactionRule struct {
+	Field  string      `json:"field"`
+	Action string      `json:"action"`
+	Value  interface{} `json:"value"`
+	Length int         `json:"length"`
+
+	path []string
+}
+
+// Redactor applies the redaction rules of a namespace to its documents.
+// Hashed and faked values are derived from an HMAC of the original value
+// keyed with the salt, so equal values redact to equal results in every
+// collection and references between collections still join.
+type Redactor struct {
+	salt  []byte
+	rules []*RedactionRule
+}
+
+// RedactionConfig holds the redactors of a --redactionFile, in file order.
+type RedactionConfig struct {
+	patterns  []string
+	redactors []*Redactor
+}
+
+// redactionFile is the layout of a --redactionFile
+type redactionFile struct {
+	Salt  string `json:"salt"`
+	Rules []struct {
+		Namespace string           `json:"ns"`
+		Fields    []*RedactionRule `json:"fields"`
+	} `json:"rules"`
+}
+
+// ParseRedactionFile parses the contents of a --redactionFile, e.g.
+//
+//	{
+//	  "salt": "a long random secret",
+//	  "rules": [
+//	    {"ns": "app.users", "fields": [
+//	      {"field": "password", "action": "drop"},
+//	      {"field": "email", "action": "fakeEmail"},
+//	      {"field": "addresses.phone", "action": "fakePhone"},
+//	      {"field": "name", "action": "replace", "value": "REDACTED"},
+//	      {"field": "bio", "action": "truncate", "length": 20}
+//	    ]},
+//	    {"ns": "*.orders", "fields": [{"field": "userEmail", "action": "hash"}]}
+//	  ]
+//	}
+//
+// Namespaces may use shell wildcards and are matched in file order. The salt
+// is required by the hash, fakeEmail and fakePhone actions.
+func ParseRedactionFile(data []byte) (*RedactionConfig, error) {
+	file := redactionFile{}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("error parsing redaction file as json: %v", err)
+	}
+	config := &RedactionConfig{}
+	for _, nsRules := range file.Rules {
+		if _, err := path.Match(nsRules.Namespace, ""); err != nil || nsRules.Namespace == "" {
+			return nil, fmt.Errorf("invalid namespace pattern '%v' in redaction file", nsRules.Namespace)
+		}
+		redactor := &Redactor{salt: []byte(file.Salt), rules: nsRules.Fields}
+		for _, rule := range nsRules.Fields {
+			if err := rule.validate(file.Salt != ""); err != nil {
+				return nil, fmt.Errorf("error in redaction rules for '%v': %v", nsRules.Namespace, err)
+			}
+		}
+		config.patterns = append(config.patterns, nsRules.Namespace)
+		config.redactors = append(config.redactors, redactor)
+	}
+	return config, nil
+}
+
+// validate checks the rule and prepares its path and replacement value
+func (rule *RedactionRule) validate(haveSalt bool) error {
+	if rule.Field == "" {
+		return fmt.Errorf("redaction rule without a field")
+	}
+	rule.path = strings.Split(rule.Field, ".")
+	for _, elem := range rule.path {
+		if elem == "" {
+			return fmt.Errorf("invalid field '%v'", rule.Field)
+		}
+	}
+	switch rule.Action {
+	case RedactDrop:
+	case RedactHash, RedactFakeEmail, RedactFakePhone:
+		if !haveSalt {
+			return fmt.Errorf("the %v action on '%v' requires a salt", rule.Action, rule.Field)
+		}
+	case RedactReplace:
+		value, err := bsonutil.ConvertJSONValueToBSON(rule.Value)
+		if err != nil {
+			return fmt.Errorf("error converting replacement for '%v': %v", rule.Field, err)
+		}
+		rule.Value = value
+	case RedactTruncate:
+		if rule.Length < 0 {
+			return fmt.Errorf("truncate length of '%v' must not be negative", rule.Field)
+		}
+	default:
+		return fmt.Errorf("unknown action '%v' for '%v'", rule.Action, rule.Field)
+	}
+	return nil
+}
+
+// RedactorFor returns the redactor of the first namespace pattern
+// matching ns, or nil if its documents are not redacted.
+func (config *RedactionConfig) RedactorFor(ns string) *Redactor {
+	for i, pattern := range config.patterns {
+		if matched, _ := path.Match(pattern, ns); matched {
+			return config.redactors[i]
+		}
+	}
+	return nil
+}
+
+// Redact applies the redactor's rules to a raw BSON document
+// and returns the redacted document.
+func (redactor *Redactor) Redact(raw []byte) ([]byte, error) {
+	doc := bson.D{}
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("error reading document to redact: %v", err)
+	}
+	var redacted interface{} = doc
+	var err error
+	for _, rule := range redactor.rules {
+		if redacted, err = redactor.apply(redacted, rule, rule.path); err != nil {
+			return nil, fmt.Errorf("error redacting '%v': %v", rule.Field, err)
+		}
+	}
+	out, err := bson.Marshal(redacted)
+	if err != nil {
+		return nil, fmt.Errorf("error writing redacted document: %v", err)
+	}
+	return out, nil
+}
+
+// apply redacts the field at the remaining path within value,
+// returning value with the field redacted.
+func (redactor *Redactor) apply(value interface{}, rule *RedactionRule, fieldPath []string) (interface{}, error) {
+	var err error
+	switch v := value.(type) {
+	case bson.D:
+		for i := 0; i < len(v); i++ {
+			if v[i].Name != fieldPath[0] {
+				continue
+			}
+			if len(fieldPath) > 1 {
+				v[i].Value, err = redactor.apply(v[i].Value, rule, fieldPath[1:])
+				return v, err
+			}
+			if rule.Action == RedactDrop {
+				return append(v[:i], v[i+1:]...), nil
+			}
+			v[i].Value, err = redactor.redactValue(v[i].Value, rule)
+			return v, err
+		}
+	case []interface{}:
+		if index, convErr := strconv.Atoi(fieldPath[0]); convErr == nil {
+			switch {
+			case index < 0 || index >= len(v):
+			case len(fieldPath) > 1:
+				v[index], err = redactor.apply(v[index], rule, fieldPath[1:])
+			case rule.Action == RedactDrop:
+				v[index] = nil
+			default:
+				v[index], err = redactor.redactValue(v[index], rule)
+			}
+			return v, err
+		}
+		for i := range v {
+			if v[i], err = redactor.apply(v[i], rule, fieldPath); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return value, nil
+}
+
+// redactValue returns the redacted form of a single value
+func (redactor *Redactor) redactValue(value interface{}, rule *RedactionRule) (interface{}, error) {
+	switch rule.Action {
+	case RedactReplace:
+		return rule.Value, nil
+	case RedactTruncate:
+		if s, ok := value.(string); ok && utf8.RuneCountInString(s) > rule.Length {
+			return string([]rune(s)[:rule.Length]), nil
+		}
+		return value, nil
+	case RedactFakeEmail:
+		if s, ok := value.(string); ok {
+			return redactor.fakeEmail(s)
+		}
+	case RedactFakePhone:
+		if s, ok := value.(string); ok {
+			return redactor.fakeDigits(s)
+		}
+	}
+	// hashing, and faking values that are not strings
+	stream, err := redactor.keystream(value, sha256.Size)
+	if err != nil {
+		return nil, err
+	}
+	return hex.EncodeToString(stream), nil
+}
+
+// fakeEmail replaces an email address with one of the same shape: the
+// local part keeps its length and punctuation, the domain is example.com.
+func (redactor *Redactor) fakeEmail(email string) (string, error) {
+	local := email
+	if at := strings.LastIndex(email, "@"); at >= 0 {
+		local = email[:at]
+	}
+	stream, err := redactor.keystream(email, len(local))
+	if err != nil {
+		return "", err
+	}
+	out := []byte(local)
+	for i, c := range out {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+			out[i] = 'a' + stream[i]%26
+		case c >= '0' && c <= '9':
+			out[i] = '0' + stream[i]%10
+		}
+	}
+	return string(out) + "@example.com", nil
+}
+
+// fakeDigits replaces every digit of s, keeping its other characters,
+// so phone numbers keep their formatting
+func (redactor *Redactor) fakeDigits(s string) (string, error) {
+	stream, err := redactor.keystream(s, len(s))
+	if err != nil {
+		return "", err
+	}
+	out := []byte(s)
+	for i, c := range out {
+		if c >= '0' && c <= '9' {
+			out[i] = '0' + stream[i]%10
+		}
+	}
+	return string(out), nil
+}
+
+// keystream derives n bytes from an HMAC-SHA256 of the value keyed with
+// the salt. The value is hashed as BSON, so the result only depends on
+// its type and contents.
+func (redactor *Redactor) keystream(value interface{}, n int) ([]byte, error) {
+	raw, err := bson.Marshal(bson.D{{"", value}})
+	if err != nil {
+		return nil, err
+	}
+	stream := []byte{}
+	counter := make([]byte, 4)
+	for block := uint32(0); len(stream) < n; block++ {
+		mac := hmac.New(sha256.New, redactor.salt)
+		binary.BigEndian.PutUint32(counter, block)
+		mac.Write(counter)
+		mac.Write(raw)
+		stream = mac.Sum(stream)
+	}
+	return stream[:n], nil
+}
+
+// loadRedactionFile reads the redaction rules of --redactionFile
+func (dump *MongoDump) loadRedactionFile() error {
+	data, err := ioutil.ReadFile(dump.OutputOptions.RedactionFile)
+	if err != nil {
+		return fmt.Errorf("error reading redaction file: %v", err)
+	}
+	dump.redaction, err = ParseRedactionFile(data)
+	if err != nil {
+		return err
+	}
+	dump.redactors = map[string]*Redactor{}
+	return nil
+}
@@ -0,0 +1,106 @@
+package mongodump
+
+import (
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+	"regexp"
+	"testing"
+)
+
+func TestRedaction(t *testing.T) {
+
+	testutil.VerifyTestType(t, testutil.UNIT_TEST_TYPE)
+
+	Convey("With a redaction file for users and orders", t, func() {
+		config, err := ParseRedactionFile([]byte(`{
+			"salt": "pepper",
+			"rules": [
+				{"ns": "app.users", "fields": [
+					{"field": "password", "action": "drop"},
+					{"field": "email", "action": "fakeEmail"},
+					{"field": "addresses.phone", "action": "fakePhone"},
+					{"field": "name", "action": "replace", "value": "REDACTED"},
+					{"field": "bio", "action": "truncate", "length": 3},
+					{"field": "tags.0", "action": "hash"}
+				]},
+				{"ns": "*.orders", "fields": [{"field": "userEmail", "action": "hash"}]}
+			]
+		}`))
+		So(err, ShouldBeNil)
+		users := config.RedactorFor("app.users")
+		orders := config.RedactorFor("shop.orders")
+		So(users, ShouldNotBeNil)
+		So(orders, ShouldNotBeNil)
+		So(config.RedactorFor("app.sessions"), ShouldBeNil)
+
+		redact := func(redactor *Redactor, doc bson.D) bson.M {
+			raw, err := bson.Marshal(doc)
+			So(err, ShouldBeNil)
+			raw, err = redactor.Redact(raw)
+			So(err, ShouldBeNil)
+			out := bson.M{}
+			So(bson.Unmarshal(raw, &out), ShouldBeNil)
+			return out
+		}
+
+		user := redact(users, bson.D{
+			{"_id", 1},
+			{"name", "Jane Doe"},
+			{"password", "hunter2"},
+			{"email", "jane.doe42@corp.com"},
+			{"bio", "likes long walks"},
+			{"tags", []interface{}{"vip", "beta"}},
+			{"addresses", []interface{}{
+				bson.D{{"city", "NYC"}, {"phone", "+1 (212) 555-0100"}},
+				bson.D{{"city", "SF"}},
+			}},
+		})
+
+		Convey("each action should be applied to its field", func() {
+			_, hasPassword := user["password"]
+			So(hasPassword, ShouldBeFalse)
+			So(user["name"], ShouldEqual, "REDACTED")
+			So(user["bio"], ShouldEqual, "lik")
+			So(user["_id"], ShouldEqual, 1)
+
+			email := user["email"].(string)
+			So(email, ShouldNotEqual, "jane.doe42@corp.com")
+			So(regexp.MustCompile(`^[a-z]{4}\.[a-z]{3}[0-9]{2}@example\.com$`).MatchString(email), ShouldBeTrue)
+
+			tags := user["tags"].([]interface{})
+			So(len(tags[0].(string)), ShouldEqual, 64)
+			So(tags[1], ShouldEqual, "beta")
+		})
+
+		Convey("arrays along the path should be traversed", func() {
+			addresses := user["addresses"].([]interface{})
+			phone := addresses[0].(bson.M)["phone"].(string)
+			So(phone, ShouldNotEqual, "+1 (212) 555-0100")
+			So(regexp.MustCompile(`^\+[0-9] \([0-9]{3}\) [0-9]{3}-[0-9]{4}$`).MatchString(phone), ShouldBeTrue)
+			_, hasPhone := addresses[1].(bson.M)["phone"]
+			So(hasPhone, ShouldBeFalse)
+		})
+
+		Convey("hashing should be deterministic across collections", func() {
+			first := redact(orders, bson.D{{"userEmail", "jane@corp.com"}})
+			second := redact(orders, bson.D{{"userEmail", "jane@corp.com"}})
+			other := redact(orders, bson.D{{"userEmail", "john@corp.com"}})
+			So(first["userEmail"], ShouldEqual, second["userEmail"])
+			So(first["userEmail"], ShouldNotEqual, other["userEmail"])
+		})
+	})
+
+	Convey("Invalid redaction files should be rejected", t, func() {
+		for _, data := range []string{
+			`not json`,
+			`{"rules": [{"ns": "app.users", "fields": [{"field": "ssn", "action": "hash"}]}]}`,
+			`{"rules": [{"ns": "app.users", "fields": [{"field": "ssn", "action": "shred"}]}]}`,
+			`{"rules": [{"ns": "app.users", "fields": [{"field": "a..b", "action": "drop"}]}]}`,
+			`{"rules": [{"ns": "", "fields": []}]}`,
+		} {
+			_, err := ParseRedactionFile([]byte(data))
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
@@ -0,0 +1,308 @@
+package mongodump
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/util"
+	"gopkg.in/mgo.v2/bson"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReportFilename is the name of the summary report that mongodump
+// writes to the root of the dump folder once the dump is complete
+const ReportFilename = "dump_report.json"
+
+// ReportFile describes a single file of the dump, with its path relative
+// to the dump folder, and the size and SHA-256 of its contents on disk.
+type ReportFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// ReportIntent describes what was dumped of a single collection. Documents
+// and Bytes count the BSON written before any compression. Resumed entries
+// were dumped by an earlier, interrupted run of a --resume'd dump.
+type ReportIntent struct {
+	Namespace      string        `json:"ns"`
+	Documents      int64         `json:"documents"`
+	Bytes          int64         `json:"bytes"`
+	Indexes        int           `json:"indexes"`
+	ElapsedSeconds float64       `json:"elapsedSeconds"`
+	Resumed        bool          `json:"resumed,omitempty"`
+	Files          []*ReportFile `json:"files"`
+
+	intent   *intents.Intent
+	partsDir string
+}
+
+// DumpReport is a machine readable summary of a completed dump, for backup
+// catalogs to ingest and for verifying the dump's files later on. Files
+// lists the files that do not belong to a collection, such as the oplog.
+type DumpReport struct {
+	ServerVersion string              `json:"serverVersion"`
+	StartTime     time.Time           `json:"startTime"`
+	EndTime       time.Time           `json:"endTime"`
+	OplogStart    bson.MongoTimestamp `json:"oplogStart,omitempty"`
+	OplogEnd      bson.MongoTimestamp `json:"oplogEnd,omitempty"`
+	Intents       []*ReportIntent     `json:"intents"`
+	Files         []*ReportFile       `json:"files"`
+
+	dir       string
+	lock      sync.Mutex
+	checksums map[string]*ReportFile
+}
+
+// NewDumpReport returns an empty report for the given dump folder
+func NewDumpReport(dumpDir string) *DumpReport {
+	return &DumpReport{
+		StartTime: time.Now(),
+		Intents:   []*ReportIntent{},
+		Files:     []*ReportFile{},
+		dir:       dumpDir,
+		checksums: map[string]*ReportFile{},
+	}
+}
+
+// AddIntent adds the result of dumping an intent to the report, along
+// with the folder its parts are in if it was dumped in _id ranges
+func (report *DumpReport) AddIntent(intent *intents.Intent, partsDir string, entry *ReportIntent) {
+	report.lock.Lock()
+	defer report.lock.Unlock()
+	entry.Namespace = intent.Key()
+	entry.intent = intent
+	entry.partsDir = partsDir
+	report.Intents = append(report.Intents, entry)
+}
+
+// SetOplogRange records the range of oplog entries captured by the dump
+func (report *DumpReport) SetOplogRange(start, end bson.MongoTimestamp) {
+	report.lock.Lock()
+	defer report.lock.Unlock()
+	report.OplogStart = start
+	report.OplogEnd = end
+}
+
+// recordFile records the size and checksum of a file written by the dump
+func (report *DumpReport) recordFile(path string, size int64, sum string) {
+	report.lock.Lock()
+	defer report.lock.Unlock()
+	report.checksums[path] = &ReportFile{Size: size, SHA256: sum}
+}
+
+// Write fills in the files of every intent and writes the report to the
+// dump folder. Files that were not written by this run of mongodump, as
+// when resuming, are checksummed from disk.
+func (report *DumpReport) Write() error {
+	report.lock.Lock()
+	defer report.lock.Unlock()
+	report.EndTime = time.Now()
+
+	sort.Sort(reportIntentsByNamespace(report.Intents))
+	for _, entry := range report.Intents {
+		paths, err := intentFiles(entry.intent, entry.partsDir)
+		if err != nil {
+			return err
+		}
+		entry.Files = []*ReportFile{}
+		for _, path := range paths {
+			file, err := report.checksum(path)
+			if err != nil {
+				return err
+			}
+			entry.Files = append(entry.Files, file)
+		}
+	}
+	// everything left over is not part of a collection
+	others := []string{}
+	for path := range report.checksums {
+		others = append(others, path)
+	}
+	sort.Strings(others)
+	report.Files = []*ReportFile{}
+	for _, path := range others {
+		file, err := report.checksum(path)
+		if err != nil {
+			return err
+		}
+		report.Files = append(report.Files, file)
+	}
+
+	jsonBytes, err := json.MarshalIndent(report, "", "\t")
+	if err != nil {
+		return fmt.Errorf("error marshalling dump report: %v", err)
+	}
+	reportPath := filepath.Join(report.dir, ReportFilename)
+	tmpPath := reportPath + ".tmp"
+	if err = ioutil.WriteFile(tmpPath, jsonBytes, 0644); err != nil {
+		return fmt.Errorf("error writing dump report: %v", err)
+	}
+	if err = os.Rename(tmpPath, reportPath); err != nil {
+		return fmt.Errorf("error writing dump report: %v", err)
+	}
+	return nil
+}
+
+// checksum returns the report entry of the file at path, and removes
+// it from the recorded checksums. The caller must hold the lock.
+func (report *DumpReport) checksum(path string) (*ReportFile, error) {
+	file, ok := report.checksums[path]
+	if ok {
+		delete(report.checksums, path)
+	} else {
+		var err error
+		if file, err = checksumFromDisk(path); err != nil {
+			return nil, err
+		}
+	}
+	relPath, err := filepath.Rel(report.dir, path)
+	if err != nil {
+		return nil, fmt.Errorf("error finding path of %v in dump: %v", path, err)
+	}
+	file.Path = filepath.ToSlash(relPath)
+	return file, nil
+}
+
+// checksumFromDisk reads the file at path to compute its checksum
+func checksumFromDisk(path string) (*ReportFile, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %v to checksum: %v", path, err)
+	}
+	defer in.Close()
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, in)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %v to checksum: %v", path, err)
+	}
+	return &ReportFile{Size: size, SHA256: hex.EncodeToString(hasher.Sum(nil))}, nil
+}
+
+// intentFiles lists the files of the dump that hold the given intent: its
+// bson file or the numbered parts in partsDir, and its metadata file if any
+func intentFiles(intent *intents.Intent, partsDir string) ([]string, error) {
+	paths := []string{}
+	entries, err := ioutil.ReadDir(partsDir)
+	switch {
+	case err == nil:
+		parts := make([]string, len(entries))
+		for _, entry := range entries {
+			// part files are named <N>.bson[.<compression>]
+			part, err := strconv.Atoi(strings.SplitN(entry.Name(), ".", 2)[0])
+			if err != nil || part < 0 || part >= len(parts) {
+				return nil, fmt.Errorf("unexpected file %v in %v", entry.Name(), partsDir)
+			}
+			parts[part] = filepath.Join(partsDir, entry.Name())
+		}
+		paths = append(paths, parts...)
+	case os.IsNotExist(err):
+		// views only have metadata
+		if intent.BSONPath != "" {
+			paths = append(paths, intent.BSONPath)
+		}
+	default:
+		return nil, fmt.Errorf("error reading parts of %v: %v", intent.Key(), err)
+	}
+	if !intent.IsSystemIndexes() {
+		if _, err := os.Stat(intent.MetadataPath); err == nil {
+			paths = append(paths, intent.MetadataPath)
+		}
+	}
+	return paths, nil
+}
+
+// countIndexesFromDisk counts the indexes in the metadata file of an intent
+// that was dumped by an earlier run of mongodump
+func countIndexesFromDisk(intent *intents.Intent, key *util.EncryptionKey) (int, error) {
+	if intent.IsSystemIndexes() {
+		return 0, nil
+	}
+	in, err := util.OpenDecrypted(intent.MetadataPath, key)
+	if err != nil {
+		return 0, fmt.Errorf("error opening metadata of %v: %v", intent.Key(), err)
+	}
+	defer in.Close()
+	meta := Metadata{}
+	if err = json.NewDecoder(in).Decode(&meta); err != nil {
+		return 0, fmt.Errorf("error reading metadata of %v: %v", intent.Key(), err)
+	}
+	return len(meta.Indexes), nil
+}
+
+type reportIntentsByNamespace []*ReportIntent
+
+func (entries reportIntentsByNamespace) Len() int { return len(entries) }
+func (entries reportIntentsByNamespace) Swap(i, j int) {
+	entries[i], entries[j] = entries[j], entries[i]
+}
+func (entries reportIntentsByNamespace) Less(i, j int) bool {
+	return entries[i].Namespace < entries[j].Namespace
+}
+
+// checksumFile is a dump output file that checksums everything written
+// to it, and records the checksum in the dump report when closed
+type checksumFile struct {
+	file   *os.File
+	hash   hash.Hash
+	size   int64
+	report *DumpReport
+}
+
+func (cf *checksumFile) Write(p []byte) (int, error) {
+	n, err := cf.file.Write(p)
+	cf.hash.Write(p[:n])
+	cf.size += int64(n)
+	return n, err
+}
+
+func (cf *checksumFile) Close() error {
+	if err := cf.file.Close(); err != nil {
+		return err
+	}
+	cf.report.recordFile(cf.file.Name(), cf.size, hex.EncodeToString(cf.hash.Sum(nil)))
+	return nil
+}
+
+// prepareReport starts the summary report of a dump to a folder
+func (dump *MongoDump) prepareReport() error {
+	session, err := dump.sessionProvider.GetSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	buildInfo, err := session.BuildInfo()
+	if err != nil {
+		return fmt.Errorf("error getting server version: %v", err)
+	}
+	dump.report = NewDumpReport(dump.OutputOptions.Out)
+	dump.report.ServerVersion = buildInfo.Version
+	return nil
+}
+
+// reportResumedIntent adds an intent that a resumed dump skips to the
+// report, with the counts its manifest entry recorded
+func (dump *MongoDump) reportResumedIntent(intent *intents.Intent, entry *ManifestEntry) error {
+	indexes, err := countIndexesFromDisk(intent, dump.encryption)
+	if err != nil {
+		return err
+	}
+	dump.report.AddIntent(intent, dump.partsPath(intent), &ReportIntent{
+		Documents: entry.Documents,
+		Bytes:     entry.Bytes,
+		Indexes:   indexes,
+		Resumed:   true,
+	})
+	return nil
+}
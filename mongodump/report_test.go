@@ -0,0 +1,100 @@
+package mongodump
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/testutil"
+	"github.com/mongodb/mongo-tools/common/util"
+	"github.com/mongodb/mongo-tools/mongodump/options"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDumpReport(t *testing.T) {
+
+	testutil.VerifyTestType(t, testutil.UNIT_TEST_TYPE)
+
+	Convey("With a report on a gzipped dump of two collections", t, func() {
+		dumpDir, err := ioutil.TempDir("", "mongodump_report_test")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(dumpDir)
+		})
+		codec, err := util.GetCompressionCodec("gzip")
+		So(err, ShouldBeNil)
+		md := &MongoDump{
+			OutputOptions: &options.OutputOptions{Out: dumpDir},
+			compression:   codec,
+			report:        NewDumpReport(dumpDir),
+		}
+		So(os.MkdirAll(filepath.Join(dumpDir, "db"), 0755), ShouldBeNil)
+
+		writeFile := func(path, contents string) {
+			out, err := md.createOutputFile(path)
+			So(err, ShouldBeNil)
+			_, err = out.Write([]byte(contents))
+			So(err, ShouldBeNil)
+			So(out.Close(), ShouldBeNil)
+		}
+
+		// one collection written whole, one in two parts
+		whole := &intents.Intent{DB: "db", C: "whole",
+			BSONPath:     md.withExtension(md.outputPath("db", "whole"), ".bson"),
+			MetadataPath: md.withExtension(md.outputPath("db", "whole"), ".metadata.json")}
+		split := &intents.Intent{DB: "db", C: "split",
+			BSONPath:     md.withExtension(md.outputPath("db", "split"), ".bson"),
+			MetadataPath: md.withExtension(md.outputPath("db", "split"), ".metadata.json")}
+		writeFile(whole.BSONPath, "whole bson")
+		writeFile(whole.MetadataPath, `{"indexes":[]}`)
+		So(os.MkdirAll(md.partsPath(split), 0755), ShouldBeNil)
+		for _, part := range []string{"0", "1"} {
+			writeFile(filepath.Join(md.partsPath(split), md.withExtension(part, ".bson")), "part "+part)
+		}
+		writeFile(split.MetadataPath, `{"indexes":[]}`)
+		writeFile(filepath.Join(dumpDir, md.withExtension("oplog", ".bson")), "oplog")
+
+		md.reportIntent(whole, 1, 10, 1, md.report.StartTime)
+		md.reportIntent(split, 2, 20, 2, md.report.StartTime)
+		md.report.SetOplogRange(bson.MongoTimestamp(1), bson.MongoTimestamp(2))
+		So(md.report.Write(), ShouldBeNil)
+
+		jsonBytes, err := ioutil.ReadFile(filepath.Join(dumpDir, ReportFilename))
+		So(err, ShouldBeNil)
+		report := DumpReport{}
+		So(json.Unmarshal(jsonBytes, &report), ShouldBeNil)
+
+		Convey("every intent should be listed with its files", func() {
+			So(len(report.Intents), ShouldEqual, 2)
+			So(report.Intents[0].Namespace, ShouldEqual, "db.split")
+			So(report.Intents[0].Documents, ShouldEqual, 2)
+			So(report.Intents[0].Indexes, ShouldEqual, 2)
+			So(len(report.Intents[0].Files), ShouldEqual, 3)
+			So(report.Intents[0].Files[0].Path, ShouldEqual, "db/split.parts/0.bson.gz")
+			So(report.Intents[0].Files[1].Path, ShouldEqual, "db/split.parts/1.bson.gz")
+			So(report.Intents[0].Files[2].Path, ShouldEqual, "db/split.metadata.json.gz")
+			So(report.Intents[1].Namespace, ShouldEqual, "db.whole")
+			So(len(report.Intents[1].Files), ShouldEqual, 2)
+			So(report.OplogEnd, ShouldEqual, bson.MongoTimestamp(2))
+		})
+
+		Convey("the oplog should be listed among the other files", func() {
+			So(len(report.Files), ShouldEqual, 1)
+			So(report.Files[0].Path, ShouldEqual, "oplog.bson.gz")
+		})
+
+		Convey("checksums should match the compressed files on disk", func() {
+			file := report.Intents[1].Files[0]
+			onDisk, err := ioutil.ReadFile(filepath.Join(dumpDir, file.Path))
+			So(err, ShouldBeNil)
+			sum := sha256.Sum256(onDisk)
+			So(file.SHA256, ShouldEqual, hex.EncodeToString(sum[:]))
+			So(file.Size, ShouldEqual, len(onDisk))
+		})
+	})
+}
@@ -0,0 +1,220 @@
+package mongodump
+
+import (
+	"fmt"
+	"github.com/mongodb/mongo-tools/common/json"
+	"github.com/mongodb/mongo-tools/common/log"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ThrottlePollInterval is how often the --throttleFile is checked for changes
+const ThrottlePollInterval = time.Second
+
+// tokenBucket limits a rate to a number of tokens per second, allowing
+// bursts of up to one second's worth of tokens. Taking more tokens than
+// are available puts the bucket in debt, which later takers wait out.
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens accrued since the bucket was last used
+func (bucket *tokenBucket) refill(now time.Time) {
+	if bucket.rate > 0 {
+		bucket.tokens += now.Sub(bucket.last).Seconds() * bucket.rate
+		if bucket.tokens > bucket.rate {
+			bucket.tokens = bucket.rate
+		}
+	}
+	bucket.last = now
+}
+
+// take removes n tokens from the bucket and returns how long the
+// caller must wait for them to have been available
+func (bucket *tokenBucket) take(n float64, now time.Time) time.Duration {
+	bucket.refill(now)
+	if bucket.rate <= 0 {
+		return 0
+	}
+	bucket.tokens -= n
+	if bucket.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-bucket.tokens / bucket.rate * float64(time.Second))
+}
+
+// setRate changes the rate of the bucket, where 0 means unlimited
+func (bucket *tokenBucket) setRate(rate float64, now time.Time) {
+	bucket.refill(now)
+	switch {
+	case rate <= 0:
+		bucket.tokens = 0
+	case bucket.rate <= 0 || bucket.tokens > rate:
+		bucket.tokens = rate
+	}
+	bucket.rate = rate
+}
+
+// ThrottleLimits are the rates a dump is throttled to, where 0 is unlimited
+type ThrottleLimits struct {
+	BytesPerSec int64 `json:"maxBytesPerSec"`
+	DocsPerSec  int64 `json:"maxDocsPerSec"`
+}
+
+func (limits ThrottleLimits) String() string {
+	describe := func(rate int64, unit string) string {
+		if rate <= 0 {
+			return "unlimited " + unit
+		}
+		return fmt.Sprintf("%v %v", rate, unit)
+	}
+	return describe(limits.BytesPerSec, "bytes/sec") + ", " + describe(limits.DocsPerSec, "docs/sec")
+}
+
+// Throttle limits the rate at which documents are read from the server.
+// A single throttle is shared by every goroutine of a dump, so its limits
+// hold for the dump as a whole.
+type Throttle struct {
+	lock   sync.Mutex
+	limits ThrottleLimits
+	bytes  tokenBucket
+	docs   tokenBucket
+}
+
+// NewThrottle returns a throttle enforcing the given limits
+func NewThrottle(limits ThrottleLimits) *Throttle {
+	throttle := &Throttle{}
+	throttle.SetLimits(limits)
+	return throttle
+}
+
+// Limits returns the limits the throttle currently enforces
+func (throttle *Throttle) Limits() ThrottleLimits {
+	throttle.lock.Lock()
+	defer throttle.lock.Unlock()
+	return throttle.limits
+}
+
+// SetLimits changes the limits of the throttle, taking effect immediately
+func (throttle *Throttle) SetLimits(limits ThrottleLimits) {
+	throttle.lock.Lock()
+	defer throttle.lock.Unlock()
+	now := time.Now()
+	throttle.limits = limits
+	throttle.bytes.setRate(float64(limits.BytesPerSec), now)
+	throttle.docs.setRate(float64(limits.DocsPerSec), now)
+}
+
+// Wait blocks until reading another document of the given size
+// is allowed by the limits of the throttle
+func (throttle *Throttle) Wait(size int) {
+	if wait := throttle.reserve(size, time.Now()); wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// reserve accounts for reading a document of the given size at
+// time now, and returns how long to wait before reading it
+func (throttle *Throttle) reserve(size int, now time.Time) time.Duration {
+	throttle.lock.Lock()
+	defer throttle.lock.Unlock()
+	wait := throttle.docs.take(1, now)
+	if bytesWait := throttle.bytes.take(float64(size), now); bytesWait > wait {
+		wait = bytesWait
+	}
+	return wait
+}
+
+// readThrottleFile reads new limits from a --throttleFile, e.g.
+//
+//	{"maxBytesPerSec": 10485760, "maxDocsPerSec": 5000}
+//
+// Limits missing from the file keep their current value.
+func readThrottleFile(path string, current ThrottleLimits) (ThrottleLimits, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return current, fmt.Errorf("error reading throttle file: %v", err)
+	}
+	limits := current
+	if err = json.Unmarshal(data, &limits); err != nil {
+		return current, fmt.Errorf("error parsing throttle file as json: %v", err)
+	}
+	if limits.BytesPerSec < 0 || limits.DocsPerSec < 0 {
+		return current, fmt.Errorf("limits in throttle file must not be negative")
+	}
+	return limits, nil
+}
+
+// startThrottle sets up the throttle of --maxBytesPerSec and --maxDocsPerSec.
+// With a --throttleFile, the limits are re-read from it whenever it changes
+// or the process receives SIGHUP, until the returned function is called.
+func (dump *MongoDump) startThrottle() (func(), error) {
+	if dump.throttle != nil {
+		// shared with the other members of a --cluster dump
+		return func() {}, nil
+	}
+	limits := ThrottleLimits{
+		BytesPerSec: dump.OutputOptions.MaxBytesPerSec,
+		DocsPerSec:  dump.OutputOptions.MaxDocsPerSec,
+	}
+	path := dump.OutputOptions.ThrottleFile
+	var modTime time.Time
+	if path != "" {
+		if info, err := os.Stat(path); err == nil {
+			modTime = info.ModTime()
+			if limits, err = readThrottleFile(path, limits); err != nil {
+				return nil, err
+			}
+		} else if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("error reading throttle file: %v", err)
+		}
+	}
+	if limits.BytesPerSec <= 0 && limits.DocsPerSec <= 0 && path == "" {
+		return func() {}, nil
+	}
+	dump.throttle = NewThrottle(limits)
+	log.Logf(log.Always, "throttling dump to %v", limits)
+	if path == "" {
+		return func() {}, nil
+	}
+
+	done := make(chan struct{})
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	ticker := time.NewTicker(ThrottlePollInterval)
+	go func() {
+		for {
+			force := false
+			select {
+			case <-done:
+				return
+			case <-hangup:
+				force = true
+			case <-ticker.C:
+			}
+			info, err := os.Stat(path)
+			if err != nil || (!force && info.ModTime().Equal(modTime)) {
+				continue
+			}
+			modTime = info.ModTime()
+			limits, err := readThrottleFile(path, dump.throttle.Limits())
+			if err != nil {
+				log.Logf(log.Always, "keeping current throttle limits: %v", err)
+				continue
+			}
+			dump.throttle.SetLimits(limits)
+			log.Logf(log.Always, "throttling dump to %v", limits)
+		}
+	}()
+	return func() {
+		signal.Stop(hangup)
+		ticker.Stop()
+		close(done)
+	}, nil
+}
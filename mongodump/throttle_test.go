@@ -0,0 +1,63 @@
+package mongodump
+
+import (
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestThrottle(t *testing.T) {
+
+	testutil.VerifyTestType(t, testutil.UNIT_TEST_TYPE)
+
+	Convey("With a throttle of 1000 bytes and 10 documents per second", t, func() {
+		throttle := NewThrottle(ThrottleLimits{BytesPerSec: 1000, DocsPerSec: 10})
+		start := throttle.bytes.last
+
+		Convey("a second's worth of documents should not wait", func() {
+			for i := 0; i < 10; i++ {
+				So(throttle.reserve(10, start), ShouldEqual, 0)
+			}
+			So(throttle.reserve(10, start), ShouldEqual, 100*time.Millisecond)
+		})
+
+		Convey("large documents should wait for the byte limit", func() {
+			So(throttle.reserve(1500, start), ShouldEqual, 500*time.Millisecond)
+			So(throttle.reserve(500, start), ShouldEqual, time.Second)
+			So(throttle.reserve(0, start.Add(2*time.Second)), ShouldEqual, 0)
+		})
+
+		Convey("lifting the limits should stop waiting", func() {
+			So(throttle.reserve(3000, start), ShouldEqual, 2*time.Second)
+			throttle.SetLimits(ThrottleLimits{})
+			So(throttle.reserve(3000, time.Now()), ShouldEqual, 0)
+		})
+	})
+
+	Convey("With a throttle file", t, func() {
+		file, err := ioutil.TempFile("", "mongodump_throttle_test")
+		So(err, ShouldBeNil)
+		file.Close()
+		Reset(func() {
+			os.Remove(file.Name())
+		})
+		current := ThrottleLimits{BytesPerSec: 100, DocsPerSec: 10}
+
+		Convey("limits missing from the file should be kept", func() {
+			So(ioutil.WriteFile(file.Name(), []byte(`{"maxDocsPerSec": 20}`), 0644), ShouldBeNil)
+			limits, err := readThrottleFile(file.Name(), current)
+			So(err, ShouldBeNil)
+			So(limits, ShouldResemble, ThrottleLimits{BytesPerSec: 100, DocsPerSec: 20})
+		})
+
+		Convey("invalid files should keep the current limits", func() {
+			So(ioutil.WriteFile(file.Name(), []byte(`{"maxBytesPerSec": -1}`), 0644), ShouldBeNil)
+			limits, err := readThrottleFile(file.Name(), current)
+			So(err, ShouldNotBeNil)
+			So(limits, ShouldResemble, current)
+		})
+	})
+}
@@ -18,6 +18,7 @@ func main() {
 	opts.AddOptions(outputOpts)
 	inputOpts := &options.InputOptions{}
 	opts.AddOptions(inputOpts)
+	opts.AddReadPreferenceOptions()
 
 	args, err := opts.Parse()
 	if err != nil {
@@ -31,6 +31,7 @@ func main() {
 
 	storageOpts := &options.StorageOptions{}
 	opts.AddOptions(storageOpts)
+	opts.AddReadPreferenceOptions()
 
 	args, err := opts.Parse()
 	if err != nil {
@@ -177,6 +177,16 @@ func (self *MongoFiles) handlePut(gfs *mgo.GridFS) (string, error) {
 
 // Run the mongofiles utility
 func (self *MongoFiles) Run(displayConnUrl bool) (string, error) {
+	if pref := self.ToolOptions.ReadPreference; pref != nil {
+		if err := pref.Validate(); err != nil {
+			return "", err
+		}
+		// only reads may go to secondaries
+		if !pref.IsPrimary() && (self.Command == Put || self.Command == Delete) {
+			return "", fmt.Errorf("--readPreference=%v can not be used with '%v'", pref.Mode, self.Command)
+		}
+	}
+
 	connUrl := self.ToolOptions.Host
 	if connUrl == "" {
 		connUrl = util.DefaultHost
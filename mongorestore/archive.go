@@ -0,0 +1,261 @@
+package mongorestore
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
+	"io"
+	"os"
+)
+
+// archiveSource holds the state of a restore from a mongodump archive.
+// Every intent restored from the archive reads its data through either a
+// pipe fed by the demultiplexer, or, for the small special collections
+// that are restored outside of the regular intent queue, an in-memory buffer.
+// The oplog, which is only replayed once everything else is restored,
+// is spooled to a temporary file as the archive is read.
+type archiveSource struct {
+	// name is the archive's path, or "-" for stdin
+	name string
+
+	in       io.ReadCloser
+	demux    *archive.Demultiplexer
+	prelude  *archive.Prelude
+	metadata map[string]string
+	pipes    map[string]*archive.Pipe
+	buffers  map[string]*archive.Buffer
+	spools   map[string]*archive.Spool
+	result   chan error
+}
+
+// bufferedReadCloser lets an archive source be peeked at while
+// still closing the underlying file or stream.
+type bufferedReadCloser struct {
+	*bufio.Reader
+	io.Closer
+}
+
+// openArchive opens the --archive source, decompressing it if it was
+// compressed, and reads the archive's prelude.
+func (restore *MongoRestore) openArchive() error {
+	source := &archiveSource{
+		name:     restore.InputOptions.Archive,
+		metadata: map[string]string{},
+		pipes:    map[string]*archive.Pipe{},
+		buffers:  map[string]*archive.Buffer{},
+		spools:   map[string]*archive.Spool{},
+	}
+
+	var err error
+	if source.name == "-" {
+		log.Log(log.Always, "reading archive from stdin")
+		source.in = os.Stdin
+	} else {
+		log.Logf(log.Always, "reading archive from %v", source.name)
+		source.in, err = os.Open(source.name)
+		if err != nil {
+			return fmt.Errorf("error opening archive: %v", err)
+		}
+	}
+	// archives piped from mongodump --gzip or --encryptionKeyFile are
+	// recognized by their first bytes, so --gzip does not have to be
+	// repeated here
+	buffered := &bufferedReadCloser{bufio.NewReader(source.in), source.in}
+	source.in = buffered
+	encrypted, err := util.IsEncrypted(buffered.Reader)
+	if err != nil {
+		return fmt.Errorf("error reading archive: %v", err)
+	}
+	switch {
+	case encrypted && restore.encryption == nil:
+		return fmt.Errorf("archive is encrypted, an --encryptionKeyFile is needed to restore it")
+	case !encrypted && restore.encryption != nil:
+		return fmt.Errorf("archive is not encrypted, but --encryptionKeyFile was specified")
+	case encrypted:
+		log.Log(log.DebugLow, "archive is encrypted")
+		if source.in, err = restore.encryption.WrapReadCloser(source.in); err != nil {
+			return fmt.Errorf("error decrypting archive: %v", err)
+		}
+		buffered = &bufferedReadCloser{bufio.NewReader(source.in), source.in}
+		source.in = buffered
+	}
+	codec, err := util.DetectCompressionCodec(buffered.Reader)
+	if err != nil {
+		return fmt.Errorf("error reading archive: %v", err)
+	}
+	if codec == nil && restore.InputOptions.Gzip {
+		return fmt.Errorf("archive is not gzip compressed, but --gzip was specified")
+	}
+	if codec != nil {
+		log.Logf(log.DebugLow, "archive is %v compressed", codec.Name)
+		source.in, err = codec.WrapReadCloser(source.in)
+		if err != nil {
+			return fmt.Errorf("error reading archive: %v", err)
+		}
+	}
+
+	source.demux = archive.NewDemultiplexer(source.in)
+	source.prelude, err = source.demux.ReadPrelude()
+	if err != nil {
+		return err
+	}
+	log.Logf(log.DebugLow, "archive written by version %v with %v concurrent collections",
+		source.prelude.ToolVersion, source.prelude.ConcurrentCollections)
+	restore.archive = source
+	return nil
+}
+
+// CreateIntentsFromArchive builds intents for every namespace listed in
+// the archive's prelude, honoring the --db and --collection filters, and
+// registers where the data for each of them is delivered.
+func (restore *MongoRestore) CreateIntentsFromArchive() error {
+	source := restore.archive
+	for _, ns := range source.prelude.Namespaces {
+		intent := &intents.Intent{
+			DB:       ns.Database,
+			C:        ns.Collection,
+			BSONPath: source.name,
+			Size:     ns.Size,
+		}
+		if !intent.IsOplog() {
+			if restore.ToolOptions.DB != "" && restore.ToolOptions.DB != intent.DB {
+				continue
+			}
+			if restore.ToolOptions.Collection != "" && restore.ToolOptions.Collection != intent.C {
+				continue
+			}
+		}
+		restored, err := restore.mapIntent(intent)
+		if err != nil {
+			return err
+		}
+		if !restored {
+			log.Logf(log.DebugLow, "skipping %v in archive, excluded by the namespace options", intent.Key())
+			continue
+		}
+		if ns.Metadata != "" {
+			intent.MetadataPath = source.name
+			source.metadata[intent.Key()] = ns.Metadata
+		}
+
+		switch {
+		case intent.IsOplog():
+			if !restore.InputOptions.OplogReplay {
+				log.Log(log.DebugLow, "skipping oplog in archive, --oplogReplay is not set")
+				continue
+			}
+			// the oplog is only read once every collection is restored,
+			// long after the demultiplexer has to get past its data
+			spool, err := archive.NewSpool()
+			if err != nil {
+				return fmt.Errorf("error spooling oplog from archive: %v", err)
+			}
+			source.spools[intent.Key()] = spool
+			source.demux.Open(intent.Key(), spool)
+		case intent.IsSystemIndexes():
+			// archives always carry index definitions in their metadata
+			continue
+		case intent.IsUsers(), intent.IsRoles():
+			// these are not restored by the intent workers, so their
+			// data must be held until the rest of the restore is done
+			source.buffers[intent.Key()] = archive.NewBuffer()
+			source.demux.Open(intent.Key(), source.buffers[intent.Key()])
+		default:
+			source.pipes[intent.Key()] = archive.NewPipe()
+			source.demux.Open(intent.Key(), source.pipes[intent.Key()])
+		}
+		log.Logf(log.Info, "found collection %v in archive to restore", intent.Key())
+		restore.manager.Put(intent)
+	}
+	return nil
+}
+
+// startArchive begins reading the body of the archive in the background.
+// Intents must be popped in the order the archive delivers them, so the
+// manager is finalized with a streaming prioritizer.
+func (restore *MongoRestore) startArchive() error {
+	source := restore.archive
+	// every namespace the dump had in flight at once needs its own worker,
+	// or the demultiplexer would stall on data that nobody is reading
+	if restore.OutputOptions.JobThreads < source.prelude.ConcurrentCollections {
+		log.Logf(log.Always, "archive was written with %v concurrent collections, "+
+			"restoring with %v job threads instead of %v",
+			source.prelude.ConcurrentCollections, source.prelude.ConcurrentCollections,
+			restore.OutputOptions.JobThreads)
+		restore.OutputOptions.JobThreads = source.prelude.ConcurrentCollections
+	}
+	if restore.OutputOptions.JobThreads < 1 {
+		log.Log(log.Info, "restoring archive with 1 job thread")
+		restore.OutputOptions.JobThreads = 1
+	}
+	source.demux.NamespaceChan = make(chan string)
+	restore.manager.FinalizeStreaming(source.demux.NamespaceChan)
+	source.result = make(chan error, 1)
+	go func() {
+		source.result <- source.demux.Run()
+	}()
+	return nil
+}
+
+// removeSpools removes the temporary files the archive was spooled to,
+// whether or not the restore got as far as reading them
+func (source *archiveSource) removeSpools() {
+	for key, spool := range source.spools {
+		if err := spool.Remove(); err != nil && !os.IsNotExist(err) {
+			log.Logf(log.DebugLow, "error removing the spooled data of %v: %v", key, err)
+		}
+	}
+}
+
+// finishArchive waits for the whole archive to be read and closes it.
+func (restore *MongoRestore) finishArchive() error {
+	err := <-restore.archive.result
+	restore.archive.in.Close()
+	if err != nil {
+		return fmt.Errorf("error reading archive: %v", err)
+	}
+	return nil
+}
+
+// openIntentBSON returns a reader over the BSON data of the given intent,
+// from the archive or snapshot if there is one or from the intent's file or parts otherwise.
+func (restore *MongoRestore) openIntentBSON(intent *intents.Intent) (io.ReadCloser, error) {
+	if restore.snapshot != nil {
+		return restore.openSnapshotBSON(intent)
+	}
+	if restore.archive == nil {
+		if len(intent.BSONParts) > 0 {
+			return &partsReader{paths: intent.BSONParts, key: restore.encryption, fs: restore.dumpFS()}, nil
+		}
+		return openDumpFile(restore.dumpFS(), intent.BSONPath, restore.encryption)
+	}
+	if buffer, ok := restore.archive.buffers[intent.Key()]; ok {
+		return buffer.Open()
+	}
+	if spool, ok := restore.archive.spools[intent.Key()]; ok {
+		return spool.Open()
+	}
+	if pipe, ok := restore.archive.pipes[intent.Key()]; ok {
+		return pipe, nil
+	}
+	return nil, fmt.Errorf("no data for %v in archive", intent.Key())
+}
+
+// readIntentMetadata returns the metadata JSON of the given intent,
+// from the archive or snapshot if there is one or from the intent's file otherwise.
+func (restore *MongoRestore) readIntentMetadata(intent *intents.Intent) ([]byte, error) {
+	if restore.snapshot != nil {
+		return restore.readSnapshotMetadata(intent)
+	}
+	if restore.archive == nil {
+		return readMetadataFile(restore.dumpFS(), intent.MetadataPath, restore.encryption)
+	}
+	metadata, ok := restore.archive.metadata[intent.Key()]
+	if !ok {
+		return nil, fmt.Errorf("no metadata for %v in archive", intent.Key())
+	}
+	return []byte(metadata), nil
+}
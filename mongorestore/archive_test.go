@@ -0,0 +1,206 @@
+package mongorestore
+
+import (
+	"bytes"
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/intents"
+	commonOpts "github.com/mongodb/mongo-tools/common/options"
+	"github.com/mongodb/mongo-tools/common/testutil"
+	"github.com/mongodb/mongo-tools/mongorestore/options"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestArchive writes an archive holding the given documents of the
+// users collection of dbName and oplog entries, in that order, as mongodump
+// --oplog does
+func writeTestArchive(path, dbName string, users, oplog []byte) error {
+	out := &bytes.Buffer{}
+	mux := archive.NewMultiplexer(out)
+	err := mux.WritePrelude(&archive.Prelude{
+		ToolVersion:           "test",
+		ConcurrentCollections: 1,
+		Namespaces: []*archive.NamespaceMetadata{
+			&archive.NamespaceMetadata{Database: dbName, Collection: "users"},
+			&archive.NamespaceMetadata{Collection: "oplog"},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	in := mux.Open(dbName, "users")
+	if _, err = in.Write(users); err != nil {
+		return err
+	}
+	if err = in.Close(); err != nil {
+		return err
+	}
+	in = mux.Open("", "oplog")
+	if _, err = in.Write(oplog); err != nil {
+		return err
+	}
+	if err = in.Close(); err != nil {
+		return err
+	}
+	if err = mux.Close(); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, out.Bytes(), 0644)
+}
+
+// testArchiveData returns 100 documents of the users collection of dbName,
+// and oplog entries inserting 100 more
+func testArchiveData(dbName string) ([]byte, []byte) {
+	users, oplog := []byte{}, []byte{}
+	for i := 0; i < 100; i++ {
+		doc, err := bson.Marshal(bson.D{{"_id", i}})
+		So(err, ShouldBeNil)
+		users = append(users, doc...)
+		entry, err := bson.Marshal(Oplog{Timestamp: bson.MongoTimestamp(int64(i+1) << 32),
+			Operation: "i", Namespace: dbName + ".users", Object: bson.M{"_id": 100 + i}})
+		So(err, ShouldBeNil)
+		oplog = append(oplog, entry...)
+	}
+	return users, oplog
+}
+
+func TestArchiveOplogReplay(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UNIT_TEST_TYPE)
+
+	Convey("With an archive holding a collection and its oplog", t, func() {
+		tempDir, err := ioutil.TempDir("", "mongorestore_archive")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(tempDir)
+		})
+		users, oplog := testArchiveData("app")
+		path := filepath.Join(tempDir, "dump.archive")
+		So(writeTestArchive(path, "app", users, oplog), ShouldBeNil)
+
+		restore := &MongoRestore{
+			ToolOptions:   &commonOpts.ToolOptions{Namespace: &commonOpts.Namespace{}},
+			InputOptions:  &options.InputOptions{Archive: path, OplogReplay: true},
+			OutputOptions: &options.OutputOptions{JobThreads: 1},
+			manager:       intents.NewCategorizingIntentManager(),
+		}
+		So(restore.openArchive(), ShouldBeNil)
+		Reset(func() {
+			restore.archive.removeSpools()
+		})
+		So(restore.CreateIntentsFromArchive(), ShouldBeNil)
+		So(restore.manager.Oplog(), ShouldNotBeNil)
+
+		Convey("the oplog can be read once every collection is restored", func() {
+			So(restore.startArchive(), ShouldBeNil)
+			restored := make(chan []byte)
+			go func() {
+				// stand in for the restore workers
+				for intent := restore.manager.Pop(); intent != nil; intent = restore.manager.Pop() {
+					in, err := restore.openIntentBSON(intent)
+					if err != nil {
+						restored <- nil
+						return
+					}
+					data, _ := ioutil.ReadAll(in)
+					in.Close()
+					restore.manager.Finish(intent)
+					restored <- data
+				}
+				close(restored)
+			}()
+
+			var data [][]byte
+			timeout := time.After(10 * time.Second)
+		collections:
+			for {
+				select {
+				case collection, ok := <-restored:
+					if !ok {
+						break collections
+					}
+					data = append(data, collection)
+				case <-timeout:
+					So("the restore of the archive is stuck", ShouldBeEmpty)
+					return
+				}
+			}
+			So(data, ShouldResemble, [][]byte{users})
+
+			in, err := restore.openIntentBSON(restore.manager.Oplog())
+			So(err, ShouldBeNil)
+			replayed, err := ioutil.ReadAll(in)
+			So(err, ShouldBeNil)
+			So(in.Close(), ShouldBeNil)
+			So(replayed, ShouldResemble, oplog)
+			So(restore.finishArchive(), ShouldBeNil)
+		})
+	})
+}
+
+func TestArchiveOplogRestore(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.INTEGRATION_TEST_TYPE)
+
+	Convey("An archive with an oplog should be restored with --oplogReplay", t, func() {
+		dbName := "mongorestore_archive_test_db"
+		tempDir, err := ioutil.TempDir("", "mongorestore_archive")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(tempDir)
+		})
+		users, oplog := testArchiveData(dbName)
+		path := filepath.Join(tempDir, "dump.archive")
+		So(writeTestArchive(path, dbName, users, oplog), ShouldBeNil)
+
+		ssl := testutil.GetSSLOptions()
+		auth := testutil.GetAuthOptions()
+		toolOptions := &commonOpts.ToolOptions{
+			Connection: &commonOpts.Connection{Host: "localhost", Port: "27017"},
+			Namespace:  &commonOpts.Namespace{},
+			SSL:        &ssl,
+			Auth:       &auth,
+			Verbosity:  &commonOpts.Verbosity{},
+		}
+		provider, err := db.InitSessionProvider(*toolOptions)
+		So(err, ShouldBeNil)
+		session, err := provider.GetSession()
+		So(err, ShouldBeNil)
+		Reset(func() {
+			session.DB(dbName).DropDatabase()
+			session.Close()
+		})
+
+		restore := &MongoRestore{
+			ToolOptions:  toolOptions,
+			InputOptions: &options.InputOptions{Archive: path, OplogReplay: true},
+			OutputOptions: &options.OutputOptions{
+				Drop:           true,
+				JobThreads:     4,
+				BulkWriters:    1,
+				BulkBufferSize: 1000,
+				IndexWorkers:   4,
+				OplogWorkers:   1,
+			},
+			SessionProvider: provider,
+		}
+		done := make(chan error)
+		go func() {
+			done <- restore.Restore()
+		}()
+		select {
+		case err = <-done:
+			So(err, ShouldBeNil)
+		case <-time.After(time.Minute):
+			So("the restore of the archive is stuck", ShouldBeEmpty)
+			return
+		}
+		count, err := session.DB(dbName).C("users").Count()
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 200)
+	})
+}
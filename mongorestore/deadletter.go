@@ -0,0 +1,117 @@
+package mongorestore
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/log"
+	"os"
+	"path/filepath"
+)
+
+// deadLetterFile holds the documents of a namespace that failed to be
+// written, laid out like a dump so that they can be restored again: the
+// documents go to <db>/<collection>.bson, and their errors, one JSON
+// object per line, to <db>/<collection>.errors.json
+type deadLetterFile struct {
+	docs   *os.File
+	errors *os.File
+	count  int
+}
+
+// deadLetterError is a line of a .errors.json file. Index is the position
+// of the failed document in the .bson file.
+type deadLetterError struct {
+	Index  int    `json:"index"`
+	Code   int    `json:"code"`
+	ErrMsg string `json:"errmsg"`
+}
+
+// handleWriteErrors counts and logs the documents of a *db.BulkWriteError,
+// writes them to the --deadLetterDir, and returns an error if there are more
+// than --maxWriteErrors in total. Other errors are returned as is.
+func (restore *MongoRestore) handleWriteErrors(ns string, err error) error {
+	bulkErr, ok := err.(*db.BulkWriteError)
+	if !ok {
+		return err
+	}
+	restore.writeErrorLock.Lock()
+	defer restore.writeErrorLock.Unlock()
+	for _, docErr := range bulkErr.Errors {
+		log.Logf(log.Info, "error writing document to %v: %v", ns, docErr)
+		if restore.OutputOptions.DeadLetterDir != "" {
+			if err := restore.writeDeadLetter(ns, docErr); err != nil {
+				return err
+			}
+		}
+	}
+	restore.writeErrors += len(bulkErr.Errors)
+	if max := restore.OutputOptions.MaxWriteErrors; max >= 0 && restore.writeErrors > max {
+		return fmt.Errorf("%v documents failed to be written, more than the %v allowed by --maxWriteErrors; last error: %v",
+			restore.writeErrors, max, bulkErr.Errors[len(bulkErr.Errors)-1])
+	}
+	return nil
+}
+
+// writeDeadLetter appends a failed document and its error to the
+// files of its namespace, creating them on the first failure.
+// Must be called with writeErrorLock held.
+func (restore *MongoRestore) writeDeadLetter(ns string, docErr db.DocumentError) error {
+	file, ok := restore.deadLetters[ns]
+	if !ok {
+		dbName, collection := splitNS(ns)
+		dir := filepath.Join(restore.OutputOptions.DeadLetterDir, dbName)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("error creating dead letter directory %v: %v", dir, err)
+		}
+		file = &deadLetterFile{}
+		var err error
+		path := filepath.Join(dir, collection+".bson")
+		if file.docs, err = os.Create(path); err != nil {
+			return fmt.Errorf("error creating dead letter file %v: %v", path, err)
+		}
+		path = filepath.Join(dir, collection+".errors.json")
+		if file.errors, err = os.Create(path); err != nil {
+			file.docs.Close()
+			return fmt.Errorf("error creating dead letter file %v: %v", path, err)
+		}
+		if restore.deadLetters == nil {
+			restore.deadLetters = map[string]*deadLetterFile{}
+		}
+		restore.deadLetters[ns] = file
+	}
+	if _, err := file.docs.Write(docErr.Doc.Data); err != nil {
+		return fmt.Errorf("error writing dead letter document of %v: %v", ns, err)
+	}
+	jsonBytes, err := json.Marshal(deadLetterError{file.count, docErr.Code, docErr.ErrMsg})
+	if err != nil {
+		return fmt.Errorf("error marshalling dead letter error of %v: %v", ns, err)
+	}
+	if _, err = file.errors.Write(append(jsonBytes, '\n')); err != nil {
+		return fmt.Errorf("error writing dead letter error of %v: %v", ns, err)
+	}
+	file.count++
+	return nil
+}
+
+// closeDeadLetters closes the files of the --deadLetterDir
+// and logs how many documents failed to be written
+func (restore *MongoRestore) closeDeadLetters() error {
+	restore.writeErrorLock.Lock()
+	defer restore.writeErrorLock.Unlock()
+	if restore.writeErrors > 0 {
+		log.Logf(log.Always, "%v documents failed to be written", restore.writeErrors)
+	}
+	var firstErr error
+	for ns, file := range restore.deadLetters {
+		log.Logf(log.Always, "wrote %v failed documents of %v to %v",
+			file.count, ns, file.docs.Name())
+		for _, f := range []*os.File{file.docs, file.errors} {
+			if err := f.Close(); err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("error closing dead letter file %v: %v", f.Name(), err)
+			}
+		}
+	}
+	restore.deadLetters = nil
+	return firstErr
+}
@@ -0,0 +1,81 @@
+package mongorestore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/testutil"
+	"github.com/mongodb/mongo-tools/mongorestore/options"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDeadLetters(t *testing.T) {
+
+	testutil.VerifyTestType(t, testutil.UNIT_TEST_TYPE)
+
+	Convey("With a MongoRestore allowing 2 write errors", t, func() {
+		dir, err := ioutil.TempDir("", "deadletter_test")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(dir)
+		})
+		restore := &MongoRestore{
+			OutputOptions: &options.OutputOptions{MaxWriteErrors: 2, DeadLetterDir: dir},
+		}
+		docError := func(id int, code int) db.DocumentError {
+			raw, err := bson.Marshal(bson.M{"_id": id})
+			So(err, ShouldBeNil)
+			return db.DocumentError{Doc: bson.Raw{Data: raw}, Code: code, ErrMsg: "E11000 duplicate key"}
+		}
+
+		Convey("failed documents should be written out with their errors until there are too many", func() {
+			So(restore.handleWriteErrors("app.users", nil), ShouldBeNil)
+			err := restore.handleWriteErrors("app.users",
+				&db.BulkWriteError{[]db.DocumentError{docError(1, 11000), docError(2, 11000)}})
+			So(err, ShouldBeNil)
+			So(restore.handleWriteErrors("app.users",
+				&db.BulkWriteError{[]db.DocumentError{docError(3, 121)}}), ShouldNotBeNil)
+			So(restore.writeErrors, ShouldEqual, 3)
+			So(restore.closeDeadLetters(), ShouldBeNil)
+
+			data, err := ioutil.ReadFile(filepath.Join(dir, "app", "users.bson"))
+			So(err, ShouldBeNil)
+			source := db.NewDecodedBSONSource(db.NewBSONSource(ioutil.NopCloser(bytes.NewReader(data))))
+			ids := []interface{}{}
+			doc := bson.M{}
+			for source.Next(&doc) {
+				ids = append(ids, doc["_id"])
+			}
+			So(ids, ShouldResemble, []interface{}{1, 2, 3})
+
+			errorsFile, err := os.Open(filepath.Join(dir, "app", "users.errors.json"))
+			So(err, ShouldBeNil)
+			defer errorsFile.Close()
+			lines := []deadLetterError{}
+			scanner := bufio.NewScanner(errorsFile)
+			for scanner.Scan() {
+				line := deadLetterError{}
+				So(json.Unmarshal(scanner.Bytes(), &line), ShouldBeNil)
+				lines = append(lines, line)
+			}
+			So(lines, ShouldResemble, []deadLetterError{
+				{0, 11000, "E11000 duplicate key"},
+				{1, 11000, "E11000 duplicate key"},
+				{2, 121, "E11000 duplicate key"},
+			})
+		})
+
+		Convey("other errors should be returned as they are", func() {
+			other := fmt.Errorf("connection lost")
+			So(restore.handleWriteErrors("app.users", other), ShouldEqual, other)
+			So(restore.writeErrors, ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,308 @@
+package mongorestore
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"gopkg.in/mgo.v2/bson"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Dry run actions on a namespace
+const (
+	DryRunCreate = "create"
+	DryRunDrop   = "drop"
+	DryRunAppend = "append"
+)
+
+// Dry run output formats
+const (
+	DryRunText = "text"
+	DryRunJSON = "json"
+)
+
+// DryRunNamespace is what restoring a single namespace would do. Documents
+// and Bytes count the BSON in the dump, and are -1 when they cannot be
+// counted without consuming the input, as with archives.
+type DryRunNamespace struct {
+	Namespace   string      `json:"ns"`
+	RenamedFrom string      `json:"renamedFrom,omitempty"`
+	View        bool        `json:"view,omitempty"`
+	Exists      bool        `json:"exists"`
+	Action      string      `json:"action"`
+	Options     interface{} `json:"options,omitempty"`
+	Indexes     []string    `json:"indexes"`
+	Documents   int64       `json:"documents"`
+	Bytes       int64       `json:"bytes"`
+}
+
+// DryRunOplog is the part of the dump's oplog that would be replayed,
+// with timestamps in the <seconds>:<ordinal> form of --oplogLimit
+type DryRunOplog struct {
+	Entries int64  `json:"entries"`
+	Bytes   int64  `json:"bytes"`
+	First   string `json:"first,omitempty"`
+	Last    string `json:"last,omitempty"`
+}
+
+// DryRunPlan is everything a restore would do, as reported by --dryRun
+type DryRunPlan struct {
+	Namespaces []*DryRunNamespace `json:"namespaces"`
+	Users      bool               `json:"users"`
+	Roles      bool               `json:"roles"`
+	Oplog      *DryRunOplog       `json:"oplog,omitempty"`
+}
+
+// DryRun builds the plan of the restore from the intents that were created,
+// reading the metadata and counting the documents of every namespace and
+// checking which of them exist on the target, and writes it to out
+// without modifying the target.
+func (restore *MongoRestore) DryRun(out io.Writer) error {
+	log.Log(log.Always, "dry run, nothing will be restored")
+	plan, err := restore.DryRunPlan()
+	if err != nil {
+		return err
+	}
+	if restore.OutputOptions.DryRunFormat == DryRunJSON {
+		jsonBytes, err := json.MarshalIndent(plan, "", "\t")
+		if err != nil {
+			return fmt.Errorf("error marshalling dry run plan: %v", err)
+		}
+		_, err = fmt.Fprintf(out, "%s\n", jsonBytes)
+		return err
+	}
+	return plan.WriteText(out)
+}
+
+// DryRunPlan builds the plan of the restore. Must be called
+// before the intent manager is finalized.
+func (restore *MongoRestore) DryRunPlan() (*DryRunPlan, error) {
+	plan := &DryRunPlan{Namespaces: []*DryRunNamespace{}}
+	buf := make([]byte, db.MaxBSONSize)
+	intentList := restore.manager.Intents()
+	restore.sortPackedIntents(intentList)
+	for _, intent := range intentList {
+		entry, err := restore.dryRunIntent(intent, buf)
+		if err != nil {
+			return nil, fmt.Errorf("error planning restore of %v: %v", intent.Key(), err)
+		}
+		plan.Namespaces = append(plan.Namespaces, entry)
+	}
+	sort.Sort(dryRunNamespacesByName(plan.Namespaces))
+
+	if restore.restoresUsersAndRoles() {
+		plan.Users = restore.manager.Users() != nil
+		plan.Roles = restore.manager.Roles() != nil
+	}
+	if restore.InputOptions.OplogReplay && restore.manager.Oplog() != nil {
+		var err error
+		if plan.Oplog, err = restore.dryRunOplog(restore.manager.Oplog()); err != nil {
+			return nil, fmt.Errorf("error planning oplog replay: %v", err)
+		}
+	}
+	return plan, nil
+}
+
+// dryRunIntent works out what RestoreIntent would do with an intent
+func (restore *MongoRestore) dryRunIntent(intent *intents.Intent, buf []byte) (*DryRunNamespace, error) {
+	entry := &DryRunNamespace{Namespace: intent.Key(), Indexes: []string{}}
+	if source, ok := restore.renamedFrom[intent.Key()]; ok {
+		entry.RenamedFrom = source
+	}
+
+	var meta *Metadata
+	if intent.MetadataPath != "" {
+		jsonBytes, err := restore.readIntentMetadata(intent)
+		if err != nil {
+			return nil, fmt.Errorf("error reading metadata: %v", err)
+		}
+		if meta, err = restore.MetadataFromJSON(jsonBytes); err != nil {
+			return nil, fmt.Errorf("error parsing metadata: %v", err)
+		}
+		entry.View = meta.IsView()
+	}
+
+	exists, err := restore.DBHasCollection(intent)
+	if err != nil {
+		return nil, fmt.Errorf("error reading database: %v", err)
+	}
+	entry.Exists = exists
+	switch {
+	case !exists:
+		entry.Action = DryRunCreate
+	case restore.OutputOptions.Drop && !strings.HasPrefix(intent.C, "system."):
+		entry.Action = DryRunDrop
+	default:
+		entry.Action = DryRunAppend
+	}
+
+	// options only apply to collections that are created
+	if meta != nil && meta.Options != nil && entry.Action != DryRunAppend &&
+		!restore.OutputOptions.NoOptionsRestore {
+		if entry.Options, err = bsonutil.ConvertBSONValueToJSON(meta.Options); err != nil {
+			return nil, fmt.Errorf("error converting options: %v", err)
+		}
+	}
+	if entry.View {
+		return entry, nil
+	}
+
+	if !restore.OutputOptions.NoIndexRestore {
+		var indexes []IndexDocument
+		sourceDB, _ := restore.sourceOf(intent)
+		if meta != nil {
+			indexes = meta.Indexes
+		} else if restore.archive == nil && restore.manager.SystemIndexes(sourceDB) != nil {
+			indexes, err = restore.IndexesFromBSON(intent, restore.manager.SystemIndexes(sourceDB))
+			if err != nil {
+				return nil, fmt.Errorf("error reading indexes: %v", err)
+			}
+		}
+		for _, index := range indexes {
+			entry.Indexes = append(entry.Indexes, fmt.Sprintf("%v", index.Options["name"]))
+		}
+	}
+
+	if intent.BSONPath == "" {
+		return entry, nil
+	}
+	if restore.archive != nil {
+		// counting would consume the archive
+		entry.Documents, entry.Bytes = -1, intent.Size
+		return entry, nil
+	}
+	rawBSONSource, err := restore.openIntentBSON(intent)
+	if err != nil {
+		return nil, fmt.Errorf("error reading bson: %v", err)
+	}
+	bsonSource := db.NewBSONSource(rawBSONSource)
+	defer bsonSource.Close()
+	for {
+		hasDoc, docSize := bsonSource.LoadNextInto(buf)
+		if !hasDoc {
+			break
+		}
+		entry.Documents++
+		entry.Bytes += int64(docSize)
+	}
+	if err = bsonSource.Err(); err != nil {
+		return nil, fmt.Errorf("error reading bson: %v", err)
+	}
+	return entry, nil
+}
+
+// dryRunOplog counts the oplog entries RestoreOplog would replay
+func (restore *MongoRestore) dryRunOplog(intent *intents.Intent) (*DryRunOplog, error) {
+	oplog := &DryRunOplog{}
+	if restore.archive != nil {
+		// counting would consume the archive
+		oplog.Entries, oplog.Bytes = -1, intent.Size
+		return oplog, nil
+	}
+	sources := restore.oplogSources
+	if sources == nil {
+		source, err := restore.dumpOplogSource(intent)
+		if err != nil {
+			return nil, err
+		}
+		sources = []*oplogSource{source}
+	}
+	reader := restore.newOplogReader(sources)
+	defer reader.Close()
+	entry := Oplog{}
+	for {
+		size, ok := reader.Next(&entry)
+		if !ok {
+			break
+		}
+		if !restore.selectOplogEntry(&entry) {
+			continue
+		}
+		if oplog.Entries == 0 {
+			oplog.First = formatTimestamp(entry.Timestamp)
+		}
+		oplog.Last = formatTimestamp(entry.Timestamp)
+		oplog.Entries++
+		oplog.Bytes += int64(size)
+	}
+	if err := reader.Err(); err != nil {
+		return nil, fmt.Errorf("error reading oplog: %v", err)
+	}
+	return oplog, nil
+}
+
+// formatTimestamp formats a timestamp the way ParseTimestampFlag reads it
+func formatTimestamp(ts bson.MongoTimestamp) string {
+	return fmt.Sprintf("%v:%v", uint64(ts)>>32, uint32(ts))
+}
+
+// WriteText writes the plan in a form meant to be read by people
+func (plan *DryRunPlan) WriteText(out io.Writer) error {
+	lines := []string{}
+	for _, entry := range plan.Namespaces {
+		kind := "collection"
+		if entry.View {
+			kind = "view"
+		}
+		line := fmt.Sprintf("%v %v %v", entry.Action, kind, entry.Namespace)
+		if entry.RenamedFrom != "" {
+			line += fmt.Sprintf(" (from %v)", entry.RenamedFrom)
+		}
+		if !entry.View {
+			if entry.Documents < 0 {
+				line += fmt.Sprintf(": %v bytes", entry.Bytes)
+			} else {
+				line += fmt.Sprintf(": %v documents, %v bytes", entry.Documents, entry.Bytes)
+			}
+		}
+		lines = append(lines, line)
+		if entry.Options != nil {
+			options, err := json.Marshal(entry.Options)
+			if err != nil {
+				return fmt.Errorf("error marshalling options of %v: %v", entry.Namespace, err)
+			}
+			lines = append(lines, fmt.Sprintf("\twith options %s", options))
+		}
+		if len(entry.Indexes) > 0 {
+			lines = append(lines, fmt.Sprintf("\tbuilding indexes %v", strings.Join(entry.Indexes, ", ")))
+		}
+	}
+	if plan.Users {
+		lines = append(lines, "restore users")
+	}
+	if plan.Roles {
+		lines = append(lines, "restore roles")
+	}
+	if plan.Oplog != nil {
+		switch {
+		case plan.Oplog.Entries < 0:
+			lines = append(lines, fmt.Sprintf("replay oplog: %v bytes", plan.Oplog.Bytes))
+		case plan.Oplog.Entries == 0:
+			lines = append(lines, "replay oplog: no entries")
+		default:
+			lines = append(lines, fmt.Sprintf("replay oplog: %v entries, %v bytes, from %v to %v",
+				plan.Oplog.Entries, plan.Oplog.Bytes, plan.Oplog.First, plan.Oplog.Last))
+		}
+	}
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(out, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type dryRunNamespacesByName []*DryRunNamespace
+
+func (entries dryRunNamespacesByName) Len() int { return len(entries) }
+func (entries dryRunNamespacesByName) Swap(i, j int) {
+	entries[i], entries[j] = entries[j], entries[i]
+}
+func (entries dryRunNamespacesByName) Less(i, j int) bool {
+	return entries[i].Namespace < entries[j].Namespace
+}
@@ -0,0 +1,59 @@
+package mongorestore
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+	"testing"
+)
+
+func TestDryRunPlan(t *testing.T) {
+
+	testutil.VerifyTestType(t, testutil.UNIT_TEST_TYPE)
+
+	Convey("With a dry run plan", t, func() {
+		plan := &DryRunPlan{
+			Namespaces: []*DryRunNamespace{
+				{Namespace: "app.logs", Exists: true, Action: DryRunAppend,
+					Indexes: []string{}, Documents: 3, Bytes: 120},
+				{Namespace: "app.users", RenamedFrom: "prod.users", Action: DryRunCreate,
+					Options: map[string]interface{}{"capped": true},
+					Indexes: []string{"_id_", "email_1"}, Documents: 2, Bytes: 80},
+				{Namespace: "app.active", View: true, Action: DryRunCreate, Indexes: []string{}},
+			},
+			Users: true,
+			Oplog: &DryRunOplog{Entries: 2, Bytes: 200, First: "10:1", Last: "12:3"},
+		}
+
+		Convey("the text report should have a line per action", func() {
+			out := &bytes.Buffer{}
+			So(plan.WriteText(out), ShouldBeNil)
+			So(out.String(), ShouldEqual, "append collection app.logs: 3 documents, 120 bytes\n"+
+				"create collection app.users (from prod.users): 2 documents, 80 bytes\n"+
+				"\twith options {\"capped\":true}\n"+
+				"\tbuilding indexes _id_, email_1\n"+
+				"create view app.active\n"+
+				"restore users\n"+
+				"replay oplog: 2 entries, 200 bytes, from 10:1 to 12:3\n")
+		})
+
+		Convey("the JSON report should read back into a plan", func() {
+			jsonBytes, err := json.Marshal(plan)
+			So(err, ShouldBeNil)
+			decoded := &DryRunPlan{}
+			So(json.Unmarshal(jsonBytes, decoded), ShouldBeNil)
+			So(len(decoded.Namespaces), ShouldEqual, 3)
+			So(decoded.Namespaces[1].RenamedFrom, ShouldEqual, "prod.users")
+			So(decoded.Roles, ShouldBeFalse)
+			So(*decoded.Oplog, ShouldResemble, *plan.Oplog)
+		})
+	})
+
+	Convey("Timestamps should be formatted the way --oplogLimit reads them", t, func() {
+		ts, err := ParseTimestampFlag(formatTimestamp(bson.MongoTimestamp(1234<<32 | 5)))
+		So(err, ShouldBeNil)
+		So(ts, ShouldEqual, bson.MongoTimestamp(1234<<32|5))
+	})
+}
@@ -0,0 +1,526 @@
+package mongorestore
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dumpFS is the file system the files of a dump folder are read from,
+// so that dumps packed in tar or zip files are restored without
+// extracting them. Paths are those of the host, or within the packed file.
+type dumpFS interface {
+	// ReadDir returns the entries of a folder sorted by name
+	ReadDir(dir string) ([]os.FileInfo, error)
+	Stat(path string) (os.FileInfo, error)
+	Open(path string) (io.ReadCloser, error)
+	Close() error
+}
+
+// osFS is the dumpFS of dump folders on disk
+type osFS struct{}
+
+func (osFS) ReadDir(dir string) ([]os.FileInfo, error) { return ioutil.ReadDir(dir) }
+func (osFS) Stat(path string) (os.FileInfo, error)     { return os.Lstat(path) }
+func (osFS) Open(path string) (io.ReadCloser, error)   { return os.Open(path) }
+func (osFS) Close() error                              { return nil }
+
+// dumpFS returns the file system the dump folder is read from
+func (restore *MongoRestore) dumpFS() dumpFS {
+	if restore.packedDump != nil {
+		return restore.packedDump
+	}
+	return osFS{}
+}
+
+// openDumpFile opens a file of the dump folder for reading,
+// decrypting and decompressing it like util.OpenDecrypted
+func openDumpFile(fs dumpFS, path string, key *util.EncryptionKey) (io.ReadCloser, error) {
+	if fs == nil {
+		return util.OpenDecrypted(path, key)
+	}
+	file, err := fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return util.WrapDecrypted(file, path, key)
+}
+
+// packedDumpExtensions are the extensions of the files dumps are packed in
+var packedDumpExtensions = []string{".tar", ".tgz", ".zip"}
+
+// isPackedDump returns whether the file name is that of a tar file,
+// compressed with any known codec, or of a zip file
+func isPackedDump(name string) bool {
+	_, name = util.CompressionCodecForFile(name)
+	for _, extension := range packedDumpExtensions {
+		if strings.HasSuffix(name, extension) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitPackedDumpPath splits a path that leads into a packed dump, such
+// as "backup.tar.gz/dump/app", into the packed file and the path within it,
+// or returns false if no part of the path is a packed file
+func splitPackedDumpPath(target string) (string, string, bool) {
+	parts := strings.Split(filepath.Clean(target), string(filepath.Separator))
+	for i := range parts {
+		packed := strings.Join(parts[:i+1], string(filepath.Separator))
+		if packed == "" || !isPackedDump(packed) {
+			continue
+		}
+		if info, err := os.Stat(packed); err == nil && !info.IsDir() {
+			return packed, strings.Join(parts[i+1:], "/"), true
+		}
+	}
+	return "", "", false
+}
+
+// packedDumpFS reads a dump folder from the members of a tar file, which
+// may be compressed, or of a zip file, in place. Compressed streams cannot
+// be seeked, so the members of a compressed tar are read from a single pass
+// decompressing it, which is best made in the order they are packed in; its
+// small files other than BSON, such as metadata, are kept in memory when it
+// is listed, so that they can be read at any time.
+type packedDumpFS struct {
+	path string
+	// members are the files of the packed dump by path, and dirs
+	// the entries of every folder, including implicit ones
+	members map[string]*packedMember
+	dirs    map[string]map[string]os.FileInfo
+
+	zip *zip.ReadCloser
+	// stream reads the members of a compressed tar
+	stream *tarStream
+}
+
+type packedMember struct {
+	info os.FileInfo
+	// offset is where the data of a member of a tar starts, within the
+	// decompressed tar if it is compressed, and data is the member itself
+	// if it is kept in memory
+	offset  int64
+	data    []byte
+	zipFile *zip.File
+}
+
+// maxBufferedMember is the size up to which the files of a compressed
+// tar other than the BSON of collections are kept in memory
+const maxBufferedMember = 16 * 1024 * 1024
+
+// packedDirInfo describes a folder of a packed dump
+type packedDirInfo string
+
+func (dir packedDirInfo) Name() string       { return path.Base(string(dir)) }
+func (dir packedDirInfo) Size() int64        { return 0 }
+func (dir packedDirInfo) Mode() os.FileMode  { return os.ModeDir | 0755 }
+func (dir packedDirInfo) ModTime() time.Time { return time.Time{} }
+func (dir packedDirInfo) IsDir() bool        { return true }
+func (dir packedDirInfo) Sys() interface{}   { return nil }
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.Reader.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// openPackedDump lists the members of the tar or zip file at the given path
+func openPackedDump(packedPath string) (*packedDumpFS, error) {
+	fs := &packedDumpFS{
+		path:    packedPath,
+		members: map[string]*packedMember{},
+		dirs:    map[string]map[string]os.FileInfo{"": {}},
+	}
+	codec, name := util.CompressionCodecForFile(packedPath)
+	if strings.HasSuffix(name, ".tgz") {
+		codec, _ = util.GetCompressionCodec("gzip")
+	}
+	if strings.HasSuffix(name, ".zip") {
+		reader, err := zip.OpenReader(packedPath)
+		if err != nil {
+			return nil, fmt.Errorf("error opening zip file %v: %v", packedPath, err)
+		}
+		fs.zip = reader
+		for _, file := range reader.File {
+			fs.add(file.Name, file.FileInfo(), &packedMember{info: file.FileInfo(), zipFile: file})
+		}
+		return fs, nil
+	}
+
+	file, err := os.Open(packedPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var in io.Reader = file
+	if codec != nil {
+		decompressed, err := codec.WrapReadCloser(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading tar file %v: %v", packedPath, err)
+		}
+		defer decompressed.Close()
+		in = decompressed
+		fs.stream = &tarStream{path: packedPath, codec: codec}
+	}
+	counter := &countingReader{Reader: in}
+	tarReader := tar.NewReader(counter)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading tar file %v: %v", packedPath, err)
+		}
+		switch header.Typeflag {
+		case tar.TypeReg, tar.TypeRegA, tar.TypeDir:
+			info := header.FileInfo()
+			member := &packedMember{info: info, offset: counter.n}
+			// the indexes of legacy dumps are read for each collection
+			if baseName, fileType := GetInfoFromFilename(header.Name); fs.stream != nil && !info.IsDir() &&
+				(fileType != BSONFileType || baseName == "system.indexes") && info.Size() <= maxBufferedMember {
+				if member.data, err = ioutil.ReadAll(tarReader); err != nil {
+					return nil, fmt.Errorf("error reading %v from tar file %v: %v", header.Name, packedPath, err)
+				}
+			}
+			fs.add(header.Name, info, member)
+		default:
+			log.Logf(log.DebugLow, "skipping %v in %v, which is not a file or folder", header.Name, packedPath)
+		}
+	}
+	return fs, nil
+}
+
+// tarStream reads the members of a compressed tar from a single pass
+// decompressing it. Members past the last one read are reached by reading
+// on through the ones in between, and earlier ones by decompressing the tar
+// from its start again. Only one member can be read at a time.
+type tarStream struct {
+	path  string
+	codec *util.CompressionCodec
+	lock  sync.Mutex
+	in    io.ReadCloser
+	// pos is how far into the decompressed tar in has been read,
+	// and reading the member being read from it, if any
+	pos     int64
+	reading string
+}
+
+// open returns a reader for the member of the given size at offset
+func (ts *tarStream) open(name string, offset, size int64) (io.ReadCloser, error) {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	if ts.reading != "" {
+		return nil, fmt.Errorf("cannot read %v from compressed tar file %v while %v is being read from it",
+			name, ts.path, ts.reading)
+	}
+	if ts.in == nil || offset < ts.pos {
+		if ts.in != nil {
+			log.Logf(log.Info, "decompressing %v from its start again to read %v", ts.path, name)
+		}
+		if err := ts.restart(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := io.CopyN(ioutil.Discard, ts.in, offset-ts.pos); err != nil {
+		ts.close()
+		return nil, fmt.Errorf("error reading %v from tar file %v: %v", name, ts.path, err)
+	}
+	ts.pos = offset
+	ts.reading = name
+	return &tarStreamMember{Reader: io.LimitReader(ts.in, size), stream: ts}, nil
+}
+
+// restart decompresses the tar from its start
+func (ts *tarStream) restart() error {
+	ts.close()
+	file, err := os.Open(ts.path)
+	if err != nil {
+		return err
+	}
+	in, err := ts.codec.WrapReadCloser(file)
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("error reading tar file %v: %v", ts.path, err)
+	}
+	ts.in, ts.pos = in, 0
+	return nil
+}
+
+func (ts *tarStream) close() error {
+	if ts.in == nil {
+		return nil
+	}
+	err := ts.in.Close()
+	ts.in = nil
+	return err
+}
+
+func (ts *tarStream) Close() error {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	return ts.close()
+}
+
+// tarStreamMember reads a member of a compressed tar from its tarStream
+type tarStreamMember struct {
+	io.Reader
+	stream *tarStream
+	closed bool
+}
+
+func (member *tarStreamMember) Read(p []byte) (int, error) {
+	n, err := member.Reader.Read(p)
+	member.stream.pos += int64(n)
+	return n, err
+}
+
+func (member *tarStreamMember) Close() error {
+	member.stream.lock.Lock()
+	defer member.stream.lock.Unlock()
+	if !member.closed {
+		member.closed = true
+		member.stream.reading = ""
+	}
+	return nil
+}
+
+// cleanPackedPath turns a path within a packed dump into the form
+// members are kept by, in which the root folder is the empty string
+func cleanPackedPath(name string) string {
+	name = path.Clean("/" + filepath.ToSlash(name))
+	return strings.TrimPrefix(name, "/")
+}
+
+// add registers a member, and every folder it is in
+func (fs *packedDumpFS) add(name string, info os.FileInfo, member *packedMember) {
+	name = cleanPackedPath(name)
+	if name == "" {
+		return
+	}
+	if info.IsDir() {
+		fs.addDir(name)
+		return
+	}
+	fs.members[name] = member
+	parent := path.Dir(name)
+	if parent == "." {
+		parent = ""
+	}
+	fs.addDir(parent)
+	fs.dirs[parent][path.Base(name)] = info
+}
+
+func (fs *packedDumpFS) addDir(dir string) {
+	if _, ok := fs.dirs[dir]; ok {
+		return
+	}
+	fs.dirs[dir] = map[string]os.FileInfo{}
+	if dir == "" {
+		return
+	}
+	parent := path.Dir(dir)
+	if parent == "." {
+		parent = ""
+	}
+	fs.addDir(parent)
+	fs.dirs[parent][path.Base(dir)] = packedDirInfo(dir)
+}
+
+func (fs *packedDumpFS) ReadDir(dir string) ([]os.FileInfo, error) {
+	entries, ok := fs.dirs[cleanPackedPath(dir)]
+	if !ok {
+		return nil, &os.PathError{Op: "readdir", Path: fs.path + ":" + dir, Err: os.ErrNotExist}
+	}
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	infos := make([]os.FileInfo, len(names))
+	for i, name := range names {
+		infos[i] = entries[name]
+	}
+	return infos, nil
+}
+
+func (fs *packedDumpFS) Stat(name string) (os.FileInfo, error) {
+	name = cleanPackedPath(name)
+	if member, ok := fs.members[name]; ok {
+		return member.info, nil
+	}
+	if _, ok := fs.dirs[name]; ok {
+		return packedDirInfo(name), nil
+	}
+	return nil, &os.PathError{Op: "stat", Path: fs.path + ":" + name, Err: os.ErrNotExist}
+}
+
+// packedReader reads a member of a packed dump, closing what it was read from
+type packedReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (pr *packedReader) Close() error {
+	var err error
+	for _, closer := range pr.closers {
+		if closeErr := closer.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (fs *packedDumpFS) Open(name string) (io.ReadCloser, error) {
+	name = cleanPackedPath(name)
+	member, ok := fs.members[name]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: fs.path + ":" + name, Err: os.ErrNotExist}
+	}
+	if member.zipFile != nil {
+		return member.zipFile.Open()
+	}
+	if member.data != nil {
+		return ioutil.NopCloser(bytes.NewReader(member.data)), nil
+	}
+	if fs.stream != nil {
+		return fs.stream.open(name, member.offset, member.info.Size())
+	}
+	file, err := os.Open(fs.path)
+	if err != nil {
+		return nil, err
+	}
+	return &packedReader{io.NewSectionReader(file, member.offset, member.info.Size()), []io.Closer{file}}, nil
+}
+
+func (fs *packedDumpFS) Close() error {
+	if fs.zip != nil {
+		return fs.zip.Close()
+	}
+	if fs.stream != nil {
+		return fs.stream.Close()
+	}
+	return nil
+}
+
+// dumpRoot returns the folder within the packed dump that holds the dump,
+// which is the folder a dump was packed from if it is the only thing
+// packed, as in "tar czf dump.tar.gz dump", or the root otherwise
+func (fs *packedDumpFS) dumpRoot() string {
+	root := fs.dirs[""]
+	if len(root) != 1 {
+		return ""
+	}
+	for name, info := range root {
+		if !info.IsDir() {
+			return ""
+		}
+		// a folder holding collections is a database, not a dump
+		for _, entry := range fs.dirs[name] {
+			if _, fileType := GetInfoFromFilename(entry.Name()); fileType != UnknownFileType && !entry.IsDir() {
+				if _, base := util.CompressionCodecForFile(entry.Name()); base != "oplog.bson" && base != "oplog.metadata.json" {
+					return ""
+				}
+			}
+		}
+		return name
+	}
+	return ""
+}
+
+// openPackedDump reads the dump from the tar or zip file at packedPath,
+// at the given path within it, or at its dumpRoot if the path is empty
+func (restore *MongoRestore) openPackedDump(packedPath, inner string) error {
+	fs, err := openPackedDump(packedPath)
+	if err != nil {
+		return err
+	}
+	if inner == "" {
+		inner = fs.dumpRoot()
+	}
+	if _, err = fs.Stat(inner); err != nil {
+		fs.Close()
+		return fmt.Errorf("error reading dump from %v: %v", packedPath, err)
+	}
+	log.Logf(log.Always, "reading the dump in %v from %v/%v", packedPath, packedPath, inner)
+	restore.packedDump = fs
+	restore.TargetDirectory = inner
+	return nil
+}
+
+// streamsPackedDump returns whether the dump is read from a compressed tar,
+// whose members are best read in the order they are packed in
+func (restore *MongoRestore) streamsPackedDump() bool {
+	return restore.packedDump != nil && restore.packedDump.stream != nil
+}
+
+// intentOffset returns where the data of the first file read for an
+// intent, its BSON file or first part, or else its metadata, is packed
+func (fs *packedDumpFS) intentOffset(intent *intents.Intent) int64 {
+	name := intent.MetadataPath
+	if len(intent.BSONParts) > 0 {
+		name = intent.BSONParts[0]
+	} else if intent.BSONPath != "" {
+		name = intent.BSONPath
+	}
+	if member, ok := fs.members[cleanPackedPath(name)]; ok {
+		return member.offset
+	}
+	return math.MaxInt64
+}
+
+type intentsByOffset struct {
+	list []*intents.Intent
+	fs   *packedDumpFS
+}
+
+func (byOffset intentsByOffset) Len() int { return len(byOffset.list) }
+func (byOffset intentsByOffset) Swap(i, j int) {
+	byOffset.list[i], byOffset.list[j] = byOffset.list[j], byOffset.list[i]
+}
+func (byOffset intentsByOffset) Less(i, j int) bool {
+	return byOffset.fs.intentOffset(byOffset.list[i]) < byOffset.fs.intentOffset(byOffset.list[j])
+}
+
+// sortPackedIntents sorts intents in the order their files are packed in,
+// if the dump is read from a compressed tar, so that it is read in one pass
+func (restore *MongoRestore) sortPackedIntents(intentList []*intents.Intent) {
+	if restore.streamsPackedDump() {
+		sort.Stable(intentsByOffset{intentList, restore.packedDump})
+	}
+}
+
+// packedIntentOrder returns the keys of the intents in the order their
+// files are packed in, for the manager's streaming prioritizer
+func (restore *MongoRestore) packedIntentOrder() <-chan string {
+	intentList := restore.manager.Intents()
+	restore.sortPackedIntents(intentList)
+	order := make(chan string, len(intentList))
+	for _, intent := range intentList {
+		order <- intent.Key()
+	}
+	close(order)
+	return order
+}
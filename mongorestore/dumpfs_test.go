@@ -0,0 +1,253 @@
+package mongorestore
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// packTestDump packs the files under dir into the tar or zip file at
+// path, under the given prefix, compressing tars whose name ends in .gz
+func packTestDump(path, dir, prefix string) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	var add func(name string, info os.FileInfo, data []byte) error
+	var finish func() error
+	if strings.HasSuffix(path, ".zip") {
+		zipWriter := zip.NewWriter(out)
+		add = func(name string, info os.FileInfo, data []byte) error {
+			if info.IsDir() {
+				return nil
+			}
+			w, err := zipWriter.Create(name)
+			if err != nil {
+				return err
+			}
+			_, err = w.Write(data)
+			return err
+		}
+		finish = zipWriter.Close
+	} else {
+		var w io.Writer = out
+		var gzipWriter *gzip.Writer
+		if strings.HasSuffix(path, ".gz") {
+			gzipWriter = gzip.NewWriter(out)
+			w = gzipWriter
+		}
+		tarWriter := tar.NewWriter(w)
+		add = func(name string, info os.FileInfo, data []byte) error {
+			header, err := tar.FileInfoHeader(info, "")
+			if err != nil {
+				return err
+			}
+			header.Name = name
+			if err = tarWriter.WriteHeader(header); err != nil {
+				return err
+			}
+			_, err = tarWriter.Write(data)
+			return err
+		}
+		finish = func() error {
+			if err := tarWriter.Close(); err != nil {
+				return err
+			}
+			if gzipWriter != nil {
+				return gzipWriter.Close()
+			}
+			return nil
+		}
+	}
+	err = filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil || file == dir {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		var data []byte
+		if !info.IsDir() {
+			if data, err = ioutil.ReadFile(file); err != nil {
+				return err
+			}
+		}
+		return add(prefix+filepath.ToSlash(rel), info, data)
+	})
+	if err != nil {
+		return err
+	}
+	return finish()
+}
+
+func TestPackedDump(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UNIT_TEST_TYPE)
+
+	Convey("With the test dump packed in various ways", t, func() {
+		tempDir, err := ioutil.TempDir("", "mongorestore_packed_dump")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(tempDir)
+		})
+		c1, err := ioutil.ReadFile("testdata/testdirs/db1/c1.bson")
+		So(err, ShouldBeNil)
+
+		for _, name := range []string{"dump.tar", "dump.tar.gz", "dump.zip"} {
+			packed := filepath.Join(tempDir, name)
+			So(packTestDump(packed, "testdata/testdirs", "dump/"), ShouldBeNil)
+
+			Convey("the dump folder in "+name+" should be found", func() {
+				path, inner, ok := splitPackedDumpPath(packed)
+				So(ok, ShouldBeTrue)
+				So(path, ShouldEqual, packed)
+				So(inner, ShouldEqual, "")
+				_, inner, ok = splitPackedDumpPath(filepath.Join(packed, "dump", "db1"))
+				So(ok, ShouldBeTrue)
+				So(inner, ShouldEqual, "dump/db1")
+
+				restore := &MongoRestore{manager: intents.NewCategorizingIntentManager()}
+				So(restore.openPackedDump(packed, ""), ShouldBeNil)
+				Reset(func() {
+					restore.packedDump.Close()
+				})
+				So(restore.TargetDirectory, ShouldEqual, "dump")
+
+				Convey("and its intents created as from a folder", func() {
+					So(restore.CreateAllIntents(restore.TargetDirectory), ShouldBeNil)
+					So(restore.manager.Oplog(), ShouldNotBeNil)
+					restore.manager.Finalize(intents.Legacy)
+					keys := []string{}
+					var first *intents.Intent
+					for intent := restore.manager.Pop(); intent != nil; intent = restore.manager.Pop() {
+						if first == nil {
+							first = intent
+						}
+						keys = append(keys, intent.Key())
+					}
+					So(keys, ShouldResemble, []string{"db1.c1", "db1.c2", "db1.c3", "db2.c1"})
+					So(first.MetadataPath, ShouldNotEqual, "")
+
+					Convey("with their files read in place", func() {
+						in, err := restore.openIntentBSON(first)
+						So(err, ShouldBeNil)
+						data, err := ioutil.ReadAll(in)
+						So(err, ShouldBeNil)
+						So(in.Close(), ShouldBeNil)
+						So(data, ShouldResemble, c1)
+						_, err = restore.readIntentMetadata(first)
+						So(err, ShouldBeNil)
+					})
+				})
+			})
+		}
+
+		Convey("with a compressed tar packed out of order", func() {
+			packed := filepath.Join(tempDir, "stream.tar.gz")
+			members := []string{"dump/db2/b.bson", "dump/db1/c.bson", "dump/db1/a.metadata.json", "dump/db1/a.bson"}
+			out, err := os.Create(packed)
+			So(err, ShouldBeNil)
+			gzipWriter := gzip.NewWriter(out)
+			tarWriter := tar.NewWriter(gzipWriter)
+			for _, name := range members {
+				data := []byte(strings.Repeat(name, 100))
+				So(tarWriter.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data))}), ShouldBeNil)
+				_, err = tarWriter.Write(data)
+				So(err, ShouldBeNil)
+			}
+			So(tarWriter.Close(), ShouldBeNil)
+			So(gzipWriter.Close(), ShouldBeNil)
+			So(out.Close(), ShouldBeNil)
+
+			restore := &MongoRestore{manager: intents.NewCategorizingIntentManager()}
+			So(restore.openPackedDump(packed, ""), ShouldBeNil)
+			Reset(func() {
+				restore.packedDump.Close()
+			})
+			fs := restore.packedDump
+			readMember := func(name string) string {
+				in, err := fs.Open(name)
+				So(err, ShouldBeNil)
+				data, err := ioutil.ReadAll(in)
+				So(err, ShouldBeNil)
+				So(in.Close(), ShouldBeNil)
+				return string(data)
+			}
+
+			Convey("its members should be read from a single decompressed pass", func() {
+				So(restore.streamsPackedDump(), ShouldBeTrue)
+				So(fs.members["dump/db1/a.metadata.json"].data, ShouldNotBeNil)
+				So(fs.members["dump/db1/a.bson"].data, ShouldBeNil)
+
+				in, err := fs.Open("dump/db1/c.bson")
+				So(err, ShouldBeNil)
+				_, err = fs.Open("dump/db1/a.bson")
+				So(err, ShouldNotBeNil)
+				// metadata is kept in memory, and can be read at any time
+				So(readMember("dump/db1/a.metadata.json"), ShouldEqual, strings.Repeat("dump/db1/a.metadata.json", 100))
+				data, err := ioutil.ReadAll(in)
+				So(err, ShouldBeNil)
+				So(in.Close(), ShouldBeNil)
+				So(string(data), ShouldEqual, strings.Repeat("dump/db1/c.bson", 100))
+				So(fs.stream.pos, ShouldEqual, fs.members["dump/db1/c.bson"].offset+int64(len(data)))
+
+				So(readMember("dump/db1/a.bson"), ShouldEqual, strings.Repeat("dump/db1/a.bson", 100))
+				// going back decompresses the tar from its start again
+				So(readMember("dump/db2/b.bson"), ShouldEqual, strings.Repeat("dump/db2/b.bson", 100))
+				So(readMember("dump/db1/a.bson"), ShouldEqual, strings.Repeat("dump/db1/a.bson", 100))
+			})
+
+			Convey("its intents should be restored in the order they are packed in", func() {
+				So(restore.CreateAllIntents(restore.TargetDirectory), ShouldBeNil)
+				keys := []string{}
+				for key := range restore.packedIntentOrder() {
+					keys = append(keys, key)
+				}
+				So(keys, ShouldResemble, []string{"db2.b", "db1.c", "db1.a"})
+			})
+		})
+
+		Convey("the oplog range of a packed dump should be read in place", func() {
+			dumpDir := filepath.Join(tempDir, "dump")
+			So(os.Mkdir(dumpDir, 0755), ShouldBeNil)
+			So(ioutil.WriteFile(filepath.Join(dumpDir, "oplog.bson"), []byte{}, 0644), ShouldBeNil)
+			So(ioutil.WriteFile(filepath.Join(dumpDir, "oplog.metadata.json"),
+				[]byte(`{"start":{"$timestamp":{"t":100,"i":1}},"end":{"$timestamp":{"t":200,"i":2}}}`), 0644), ShouldBeNil)
+			packed := filepath.Join(tempDir, "oplog.tar.gz")
+			So(packTestDump(packed, dumpDir, "dump/"), ShouldBeNil)
+
+			restore := &MongoRestore{}
+			So(restore.openPackedDump(packed, ""), ShouldBeNil)
+			Reset(func() {
+				restore.packedDump.Close()
+			})
+			meta, err := restore.readDumpOplogMetadata()
+			So(err, ShouldBeNil)
+			So(meta.Start, ShouldEqual, bson.MongoTimestamp(100<<32|1))
+			So(meta.End, ShouldEqual, bson.MongoTimestamp(200<<32|2))
+		})
+
+		Convey("a missing folder within a packed dump should be an error", func() {
+			packed := filepath.Join(tempDir, "dump.tar")
+			So(packTestDump(packed, "testdata/testdirs", ""), ShouldBeNil)
+			restore := &MongoRestore{}
+			So(restore.openPackedDump(packed, "nothing"), ShouldNotBeNil)
+		})
+
+		Convey("a folder is not a packed dump", func() {
+			_, _, ok := splitPackedDumpPath("testdata/testdirs")
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
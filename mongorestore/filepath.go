@@ -5,9 +5,9 @@ import (
 	"github.com/mongodb/mongo-tools/common/intents"
 	"github.com/mongodb/mongo-tools/common/log"
 	"github.com/mongodb/mongo-tools/common/util"
-	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
@@ -22,9 +22,10 @@ const (
 )
 
 // GetInfoFromFilename pulls the base collection name and
-// type of file from a .bson/.metadata.json file
+// type of file from a .bson/.metadata.json file. Files compressed
+// with a known codec (e.g. ".bson.gz") are recognized as well.
 func GetInfoFromFilename(filename string) (string, FileType) {
-	baseFileName := filepath.Base(filename)
+	_, baseFileName := util.CompressionCodecForFile(filepath.Base(filename))
 	switch {
 	case strings.HasSuffix(baseFileName, ".metadata.json"):
 		// this logic can't be simple because technically
@@ -46,7 +47,7 @@ func GetInfoFromFilename(filename string) (string, FileType) {
 
 func (restore *MongoRestore) CreateAllIntents(fullpath string) error {
 	log.Logf(log.DebugHigh, "using %v as dump root directory", fullpath)
-	entries, err := ioutil.ReadDir(fullpath)
+	entries, err := restore.dumpFS().ReadDir(fullpath)
 	if err != nil {
 		return fmt.Errorf("error reading root dump folder: %v", err)
 	}
@@ -60,13 +61,23 @@ func (restore *MongoRestore) CreateAllIntents(fullpath string) error {
 				return err
 			}
 		} else {
-			if entry.Name() == "oplog.bson" {
+			_, name := util.CompressionCodecForFile(entry.Name())
+			switch name {
+			case "oplog.bson":
 				restore.manager.Put(&intents.Intent{
 					C:        "oplog", //TODO make this a helper in intent
 					BSONPath: filepath.Join(fullpath, entry.Name()),
 					Size:     entry.Size(),
 				})
-			} else {
+			case "oplog.metadata.json":
+				// records which oplog entries the dump holds,
+				// only needed by mongodump --incrementalFrom
+				log.Logf(log.DebugLow, "found oplog metadata file %v",
+					filepath.Join(fullpath, entry.Name()))
+			case "dump_manifest.json", "dump_report.json", "cluster_dump.json":
+				log.Logf(log.DebugLow, "skipping mongodump bookkeeping file %v",
+					filepath.Join(fullpath, entry.Name()))
+			default:
 				log.Logf(log.Always, `don't know what to do with file "%v", skipping...`,
 					filepath.Join(fullpath, entry.Name()))
 			}
@@ -80,7 +91,7 @@ func (restore *MongoRestore) CreateAllIntents(fullpath string) error {
 func (restore *MongoRestore) CreateIntentsForDB(db, fullpath string) error {
 
 	log.Logf(log.DebugHigh, "reading collections for database %v in %v", db, fullpath)
-	entries, err := ioutil.ReadDir(fullpath)
+	entries, err := restore.dumpFS().ReadDir(fullpath)
 	if err != nil {
 		return fmt.Errorf("error reading db folder %v: %v", db, err)
 	}
@@ -88,6 +99,18 @@ func (restore *MongoRestore) CreateIntentsForDB(db, fullpath string) error {
 	usesMetadataFiles := hasMetadataFiles(entries)
 	for _, entry := range entries {
 		if entry.IsDir() {
+			if collection, ok := getCollectionFromPartsDir(entry.Name()); ok {
+				intent, err := createIntentForParts(restore.dumpFS(), db, collection, filepath.Join(fullpath, entry.Name()))
+				if err != nil {
+					return err
+				}
+				log.Logf(log.Info, "found collection %v bson to restore in %v parts",
+					intent.Key(), len(intent.BSONParts))
+				if err = restore.putIntent(intent); err != nil {
+					return err
+				}
+				continue
+			}
 			log.Logf(log.Always, `don't know what to do with subdirectory "%v", skipping...`,
 				filepath.Join(fullpath, entry.Name()))
 		} else {
@@ -110,7 +133,9 @@ func (restore *MongoRestore) CreateIntentsForDB(db, fullpath string) error {
 					BSONPath: filepath.Join(fullpath, entry.Name()),
 				}
 				log.Logf(log.Info, "found collection %v bson to restore", intent.Key())
-				restore.manager.Put(intent)
+				if err = restore.putIntent(intent); err != nil {
+					return err
+				}
 			case MetadataFileType:
 				usesMetadataFiles = true
 				intent := &intents.Intent{
@@ -119,7 +144,9 @@ func (restore *MongoRestore) CreateIntentsForDB(db, fullpath string) error {
 					MetadataPath: filepath.Join(fullpath, entry.Name()),
 				}
 				log.Logf(log.Info, "found collection %v metadata to restore", intent.Key())
-				restore.manager.Put(intent)
+				if err = restore.putIntent(intent); err != nil {
+					return err
+				}
 			default:
 				log.Logf(log.Always, `don't know what to do with file "%v", skipping...`,
 					filepath.Join(fullpath, entry.Name()))
@@ -129,10 +156,51 @@ func (restore *MongoRestore) CreateIntentsForDB(db, fullpath string) error {
 	return nil
 }
 
+// getCollectionFromPartsDir returns the collection name of a folder of part
+// files written by mongodump --numParallelRanges, named "<collection>.parts".
+func getCollectionFromPartsDir(dirName string) (string, bool) {
+	if !strings.HasSuffix(dirName, ".parts") {
+		return "", false
+	}
+	return strings.TrimSuffix(dirName, ".parts"), true
+}
+
+// createIntentForParts builds a single intent for all of the numbered
+// part files of a collection that was dumped in parallel ranges.
+func createIntentForParts(fs dumpFS, db, collection, partsDir string) (*intents.Intent, error) {
+	entries, err := fs.ReadDir(partsDir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading parts folder %v: %v", partsDir, err)
+	}
+	parts := make([]string, len(entries))
+	var size int64
+	for _, entry := range entries {
+		partName, fileType := GetInfoFromFilename(entry.Name())
+		part, err := strconv.Atoi(partName)
+		if entry.IsDir() || fileType != BSONFileType || err != nil || part < 0 || part >= len(parts) {
+			return nil, fmt.Errorf("unexpected file %v in parts folder %v", entry.Name(), partsDir)
+		}
+		parts[part] = filepath.Join(partsDir, entry.Name())
+		size += entry.Size()
+	}
+	for part, path := range parts {
+		if path == "" {
+			return nil, fmt.Errorf("part %v is missing from parts folder %v", part, partsDir)
+		}
+	}
+	return &intents.Intent{
+		DB:        db,
+		C:         collection,
+		BSONPath:  partsDir,
+		BSONParts: parts,
+		Size:      size,
+	}, nil
+}
+
 // helper for searching a list of FileInfo for metadata files
 func hasMetadataFiles(files []os.FileInfo) bool {
 	for _, file := range files {
-		if strings.HasSuffix(file.Name(), ".metadata.json") {
+		if _, fileType := GetInfoFromFilename(file.Name()); fileType == MetadataFileType {
 			return true
 		}
 	}
@@ -157,46 +225,53 @@ func (restore *MongoRestore) CreateIntentForCollection(
 			C:        collection,
 			BSONPath: "-",
 		}
-		restore.manager.Put(intent)
-		return nil
+		return restore.putIntent(intent)
 	}
 
 	// first make sure the bson file exists and is valid
-	file, err := os.Lstat(fullpath)
+	file, err := restore.dumpFS().Stat(fullpath)
 	if err != nil {
 		return err
 	}
-	if file.IsDir() {
-		return fmt.Errorf("file %v is a directory, not a bson file", fullpath)
-	}
-
-	baseName, fileType := GetInfoFromFilename(file.Name())
-	if fileType != BSONFileType {
-		return fmt.Errorf("file %v does not have .bson extension", fullpath)
-	}
 
 	// then create its intent
-	intent := &intents.Intent{
-		DB:       db,
-		C:        collection,
-		BSONPath: fullpath,
-		Size:     file.Size(),
+	var intent *intents.Intent
+	var baseName string
+	if partsName, ok := getCollectionFromPartsDir(file.Name()); ok && file.IsDir() {
+		baseName = partsName
+		intent, err = createIntentForParts(restore.dumpFS(), db, collection, fullpath)
+		if err != nil {
+			return err
+		}
+	} else {
+		if file.IsDir() {
+			return fmt.Errorf("file %v is a directory, not a bson file", fullpath)
+		}
+		var fileType FileType
+		baseName, fileType = GetInfoFromFilename(file.Name())
+		if fileType != BSONFileType {
+			return fmt.Errorf("file %v does not have .bson extension", fullpath)
+		}
+		intent = &intents.Intent{
+			DB:       db,
+			C:        collection,
+			BSONPath: fullpath,
+			Size:     file.Size(),
+		}
 	}
 
 	// finally, check if it has a .metadata.json file in its folder
 	log.Logf(log.DebugLow, "scanning directory %v for metadata file", filepath.Dir(fullpath))
-	entries, err := ioutil.ReadDir(filepath.Dir(fullpath))
+	entries, err := restore.dumpFS().ReadDir(filepath.Dir(fullpath))
 	if err != nil {
 		// try and carry on if we can
 		log.Logf(log.Info, "error attempting to locate metadata for file: %v", err)
 		log.Log(log.Info, "restoring collection without metadata")
-		restore.manager.Put(intent)
-		return nil
+		return restore.putIntent(intent)
 	}
-	metadataName := baseName + ".metadata.json"
 	for _, entry := range entries {
-		if entry.Name() == metadataName {
-			metadataPath := filepath.Join(filepath.Dir(fullpath), metadataName)
+		if name, fileType := GetInfoFromFilename(entry.Name()); name == baseName && fileType == MetadataFileType {
+			metadataPath := filepath.Join(filepath.Dir(fullpath), entry.Name())
 			log.Logf(log.Info, "found metadata for collection at %v", metadataPath)
 			intent.MetadataPath = metadataPath
 			break
@@ -207,7 +282,5 @@ func (restore *MongoRestore) CreateIntentForCollection(
 		log.Log(log.Info, "restoring collection without metadata")
 	}
 
-	restore.manager.Put(intent)
-
-	return nil
+	return restore.putIntent(intent)
 }
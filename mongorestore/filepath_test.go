@@ -8,6 +8,9 @@ import (
 	"github.com/mongodb/mongo-tools/common/testutil"
 	"github.com/mongodb/mongo-tools/common/util"
 	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -19,6 +22,36 @@ func init() {
 	})
 }
 
+func TestGetInfoFromFilename(t *testing.T) {
+
+	testutil.VerifyTestType(t, testutil.UNIT_TEST_TYPE)
+
+	Convey("With a set of dump file names", t, func() {
+		Convey("uncompressed bson and metadata files should be recognized", func() {
+			name, fileType := GetInfoFromFilename("db/c1.bson")
+			So(name, ShouldEqual, "c1")
+			So(fileType, ShouldEqual, BSONFileType)
+			name, fileType = GetInfoFromFilename("db/c1.metadata.json")
+			So(name, ShouldEqual, "c1")
+			So(fileType, ShouldEqual, MetadataFileType)
+		})
+
+		Convey("gzipped bson and metadata files should be recognized", func() {
+			name, fileType := GetInfoFromFilename("db/c1.bson.gz")
+			So(name, ShouldEqual, "c1")
+			So(fileType, ShouldEqual, BSONFileType)
+			name, fileType = GetInfoFromFilename("db/c1.metadata.json.gz")
+			So(name, ShouldEqual, "c1")
+			So(fileType, ShouldEqual, MetadataFileType)
+		})
+
+		Convey("other files should be unknown", func() {
+			_, fileType := GetInfoFromFilename("db/c2.txt.gz")
+			So(fileType, ShouldEqual, UnknownFileType)
+		})
+	})
+}
+
 func TestCreateAllIntents(t *testing.T) {
 	// This tests creates intents based on the test file tree:
 	//   testdirs/badfile.txt
@@ -238,3 +271,43 @@ func TestCreateIntentsForCollection(t *testing.T) {
 
 	})
 }
+
+func TestCreateIntentForParts(t *testing.T) {
+
+	testutil.VerifyTestType(t, testutil.UNIT_TEST_TYPE)
+
+	Convey("With a folder of collection parts", t, func() {
+		partsDir, err := ioutil.TempDir("", "big.parts")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(partsDir)
+		})
+		So(ioutil.WriteFile(filepath.Join(partsDir, "0.bson"), []byte("first"), 0644), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(partsDir, "1.bson"), []byte(""), 0644), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(partsDir, "2.bson"), []byte("third"), 0644), ShouldBeNil)
+
+		Convey("a single intent should cover every part in order", func() {
+			intent, err := createIntentForParts(osFS{}, "db", "big", partsDir)
+			So(err, ShouldBeNil)
+			So(intent.Key(), ShouldEqual, "db.big")
+			So(intent.BSONPath, ShouldEqual, partsDir)
+			So(len(intent.BSONParts), ShouldEqual, 3)
+			So(intent.BSONParts[2], ShouldEqual, filepath.Join(partsDir, "2.bson"))
+			So(intent.Size, ShouldEqual, 10)
+
+			Convey("and reading it should read the parts back to back", func() {
+				reader := &partsReader{paths: intent.BSONParts}
+				data, err := ioutil.ReadAll(reader)
+				So(err, ShouldBeNil)
+				So(string(data), ShouldEqual, "firstthird")
+				So(reader.Close(), ShouldBeNil)
+			})
+		})
+
+		Convey("a missing part should be an error", func() {
+			So(os.Remove(filepath.Join(partsDir, "1.bson")), ShouldBeNil)
+			_, err := createIntentForParts(osFS{}, "db", "big", partsDir)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,248 @@
+package mongorestore
+
+import (
+	"fmt"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/progress"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// indexBuild is a createIndexes command run by RestoreIndexes, for all the
+// indexes of a collection with --batchIndexBuilds, or for one of them
+type indexBuild struct {
+	intent  *intents.Intent
+	indexes []IndexDocument
+
+	// done and total are the progress of the build,
+	// as last reported by the server in currentOp
+	done, total int
+	bar         *progress.ProgressBar
+}
+
+// name identifies the build in logs and progress bars
+func (build *indexBuild) name() string {
+	if len(build.indexes) == 1 {
+		return fmt.Sprintf("%v index %v", build.intent.Key(), build.indexes[0].Options["name"])
+	}
+	return fmt.Sprintf("%v indexes", build.intent.Key())
+}
+
+// currentOpEntry is the part of an operation reported by currentOp that
+// tells the progress of an index build. Servers report a createIndexes
+// command under the namespace of its collection, or under "<db>.$cmd".
+type currentOpEntry struct {
+	Namespace string `bson:"ns"`
+	Msg       string `bson:"msg"`
+	Progress  struct {
+		Done  int `bson:"done"`
+		Total int `bson:"total"`
+	} `bson:"progress"`
+	Command struct {
+		CreateIndexes string `bson:"createIndexes"`
+		Indexes       []struct {
+			Name string `bson:"name"`
+		} `bson:"indexes"`
+	} `bson:"command"`
+}
+
+// matches returns whether the operation is the build
+func (build *indexBuild) matches(op *currentOpEntry) bool {
+	if !strings.HasPrefix(op.Msg, "Index Build") || op.Progress.Total == 0 {
+		return false
+	}
+	ns := op.Namespace
+	if op.Command.CreateIndexes != "" {
+		ns = build.intent.DB + "." + op.Command.CreateIndexes
+		if op.Namespace != build.intent.DB+".$cmd" && op.Namespace != ns {
+			return false
+		}
+	}
+	if ns != build.intent.Key() {
+		return false
+	}
+	if len(op.Command.Indexes) == 0 {
+		// older servers do not say which index is being built
+		return true
+	}
+	for _, opIndex := range op.Command.Indexes {
+		for _, index := range build.indexes {
+			if name, _ := index.Options["name"].(string); name == opIndex.Name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// deferIndexes queues the indexes of a collection to be built
+// by RestoreIndexes, once all collections are restored
+func (restore *MongoRestore) deferIndexes(intent *intents.Intent, indexes []IndexDocument) {
+	log.Logf(log.Info, "deferring %v indexes of %v until collections are restored", len(indexes), intent.Key())
+	restore.indexLock.Lock()
+	defer restore.indexLock.Unlock()
+	if restore.OutputOptions.BatchIndexBuilds {
+		restore.indexBuilds = append(restore.indexBuilds, &indexBuild{intent: intent, indexes: indexes})
+		return
+	}
+	for _, index := range indexes {
+		restore.indexBuilds = append(restore.indexBuilds,
+			&indexBuild{intent: intent, indexes: []IndexDocument{index}})
+	}
+}
+
+// indexBuildsBySize sorts the builds of the largest collections first,
+// so that they do not start last and hold up the end of the restore
+type indexBuildsBySize []*indexBuild
+
+func (builds indexBuildsBySize) Len() int      { return len(builds) }
+func (builds indexBuildsBySize) Swap(i, j int) { builds[i], builds[j] = builds[j], builds[i] }
+func (builds indexBuildsBySize) Less(i, j int) bool {
+	return builds[i].intent.Size > builds[j].intent.Size
+}
+
+// RestoreIndexes builds the deferred indexes of all collections,
+// --numIndexWorkers at a time, reporting their progress from currentOp
+func (restore *MongoRestore) RestoreIndexes() error {
+	builds := restore.indexBuilds
+	restore.indexBuilds = nil
+	if len(builds) == 0 {
+		return nil
+	}
+	sort.Stable(indexBuildsBySize(builds))
+	numWorkers := restore.OutputOptions.IndexWorkers
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+	log.Logf(log.Always, "building indexes with %v index builds, %v at a time", len(builds), numWorkers)
+	start := time.Now()
+
+	// running holds the builds in progress, for the progress poller
+	running := map[*indexBuild]bool{}
+	var runningLock sync.Mutex
+	stopPolling := make(chan struct{})
+	pollerDone := make(chan struct{})
+	go func() {
+		restore.pollIndexBuilds(running, &runningLock, stopPolling)
+		close(pollerDone)
+	}()
+	defer func() {
+		close(stopPolling)
+		<-pollerDone
+	}()
+
+	buildChan := make(chan *indexBuild, len(builds))
+	for _, build := range builds {
+		buildChan <- build
+	}
+	close(buildChan)
+	resultChan := make(chan error, numWorkers)
+	killChan := make(chan struct{})
+	defer close(killChan)
+	for i := 0; i < numWorkers; i++ {
+		go func() {
+			for build := range buildChan {
+				select {
+				case <-killChan:
+					resultChan <- nil
+					return
+				default:
+				}
+				log.Logf(log.Always, "building %v", build.name())
+				runningLock.Lock()
+				running[build] = true
+				runningLock.Unlock()
+				err := restore.CreateIndexes(build.intent, build.indexes)
+				runningLock.Lock()
+				delete(running, build)
+				if build.bar != nil {
+					restore.progressManager.Detach(build.bar)
+				}
+				runningLock.Unlock()
+				if err != nil {
+					resultChan <- fmt.Errorf("error building %v: %v", build.name(), err)
+					return
+				}
+				log.Logf(log.Info, "finished building %v", build.name())
+			}
+			resultChan <- nil
+		}()
+	}
+	for i := 0; i < numWorkers; i++ {
+		if err := <-resultChan; err != nil {
+			return err
+		}
+	}
+	log.Logf(log.Always, "finished building indexes in %v", time.Since(start))
+	return nil
+}
+
+// pollIndexBuilds reads the progress of the running builds from
+// currentOp until stop is closed, showing a progress bar for every
+// build the server reports progress of
+func (restore *MongoRestore) pollIndexBuilds(running map[*indexBuild]bool, runningLock *sync.Mutex, stop <-chan struct{}) {
+	session, err := restore.SessionProvider.GetSession()
+	if err != nil {
+		log.Logf(log.Info, "cannot report index build progress: error establishing connection: %v", err)
+		return
+	}
+	defer session.Close()
+
+	ticker := time.NewTicker(ProgressBarWaitTime)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		ops, err := currentOps(session)
+		if err != nil {
+			log.Logf(log.DebugLow, "cannot report index build progress: %v", err)
+			continue
+		}
+		runningLock.Lock()
+		for build := range running {
+			for i := range ops {
+				if !build.matches(&ops[i]) {
+					continue
+				}
+				build.done, build.total = ops[i].Progress.Done, ops[i].Progress.Total
+				if build.bar == nil {
+					build.bar = &progress.ProgressBar{
+						Name:       build.name(),
+						Max:        build.total,
+						CounterPtr: &build.done,
+						Writer:     log.Writer(0),
+						BarLength:  ProgressBarLength,
+					}
+					restore.progressManager.Attach(build.bar)
+				}
+				// a build reports each of its phases from zero
+				build.bar.Max = build.total
+				break
+			}
+		}
+		runningLock.Unlock()
+	}
+}
+
+// currentOps returns the operations running on the server, using the
+// currentOp command, or the $cmd.sys.inprog query on servers before 3.2
+func currentOps(session *mgo.Session) ([]currentOpEntry, error) {
+	result := struct {
+		InProg []currentOpEntry `bson:"inprog"`
+	}{}
+	err := session.DB("admin").Run(bson.D{{"currentOp", 1}}, &result)
+	if err != nil {
+		if err = session.DB("admin").C("$cmd.sys.inprog").Find(nil).One(&result); err != nil {
+			return nil, fmt.Errorf("error running currentOp: %v", err)
+		}
+	}
+	return result.InProg, nil
+}
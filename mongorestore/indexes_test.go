@@ -0,0 +1,86 @@
+package mongorestore
+
+import (
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/testutil"
+	"github.com/mongodb/mongo-tools/mongorestore/options"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+	"sort"
+	"testing"
+)
+
+func TestDeferIndexes(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UNIT_TEST_TYPE)
+
+	small := &intents.Intent{DB: "app", C: "small", Size: 10}
+	large := &intents.Intent{DB: "app", C: "large", Size: 1000}
+	indexes := []IndexDocument{
+		{Options: bson.M{"name": "a_1"}, Key: bson.D{{"a", 1}}},
+		{Options: bson.M{"name": "b_1"}, Key: bson.D{{"b", 1}}},
+	}
+
+	Convey("With deferred indexes", t, func() {
+		restore := &MongoRestore{OutputOptions: &options.OutputOptions{}}
+
+		Convey("every index is built on its own by default", func() {
+			restore.deferIndexes(small, indexes)
+			restore.deferIndexes(large, indexes[:1])
+			So(len(restore.indexBuilds), ShouldEqual, 3)
+			So(restore.indexBuilds[0].name(), ShouldEqual, "app.small index a_1")
+
+			Convey("and the largest collections are built first", func() {
+				sort.Stable(indexBuildsBySize(restore.indexBuilds))
+				So(restore.indexBuilds[0].intent, ShouldEqual, large)
+				So(restore.indexBuilds[1].name(), ShouldEqual, "app.small index a_1")
+				So(restore.indexBuilds[2].name(), ShouldEqual, "app.small index b_1")
+			})
+		})
+
+		Convey("the indexes of a collection are built together with --batchIndexBuilds", func() {
+			restore.OutputOptions.BatchIndexBuilds = true
+			restore.deferIndexes(small, indexes)
+			So(len(restore.indexBuilds), ShouldEqual, 1)
+			So(len(restore.indexBuilds[0].indexes), ShouldEqual, 2)
+			So(restore.indexBuilds[0].name(), ShouldEqual, "app.small indexes")
+		})
+	})
+}
+
+func TestIndexBuildMatches(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UNIT_TEST_TYPE)
+
+	Convey("With an index build", t, func() {
+		build := &indexBuild{
+			intent:  &intents.Intent{DB: "app", C: "users"},
+			indexes: []IndexDocument{{Options: bson.M{"name": "a_1"}}},
+		}
+		op := &currentOpEntry{Namespace: "app.users", Msg: "Index Build: 10/100 10%"}
+		op.Progress.Done, op.Progress.Total = 10, 100
+
+		Convey("operations on its collection match", func() {
+			So(build.matches(op), ShouldBeTrue)
+			op.Namespace = "app.orders"
+			So(build.matches(op), ShouldBeFalse)
+		})
+
+		Convey("operations that are not index builds do not match", func() {
+			op.Msg = "query"
+			So(build.matches(op), ShouldBeFalse)
+		})
+
+		Convey("createIndexes commands match by collection and index name", func() {
+			op.Namespace = "app.$cmd"
+			op.Command.CreateIndexes = "users"
+			op.Command.Indexes = append(op.Command.Indexes, struct {
+				Name string `bson:"name"`
+			}{"a_1"})
+			So(build.matches(op), ShouldBeTrue)
+			op.Command.Indexes[0].Name = "b_1"
+			So(build.matches(op), ShouldBeFalse)
+			op.Command.Indexes[0].Name = "a_1"
+			op.Command.CreateIndexes = "orders"
+			So(build.matches(op), ShouldBeFalse)
+		})
+	})
+}
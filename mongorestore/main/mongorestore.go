@@ -19,6 +19,8 @@ func main() {
 	opts.AddOptions(inputOpts)
 	outputOpts := &options.OutputOptions{}
 	opts.AddOptions(outputOpts)
+	nsOpts := &options.NSOptions{}
+	opts.AddOptions(nsOpts)
 
 	extraArgs, err := opts.Parse()
 	if err != nil {
@@ -45,6 +47,10 @@ func main() {
 		fmt.Printf("error parsing command line options: %v\n", err)
 		os.Exit(2)
 	}
+	if inputOpts.Archive != "" && (len(extraArgs) > 0 || inputOpts.Directory != "") {
+		fmt.Printf("error parsing command line options: cannot use --archive with a dump directory\n")
+		os.Exit(2)
+	}
 	targetDir = util.ToUniversalPath(targetDir)
 
 	opts.Direct = true
@@ -53,6 +59,7 @@ func main() {
 		ToolOptions:     opts,
 		OutputOptions:   outputOpts,
 		InputOptions:    inputOpts,
+		NSOptions:       nsOpts,
 		TargetDirectory: targetDir,
 		SessionProvider: db.NewSessionProvider(*opts),
 	}
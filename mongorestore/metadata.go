@@ -10,18 +10,27 @@ import (
 	"github.com/mongodb/mongo-tools/common/util"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
-	"os"
 	"strings"
 )
 
 const Users = "users"
 const Roles = "roles"
 
+// Metadata is the contents of a collection's .metadata.json file. Version
+// is 0 for files written before the collection type and UUID were recorded.
 type Metadata struct {
-	Options bson.D          `json:"options,omitempty"`
+	Version int             `json:"version"`
+	Type    string          `json:"type"`
+	UUID    string          `json:"uuid"`
+	Options bson.D          `json:"-"`
 	Indexes []IndexDocument `json:"indexes"`
 }
 
+// IsView returns true if the metadata describes a view
+func (meta *Metadata) IsView() bool {
+	return meta.Type == "view"
+}
+
 // this struct is used to read in the options of a set of indexes
 type metaDataMapIndex struct {
 	Indexes []bson.M `json:"indexes"`
@@ -34,11 +43,29 @@ type IndexDocument struct {
 
 // MetadataFromJSON takes a slice of JSON bytes and unmarshals them into usable
 // collection options and indexes for restoring collections.
-func (restore *MongoRestore) MetadataFromJSON(jsonBytes []byte) (bson.D, []IndexDocument, error) {
+func (restore *MongoRestore) MetadataFromJSON(jsonBytes []byte) (*Metadata, error) {
 	meta := &Metadata{}
 	err := json.Unmarshal(jsonBytes, meta)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
+	}
+
+	// options are read in order at every level, since the order of
+	// the stages of a view's pipeline and of sort documents matters
+	metaAsD, err := json.UnmarshalBsonDNested(jsonBytes)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling metadata in order: %v", err)
+	}
+	if jsonOptions, err := bsonutil.FindValueByKey("options", &metaAsD); err == nil {
+		options, err := bsonutil.ConvertNestedJSONValueToBSON(jsonOptions)
+		if err != nil {
+			return nil, fmt.Errorf("error converting collection options: %v", err)
+		}
+		optionsD, ok := options.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("collection options must be an object")
+		}
+		meta.Options = optionsD
 	}
 
 	// first get the ordered key information for each index,
@@ -46,7 +73,7 @@ func (restore *MongoRestore) MetadataFromJSON(jsonBytes []byte) (bson.D, []Index
 	metaAsMap := metaDataMapIndex{}
 	err = json.Unmarshal(jsonBytes, &metaAsMap)
 	if err != nil {
-		return nil, nil, fmt.Errorf("error unmarshalling metadata as map: %v", err)
+		return nil, fmt.Errorf("error unmarshalling metadata as map: %v", err)
 	}
 	for i := range meta.Indexes {
 		// remove "key" and "v" from the map versions
@@ -54,14 +81,15 @@ func (restore *MongoRestore) MetadataFromJSON(jsonBytes []byte) (bson.D, []Index
 		meta.Indexes[i].Options = metaAsMap.Indexes[i]
 	}
 
-	return meta.Options, meta.Indexes, nil
+	return meta, nil
 }
 
 //TODO test this
-func (restore *MongoRestore) IndexesFromBSON(intent *intents.Intent, bsonFile string) ([]IndexDocument, error) {
+func (restore *MongoRestore) IndexesFromBSON(intent, indexesIntent *intents.Intent) ([]IndexDocument, error) {
+	bsonFile := indexesIntent.BSONPath
 	log.Logf(log.DebugLow, "scanning %v for indexes on %v collections", bsonFile, intent.C)
 
-	rawFile, err := os.Open(bsonFile)
+	rawFile, err := restore.openIntentBSON(indexesIntent)
 	if err != nil {
 		return nil, fmt.Errorf("error reading index bson file %v: %v", bsonFile, err)
 	}
@@ -70,11 +98,13 @@ func (restore *MongoRestore) IndexesFromBSON(intent *intents.Intent, bsonFile st
 	defer bsonSource.Close()
 
 	// iterate over stored indexes, saving all that match the collection
+	// under the name it was dumped with
+	_, sourceC := restore.sourceOf(intent)
 	indexDocument := &IndexDocument{}
 	collectionIndexes := []IndexDocument{}
 	for bsonSource.Next(indexDocument) {
 		namespace := indexDocument.Options["ns"].(string)
-		if stripDBFromNS(namespace) == intent.C {
+		if stripDBFromNS(namespace) == sourceC {
 			log.Logf(log.DebugHigh, "\tfound index %v", indexDocument.Options["name"])
 			collectionIndexes = append(collectionIndexes, *indexDocument)
 		}
@@ -134,6 +164,10 @@ func (restore *MongoRestore) CreateIndexes(intent *intents.Intent, indexes []Ind
 		if !restore.OutputOptions.KeepIndexVersion {
 			delete(index.Options, "v")
 		}
+
+		if restore.OutputOptions.BackgroundIndexBuilds {
+			index.Options["background"] = true
+		}
 	}
 
 	session, err := restore.SessionProvider.GetSession()
@@ -191,12 +225,8 @@ func (restore *MongoRestore) LegacyInsertIndex(intent *intents.Intent, index Ind
 }
 
 func (restore *MongoRestore) CreateCollection(intent *intents.Intent, options bson.D) error {
-	jsonCommand, err := bsonutil.ConvertBSONValueToJSON(
-		append(bson.D{{"create", intent.C}}, options...),
-	)
-	if err != nil {
-		return err
-	}
+	// options are already converted from extended JSON
+	command := append(bson.D{{"create", intent.C}}, options...)
 
 	session, err := restore.SessionProvider.GetSession()
 	if err != nil {
@@ -206,7 +236,7 @@ func (restore *MongoRestore) CreateCollection(intent *intents.Intent, options bs
 	defer session.Close()
 
 	res := bson.M{}
-	err = session.DB(intent.DB).Run(jsonCommand, &res)
+	err = session.DB(intent.DB).Run(command, &res)
 	if err != nil {
 		return fmt.Errorf("error running create command: %v", err)
 	}
@@ -231,7 +261,7 @@ func (restore *MongoRestore) RestoreUsersOrRoles(collectionType string, intent *
 		return fmt.Errorf("cannot use %v as a collection type in RestoreUsersOrRoles", collectionType)
 	}
 
-	rawFile, err := os.Open(intent.BSONPath)
+	rawFile, err := restore.openIntentBSON(intent)
 	if err != nil {
 		return fmt.Errorf("error reading index bson file %v: %v", intent.BSONPath, err)
 	}
@@ -276,23 +306,11 @@ func (restore *MongoRestore) RestoreUsersOrRoles(collectionType string, intent *
 		userTargetDB = ""
 	}
 
-	// we have to manually convert mgo's safety to a writeconcern object
-	writeConcern := bson.M{}
-	if restore.safety == nil {
-		writeConcern["w"] = 0
-	} else {
-		if restore.safety.WMode == "majority" {
-			writeConcern["w"] = "majority"
-		} else {
-			writeConcern["w"] = restore.safety.W
-		}
-	}
-
 	command := bsonutil.MarshalD{
 		{"_mergeAuthzCollections", 1},
 		{tempColCommandField, "admin." + tempCol},
 		{"drop", restore.OutputOptions.Drop},
-		{"writeConcern", writeConcern},
+		{"writeConcern", restore.writeConcern()},
 		{"db", userTargetDB},
 	}
 
@@ -1,9 +1,12 @@
 package mongorestore
 
 import (
+	"github.com/mongodb/mongo-tools/common/intents"
 	"github.com/mongodb/mongo-tools/common/testutil"
 	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
 	"testing"
+	"time"
 )
 
 //TODO TODO TODO
@@ -17,3 +20,75 @@ func TestCollectionInfoFromOptions(t *testing.T) {
 	})
 
 }
+
+func TestMetadataFromJSON(t *testing.T) {
+
+	testutil.VerifyTestType(t, testutil.UNIT_TEST_TYPE)
+
+	Convey("With a MongoRestore instance", t, func() {
+		restore := &MongoRestore{}
+
+		Convey("metadata of a view should keep its pipeline in order", func() {
+			meta, err := restore.MetadataFromJSON([]byte(`{
+				"version": 2, "type": "view",
+				"options": {"viewOn": "orders", "pipeline": [
+					{"$match": {"placed": {"$gte": {"$date": 1420070400000}}}},
+					{"$sort": {"total": -1, "placed": 1}}
+				]},
+				"indexes": []
+			}`))
+			So(err, ShouldBeNil)
+			So(meta.IsView(), ShouldBeTrue)
+			So(meta.Version, ShouldEqual, 2)
+			So(meta.Options[0].Name, ShouldEqual, "viewOn")
+			pipeline := meta.Options[1].Value.([]interface{})
+			match := pipeline[0].(bson.D)[0].Value.(bson.D)
+			placed := match[0].Value.(bson.D)
+			So(placed[0].Value, ShouldHaveSameTypeAs, time.Time{})
+			sort := pipeline[1].(bson.D)[0].Value.(bson.D)
+			So(sort[0].Name, ShouldEqual, "total")
+			So(sort[1].Name, ShouldEqual, "placed")
+		})
+
+		Convey("metadata without a version should still be read", func() {
+			meta, err := restore.MetadataFromJSON([]byte(`{
+				"options": {"capped": true, "size": 4096},
+				"indexes": [{"v": 1, "key": {"a": 1, "b": -1}, "name": "a_1_b_-1", "ns": "db.c"}]
+			}`))
+			So(err, ShouldBeNil)
+			So(meta.IsView(), ShouldBeFalse)
+			So(meta.Options, ShouldResemble, bson.D{{"capped", true}, {"size", float64(4096)}})
+			So(len(meta.Indexes), ShouldEqual, 1)
+			So(meta.Indexes[0].Key[1].Name, ShouldEqual, "b")
+			So(meta.Indexes[0].Options["name"], ShouldEqual, "a_1_b_-1")
+		})
+	})
+}
+
+func TestOrderViews(t *testing.T) {
+
+	testutil.VerifyTestType(t, testutil.UNIT_TEST_TYPE)
+
+	view := func(c, on string) *deferredView {
+		return &deferredView{
+			intent:  &intents.Intent{DB: "db", C: c},
+			options: bson.D{{"viewOn", on}, {"pipeline", []interface{}{}}},
+		}
+	}
+
+	Convey("Views should be created after the views they are on", t, func() {
+		ordered, err := orderViews([]*deferredView{
+			view("top", "middle"), view("middle", "bottom"), view("bottom", "orders"),
+		})
+		So(err, ShouldBeNil)
+		So(len(ordered), ShouldEqual, 3)
+		So(ordered[0].intent.C, ShouldEqual, "bottom")
+		So(ordered[1].intent.C, ShouldEqual, "middle")
+		So(ordered[2].intent.C, ShouldEqual, "top")
+	})
+
+	Convey("Views defined on each other should be rejected", t, func() {
+		_, err := orderViews([]*deferredView{view("a", "b"), view("b", "a")})
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,139 @@
+package mongorestore
+
+import (
+	"fmt"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/log"
+	"gopkg.in/mgo.v2/bson"
+	"strings"
+)
+
+// Modes of writing documents, as given to --mode
+const (
+	// insert every document, leaving existing ones with the same _id alone
+	ModeInsert = "insert"
+	// replace the matching document, or insert if there is none
+	ModeUpsert = "upsert"
+	// replace the matching document, skipping documents that match none
+	ModeReplace = "replace"
+	// set the fields of the document into the matching one, or insert if there is none
+	ModeMerge = "merge"
+)
+
+// writeConcern converts mgo's safety to a write concern object
+func (restore *MongoRestore) writeConcern() bson.M {
+	writeConcern := bson.M{}
+	if restore.safety == nil {
+		writeConcern["w"] = 0
+	} else {
+		if restore.safety.WMode == "majority" {
+			writeConcern["w"] = "majority"
+		} else {
+			writeConcern["w"] = restore.safety.W
+		}
+	}
+	return writeConcern
+}
+
+// upsertSelector builds the query that matches the document in the target
+// collection, from the values the document has for the given fields. Fields
+// may be dotted paths into subdocuments; missing ones are matched as null.
+func upsertSelector(fields []string, doc bson.D) bson.D {
+	selector := bson.D{}
+	for _, field := range fields {
+		selector = append(selector, bson.DocElem{field, lookupField(field, doc)})
+	}
+	return selector
+}
+
+// lookupField returns the value at the dotted path in doc, or nil
+func lookupField(path string, doc bson.D) interface{} {
+	name, rest := path, ""
+	if i := strings.Index(path, "."); i >= 0 {
+		name, rest = path[:i], path[i+1:]
+	}
+	for _, elem := range doc {
+		if elem.Name != name {
+			continue
+		}
+		if rest == "" {
+			return elem.Value
+		}
+		if subDoc, ok := elem.Value.(bson.D); ok {
+			return lookupField(rest, subDoc)
+		}
+		return nil
+	}
+	return nil
+}
+
+// selectsByID returns whether the upsert fields are just _id
+func selectsByID(fields []string) bool {
+	return len(fields) == 1 && fields[0] == "_id"
+}
+
+// withoutID returns doc without its _id
+func withoutID(doc bson.D) bson.D {
+	fields := bson.D{}
+	for _, elem := range doc {
+		if elem.Name != "_id" {
+			fields = append(fields, elem)
+		}
+	}
+	return fields
+}
+
+// updateFor returns the update that writes doc in the given --mode,
+// and whether it is an upsert. Documents matched by fields other than
+// _id keep their own _id, which cannot be changed.
+func updateFor(mode string, fields []string, doc bson.D) (interface{}, bool) {
+	switch mode {
+	case ModeReplace:
+		if !selectsByID(fields) {
+			return withoutID(doc), false
+		}
+		return doc, false
+	case ModeMerge:
+		fields := bson.D{}
+		update := bson.D{}
+		for _, elem := range doc {
+			if elem.Name == "_id" {
+				// _id cannot be set on existing documents, but new
+				// ones should get the one from the dump
+				update = append(update, bson.DocElem{"$setOnInsert", bson.D{elem}})
+			} else {
+				fields = append(fields, elem)
+			}
+		}
+		if len(fields) > 0 {
+			update = append(bson.D{{"$set", fields}}, update...)
+		}
+		return update, true
+	default:
+		return doc, true
+	}
+}
+
+// updateDocument buffers the write of a document in a --mode other than insert
+func (restore *MongoRestore) updateDocument(updater *db.BufferedBulkUpdater, rawDoc bson.Raw) error {
+	doc := bson.D{}
+	if err := bson.Unmarshal(rawDoc.Data, &doc); err != nil {
+		return fmt.Errorf("invalid object: %v", err)
+	}
+	update, upsert := updateFor(restore.OutputOptions.Mode, restore.upsertFields, doc)
+	return updater.Update(upsertSelector(restore.upsertFields, doc), update, upsert, rawDoc)
+}
+
+// addWriteResult logs what a --mode other than insert did
+// to a collection, and adds it to the totals of the restore
+func (restore *MongoRestore) addWriteResult(ns string, result db.BulkUpdateResult) {
+	log.Logf(log.Always, "%v: %v", ns, formatWriteResult(result))
+	restore.writeResultLock.Lock()
+	defer restore.writeResultLock.Unlock()
+	restore.writeResult.Add(result)
+}
+
+func formatWriteResult(result db.BulkUpdateResult) string {
+	return fmt.Sprintf("%v documents inserted, %v matched, %v modified",
+		result.Upserted, result.Matched, result.Modified)
+}
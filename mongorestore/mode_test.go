@@ -0,0 +1,123 @@
+package mongorestore
+
+import (
+	"github.com/mongodb/mongo-tools/common/db"
+	commonOpts "github.com/mongodb/mongo-tools/common/options"
+	"github.com/mongodb/mongo-tools/common/testutil"
+	"github.com/mongodb/mongo-tools/mongorestore/options"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+	"testing"
+)
+
+func TestWriteModes(t *testing.T) {
+
+	testutil.VerifyTestType(t, testutil.UNIT_TEST_TYPE)
+
+	doc := bson.D{{"_id", 1}, {"name", "ann"}, {"address", bson.D{{"city", "oslo"}}}}
+
+	Convey("Selectors should hold the values of the upsert fields", t, func() {
+		So(upsertSelector([]string{"_id"}, doc), ShouldResemble, bson.D{{"_id", 1}})
+		So(upsertSelector([]string{"name", "address.city"}, doc), ShouldResemble,
+			bson.D{{"name", "ann"}, {"address.city", "oslo"}})
+		So(upsertSelector([]string{"missing", "name.first"}, doc), ShouldResemble,
+			bson.D{{"missing", nil}, {"name.first", nil}})
+	})
+
+	byID := []string{"_id"}
+	byName := []string{"name"}
+
+	Convey("Updates should depend on the mode", t, func() {
+		update, upsert := updateFor(ModeUpsert, byID, doc)
+		So(update, ShouldResemble, doc)
+		So(upsert, ShouldBeTrue)
+
+		update, upsert = updateFor(ModeReplace, byID, doc)
+		So(update, ShouldResemble, doc)
+		So(upsert, ShouldBeFalse)
+
+		update, upsert = updateFor(ModeMerge, byID, doc)
+		So(update, ShouldResemble, bson.D{
+			{"$set", bson.D{{"name", "ann"}, {"address", bson.D{{"city", "oslo"}}}}},
+			{"$setOnInsert", bson.D{{"_id", 1}}},
+		})
+		So(upsert, ShouldBeTrue)
+
+		update, _ = updateFor(ModeMerge, byID, bson.D{{"_id", 1}})
+		So(update, ShouldResemble, bson.D{{"$setOnInsert", bson.D{{"_id", 1}}}})
+	})
+
+	Convey("Documents matched by other fields should keep their own _id", t, func() {
+		// the document in the collection may have any other _id
+		update, upsert := updateFor(ModeReplace, byName, doc)
+		So(update, ShouldResemble, bson.D{{"name", "ann"}, {"address", bson.D{{"city", "oslo"}}}})
+		So(upsert, ShouldBeFalse)
+
+		update, upsert = updateFor(ModeMerge, byName, doc)
+		So(update, ShouldResemble, bson.D{
+			{"$set", bson.D{{"name", "ann"}, {"address", bson.D{{"city", "oslo"}}}}},
+			{"$setOnInsert", bson.D{{"_id", 1}}},
+		})
+		So(upsert, ShouldBeTrue)
+	})
+
+	Convey("Upserting by fields other than _id should be refused", t, func() {
+		restore := &MongoRestore{
+			ToolOptions:  &commonOpts.ToolOptions{Namespace: &commonOpts.Namespace{}, Connection: &commonOpts.Connection{}},
+			InputOptions: &options.InputOptions{},
+			OutputOptions: &options.OutputOptions{WriteConcern: "1", Mode: ModeUpsert,
+				UpsertFields: "name"},
+		}
+		So(restore.ParseAndValidateOptions(), ShouldNotBeNil)
+
+		restore.OutputOptions.Mode = ModeReplace
+		So(restore.ParseAndValidateOptions(), ShouldBeNil)
+		So(restore.upsertFields, ShouldResemble, byName)
+	})
+}
+
+func TestWriteModesByOtherFields(t *testing.T) {
+
+	testutil.VerifyTestType(t, testutil.INTEGRATION_TEST_TYPE)
+
+	Convey("With a document restored over one with a different _id", t, func() {
+		ssl := testutil.GetSSLOptions()
+		auth := testutil.GetAuthOptions()
+		provider, err := db.InitSessionProvider(commonOpts.ToolOptions{
+			Connection: &commonOpts.Connection{Host: "localhost", Port: "27017"},
+			SSL:        &ssl,
+			Auth:       &auth,
+		})
+		So(err, ShouldBeNil)
+		session, err := provider.GetSession()
+		So(err, ShouldBeNil)
+		writeCommands, err := provider.SupportsWriteCommands()
+		So(err, ShouldBeNil)
+		testCol := session.DB("mongorestore_mode_test_db").C("people")
+		Reset(func() {
+			session.DB("mongorestore_mode_test_db").DropDatabase()
+			session.Close()
+		})
+		So(testCol.Insert(bson.D{{"_id", 99}, {"name", "ann"}}), ShouldBeNil)
+		rawDoc, err := bson.Marshal(bson.D{{"_id", 1}, {"name", "ann"}, {"age", 30}})
+		So(err, ShouldBeNil)
+
+		for _, mode := range []string{ModeReplace, ModeMerge} {
+			mode := mode
+			Convey("--mode "+mode+" matching by name should keep the existing _id", func() {
+				restore := &MongoRestore{
+					OutputOptions: &options.OutputOptions{Mode: mode},
+					upsertFields:  []string{"name"},
+				}
+				updater := db.NewBufferedBulkUpdater(testCol, 10, writeCommands, bson.M{"w": 1})
+				So(restore.updateDocument(updater, bson.Raw{Kind: 3, Data: rawDoc}), ShouldBeNil)
+				So(updater.Flush(), ShouldBeNil)
+
+				result := bson.M{}
+				So(testCol.Find(nil).One(&result), ShouldBeNil)
+				So(result["_id"], ShouldEqual, 99)
+				So(result["age"], ShouldEqual, 30)
+			})
+		}
+	})
+}
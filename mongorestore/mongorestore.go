@@ -7,17 +7,22 @@ import (
 	"github.com/mongodb/mongo-tools/common/log"
 	commonopts "github.com/mongodb/mongo-tools/common/options"
 	"github.com/mongodb/mongo-tools/common/progress"
+	"github.com/mongodb/mongo-tools/common/repository"
 	"github.com/mongodb/mongo-tools/common/util"
 	"github.com/mongodb/mongo-tools/mongorestore/options"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
+	"os"
 	"strconv"
+	"strings"
+	"sync"
 )
 
 type MongoRestore struct {
 	ToolOptions   *commonopts.ToolOptions
 	InputOptions  *options.InputOptions
 	OutputOptions *options.OutputOptions
+	NSOptions     *options.NSOptions
 
 	SessionProvider *db.SessionProvider
 
@@ -34,6 +39,45 @@ type MongoRestore struct {
 	objCheck   bool
 	oplogLimit bson.MongoTimestamp
 	useStdin   bool
+	archive    *archiveSource
+	encryption *util.EncryptionKey
+	snapshot   *repository.Snapshot
+	// packedDump is set when the dump folder is read from a tar or zip file
+	packedDump *packedDumpFS
+
+	// nsMapper applies --nsInclude, --nsExclude, --nsFrom and --nsTo,
+	// renamedFrom maps renamed namespaces to their names in the dump,
+	// and restoredFrom does so for every namespace that is restored
+	nsMapper     *NamespaceMapper
+	renamedFrom  map[string]string
+	restoredFrom map[string]string
+
+	// oplogSources are the oplogs chained by --oplogFile, and oplogFilter
+	// applies --oplogInclude, --oplogExclude, --oplogOps and --oplogStart
+	oplogSources []*oplogSource
+	oplogFilter  *OplogFilter
+
+	// views are created after all collections are restored
+	views    []*deferredView
+	viewLock sync.Mutex
+
+	// indexBuilds are run after all collections are restored
+	indexBuilds []*indexBuild
+	indexLock   sync.Mutex
+
+	// writeCommands is whether the server supports batched updates, and
+	// writeResult counts the documents written by every --mode but insert
+	writeCommands   bool
+	upsertFields    []string
+	writeResult     db.BulkUpdateResult
+	writeResultLock sync.Mutex
+
+	// reportDocumentErrors is set by --maxWriteErrors and --deadLetterDir,
+	// and writeErrors counts the documents that failed to be written
+	reportDocumentErrors bool
+	writeErrors          int
+	deadLetters          map[string]*deadLetterFile
+	writeErrorLock       sync.Mutex
 }
 
 func (restore *MongoRestore) ParseAndValidateOptions() error {
@@ -75,12 +119,27 @@ func (restore *MongoRestore) ParseAndValidateOptions() error {
 			return fmt.Errorf("cannot use --oplogLimit without --oplogReplay enabled")
 		}
 		var err error
-		restore.oplogLimit, err = ParseTimestampFlag(restore.InputOptions.OplogLimit)
+		restore.oplogLimit, err = ParseOplogLimit(restore.InputOptions.OplogLimit)
 		if err != nil {
 			return fmt.Errorf("error parsing timestamp argument to --oplogLimit: %v", err)
 		}
 	}
 
+	in := restore.InputOptions
+	if len(in.OplogInclude)+len(in.OplogExclude) > 0 || in.OplogOps != "" || in.OplogStart != "" {
+		if !in.OplogReplay {
+			return fmt.Errorf("cannot use --oplogInclude, --oplogExclude, --oplogOps or --oplogStart without --oplogReplay enabled")
+		}
+		var err error
+		restore.oplogFilter, err = NewOplogFilter(in.OplogInclude, in.OplogExclude, in.OplogOps, in.OplogStart)
+		if err != nil {
+			return err
+		}
+		if restore.oplogLimit != 0 && restore.oplogFilter.start >= restore.oplogLimit {
+			return fmt.Errorf("--oplogStart must be before --oplogLimit")
+		}
+	}
+
 	if restore.OutputOptions.WriteConcern == "" || restore.OutputOptions.WriteConcern == "majority" {
 		log.Logf(log.DebugLow, "\tdumping with w=majority")
 
@@ -114,6 +173,45 @@ func (restore *MongoRestore) ParseAndValidateOptions() error {
 		}
 	}
 
+	switch restore.OutputOptions.Mode {
+	case "":
+		restore.OutputOptions.Mode = ModeInsert
+	case ModeInsert:
+	case ModeUpsert, ModeReplace, ModeMerge:
+		if restore.safety == nil {
+			return fmt.Errorf("cannot use --mode=%v with --w=0, the writes must be acknowledged",
+				restore.OutputOptions.Mode)
+		}
+		restore.upsertFields = []string{"_id"}
+		if restore.OutputOptions.UpsertFields != "" {
+			restore.upsertFields = strings.Split(restore.OutputOptions.UpsertFields, ",")
+		}
+		// a replacement that matches a document by other fields cannot
+		// keep the _id of the dump, which would alter the matched one's
+		if restore.OutputOptions.Mode == ModeUpsert && !selectsByID(restore.upsertFields) {
+			return fmt.Errorf("cannot use --mode=%v with --upsertFields other than _id, the documents "+
+				"inserted would lose their _id; use --mode=%v or --mode=%v instead",
+				ModeUpsert, ModeMerge, ModeReplace)
+		}
+	default:
+		return fmt.Errorf("--mode must be one of '%v', '%v', '%v' or '%v', not '%v'",
+			ModeInsert, ModeUpsert, ModeReplace, ModeMerge, restore.OutputOptions.Mode)
+	}
+	if restore.OutputOptions.UpsertFields != "" && restore.OutputOptions.Mode == ModeInsert {
+		return fmt.Errorf("cannot use --upsertFields with --mode=%v", ModeInsert)
+	}
+
+	if restore.OutputOptions.MaxWriteErrors < -1 {
+		return fmt.Errorf("--maxWriteErrors must be a number of documents, or -1 for no limit")
+	}
+	if restore.OutputOptions.MaxWriteErrors != 0 || restore.OutputOptions.DeadLetterDir != "" {
+		if restore.safety == nil {
+			return fmt.Errorf("cannot use --maxWriteErrors or --deadLetterDir with --w=0, " +
+				"the writes must be acknowledged")
+		}
+		restore.reportDocumentErrors = true
+	}
+
 	if restore.tempUsersCol == "" {
 		restore.tempUsersCol = "tempusers"
 	}
@@ -121,11 +219,111 @@ func (restore *MongoRestore) ParseAndValidateOptions() error {
 		restore.tempRolesCol = "temproles"
 	}
 
+	if restore.OutputOptions.OplogWorkers < 0 {
+		return fmt.Errorf("cannot specify a negative number of oplog workers")
+	}
+
+	if restore.OutputOptions.IndexWorkers < 0 {
+		return fmt.Errorf("cannot specify a negative number of index workers")
+	}
+
+	if restore.OutputOptions.Verify {
+		switch {
+		case restore.OutputOptions.DryRun:
+			return fmt.Errorf("cannot use --verify with --dryRun")
+		case restore.OutputOptions.Drop:
+			return fmt.Errorf("cannot use --verify with --drop, it does not write anything")
+		case restore.OutputOptions.IndexesOnly:
+			return fmt.Errorf("cannot use --verify with --indexesOnly")
+		case restore.InputOptions.OplogReplay:
+			return fmt.Errorf("cannot use --verify with --oplogReplay, the oplog is not in the dump's collections")
+		case restore.InputOptions.Archive != "" || restore.TargetDirectory == "-":
+			return fmt.Errorf("cannot use --verify when restoring from an archive or stdin")
+		}
+	}
+	if restore.OutputOptions.VerifyIDs != 0 {
+		if !restore.OutputOptions.Verify {
+			return fmt.Errorf("cannot use --verifyIds without --verify")
+		}
+		if restore.OutputOptions.VerifyIDs < 0 {
+			return fmt.Errorf("cannot list a negative number of _ids with --verifyIds")
+		}
+	}
+
+	if restore.OutputOptions.IndexesOnly {
+		switch {
+		case restore.OutputOptions.NoIndexRestore:
+			return fmt.Errorf("cannot use --indexesOnly with --noIndexRestore")
+		case restore.OutputOptions.Drop:
+			return fmt.Errorf("cannot use --indexesOnly with --drop, it does not touch data")
+		case restore.InputOptions.OplogReplay:
+			return fmt.Errorf("cannot use --indexesOnly with --oplogReplay, it does not touch data")
+		case restore.OutputOptions.DryRun:
+			return fmt.Errorf("cannot use --indexesOnly with --dryRun")
+		case restore.InputOptions.Archive != "" || restore.TargetDirectory == "-":
+			return fmt.Errorf("cannot use --indexesOnly when restoring from an archive or stdin")
+		}
+	}
+
 	if restore.OutputOptions.BulkWriters < 0 {
 		return fmt.Errorf(
 			"cannot specify a negative number of insertion workers per collection")
 	}
 
+	if len(restore.InputOptions.OplogFile) > 0 {
+		if !restore.InputOptions.OplogReplay {
+			return fmt.Errorf("cannot use --oplogFile without --oplogReplay enabled")
+		}
+		if restore.InputOptions.Archive != "" || restore.TargetDirectory == "-" {
+			return fmt.Errorf("cannot use --oplogFile when restoring from an archive or stdin")
+		}
+	}
+
+	if restore.InputOptions.Archive != "" && restore.TargetDirectory == "-" {
+		return fmt.Errorf("cannot restore from both an archive and a stdin bson stream")
+	}
+
+	if restore.InputOptions.Snapshot != "" && restore.InputOptions.Repository == "" {
+		return fmt.Errorf("cannot use --snapshot without --repository")
+	}
+	if restore.InputOptions.Repository != "" {
+		if restore.InputOptions.Snapshot == "" {
+			return fmt.Errorf("must specify which --snapshot to restore from --repository")
+		}
+		if restore.InputOptions.Archive != "" || restore.TargetDirectory == "-" {
+			return fmt.Errorf("cannot restore from both a repository and an archive or stdin")
+		}
+		if restore.InputOptions.OplogReplay {
+			return fmt.Errorf("cannot use --oplogReplay with --repository, snapshots do not contain an oplog")
+		}
+	}
+
+	if restore.InputOptions.EncryptionKeyFile != "" {
+		if restore.InputOptions.Repository != "" {
+			return fmt.Errorf("cannot use --encryptionKeyFile with --repository, snapshots are not encrypted")
+		}
+		var err error
+		restore.encryption, err = util.LoadEncryptionKeyFile(restore.InputOptions.EncryptionKeyFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	if restore.NSOptions != nil {
+		ns := restore.NSOptions
+		if len(ns.NSInclude)+len(ns.NSExclude)+len(ns.NSFrom)+len(ns.NSTo) > 0 {
+			var err error
+			restore.nsMapper, err = NewNamespaceMapper(ns.NSInclude, ns.NSExclude, ns.NSFrom, ns.NSTo)
+			if err != nil {
+				return err
+			}
+			restore.renamedFrom = map[string]string{}
+		}
+		if len(ns.NSFrom) > 0 && restore.InputOptions.Archive != "" {
+			return fmt.Errorf("cannot use --nsFrom with --archive, archives are restored under the names they were dumped with")
+		}
+	}
+
 	// a single dash signals reading from stdin
 	if restore.TargetDirectory == "-" {
 		restore.useStdin = true
@@ -134,11 +332,31 @@ func (restore *MongoRestore) ParseAndValidateOptions() error {
 		}
 	}
 
+	if restore.OutputOptions.DryRunFormat == "" {
+		restore.OutputOptions.DryRunFormat = DryRunText
+	}
+	if restore.OutputOptions.DryRunFormat != DryRunText && restore.OutputOptions.DryRunFormat != DryRunJSON {
+		return fmt.Errorf("--dryRunFormat must be either '%v' or '%v', not '%v'",
+			DryRunText, DryRunJSON, restore.OutputOptions.DryRunFormat)
+	}
+	if restore.OutputOptions.DryRun && restore.useStdin {
+		return fmt.Errorf("cannot use --dryRun when restoring from stdin")
+	}
+
 	return nil
 }
 
-func (restore *MongoRestore) Restore() error {
-	err := restore.ParseAndValidateOptions()
+// restoresUsersAndRoles returns whether the users and roles of the dump,
+// if any, are restored
+func (restore *MongoRestore) restoresUsersAndRoles() bool {
+	if restore.OutputOptions.IndexesOnly {
+		return false
+	}
+	return restore.InputOptions.RestoreDBUsersAndRoles || restore.ToolOptions.DB == "" || restore.ToolOptions.DB == "admin"
+}
+
+func (restore *MongoRestore) Restore() (err error) {
+	err = restore.ParseAndValidateOptions()
 	if err != nil {
 		return fmt.Errorf("options error: %v", err)
 	}
@@ -146,7 +364,30 @@ func (restore *MongoRestore) Restore() error {
 	// 1. Build up all intents to be restored
 	restore.manager = intents.NewCategorizingIntentManager()
 
+	if restore.InputOptions.Archive == "" && restore.InputOptions.Repository == "" && !restore.useStdin {
+		if packed, inner, ok := splitPackedDumpPath(restore.TargetDirectory); ok {
+			if err = restore.openPackedDump(packed, inner); err != nil {
+				return err
+			}
+			defer restore.packedDump.Close()
+		}
+	}
+
 	switch {
+	case restore.InputOptions.Archive != "":
+		if err = restore.openArchive(); err != nil {
+			return err
+		}
+		defer restore.archive.removeSpools()
+		log.Log(log.Always, "building a list of dbs and collections to restore from archive")
+		err = restore.CreateIntentsFromArchive()
+	case restore.InputOptions.Repository != "":
+		if err = restore.openSnapshot(); err != nil {
+			return err
+		}
+		log.Logf(log.Always, "building a list of dbs and collections to restore from snapshot %v",
+			restore.snapshot.ID)
+		err = restore.CreateIntentsFromSnapshot()
 	case restore.ToolOptions.DB == "" && restore.ToolOptions.Collection == "":
 		log.Logf(log.Always,
 			"building a list of dbs and collections to restore from %v dir",
@@ -170,8 +411,58 @@ func (restore *MongoRestore) Restore() error {
 		return fmt.Errorf("error scanning filesystem: %v", err)
 	}
 
+	if len(restore.InputOptions.OplogFile) > 0 {
+		// make sure the oplogs can be replayed before restoring anything
+		if err = restore.buildOplogChain(); err != nil {
+			return fmt.Errorf("error checking oplogs: %v", err)
+		}
+	}
+
+	if restore.OutputOptions.Verify {
+		return restore.Verify(os.Stdout)
+	}
+
+	if restore.OutputOptions.DryRun {
+		if restore.archive != nil {
+			// the body of the archive is never read
+			defer restore.archive.in.Close()
+		}
+		return restore.DryRun(os.Stdout)
+	}
+
+	if restore.OutputOptions.Mode != ModeInsert || restore.reportDocumentErrors {
+		restore.writeCommands, err = restore.SessionProvider.SupportsWriteCommands()
+		if err != nil {
+			return fmt.Errorf("error determining if the server supports write commands: %v", err)
+		}
+		if !restore.writeCommands {
+			log.Logf(log.Always, "server does not support write commands, "+
+				"documents will be written one at a time")
+		}
+	}
+
+	defer func() {
+		if closeErr := restore.closeDeadLetters(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+
 	// 2. Restore them...
-	if restore.OutputOptions.JobThreads > 0 {
+	if restore.archive != nil {
+		// archives can only be read front to back, so they
+		// dictate the order in which intents are restored
+		if err = restore.startArchive(); err != nil {
+			return err
+		}
+	} else if restore.streamsPackedDump() {
+		// and so do compressed tars, which are read in a single pass
+		if restore.OutputOptions.JobThreads > 1 {
+			log.Logf(log.Always, "restoring compressed tar %v with 1 job thread instead of %v",
+				restore.packedDump.path, restore.OutputOptions.JobThreads)
+			restore.OutputOptions.JobThreads = 1
+		}
+		restore.manager.FinalizeStreaming(restore.packedIntentOrder())
+	} else if restore.OutputOptions.JobThreads > 0 {
 		restore.manager.Finalize(intents.MultiDatabaseLTF)
 	} else {
 		// use legacy restoration order if we are single-threaded
@@ -184,7 +475,7 @@ func (restore *MongoRestore) Restore() error {
 
 	// 3. Restore users/roles
 	// TODO comment all cases
-	if restore.InputOptions.RestoreDBUsersAndRoles || restore.ToolOptions.DB == "" || restore.ToolOptions.DB == "admin" {
+	if restore.restoresUsersAndRoles() {
 		if restore.manager.Users() != nil {
 			err = restore.RestoreUsersOrRoles(Users, restore.manager.Users())
 			if err != nil {
@@ -207,6 +498,17 @@ func (restore *MongoRestore) Restore() error {
 		}
 	}
 
+	if restore.archive != nil {
+		if err = restore.finishArchive(); err != nil {
+			return err
+		}
+	}
+
+	if restore.OutputOptions.Mode != ModeInsert {
+		log.Logf(log.Always, "--mode=%v: %v in total",
+			restore.OutputOptions.Mode, formatWriteResult(restore.writeResult))
+	}
+
 	log.Log(log.Always, "done")
 	return nil
 }
@@ -0,0 +1,386 @@
+package mongorestore
+
+import (
+	"fmt"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
+	"regexp"
+	"strings"
+)
+
+// nsPattern is a compiled --nsInclude, --nsExclude or --nsFrom pattern.
+// A "*" matches any run of characters, as does a "$name$" variable, whose
+// match can be used again in --nsTo. A backslash escapes the next character.
+type nsPattern struct {
+	source string
+	regexp *regexp.Regexp
+	// the variable name of every capture group, "*" for wildcards
+	captures []string
+}
+
+// nsVariableName is what may appear between the dollar signs of a variable
+var nsVariableName = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// collectionCommands are the commands logged to the oplog whose
+// value is the name of the collection they act on
+var collectionCommands = []string{"create", "drop", "collMod", "createIndexes",
+	"dropIndexes", "deleteIndexes", "emptycapped", "convertToCapped"}
+
+// nsTemplate is a compiled --nsTo pattern
+type nsTemplate struct {
+	literals []string
+	// captures[i] is the variable substituted between literals[i] and
+	// literals[i+1], "*" for the next wildcard of --nsFrom in order
+	captures []string
+}
+
+// nsRename renames the namespaces matching from to the template to
+type nsRename struct {
+	from *nsPattern
+	to   *nsTemplate
+}
+
+// NamespaceMapper decides which namespaces of a dump are restored, and
+// under which namespace, given --nsInclude, --nsExclude, and pairs of
+// --nsFrom and --nsTo patterns. The first --nsFrom that matches wins.
+type NamespaceMapper struct {
+	include []*nsPattern
+	exclude []*nsPattern
+	renames []nsRename
+}
+
+// NewNamespaceMapper compiles the given patterns
+func NewNamespaceMapper(include, exclude, from, to []string) (*NamespaceMapper, error) {
+	if len(from) != len(to) {
+		return nil, fmt.Errorf("every --nsFrom must have a matching --nsTo")
+	}
+	mapper, err := newNamespaceFilter(include, exclude, "--nsInclude", "--nsExclude")
+	if err != nil {
+		return nil, err
+	}
+	for i := range from {
+		fromPattern, err := compileNSPattern(from[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid --nsFrom '%v': %v", from[i], err)
+		}
+		toTemplate, err := compileNSTemplate(to[i], fromPattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --nsTo '%v': %v", to[i], err)
+		}
+		mapper.renames = append(mapper.renames, nsRename{fromPattern, toTemplate})
+	}
+	return mapper, nil
+}
+
+// newNamespaceFilter compiles include and exclude patterns into a mapper
+// that renames nothing, naming the flags they were given to in errors
+func newNamespaceFilter(include, exclude []string, includeFlag, excludeFlag string) (*NamespaceMapper, error) {
+	mapper := &NamespaceMapper{}
+	for _, pattern := range include {
+		compiled, err := compileNSPattern(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %v '%v': %v", includeFlag, pattern, err)
+		}
+		mapper.include = append(mapper.include, compiled)
+	}
+	for _, pattern := range exclude {
+		compiled, err := compileNSPattern(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %v '%v': %v", excludeFlag, pattern, err)
+		}
+		mapper.exclude = append(mapper.exclude, compiled)
+	}
+	return mapper, nil
+}
+
+// Included returns whether the namespace is to be restored
+func (mapper *NamespaceMapper) Included(ns string) bool {
+	included := len(mapper.include) == 0
+	for _, pattern := range mapper.include {
+		if pattern.regexp.MatchString(ns) {
+			included = true
+			break
+		}
+	}
+	for _, pattern := range mapper.exclude {
+		if pattern.regexp.MatchString(ns) {
+			return false
+		}
+	}
+	return included
+}
+
+// Renames returns whether any --nsFrom was given
+func (mapper *NamespaceMapper) Renames() bool {
+	return len(mapper.renames) > 0
+}
+
+// Map returns the namespace to restore ns to, which is ns itself
+// unless it matches an --nsFrom
+func (mapper *NamespaceMapper) Map(ns string) string {
+	for _, rename := range mapper.renames {
+		matches := rename.from.regexp.FindStringSubmatch(ns)
+		if matches == nil {
+			continue
+		}
+		values := map[string]string{}
+		wildcards := []string{}
+		for i, name := range rename.from.captures {
+			if name == "*" {
+				wildcards = append(wildcards, matches[i+1])
+			} else {
+				values[name] = matches[i+1]
+			}
+		}
+		out := rename.to.literals[0]
+		for i, name := range rename.to.captures {
+			if name == "*" {
+				out += wildcards[0]
+				wildcards = wildcards[1:]
+			} else {
+				out += values[name]
+			}
+			out += rename.to.literals[i+1]
+		}
+		return out
+	}
+	return ns
+}
+
+// parseNSPattern splits a pattern into its literal parts and the captures
+// between them, unescaping the literals
+func parseNSPattern(pattern string) ([]string, []string, error) {
+	literals := []string{}
+	captures := []string{}
+	literal := ""
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '\\':
+			if i+1 == len(pattern) {
+				return nil, nil, fmt.Errorf("trailing backslash")
+			}
+			i++
+			literal += string(pattern[i])
+		case '*':
+			literals = append(literals, literal)
+			captures = append(captures, "*")
+			literal = ""
+		case '$':
+			end := strings.IndexByte(pattern[i+1:], '$')
+			if end < 0 {
+				return nil, nil, fmt.Errorf("unterminated variable at position %v", i)
+			}
+			name := pattern[i+1 : i+1+end]
+			if !nsVariableName.MatchString(name) {
+				return nil, nil, fmt.Errorf("invalid variable name '%v'", name)
+			}
+			literals = append(literals, literal)
+			captures = append(captures, name)
+			literal = ""
+			i += end + 1
+		default:
+			literal += string(c)
+		}
+	}
+	literals = append(literals, literal)
+	return literals, captures, nil
+}
+
+func compileNSPattern(pattern string) (*nsPattern, error) {
+	literals, captures, err := parseNSPattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	expr := "^" + regexp.QuoteMeta(literals[0])
+	for i, name := range captures {
+		if name != "*" {
+			if seen[name] {
+				return nil, fmt.Errorf("variable $%v$ is used twice", name)
+			}
+			seen[name] = true
+		}
+		// lazy, so that e.g. "$db$.*" splits at the first dot
+		expr += "(.*?)" + regexp.QuoteMeta(literals[i+1])
+	}
+	compiled, err := regexp.Compile(expr + "$")
+	if err != nil {
+		return nil, err
+	}
+	return &nsPattern{source: pattern, regexp: compiled, captures: captures}, nil
+}
+
+func compileNSTemplate(pattern string, from *nsPattern) (*nsTemplate, error) {
+	literals, captures, err := parseNSPattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	fromWildcards := 0
+	fromVars := map[string]bool{}
+	for _, name := range from.captures {
+		if name == "*" {
+			fromWildcards++
+		} else {
+			fromVars[name] = true
+		}
+	}
+	wildcards := 0
+	for _, name := range captures {
+		if name == "*" {
+			wildcards++
+		} else if !fromVars[name] {
+			return nil, fmt.Errorf("variable $%v$ does not appear in --nsFrom '%v'", name, from.source)
+		}
+	}
+	if wildcards > fromWildcards {
+		return nil, fmt.Errorf("has %v wildcards, but --nsFrom '%v' only has %v", wildcards, from.source, fromWildcards)
+	}
+	return &nsTemplate{literals: literals, captures: captures}, nil
+}
+
+// splitNS splits a namespace into its database and collection
+func splitNS(ns string) (string, string) {
+	i := strings.Index(ns, ".")
+	if i < 0 {
+		return ns, ""
+	}
+	return ns[:i], ns[i+1:]
+}
+
+// mapIntent applies the namespace options to an intent as it is built,
+// renaming it in place. It returns false if the intent is not to be restored.
+// The oplog and system.indexes are never filtered nor renamed, and the users
+// and roles collections are only filtered.
+func (restore *MongoRestore) mapIntent(intent *intents.Intent) (bool, error) {
+	if restore.nsMapper == nil || intent.IsOplog() || intent.IsSystemIndexes() {
+		return true, nil
+	}
+	source := intent.Key()
+	if !restore.nsMapper.Included(source) {
+		return false, nil
+	}
+	if intent.IsUsers() || intent.IsRoles() {
+		return true, nil
+	}
+	target := restore.nsMapper.Map(source)
+	// a renamed namespace may land on another renamed one, or on
+	// one that is restored under the name it was dumped with
+	if restore.restoredFrom == nil {
+		restore.restoredFrom = map[string]string{}
+	}
+	if previous, ok := restore.restoredFrom[target]; ok && previous != source {
+		return false, fmt.Errorf("both %v and %v would be restored to %v", previous, source, target)
+	}
+	restore.restoredFrom[target] = source
+	if target == source {
+		return true, nil
+	}
+	db, collection := splitNS(target)
+	if err := util.ValidateDBName(db); err != nil {
+		return false, fmt.Errorf("invalid database name in %v, renamed from %v: %v", target, source, err)
+	}
+	if err := util.ValidateCollectionGrammar(collection); err != nil {
+		return false, fmt.Errorf("invalid collection name in %v, renamed from %v: %v", target, source, err)
+	}
+	restore.renamedFrom[target] = source
+	intent.DB, intent.C = db, collection
+	return true, nil
+}
+
+// putIntent hands an intent to the manager, unless the
+// namespace options exclude it
+func (restore *MongoRestore) putIntent(intent *intents.Intent) error {
+	source := intent.Key()
+	restored, err := restore.mapIntent(intent)
+	if err != nil {
+		return err
+	}
+	if !restored {
+		log.Logf(log.DebugLow, "skipping %v, excluded by the namespace options", source)
+		return nil
+	}
+	if intent.Key() != source {
+		log.Logf(log.Info, "restoring %v to %v", source, intent.Key())
+	}
+	restore.manager.Put(intent)
+	return nil
+}
+
+// sourceOf returns the database and collection an intent
+// was dumped from, before any --nsFrom renaming
+func (restore *MongoRestore) sourceOf(intent *intents.Intent) (string, string) {
+	if source, ok := restore.renamedFrom[intent.Key()]; ok {
+		return splitNS(source)
+	}
+	return intent.DB, intent.C
+}
+
+// oplogTarget is the namespace an oplog entry acts on, and where the entry
+// names it: in its own namespace, in the object of a renameCollection or of
+// another command, or in the index document of an index build on a server
+// before 2.6, which is an insert into system.indexes
+type oplogTarget struct {
+	ns      string
+	kind    int
+	command string
+}
+
+const (
+	oplogTargetEntry = iota
+	oplogTargetRename
+	oplogTargetCommand
+	oplogTargetIndex
+)
+
+// oplogTargetOf returns the target of an oplog entry. Commands on a whole
+// database such as dropDatabase act on the "<db>.$cmd" namespace.
+func oplogTargetOf(entry *Oplog) oplogTarget {
+	db, collection := splitNS(entry.Namespace)
+	switch collection {
+	case "$cmd":
+		if from, ok := entry.Object["renameCollection"].(string); ok {
+			return oplogTarget{ns: from, kind: oplogTargetRename}
+		}
+		for _, command := range collectionCommands {
+			if target, ok := entry.Object[command].(string); ok {
+				return oplogTarget{ns: db + "." + target, kind: oplogTargetCommand, command: command}
+			}
+		}
+	case "system.indexes":
+		if ns, ok := entry.Object["ns"].(string); ok {
+			return oplogTarget{ns: ns, kind: oplogTargetIndex}
+		}
+	}
+	return oplogTarget{ns: entry.Namespace, kind: oplogTargetEntry}
+}
+
+// mapOplogEntry applies the namespace options to an oplog entry, renaming
+// it in place, and returns false if the entry is not to be replayed.
+// Entries are matched by the namespace of their target.
+func (restore *MongoRestore) mapOplogEntry(entry *Oplog) bool {
+	mapper := restore.nsMapper
+	target := oplogTargetOf(entry)
+	if !mapper.Included(target.ns) {
+		return false
+	}
+	mapped := mapper.Map(target.ns)
+	switch target.kind {
+	case oplogTargetRename:
+		entry.Object["renameCollection"] = mapped
+		if to, ok := entry.Object["to"].(string); ok {
+			entry.Object["to"] = mapper.Map(to)
+		}
+	case oplogTargetCommand:
+		newDB, newCollection := splitNS(mapped)
+		entry.Namespace = newDB + ".$cmd"
+		entry.Object[target.command] = newCollection
+	case oplogTargetIndex:
+		newDB, _ := splitNS(mapped)
+		entry.Namespace = newDB + ".system.indexes"
+		entry.Object["ns"] = mapped
+	default:
+		entry.Namespace = mapped
+	}
+	return true
+}
@@ -0,0 +1,137 @@
+package mongorestore
+
+import (
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+	"testing"
+)
+
+func TestNamespaceMapper(t *testing.T) {
+
+	testutil.VerifyTestType(t, testutil.UNIT_TEST_TYPE)
+
+	Convey("With include and exclude patterns", t, func() {
+		mapper, err := NewNamespaceMapper([]string{"app.*", "logs.events"}, []string{"*.tmp_*"}, nil, nil)
+		So(err, ShouldBeNil)
+
+		Convey("only included namespaces that are not excluded should match", func() {
+			So(mapper.Included("app.users"), ShouldBeTrue)
+			So(mapper.Included("logs.events"), ShouldBeTrue)
+			So(mapper.Included("logs.audit"), ShouldBeFalse)
+			So(mapper.Included("app.tmp_import"), ShouldBeFalse)
+			So(mapper.Map("app.users"), ShouldEqual, "app.users")
+		})
+	})
+
+	Convey("With renaming patterns", t, func() {
+		mapper, err := NewNamespaceMapper(nil, nil,
+			[]string{"prod_*.*", "legacy.$coll$_v1", `lit\*.c`},
+			[]string{"staging_*.*", "current.$coll$", "lit.c"})
+		So(err, ShouldBeNil)
+
+		Convey("wildcards should be substituted in order", func() {
+			So(mapper.Map("prod_shop.orders"), ShouldEqual, "staging_shop.orders")
+			So(mapper.Map("prod_shop.orders.archive"), ShouldEqual, "staging_shop.orders.archive")
+		})
+
+		Convey("variables should be substituted by name", func() {
+			So(mapper.Map("legacy.users_v1"), ShouldEqual, "current.users")
+		})
+
+		Convey("escaped characters should match literally", func() {
+			So(mapper.Map("lit*.c"), ShouldEqual, "lit.c")
+			So(mapper.Map("litx.c"), ShouldEqual, "litx.c")
+		})
+
+		Convey("namespaces matching no pattern should be kept", func() {
+			So(mapper.Map("other.users"), ShouldEqual, "other.users")
+		})
+	})
+
+	Convey("Invalid renaming patterns should be rejected", t, func() {
+		for _, pair := range [][]string{
+			{"a.*", "b.*.*"},
+			{"a.$x$", "b.$y$"},
+			{"a.$x$.$x$", "b.$x$"},
+			{"a.$x", "b.c"},
+			{`a.c\`, "b.c"},
+		} {
+			_, err := NewNamespaceMapper(nil, nil, []string{pair[0]}, []string{pair[1]})
+			So(err, ShouldNotBeNil)
+		}
+		_, err := NewNamespaceMapper(nil, nil, []string{"a.*"}, nil)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("With a MongoRestore renaming prod.* to staging.*", t, func() {
+		mapper, err := NewNamespaceMapper(nil, []string{"prod.skip"}, []string{"prod.*"}, []string{"staging.*"})
+		So(err, ShouldBeNil)
+		restore := &MongoRestore{
+			manager:     intents.NewCategorizingIntentManager(),
+			nsMapper:    mapper,
+			renamedFrom: map[string]string{},
+		}
+
+		Convey("intents should be renamed and remember their source", func() {
+			So(restore.putIntent(&intents.Intent{DB: "prod", C: "users", BSONPath: "prod/users.bson"}), ShouldBeNil)
+			So(restore.putIntent(&intents.Intent{DB: "prod", C: "users", MetadataPath: "prod/users.metadata.json"}), ShouldBeNil)
+			So(restore.putIntent(&intents.Intent{DB: "prod", C: "skip", BSONPath: "prod/skip.bson"}), ShouldBeNil)
+			restore.manager.Finalize(intents.Legacy)
+			intent := restore.manager.Pop()
+			So(intent.Key(), ShouldEqual, "staging.users")
+			So(intent.MetadataPath, ShouldEqual, "prod/users.metadata.json")
+			So(restore.manager.Pop(), ShouldBeNil)
+			db, c := restore.sourceOf(intent)
+			So(db, ShouldEqual, "prod")
+			So(c, ShouldEqual, "users")
+		})
+
+		Convey("two namespaces renamed to the same one should be rejected", func() {
+			mapper, err := NewNamespaceMapper(nil, nil, []string{"a.*"}, []string{"b.c"})
+			So(err, ShouldBeNil)
+			restore.nsMapper = mapper
+			So(restore.putIntent(&intents.Intent{DB: "a", C: "x", BSONPath: "a/x.bson"}), ShouldBeNil)
+			So(restore.putIntent(&intents.Intent{DB: "a", C: "y", BSONPath: "a/y.bson"}), ShouldNotBeNil)
+		})
+
+		Convey("a namespace renamed to one restored under its own name should be rejected", func() {
+			So(restore.putIntent(&intents.Intent{DB: "staging", C: "users", BSONPath: "staging/users.bson"}), ShouldBeNil)
+			So(restore.putIntent(&intents.Intent{DB: "prod", C: "users", BSONPath: "prod/users.bson"}), ShouldNotBeNil)
+
+			Convey("in whichever order they are found", func() {
+				restore.restoredFrom = nil
+				So(restore.putIntent(&intents.Intent{DB: "prod", C: "users", BSONPath: "prod/users.bson"}), ShouldBeNil)
+				So(restore.putIntent(&intents.Intent{DB: "staging", C: "users", BSONPath: "staging/users.bson"}), ShouldNotBeNil)
+			})
+		})
+
+		Convey("oplog entries should be renamed or skipped", func() {
+			insert := &Oplog{Operation: "i", Namespace: "prod.users", Object: bson.M{"_id": 1}}
+			So(restore.mapOplogEntry(insert), ShouldBeTrue)
+			So(insert.Namespace, ShouldEqual, "staging.users")
+
+			skipped := &Oplog{Operation: "i", Namespace: "prod.skip", Object: bson.M{"_id": 1}}
+			So(restore.mapOplogEntry(skipped), ShouldBeFalse)
+
+			create := &Oplog{Operation: "c", Namespace: "prod.$cmd", Object: bson.M{"create": "orders"}}
+			So(restore.mapOplogEntry(create), ShouldBeTrue)
+			So(create.Namespace, ShouldEqual, "staging.$cmd")
+			So(create.Object["create"], ShouldEqual, "orders")
+
+			rename := &Oplog{Operation: "c", Namespace: "admin.$cmd",
+				Object: bson.M{"renameCollection": "prod.a", "to": "prod.b"}}
+			So(restore.mapOplogEntry(rename), ShouldBeTrue)
+			So(rename.Namespace, ShouldEqual, "admin.$cmd")
+			So(rename.Object["renameCollection"], ShouldEqual, "staging.a")
+			So(rename.Object["to"], ShouldEqual, "staging.b")
+
+			index := &Oplog{Operation: "i", Namespace: "prod.system.indexes",
+				Object: bson.M{"ns": "prod.users", "key": bson.M{"a": 1}, "name": "a_1"}}
+			So(restore.mapOplogEntry(index), ShouldBeTrue)
+			So(index.Namespace, ShouldEqual, "staging.system.indexes")
+			So(index.Object["ns"], ShouldEqual, "staging.users")
+		})
+	})
+}
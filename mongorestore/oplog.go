@@ -2,13 +2,12 @@ package mongorestore
 
 import (
 	"fmt"
-	"github.com/mongodb/mongo-tools/common/db"
 	"github.com/mongodb/mongo-tools/common/log"
 	"github.com/mongodb/mongo-tools/common/progress"
 	"github.com/mongodb/mongo-tools/common/util"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
-	"os"
+	"math"
 	"strconv"
 	"strings"
 	"time"
@@ -31,32 +30,44 @@ func (restore *MongoRestore) RestoreOplog() error {
 	intent := restore.manager.Oplog()
 	if intent == nil {
 		log.Log(log.Always, "no oplog.bson file in root of the dump directory, skipping oplog application")
+		return nil
 	}
 
-	fileInfo, err := os.Lstat(intent.BSONPath)
-	if err != nil {
-		return fmt.Errorf("error reading bson file: %v", err)
+	// without --oplogFile, only the dump's own oplog is replayed
+	sources := restore.oplogSources
+	if sources == nil {
+		source, err := restore.dumpOplogSource(intent)
+		if err != nil {
+			return err
+		}
+		sources = []*oplogSource{source}
 	}
-	size := fileInfo.Size()
-	log.Logf(log.Info, "\toplog %v is %v bytes", intent.BSONPath, size)
-
-	oplogFile, err := os.Open(intent.BSONPath)
-	if err != nil {
-		return fmt.Errorf("error reading oplog file: %v", err)
+	// the progress of the replay has no total if the size
+	// of any of the oplogs is only known once it is read
+	var size int64
+	for _, source := range sources {
+		if source.size == 0 {
+			size = 0
+			break
+		}
+		size += source.size
 	}
 
-	bsonSource := db.NewDecodedBSONSource(db.NewBSONSource(oplogFile))
-	defer bsonSource.Close()
+	reader := restore.newOplogReader(sources)
+	defer reader.Close()
+	if restore.OutputOptions.OplogWorkers > 1 {
+		return restore.replayOplogParallel(reader, size)
+	}
 
 	entryArray := make([]interface{}, 0, 1024)
-	rawOplogEntry := &bson.Raw{}
+	entryAsOplog := Oplog{}
 
-	var totalBytes, entrySize, bufferedBytes int
+	var entrySize, bufferedBytes int
 
 	bar := progress.ProgressBar{
 		Name:       "oplog",
 		Max:        int(size),
-		CounterPtr: &totalBytes,
+		CounterPtr: &reader.bytesRead,
 		WaitTime:   3 * time.Second,
 		Writer:     log.Writer(0),
 		BarLength:  ProgressBarLength,
@@ -74,8 +85,11 @@ func (restore *MongoRestore) RestoreOplog() error {
 	// To restore the oplog, we iterate over the oplog entries,
 	// filling up a buffer. Once the buffer reaches max document size,
 	// apply the current buffered ops and reset the buffer.
-	for bsonSource.Next(rawOplogEntry) {
-		entrySize = len(rawOplogEntry.Data)
+	for {
+		var ok bool
+		if entrySize, ok = reader.Next(&entryAsOplog); !ok {
+			break
+		}
 		if bufferedBytes+entrySize > OplogMaxCommandSize {
 			err = restore.ApplyOps(session, entryArray)
 			if err != nil {
@@ -85,25 +99,16 @@ func (restore *MongoRestore) RestoreOplog() error {
 			bufferedBytes = 0
 		}
 
-		entryAsOplog := Oplog{}
-		err = bson.Unmarshal(rawOplogEntry.Data, &entryAsOplog)
-		if err != nil {
-			return fmt.Errorf("error reading oplog: %v", err)
-		}
-		if !restore.TimestampBeforeLimit(entryAsOplog.Timestamp) {
-			log.Logf(
-				log.DebugLow,
-				"timestamp %v is not below limit of %v; ending oplog restoration",
-				entryAsOplog.Timestamp,
-				restore.oplogLimit,
-			)
-			break
+		if !restore.selectOplogEntry(&entryAsOplog) {
+			continue
 		}
 
 		bufferedBytes += entrySize
-		totalBytes += entrySize
 		entryArray = append(entryArray, entryAsOplog)
 	}
+	if err = reader.Err(); err != nil {
+		return fmt.Errorf("error reading oplog: %v", err)
+	}
 	// finally, flush the remaining entries
 	if len(entryArray) > 0 {
 		err = restore.ApplyOps(session, entryArray)
@@ -141,6 +146,19 @@ func (restore *MongoRestore) TimestampBeforeLimit(ts bson.MongoTimestamp) bool {
 	return ts < restore.oplogLimit
 }
 
+// ParseOplogLimit parses the --oplogLimit, either a timestamp for
+// ParseTimestampFlag or a time in RFC 3339 format, which limits the
+// oplog to the entries written before that second.
+func ParseOplogLimit(limit string) (bson.MongoTimestamp, error) {
+	if limitTime, err := time.Parse(time.RFC3339, limit); err == nil {
+		if limitTime.Unix() < 0 || limitTime.Unix() > math.MaxUint32 {
+			return 0, fmt.Errorf("time %v is out of the range of timestamps", limit)
+		}
+		return bson.MongoTimestamp(limitTime.Unix() << 32), nil
+	}
+	return ParseTimestampFlag(limit)
+}
+
 // ParseTimestampFlag takes in a string the form of <time_t>:<ordinal>,
 // where <time_t> is the seconds since the UNIX epoch, and <ordinal> represents
 // a counter of operations in the oplog that occurred in the specified second.
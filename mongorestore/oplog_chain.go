@@ -0,0 +1,356 @@
+package mongorestore
+
+import (
+	"fmt"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
+	"github.com/mongodb/mongo-tools/mongodump"
+	"gopkg.in/mgo.v2/bson"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// oplogSource is an oplog replayed by --oplogReplay: the dump's own,
+// or one of the later captures given with --oplogFile
+type oplogSource struct {
+	name string
+	// path is empty for the dump's own oplog, which is read through its intent
+	path string
+	// size is the number of BSON bytes in the oplog, or 0 if
+	// it is compressed or encrypted and only known once read
+	size int64
+
+	// start and end are the range of entries captured, after start up to and
+	// including end, when the dump recorded it in oplog.metadata.json. Start
+	// is zero when unknown, and end defaults to the timestamp of the last entry.
+	start, end bson.MongoTimestamp
+
+	// set by scanOplogSource
+	entries     int
+	first, last Oplog
+	// joined is whether the oplog holds the last entry of the one before
+	joined bool
+}
+
+// dumpOplogSource returns the dump's own oplog as a source
+func (restore *MongoRestore) dumpOplogSource(intent *intents.Intent) (*oplogSource, error) {
+	source := &oplogSource{name: intent.BSONPath}
+	if restore.archive != nil {
+		source.name = "archive"
+		return source, nil
+	}
+	fileInfo, err := restore.dumpFS().Stat(intent.BSONPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading bson file: %v", err)
+	}
+	source.size = restore.oplogFileSize(intent.BSONPath, fileInfo)
+	log.Logf(log.Info, "\toplog %v is %v bytes", intent.BSONPath, fileInfo.Size())
+	return source, nil
+}
+
+// newOplogFileSource returns the oplog given to --oplogFile as a source. It
+// may be an oplog BSON file, or the folder of a dump taken with --oplog or
+// --incrementalFrom, whose oplog.metadata.json tells the range it captured.
+func (restore *MongoRestore) newOplogFileSource(path string) (*oplogSource, error) {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading --oplogFile %v: %v", path, err)
+	}
+	source := &oplogSource{name: path, path: path}
+	if fileInfo.IsDir() {
+		if source.path, err = findOplogFile(path); err != nil {
+			return nil, fmt.Errorf("no oplog found in %v: %v", path, err)
+		}
+		meta, err := mongodump.ReadOplogMetadata(path, restore.encryption)
+		if err != nil {
+			return nil, fmt.Errorf("error reading oplog metadata of %v: %v", path, err)
+		}
+		source.start, source.end = meta.Start, meta.End
+		if fileInfo, err = os.Stat(source.path); err != nil {
+			return nil, fmt.Errorf("error reading %v: %v", source.path, err)
+		}
+	}
+	source.size = restore.oplogFileSize(source.path, fileInfo)
+	return source, nil
+}
+
+// oplogFileSize returns the number of BSON bytes in an oplog file, which
+// is its size on disk unless it is compressed or encrypted, or 0 otherwise
+func (restore *MongoRestore) oplogFileSize(path string, fileInfo os.FileInfo) int64 {
+	if codec, _ := util.CompressionCodecForFile(path); codec != nil || restore.encryption != nil {
+		return 0
+	}
+	return fileInfo.Size()
+}
+
+// readDumpOplogMetadata reads the oplog.metadata.json of the dump folder
+// through its dumpFS, so that packed dumps are read in place
+func (restore *MongoRestore) readDumpOplogMetadata() (*mongodump.OplogMetadata, error) {
+	fs := restore.dumpFS()
+	path := filepath.Join(restore.TargetDirectory, "oplog.metadata.json")
+	_, err := fs.Stat(path)
+	for _, codec := range util.CompressionCodecs {
+		if err == nil {
+			break
+		}
+		if _, codecErr := fs.Stat(path + codec.Extension); codecErr == nil {
+			path, err = path+codec.Extension, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	in, err := openDumpFile(fs, path, restore.encryption)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	return mongodump.ParseOplogMetadata(in, path)
+}
+
+// findOplogFile returns the path of the oplog in a dump folder,
+// which may be compressed
+func findOplogFile(dir string) (string, error) {
+	path := filepath.Join(dir, "oplog.bson")
+	_, err := os.Stat(path)
+	if err == nil {
+		return path, nil
+	}
+	for _, codec := range util.CompressionCodecs {
+		if _, codecErr := os.Stat(path + codec.Extension); codecErr == nil {
+			return path + codec.Extension, nil
+		}
+	}
+	return "", err
+}
+
+// openOplogSource returns a reader over the entries of an oplog
+func (restore *MongoRestore) openOplogSource(source *oplogSource) (io.ReadCloser, error) {
+	if source.path == "" {
+		return restore.openIntentBSON(restore.manager.Oplog())
+	}
+	return util.OpenDecrypted(source.path, restore.encryption)
+}
+
+// buildOplogChain reads the dump's oplog and every --oplogFile in order, and
+// makes sure that together they hold every entry up to the --oplogLimit. Each
+// oplog must either hold the last entry of the ones before it, or have been
+// captured from a point no later than where they end. Nothing is restored
+// if the chain has a gap, diverges, or ends before the --oplogLimit.
+func (restore *MongoRestore) buildOplogChain() error {
+	intent := restore.manager.Oplog()
+	if intent == nil {
+		return fmt.Errorf("cannot use --oplogFile, the dump has no oplog to replay first")
+	}
+	base, err := restore.dumpOplogSource(intent)
+	if err != nil {
+		return err
+	}
+	if meta, err := restore.readDumpOplogMetadata(); err == nil {
+		base.start, base.end = meta.Start, meta.End
+	} else {
+		log.Logf(log.DebugLow, "no oplog range recorded for %v: %v", base.name, err)
+	}
+	sources := []*oplogSource{base}
+	for _, path := range restore.InputOptions.OplogFile {
+		source, err := restore.newOplogFileSource(path)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, source)
+	}
+
+	// the source that ends the chain so far
+	var tail *oplogSource
+	for _, source := range sources {
+		if err = restore.scanOplogSource(source, tail); err != nil {
+			return err
+		}
+		if source.entries == 0 && source.end == 0 {
+			log.Logf(log.Always, "oplog %v is empty, skipping", source.name)
+			continue
+		}
+		if tail != nil {
+			if err = checkOplogContinuity(tail, source); err != nil {
+				return err
+			}
+			if source.end < tail.end {
+				// entirely overlapped by the chain so far
+				continue
+			}
+		}
+		tail = source
+	}
+	if tail == nil {
+		return fmt.Errorf("no oplog entries to replay")
+	}
+	log.Logf(log.Always, "replaying %v oplogs up to %v", len(sources), formatTimestamp(tail.end))
+	if restore.oplogLimit != 0 && restore.oplogLimit > tail.end+1 {
+		return fmt.Errorf("the oplogs end at %v, before the --oplogLimit of %v; "+
+			"entries in between may be missing, an oplog captured later is needed",
+			formatTimestamp(tail.end), formatTimestamp(restore.oplogLimit))
+	}
+	restore.oplogSources = sources
+	return nil
+}
+
+// scanOplogSource reads an oplog to find its first and last entries, checking
+// that they are in order, and whether it holds the last entry of tail
+func (restore *MongoRestore) scanOplogSource(source, tail *oplogSource) error {
+	log.Logf(log.Info, "checking oplog %v", source.name)
+	in, err := restore.openOplogSource(source)
+	if err != nil {
+		return fmt.Errorf("error reading oplog %v: %v", source.name, err)
+	}
+	bsonSource := db.NewDecodedBSONSource(db.NewBSONSource(in))
+	defer bsonSource.Close()
+	entry := Oplog{}
+	for bsonSource.Next(&entry) {
+		if source.entries > 0 && entry.Timestamp <= source.last.Timestamp {
+			return fmt.Errorf("oplog %v is out of order: %v follows %v", source.name,
+				formatTimestamp(entry.Timestamp), formatTimestamp(source.last.Timestamp))
+		}
+		if tail != nil && tail.entries > 0 && entry.Timestamp == tail.last.Timestamp {
+			if !sameOplogEntry(entry, tail.last) {
+				return fmt.Errorf("oplog %v diverges from %v: their entries at %v differ",
+					source.name, tail.name, formatTimestamp(entry.Timestamp))
+			}
+			source.joined = true
+		}
+		if source.entries == 0 {
+			source.first = entry
+		}
+		source.last = entry
+		source.entries++
+		entry = Oplog{}
+	}
+	if err = bsonSource.Err(); err != nil {
+		return fmt.Errorf("error reading oplog %v: %v", source.name, err)
+	}
+	if source.start != 0 && source.entries > 0 && source.first.Timestamp <= source.start {
+		return fmt.Errorf("oplog %v holds entries from %v, before the start of its recorded range at %v",
+			source.name, formatTimestamp(source.first.Timestamp), formatTimestamp(source.start))
+	}
+	if source.last.Timestamp > source.end {
+		source.end = source.last.Timestamp
+	}
+	return nil
+}
+
+// sameOplogEntry returns whether two entries with the same timestamp are
+// the same operation. Servers that do not set the h field are trusted.
+func sameOplogEntry(a, b Oplog) bool {
+	return a.HistoryID == 0 || b.HistoryID == 0 || a.HistoryID == b.HistoryID
+}
+
+// checkOplogContinuity returns an error unless source picks up where tail ends
+func checkOplogContinuity(tail, source *oplogSource) error {
+	if source.joined {
+		return nil
+	}
+	if source.start != 0 && source.start <= tail.end {
+		return nil
+	}
+	if source.entries > 0 && source.first.Timestamp <= tail.end {
+		return fmt.Errorf("oplog %v overlaps %v but does not hold its last entry at %v; "+
+			"they are not from the same replica set or an oplog was truncated",
+			source.name, tail.name, formatTimestamp(tail.last.Timestamp))
+	}
+	from := "unknown"
+	if source.start != 0 {
+		from = formatTimestamp(source.start)
+	} else if source.entries > 0 {
+		from = formatTimestamp(source.first.Timestamp)
+	}
+	return fmt.Errorf("cannot prove that oplog %v continues %v: %v ends at %v, but %v starts at %v",
+		source.name, tail.name, tail.name, formatTimestamp(tail.end), source.name, from)
+}
+
+// oplogReader reads the entries to replay from oplogs in order, once each,
+// skipping those already read from an earlier oplog, up to the --oplogLimit
+type oplogReader struct {
+	restore *MongoRestore
+	sources []*oplogSource
+
+	current   *db.DecodedBSONSource
+	next      int
+	last      bson.MongoTimestamp
+	read      int
+	bytesRead int
+	err       error
+}
+
+func (restore *MongoRestore) newOplogReader(sources []*oplogSource) *oplogReader {
+	return &oplogReader{restore: restore, sources: sources}
+}
+
+// Next reads the next entry to replay into entry and returns its size,
+// or false once all oplogs are read, the --oplogLimit is reached, or
+// an error occurs
+func (reader *oplogReader) Next(entry *Oplog) (int, bool) {
+	raw := bson.Raw{}
+	for reader.err == nil {
+		if reader.current == nil {
+			if reader.next == len(reader.sources) {
+				return 0, false
+			}
+			source := reader.sources[reader.next]
+			reader.next++
+			in, err := reader.restore.openOplogSource(source)
+			if err != nil {
+				reader.err = fmt.Errorf("error reading oplog %v: %v", source.name, err)
+				return 0, false
+			}
+			reader.current = db.NewDecodedBSONSource(db.NewBSONSource(in))
+		}
+		if !reader.current.Next(&raw) {
+			reader.err = reader.current.Err()
+			reader.current.Close()
+			reader.current = nil
+			continue
+		}
+		size := len(raw.Data)
+		reader.bytesRead += size
+		*entry = Oplog{}
+		if err := bson.Unmarshal(raw.Data, entry); err != nil {
+			reader.err = err
+			return 0, false
+		}
+		if reader.read > 0 && entry.Timestamp <= reader.last {
+			log.Logf(log.DebugHigh, "skipping oplog entry %v, already read from an earlier oplog",
+				formatTimestamp(entry.Timestamp))
+			continue
+		}
+		if !reader.restore.TimestampBeforeLimit(entry.Timestamp) {
+			log.Logf(
+				log.DebugLow,
+				"timestamp %v is not below limit of %v; ending oplog restoration",
+				entry.Timestamp,
+				reader.restore.oplogLimit,
+			)
+			reader.Close()
+			reader.next = len(reader.sources)
+			return 0, false
+		}
+		reader.last = entry.Timestamp
+		reader.read++
+		return size, true
+	}
+	return 0, false
+}
+
+// Err returns the error that stopped the reader, if any
+func (reader *oplogReader) Err() error {
+	return reader.err
+}
+
+// Close closes the oplog being read
+func (reader *oplogReader) Close() {
+	if reader.current != nil {
+		reader.current.Close()
+		reader.current = nil
+	}
+}
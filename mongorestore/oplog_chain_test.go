@@ -0,0 +1,135 @@
+package mongorestore
+
+import (
+	"encoding/json"
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/testutil"
+	"github.com/mongodb/mongo-tools/mongorestore/options"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// oplogEntry returns an insert at second ts of the oplog, with the given h
+func oplogEntry(ts, h int64) Oplog {
+	return Oplog{
+		Timestamp: bson.MongoTimestamp(ts << 32),
+		HistoryID: h,
+		Version:   2,
+		Operation: "i",
+		Namespace: "test.c",
+		Object:    bson.M{"_id": ts},
+	}
+}
+
+// writeOplog writes entries to dir/oplog.bson, and the range from
+// start to end to dir/oplog.metadata.json unless end is zero
+func writeOplog(dir string, start, end int64, entries ...Oplog) {
+	So(os.MkdirAll(dir, 0755), ShouldBeNil)
+	data := []byte{}
+	for _, entry := range entries {
+		raw, err := bson.Marshal(entry)
+		So(err, ShouldBeNil)
+		data = append(data, raw...)
+	}
+	So(ioutil.WriteFile(filepath.Join(dir, "oplog.bson"), data, 0644), ShouldBeNil)
+	if end == 0 {
+		return
+	}
+	startJSON, err := bsonutil.ConvertBSONValueToJSON(bson.MongoTimestamp(start << 32))
+	So(err, ShouldBeNil)
+	endJSON, err := bsonutil.ConvertBSONValueToJSON(bson.MongoTimestamp(end << 32))
+	So(err, ShouldBeNil)
+	jsonBytes, err := json.Marshal(map[string]interface{}{"start": startJSON, "end": endJSON})
+	So(err, ShouldBeNil)
+	So(ioutil.WriteFile(filepath.Join(dir, "oplog.metadata.json"), jsonBytes, 0644), ShouldBeNil)
+}
+
+func TestOplogChain(t *testing.T) {
+
+	testutil.VerifyTestType(t, testutil.UNIT_TEST_TYPE)
+
+	Convey("With a dump whose oplog runs from 1 to 3", t, func() {
+		dir, err := ioutil.TempDir("", "oplog_chain_test")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(dir)
+		})
+		dumpDir := filepath.Join(dir, "dump")
+		writeOplog(dumpDir, 0, 3, oplogEntry(1, 11), oplogEntry(2, 12), oplogEntry(3, 13))
+		restore := &MongoRestore{
+			InputOptions:    &options.InputOptions{OplogReplay: true},
+			TargetDirectory: dumpDir,
+			manager:         intents.NewCategorizingIntentManager(),
+		}
+		restore.manager.Put(&intents.Intent{C: "oplog", BSONPath: filepath.Join(dumpDir, "oplog.bson")})
+		replayed := func() []int64 {
+			reader := restore.newOplogReader(restore.oplogSources)
+			defer reader.Close()
+			seconds := []int64{}
+			entry := Oplog{}
+			for _, ok := reader.Next(&entry); ok; _, ok = reader.Next(&entry) {
+				seconds = append(seconds, int64(entry.Timestamp>>32))
+			}
+			So(reader.Err(), ShouldBeNil)
+			return seconds
+		}
+
+		Convey("an incremental dump taken from its end should be chained", func() {
+			writeOplog(filepath.Join(dir, "inc1"), 3, 5, oplogEntry(4, 14), oplogEntry(5, 15))
+			writeOplog(filepath.Join(dir, "inc2"), 5, 7, oplogEntry(6, 16))
+			restore.InputOptions.OplogFile = []string{filepath.Join(dir, "inc1"), filepath.Join(dir, "inc2")}
+			So(restore.buildOplogChain(), ShouldBeNil)
+			So(replayed(), ShouldResemble, []int64{1, 2, 3, 4, 5, 6})
+			So(restore.oplogSources[1].size, ShouldBeGreaterThan, 0)
+
+			Convey("and replayed up to the --oplogLimit", func() {
+				restore.oplogLimit = bson.MongoTimestamp(5 << 32)
+				So(restore.buildOplogChain(), ShouldBeNil)
+				So(replayed(), ShouldResemble, []int64{1, 2, 3, 4})
+			})
+
+			Convey("but not past its end", func() {
+				restore.oplogLimit = bson.MongoTimestamp(9 << 32)
+				So(restore.buildOplogChain(), ShouldNotBeNil)
+			})
+		})
+
+		Convey("an oplog file overlapping its end should be chained without duplicates", func() {
+			writeOplog(filepath.Join(dir, "capture"), 0, 0, oplogEntry(2, 12), oplogEntry(3, 13), oplogEntry(4, 14))
+			restore.InputOptions.OplogFile = []string{filepath.Join(dir, "capture", "oplog.bson")}
+			So(restore.buildOplogChain(), ShouldBeNil)
+			So(replayed(), ShouldResemble, []int64{1, 2, 3, 4})
+		})
+
+		Convey("an oplog file starting after its end should be refused", func() {
+			writeOplog(filepath.Join(dir, "capture"), 0, 0, oplogEntry(5, 15))
+			restore.InputOptions.OplogFile = []string{filepath.Join(dir, "capture", "oplog.bson")}
+			So(restore.buildOplogChain(), ShouldNotBeNil)
+			So(restore.oplogSources, ShouldBeNil)
+		})
+
+		Convey("an oplog file that diverges from it should be refused", func() {
+			writeOplog(filepath.Join(dir, "capture"), 0, 0, oplogEntry(3, 99), oplogEntry(4, 14))
+			restore.InputOptions.OplogFile = []string{filepath.Join(dir, "capture", "oplog.bson")}
+			So(restore.buildOplogChain(), ShouldNotBeNil)
+		})
+
+		Convey("an oplog file that overlaps it without its last entry should be refused", func() {
+			writeOplog(filepath.Join(dir, "capture"), 0, 0, oplogEntry(2, 12), oplogEntry(4, 14))
+			restore.InputOptions.OplogFile = []string{filepath.Join(dir, "capture", "oplog.bson")}
+			So(restore.buildOplogChain(), ShouldNotBeNil)
+		})
+
+		Convey("the size of a compressed oplog should not be taken from the file", func() {
+			info, err := os.Stat(filepath.Join(dumpDir, "oplog.bson"))
+			So(err, ShouldBeNil)
+			So(restore.oplogFileSize(filepath.Join(dumpDir, "oplog.bson"), info), ShouldEqual, info.Size())
+			So(restore.oplogFileSize(filepath.Join(dumpDir, "oplog.bson.gz"), info), ShouldEqual, 0)
+		})
+	})
+}
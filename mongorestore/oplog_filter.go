@@ -0,0 +1,86 @@
+package mongorestore
+
+import (
+	"fmt"
+	"github.com/mongodb/mongo-tools/common/log"
+	"gopkg.in/mgo.v2/bson"
+	"strings"
+)
+
+// oplogOperations are the values of the op field of oplog entries:
+// insert, update, delete, command and no-op
+var oplogOperations = []string{"i", "u", "d", "c", "n"}
+
+// OplogFilter selects the oplog entries to replay, given --oplogInclude and
+// --oplogExclude namespace patterns, the --oplogOps to replay, and the
+// --oplogStart of the time window. Namespaces are matched as they were in
+// the dump, before any --nsFrom renaming.
+type OplogFilter struct {
+	// namespaces renames nothing
+	namespaces *NamespaceMapper
+	// ops is nil to replay entries of every operation
+	ops   map[string]bool
+	start bson.MongoTimestamp
+}
+
+// NewOplogFilter compiles the given options, where ops is a comma-separated
+// list of operations, and start, when not empty, a timestamp or time as
+// accepted by ParseOplogLimit
+func NewOplogFilter(include, exclude []string, ops, start string) (*OplogFilter, error) {
+	namespaces, err := newNamespaceFilter(include, exclude, "--oplogInclude", "--oplogExclude")
+	if err != nil {
+		return nil, err
+	}
+	filter := &OplogFilter{namespaces: namespaces}
+	if ops != "" {
+		filter.ops = map[string]bool{}
+		for _, op := range strings.Split(ops, ",") {
+			op = strings.TrimSpace(op)
+			valid := false
+			for _, known := range oplogOperations {
+				if op == known {
+					valid = true
+					break
+				}
+			}
+			if !valid {
+				return nil, fmt.Errorf("invalid --oplogOps '%v': unknown operation '%v', must be one of %v",
+					ops, op, strings.Join(oplogOperations, ","))
+			}
+			filter.ops[op] = true
+		}
+	}
+	if start != "" {
+		if filter.start, err = ParseOplogLimit(start); err != nil {
+			return nil, fmt.Errorf("error parsing timestamp argument to --oplogStart: %v", err)
+		}
+	}
+	return filter, nil
+}
+
+// Included returns whether the entry is to be replayed
+func (filter *OplogFilter) Included(entry *Oplog) bool {
+	if entry.Timestamp < filter.start {
+		return false
+	}
+	if filter.ops != nil && !filter.ops[entry.Operation] {
+		return false
+	}
+	return filter.namespaces.Included(oplogTargetOf(entry).ns)
+}
+
+// selectOplogEntry applies the oplog filter and the namespace options to an
+// entry, renaming it in place, and returns false if it is not to be replayed
+func (restore *MongoRestore) selectOplogEntry(entry *Oplog) bool {
+	if restore.oplogFilter != nil && !restore.oplogFilter.Included(entry) {
+		log.Logf(log.DebugHigh, "skipping oplog entry %v for %v, excluded by the oplog filter",
+			formatTimestamp(entry.Timestamp), entry.Namespace)
+		return false
+	}
+	if restore.nsMapper != nil && !restore.mapOplogEntry(entry) {
+		log.Logf(log.DebugHigh, "skipping oplog entry for %v, excluded by the namespace options",
+			entry.Namespace)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,90 @@
+package mongorestore
+
+import (
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+	"testing"
+)
+
+func TestOplogFilter(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UNIT_TEST_TYPE)
+
+	insert := &Oplog{Timestamp: bson.MongoTimestamp(100 << 32), Operation: "i",
+		Namespace: "app.users", Object: bson.M{"_id": 1}}
+	update := &Oplog{Timestamp: bson.MongoTimestamp(200 << 32), Operation: "u",
+		Namespace: "app.orders", Query: bson.M{"_id": 1}, Object: bson.M{"$set": bson.M{"a": 1}}}
+	drop := &Oplog{Timestamp: bson.MongoTimestamp(300 << 32), Operation: "c",
+		Namespace: "app.$cmd", Object: bson.M{"drop": "users"}}
+	index := &Oplog{Timestamp: bson.MongoTimestamp(300<<32 | 1), Operation: "i",
+		Namespace: "app.system.indexes", Object: bson.M{"ns": "app.users", "name": "a_1"}}
+
+	Convey("With an oplog filter", t, func() {
+
+		Convey("an empty filter replays everything", func() {
+			filter, err := NewOplogFilter(nil, nil, "", "")
+			So(err, ShouldBeNil)
+			for _, entry := range []*Oplog{insert, update, drop, index} {
+				So(filter.Included(entry), ShouldBeTrue)
+			}
+		})
+
+		Convey("namespace patterns match the collection commands act on", func() {
+			filter, err := NewOplogFilter([]string{"app.users"}, nil, "", "")
+			So(err, ShouldBeNil)
+			So(filter.Included(insert), ShouldBeTrue)
+			So(filter.Included(update), ShouldBeFalse)
+			So(filter.Included(drop), ShouldBeTrue)
+			So(filter.Included(index), ShouldBeTrue)
+
+			filter, err = NewOplogFilter([]string{"app.*"}, []string{"app.orders"}, "", "")
+			So(err, ShouldBeNil)
+			So(filter.Included(insert), ShouldBeTrue)
+			So(filter.Included(update), ShouldBeFalse)
+
+			_, err = NewOplogFilter(nil, []string{`app.c\`}, "", "")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "invalid --oplogExclude")
+		})
+
+		Convey("only the given operations are replayed", func() {
+			filter, err := NewOplogFilter(nil, nil, "i, u", "")
+			So(err, ShouldBeNil)
+			So(filter.Included(insert), ShouldBeTrue)
+			So(filter.Included(update), ShouldBeTrue)
+			So(filter.Included(drop), ShouldBeFalse)
+
+			_, err = NewOplogFilter(nil, nil, "i,x", "")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("entries before the start are skipped", func() {
+			filter, err := NewOplogFilter(nil, nil, "", "200")
+			So(err, ShouldBeNil)
+			So(filter.Included(insert), ShouldBeFalse)
+			So(filter.Included(update), ShouldBeTrue)
+			So(filter.Included(drop), ShouldBeTrue)
+
+			filter, err = NewOplogFilter(nil, nil, "", "1970-01-01T00:05:00Z")
+			So(err, ShouldBeNil)
+			So(filter.Included(update), ShouldBeFalse)
+			So(filter.Included(drop), ShouldBeTrue)
+
+			_, err = NewOplogFilter(nil, nil, "", "cats")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("the filter applies before the namespace options rename entries", func() {
+			filter, err := NewOplogFilter([]string{"app.users"}, nil, "", "")
+			So(err, ShouldBeNil)
+			mapper, err := NewNamespaceMapper(nil, nil, []string{"app.*"}, []string{"copy.*"})
+			So(err, ShouldBeNil)
+			restore := &MongoRestore{oplogFilter: filter, nsMapper: mapper}
+			entry := *insert
+			So(restore.selectOplogEntry(&entry), ShouldBeTrue)
+			So(entry.Namespace, ShouldEqual, "copy.users")
+			entry = *update
+			So(restore.selectOplogEntry(&entry), ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,228 @@
+package mongorestore
+
+import (
+	"fmt"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/progress"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"hash/fnv"
+	"strings"
+	"time"
+)
+
+// oplogWork is an entry for an oplog worker to apply, or, when
+// barrier is set, a request to apply everything it holds and reply
+type oplogWork struct {
+	entry   Oplog
+	size    int
+	barrier chan error
+}
+
+// oplogWorkerKey returns the key that decides which worker applies an entry,
+// so that all the entries of a document are applied in order by the same
+// worker, or false if the entry must be applied alone, once every entry
+// before it is applied: commands, writes to system collections, which are
+// how indexes are built on older servers, and writes without an _id.
+func oplogWorkerKey(entry *Oplog) ([]byte, bool) {
+	if entry.Operation == "c" {
+		return nil, false
+	}
+	_, collection := splitNS(entry.Namespace)
+	if strings.HasPrefix(collection, "system.") {
+		return nil, false
+	}
+	if entry.Operation == "n" {
+		return []byte(entry.Namespace), true
+	}
+	var id interface{}
+	var ok bool
+	if entry.Operation == "u" {
+		id, ok = entry.Query["_id"]
+	} else {
+		id, ok = entry.Object["_id"]
+	}
+	if !ok {
+		return nil, false
+	}
+	// marshalled, so that ids of different types do not collide
+	idBytes, err := bson.Marshal(bson.D{{"_id", id}})
+	if err != nil {
+		return nil, false
+	}
+	return append([]byte(entry.Namespace+"\x00"), idBytes...), true
+}
+
+// oplogWorkerFor returns which of n workers applies entries with the given key
+func oplogWorkerFor(key []byte, n int) int {
+	hash := fnv.New32a()
+	hash.Write(key)
+	return int(hash.Sum32() % uint32(n))
+}
+
+// runOplogWorker applies the entries it is given on its own session, in
+// applyOps batches, until in is closed. After an error it only drains in,
+// reporting the error on failed if nothing is there yet, at every
+// barrier and once done.
+func (restore *MongoRestore) runOplogWorker(in <-chan oplogWork, failed, done chan<- error) {
+	session, err := restore.SessionProvider.GetSession()
+	fail := func() {
+		select {
+		case failed <- err:
+		default:
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("error establishing connection: %v", err)
+		fail()
+	} else {
+		session.SetSocketTimeout(0)
+		defer session.Close()
+	}
+
+	entryArray := make([]interface{}, 0, 1024)
+	bufferedBytes := 0
+	flush := func() {
+		if err != nil || len(entryArray) == 0 {
+			return
+		}
+		if err = restore.ApplyOps(session, entryArray); err != nil {
+			err = fmt.Errorf("error applying oplog: %v", err)
+			fail()
+		}
+		entryArray = make([]interface{}, 0, 1024)
+		bufferedBytes = 0
+	}
+	for work := range in {
+		if work.barrier != nil {
+			flush()
+			work.barrier <- err
+			continue
+		}
+		if bufferedBytes+work.size > OplogMaxCommandSize {
+			flush()
+		}
+		bufferedBytes += work.size
+		entryArray = append(entryArray, work.entry)
+	}
+	flush()
+	done <- err
+}
+
+// replayOplogParallel applies the entries of the reader with the
+// --numOplogWorkers, partitioned by namespace and _id
+func (restore *MongoRestore) replayOplogParallel(reader *oplogReader, size int64) error {
+	numWorkers := restore.OutputOptions.OplogWorkers
+	log.Logf(log.Always, "replaying oplog with %v workers", numWorkers)
+
+	progressManager := progress.NewProgressBarManager(ProgressBarWaitTime)
+	bar := &progress.ProgressBar{
+		Name:       "oplog",
+		Max:        int(size),
+		CounterPtr: &reader.bytesRead,
+		Writer:     log.Writer(0),
+		BarLength:  ProgressBarLength,
+		ShowRate:   true,
+	}
+	progressManager.Attach(bar)
+	progressManager.Start()
+	defer progressManager.Stop()
+	defer progressManager.Detach(bar)
+
+	session, err := restore.SessionProvider.GetSession()
+	if err != nil {
+		return fmt.Errorf("error establishing connection: %v", err)
+	}
+	session.SetSocketTimeout(0)
+	defer session.Close()
+
+	workers := make([]chan oplogWork, numWorkers)
+	done := make(chan error, numWorkers)
+	failed := make(chan error, 1)
+	for i := range workers {
+		workers[i] = make(chan oplogWork, 1024)
+		go restore.runOplogWorker(workers[i], failed, done)
+	}
+	// stop sends every worker the end of its input
+	// and returns the first error of any of them
+	stop := func() error {
+		var firstErr error
+		for _, worker := range workers {
+			close(worker)
+		}
+		for range workers {
+			if err := <-done; err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		workers = nil
+		return firstErr
+	}
+	defer func() {
+		if workers != nil {
+			stop()
+		}
+	}()
+
+	start := time.Now()
+	applied := 0
+	entry := Oplog{}
+	for {
+		entrySize, ok := reader.Next(&entry)
+		if !ok {
+			break
+		}
+		if !restore.selectOplogEntry(&entry) {
+			continue
+		}
+		// stop at the first error of a worker, rather than
+		// when the next barrier or the end of the oplog is reached
+		select {
+		case err = <-failed:
+			return err
+		default:
+		}
+		applied++
+		if key, ok := oplogWorkerKey(&entry); ok {
+			workers[oplogWorkerFor(key, numWorkers)] <- oplogWork{entry: entry, size: entrySize}
+			continue
+		}
+		if err = restore.applyOplogBarrier(session, workers, entry); err != nil {
+			return err
+		}
+	}
+	if err = reader.Err(); err != nil {
+		return fmt.Errorf("error reading oplog: %v", err)
+	}
+	if err = stop(); err != nil {
+		return err
+	}
+	elapsed := time.Since(start)
+	log.Logf(log.Always, "applied %v oplog entries in %v (%.0f entries/s)",
+		applied, elapsed, float64(applied)/elapsed.Seconds())
+	return nil
+}
+
+// applyOplogBarrier waits for every worker to apply the entries it holds,
+// then applies the entry alone
+func (restore *MongoRestore) applyOplogBarrier(session *mgo.Session, workers []chan oplogWork, entry Oplog) error {
+	log.Logf(log.DebugHigh, "applying oplog entry %v on %v alone", formatTimestamp(entry.Timestamp), entry.Namespace)
+	replies := make([]chan error, len(workers))
+	for i, worker := range workers {
+		replies[i] = make(chan error, 1)
+		worker <- oplogWork{barrier: replies[i]}
+	}
+	var firstErr error
+	for _, reply := range replies {
+		if err := <-reply; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+	if err := restore.ApplyOps(session, []interface{}{entry}); err != nil {
+		return fmt.Errorf("error applying oplog: %v", err)
+	}
+	return nil
+}
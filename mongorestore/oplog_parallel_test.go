@@ -0,0 +1,63 @@
+package mongorestore
+
+import (
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+	"testing"
+)
+
+func TestOplogWorkerKey(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UNIT_TEST_TYPE)
+
+	Convey("With oplog entries", t, func() {
+		insert := Oplog{Operation: "i", Namespace: "test.c", Object: bson.M{"_id": 1, "a": 1}}
+		update := Oplog{Operation: "u", Namespace: "test.c",
+			Query: bson.M{"_id": 1}, Object: bson.M{"$set": bson.M{"a": 2}}}
+		remove := Oplog{Operation: "d", Namespace: "test.c", Object: bson.M{"_id": 1}}
+
+		Convey("writes to the same document share a key", func() {
+			insertKey, ok := oplogWorkerKey(&insert)
+			So(ok, ShouldBeTrue)
+			updateKey, ok := oplogWorkerKey(&update)
+			So(ok, ShouldBeTrue)
+			removeKey, ok := oplogWorkerKey(&remove)
+			So(ok, ShouldBeTrue)
+			So(string(updateKey), ShouldEqual, string(insertKey))
+			So(string(removeKey), ShouldEqual, string(insertKey))
+			So(oplogWorkerFor(updateKey, 8), ShouldEqual, oplogWorkerFor(insertKey, 8))
+		})
+
+		Convey("ids of different types or namespaces have different keys", func() {
+			key, _ := oplogWorkerKey(&insert)
+			other := Oplog{Operation: "i", Namespace: "test.c", Object: bson.M{"_id": "1"}}
+			otherKey, ok := oplogWorkerKey(&other)
+			So(ok, ShouldBeTrue)
+			So(string(otherKey), ShouldNotEqual, string(key))
+			other = Oplog{Operation: "i", Namespace: "test.d", Object: bson.M{"_id": 1}}
+			otherKey, ok = oplogWorkerKey(&other)
+			So(ok, ShouldBeTrue)
+			So(string(otherKey), ShouldNotEqual, string(key))
+		})
+
+		Convey("commands, system collections and writes without an _id are applied alone", func() {
+			for _, entry := range []Oplog{
+				{Operation: "c", Namespace: "test.$cmd", Object: bson.M{"drop": "c"}},
+				{Operation: "i", Namespace: "test.system.indexes", Object: bson.M{"ns": "test.c"}},
+				{Operation: "u", Namespace: "test.c", Query: bson.M{"a": 1}},
+			} {
+				_, ok := oplogWorkerKey(&entry)
+				So(ok, ShouldBeFalse)
+			}
+		})
+
+		Convey("every key maps to one of the workers", func() {
+			for i := 0; i < 100; i++ {
+				key, _ := bson.Marshal(bson.M{"_id": i})
+				worker := oplogWorkerFor(key, 3)
+				So(worker, ShouldBeGreaterThanOrEqualTo, 0)
+				So(worker, ShouldBeLessThan, 3)
+			}
+		})
+	})
+}
@@ -62,6 +62,41 @@ func TestTimestampStringParsing(t *testing.T) {
 	})
 }
 
+func TestOplogLimitParsing(t *testing.T) {
+
+	testutil.VerifyTestType(t, testutil.UNIT_TEST_TYPE)
+
+	Convey("Testing some possible oplog limits:", t, func() {
+		Convey("2015-03-01T12:00:00Z [should pass]", func() {
+			ts, err := ParseOplogLimit("2015-03-01T12:00:00Z")
+			So(err, ShouldBeNil)
+			So(ts, ShouldEqual, int64(1425211200)<<32)
+		})
+
+		Convey("2015-03-01T13:00:00+01:00 [should pass]", func() {
+			ts, err := ParseOplogLimit("2015-03-01T13:00:00+01:00")
+			So(err, ShouldBeNil)
+			So(ts, ShouldEqual, int64(1425211200)<<32)
+		})
+
+		Convey("123:456 [should pass]", func() {
+			ts, err := ParseOplogLimit("123:456")
+			So(err, ShouldBeNil)
+			So(ts, ShouldEqual, (int64(123)<<32 | int64(456)))
+		})
+
+		Convey("1969-12-31T23:59:59Z [should fail]", func() {
+			_, err := ParseOplogLimit("1969-12-31T23:59:59Z")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("2015-03-01 [should fail]", func() {
+			_, err := ParseOplogLimit("2015-03-01")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
 func TestValidOplogLimitChecking(t *testing.T) {
 
 	testutil.VerifyTestType(t, testutil.UNIT_TEST_TYPE)
@@ -1,29 +1,67 @@
 package options
 
 type InputOptions struct {
-	Objcheck               bool   `long:"objcheck" description:"Validate object before inserting (default)"`
-	NoObjcheck             bool   `long:"noobjcheck" description:"Don't validate object before inserting"`
-	OplogReplay            bool   `long:"oplogReplay" description:"Replay oplog for point-in-time restore"`
-	OplogLimit             string `long:"oplogLimit" description:"Include oplog entries before the provided Timestamp (seconds[:ordinal])"`
-	RestoreDBUsersAndRoles bool   `long:"restoreDbUsersAndRoles" description:"Restore user and role definitions for the given database"`
-	Directory              string `long:"dir" description:"alternative flag for entering the dump directory"`
+	Objcheck               bool     `long:"objcheck" description:"Validate object before inserting (default)"`
+	NoObjcheck             bool     `long:"noobjcheck" description:"Don't validate object before inserting"`
+	OplogReplay            bool     `long:"oplogReplay" description:"Replay oplog for point-in-time restore"`
+	OplogLimit             string   `long:"oplogLimit" description:"Include oplog entries before the provided Timestamp (seconds[:ordinal]) or time (RFC 3339, e.g. 2015-03-01T12:00:00Z)"`
+	OplogStart             string   `long:"oplogStart" description:"Include oplog entries from the provided Timestamp (seconds[:ordinal]) or time (RFC 3339) onwards"`
+	OplogInclude           []string `long:"oplogInclude" description:"replay only the oplog entries of namespaces matching the given pattern, in which '*' matches anything (e.g. 'app.users'); commands match the collection they act on; may be repeated"`
+	OplogExclude           []string `long:"oplogExclude" description:"do not replay the oplog entries of namespaces matching the given pattern; may be repeated"`
+	OplogOps               string   `long:"oplogOps" description:"comma-separated operations of the oplog entries to replay, among i (insert), u (update), d (delete), c (command) and n (no-op); all by default"`
+	OplogFile              []string `long:"oplogFile" description:"after the dump's oplog, replay the oplog in the given file or in the folder of a dump taken with --incrementalFrom; may be repeated, in the order the oplogs were captured"`
+	RestoreDBUsersAndRoles bool     `long:"restoreDbUsersAndRoles" description:"Restore user and role definitions for the given database"`
+	Directory              string   `long:"dir" description:"alternative flag for entering the dump directory"`
+	Gzip                   bool     `long:"gzip" description:"decompress gzipped input read from stdin; compressed archives are detected automatically"`
+	Archive                string   `long:"archive" optional:"true" optional-value:"-" description:"restore dump from the archive at the given path (--archive=<file>), or from stdin if no path is given"`
+	EncryptionKeyFile      string   `long:"encryptionKeyFile" description:"decrypt a dump made with mongodump --encryptionKeyFile, using the key or passphrase held in the given file"`
+	Repository             string   `long:"repository" description:"restore a snapshot from the deduplicating repository at the given path"`
+	Snapshot               string   `long:"snapshot" description:"id of the snapshot to restore from --repository, or 'latest' for the most recent one"`
 }
 
 func (self *InputOptions) Name() string {
 	return "input"
 }
 
+type NSOptions struct {
+	NSInclude []string `long:"nsInclude" description:"restore only the namespaces matching the given pattern, in which '*' matches anything (e.g. 'app.*'); may be repeated"`
+	NSExclude []string `long:"nsExclude" description:"do not restore the namespaces matching the given pattern; may be repeated"`
+	NSFrom    []string `long:"nsFrom" description:"rename the namespaces matching the given pattern, in which '*' and '$name$' capture anything (e.g. 'prod_$app$.*'), to the --nsTo at the same position; may be repeated"`
+	NSTo      []string `long:"nsTo" description:"the namespace to restore the matching --nsFrom to, reusing its captures (e.g. 'staging_$app$.*')"`
+}
+
+func (self *NSOptions) Name() string {
+	return "namespace"
+}
+
 type OutputOptions struct {
 	Drop             bool   `long:"drop" description:"Drop each collection before import"`
 	WriteConcern     string `long:"w" description:"Minimum number of replicas per write (default=majority)"`
 	NoIndexRestore   bool   `long:"noIndexRestore" description:"Don't restore indexes"`
 	NoOptionsRestore bool   `long:"noOptionsRestore" description:"Don't restore options"`
 	KeepIndexVersion bool   `long:"keepIndexVersion" description:"Don't update index version"`
+	IndexesOnly      bool   `long:"indexesOnly" description:"only build the indexes in the dump's metadata, on the collections that already exist, without restoring any data"`
+	Mode             string `long:"mode" description:"how documents are written: 'insert' them, 'upsert' to replace or insert them, 'replace' to only replace existing ones, or 'merge' to set their fields into existing ones or insert them" default:"insert"`
+	UpsertFields     string `long:"upsertFields" description:"comma-separated fields that identify a document for --mode replace or merge, _id by default; --mode upsert only matches by _id"`
 
-	JobThreads       int  `long:"numParallelCollections" short:"j" description:"Number of collections to restore in parallel" default:"4"`
+	JobThreads       int  `long:"numParallelCollections" short:"j" description:"Number of collections to restore in parallel; raised for an archive to the number of collections it was written with at once, which must all be read together, and 1 for a compressed tar" default:"4"`
 	BulkWriters      int  `long:"numInsertionWorkersPerCollection" description:"Number of insert connections per collection" default:"1"`
 	BulkBufferSize   int  `long:"batchSize" description:"Maximum number of documents to coalesce into a single bulk insertion" default:"10000"`
 	PreserveDocOrder bool `long:"preserveOrder" description:"Preserve order of documents during restoration"`
+	IndexWorkers     int  `long:"numIndexWorkers" description:"Number of index builds to run in parallel, once all collections are restored" default:"4"`
+	OplogWorkers     int  `long:"numOplogWorkers" description:"Number of connections replaying the oplog in parallel, each applying the entries of a share of the documents in order; commands are applied alone" default:"1"`
+
+	BatchIndexBuilds      bool `long:"batchIndexBuilds" description:"build all the indexes of a collection with a single createIndexes command, which reads the collection once, instead of one command per index"`
+	BackgroundIndexBuilds bool `long:"backgroundIndexBuilds" description:"build indexes in the background, leaving the databases usable while they build"`
+
+	MaxWriteErrors int    `long:"maxWriteErrors" description:"number of documents that may fail to be written, with their errors logged, before the restore stops; -1 for no limit" default:"0"`
+	DeadLetterDir  string `long:"deadLetterDir" description:"write the documents that fail to be written to the given directory, laid out like a dump, with their errors in a .errors.json file per collection"`
+
+	DryRun       bool   `long:"dryRun" description:"report what would be restored, without writing anything"`
+	DryRunFormat string `long:"dryRunFormat" description:"format of the --dryRun report, either 'text' or 'json'" default:"text"`
+
+	Verify    bool `long:"verify" description:"compare every namespace of the dump with the target, by document count and checksum, indexes and options, without writing anything"`
+	VerifyIDs int  `long:"verifyIds" description:"with --verify, list up to the given number of _ids of the documents that are missing, extra or changed in each namespace that differs"`
 	// TODO: add hidden option for NumOSThreads to set GOMAXPROCS on CLI
 }
 
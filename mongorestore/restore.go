@@ -6,11 +6,13 @@ import (
 	"github.com/mongodb/mongo-tools/common/intents"
 	"github.com/mongodb/mongo-tools/common/log"
 	"github.com/mongodb/mongo-tools/common/progress"
+	"github.com/mongodb/mongo-tools/common/util"
 	"gopkg.in/mgo.v2/bson"
 	"io"
 	"io/ioutil"
 	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -59,7 +61,10 @@ func (restore *MongoRestore) RestoreIntents() error {
 				}
 			}
 		}
-		return nil
+		if err := restore.RestoreIndexes(); err != nil {
+			return err
+		}
+		return restore.RestoreViews()
 	}
 
 	// single-threaded
@@ -70,7 +75,10 @@ func (restore *MongoRestore) RestoreIntents() error {
 		}
 		restore.manager.Finish(intent)
 	}
-	return nil
+	if err := restore.RestoreIndexes(); err != nil {
+		return err
+	}
+	return restore.RestoreViews()
 }
 
 // RestoreIntent does the bulk of the logic to restore a collection
@@ -78,11 +86,47 @@ func (restore *MongoRestore) RestoreIntents() error {
 // TODO: overly didactic comments on each step
 func (restore *MongoRestore) RestoreIntent(intent *intents.Intent) error {
 
+	var meta *Metadata
+	if intent.MetadataPath != "" {
+		log.Logf(log.Always, "reading metadata file from %v", intent.MetadataPath)
+		jsonBytes, err := restore.readIntentMetadata(intent)
+		if err != nil {
+			return fmt.Errorf("error reading metadata file: %v", err) //TODO better errors here
+		}
+		meta, err = restore.MetadataFromJSON(jsonBytes)
+		if err != nil {
+			return fmt.Errorf("error parsing metadata file (%v): %v", string(jsonBytes), err)
+		}
+		// views may be defined on collections that are not restored
+		// yet, so they are created once all collections are done
+		if meta.IsView() {
+			if restore.OutputOptions.IndexesOnly {
+				return nil
+			}
+			return restore.deferView(intent, meta.Options)
+		}
+	}
+
 	collectionExists, err := restore.DBHasCollection(intent)
 	if err != nil {
 		return fmt.Errorf("error reading database: %v", err)
 	}
 
+	if restore.OutputOptions.IndexesOnly {
+		if !collectionExists {
+			log.Logf(log.Always, "collection %v does not exist, skipping its indexes", intent.Key())
+			return nil
+		}
+		indexes, err := restore.intentIndexes(intent, meta)
+		if err != nil {
+			return err
+		}
+		if len(indexes) > 0 {
+			restore.deferIndexes(intent, indexes)
+		}
+		return nil
+	}
+
 	if restore.safety == nil && !restore.OutputOptions.Drop && collectionExists {
 		log.Logf(log.Always, "restoring to existing collection %v without dropping", intent.Key())
 		log.Log(log.Always, "IMPORTANT: restored data will be inserted without raising errors; check your server log")
@@ -112,35 +156,18 @@ func (restore *MongoRestore) RestoreIntent(intent *intents.Intent) error {
 		}
 	}
 
-	var options bson.D
-	var indexes []IndexDocument
-
-	// get indexes from system.indexes dump if we have it but don't have metadata files
-	if intent.MetadataPath == "" && restore.manager.SystemIndexes(intent.DB) != nil {
-		systemIndexesFile := restore.manager.SystemIndexes(intent.DB).BSONPath
-		log.Logf(log.Always, "no metadata file; reading indexes from %v", systemIndexesFile)
-		indexes, err = restore.IndexesFromBSON(intent, systemIndexesFile)
-		if err != nil {
-			return fmt.Errorf("error reading indexes: %v", err)
-		}
+	indexes, err := restore.intentIndexes(intent, meta)
+	if err != nil {
+		return err
 	}
 
 	// first create collection with options
-	if intent.MetadataPath != "" {
-		log.Logf(log.Always, "reading metadata file from %v", intent.MetadataPath)
-		jsonBytes, err := ioutil.ReadFile(intent.MetadataPath)
-		if err != nil {
-			return fmt.Errorf("error reading metadata file: %v", err) //TODO better errors here
-		}
-		options, indexes, err = restore.MetadataFromJSON(jsonBytes)
-		if err != nil {
-			return fmt.Errorf("error parsing metadata file (%v): %v", string(jsonBytes), err)
-		}
+	if meta != nil {
 		if !restore.OutputOptions.NoOptionsRestore {
-			if options != nil {
+			if meta.Options != nil {
 				if !collectionExists {
 					log.Logf(log.Info, "creating collection %v using options from metadata", intent.Key())
-					err = restore.CreateCollection(intent, options)
+					err = restore.CreateCollection(intent, meta.Options)
 					if err != nil {
 						return fmt.Errorf("error creating collection %v: %v", intent.Key(), err)
 					}
@@ -157,22 +184,57 @@ func (restore *MongoRestore) RestoreIntent(intent *intents.Intent) error {
 
 	// then do bson
 	if intent.BSONPath != "" {
-		log.Logf(log.Always, "restoring %v from file %v", intent.Key(), intent.BSONPath)
+		if restore.archive != nil {
+			log.Logf(log.Always, "restoring %v from archive", intent.Key())
+		} else if restore.snapshot != nil {
+			log.Logf(log.Always, "restoring %v from snapshot %v", intent.Key(), restore.snapshot.ID)
+		} else {
+			log.Logf(log.Always, "restoring %v from file %v", intent.Key(), intent.BSONPath)
+		}
 		var rawBSONSource io.ReadCloser
 		var size int64
 
 		if restore.useStdin {
 			rawBSONSource = os.Stdin
 			log.Log(log.Always, "restoring from stdin")
+			if restore.encryption != nil {
+				rawBSONSource, err = restore.encryption.WrapReadCloser(rawBSONSource)
+				if err != nil {
+					return fmt.Errorf("error decrypting stdin: %v", err)
+				}
+			}
+			if restore.InputOptions.Gzip {
+				codec, err := util.GetCompressionCodec("gzip")
+				if err != nil {
+					return err
+				}
+				rawBSONSource, err = codec.WrapReadCloser(rawBSONSource)
+				if err != nil {
+					return fmt.Errorf("error reading from stdin: %v", err)
+				}
+			}
 		} else {
-			fileInfo, err := os.Lstat(intent.BSONPath)
-			if err != nil {
-				return fmt.Errorf("error reading bson file: %v", err)
+			if len(intent.BSONParts) > 0 {
+				log.Logf(log.Info, "\t%v parts are %v bytes in total", len(intent.BSONParts), intent.Size)
+				if codec, _ := util.CompressionCodecForFile(intent.BSONParts[0]); codec == nil && restore.encryption == nil {
+					size = intent.Size
+				}
+			} else if restore.snapshot != nil {
+				size = intent.Size
+			} else if restore.archive == nil {
+				fileInfo, err := restore.dumpFS().Stat(intent.BSONPath)
+				if err != nil {
+					return fmt.Errorf("error reading bson file: %v", err)
+				}
+				log.Logf(log.Info, "\tfile %v is %v bytes", intent.BSONPath, fileInfo.Size())
+				// the size of a compressed or encrypted file says nothing about
+				// how many bytes we will read out of it, so only show progress otherwise
+				if codec, _ := util.CompressionCodecForFile(intent.BSONPath); codec == nil && restore.encryption == nil {
+					size = fileInfo.Size()
+				}
 			}
-			size = fileInfo.Size()
-			log.Logf(log.Info, "\tfile %v is %v bytes", intent.BSONPath, size)
 
-			rawBSONSource, err = os.Open(intent.BSONPath)
+			rawBSONSource, err = restore.openIntentBSON(intent)
 			if err != nil {
 				return fmt.Errorf("error reading bson file: %v", err)
 			}
@@ -187,14 +249,9 @@ func (restore *MongoRestore) RestoreIntent(intent *intents.Intent) error {
 		}
 	}
 
-	// finally, add indexes
+	// finally, queue the indexes to be built once all collections are restored
 	if len(indexes) > 0 && !restore.OutputOptions.NoIndexRestore {
-		log.Logf(log.Always, "restoring indexes for collection %v from metadata", intent.Key())
-		//TODO better logging
-		err = restore.CreateIndexes(intent, indexes)
-		if err != nil {
-			return fmt.Errorf("error creating indexes: %v", err)
-		}
+		restore.deferIndexes(intent, indexes)
 	} else {
 		log.Log(log.Always, "no indexes to restore")
 	}
@@ -203,6 +260,83 @@ func (restore *MongoRestore) RestoreIntent(intent *intents.Intent) error {
 	return nil
 }
 
+// intentIndexes returns the indexes of a collection from its metadata, or, in
+// dumps without metadata files, from the system.indexes of its database
+func (restore *MongoRestore) intentIndexes(intent *intents.Intent, meta *Metadata) ([]IndexDocument, error) {
+	if meta != nil {
+		return meta.Indexes, nil
+	}
+	sourceDB, _ := restore.sourceOf(intent)
+	if restore.manager.SystemIndexes(sourceDB) == nil {
+		return nil, nil
+	}
+	systemIndexesFile := restore.manager.SystemIndexes(sourceDB).BSONPath
+	log.Logf(log.Always, "no metadata file; reading indexes from %v", systemIndexesFile)
+	indexes, err := restore.IndexesFromBSON(intent, restore.manager.SystemIndexes(sourceDB))
+	if err != nil {
+		return nil, fmt.Errorf("error reading indexes: %v", err)
+	}
+	return indexes, nil
+}
+
+// partsReader reads the part files of a collection one after the other,
+// as if they were a single bson file.
+type partsReader struct {
+	paths   []string
+	key     *util.EncryptionKey
+	fs      dumpFS
+	current io.ReadCloser
+}
+
+func (pr *partsReader) Read(p []byte) (int, error) {
+	for {
+		if pr.current == nil {
+			if len(pr.paths) == 0 {
+				return 0, io.EOF
+			}
+			file, err := openDumpFile(pr.fs, pr.paths[0], pr.key)
+			if err != nil {
+				return 0, err
+			}
+			pr.current = file
+			pr.paths = pr.paths[1:]
+		}
+		n, err := pr.current.Read(p)
+		if err == io.EOF {
+			if closeErr := pr.current.Close(); closeErr != nil {
+				return n, closeErr
+			}
+			pr.current = nil
+			if n == 0 {
+				continue
+			}
+			return n, nil
+		}
+		return n, err
+	}
+}
+
+func (pr *partsReader) Close() error {
+	if pr.current == nil {
+		return nil
+	}
+	err := pr.current.Close()
+	pr.current = nil
+	pr.paths = nil
+	return err
+}
+
+// readMetadataFile returns the full contents of a metadata
+// file, decrypting and decompressing it if necessary.
+func readMetadataFile(fs dumpFS, path string, key *util.EncryptionKey) ([]byte, error) {
+	file, err := openDumpFile(fs, path, key)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ioutil.ReadAll(file)
+}
+
 // RestoreCollectionToDB pipes the given BSON data into the database.
 func (restore *MongoRestore) RestoreCollectionToDB(dbName, colName string,
 	bsonSource *db.DecodedBSONSource, fileSize int64) error {
@@ -270,26 +404,59 @@ func (restore *MongoRestore) RestoreCollectionToDB(dbName, colName string,
 		close(docChan)
 	}()
 
+	// counts of the writes of a --mode other than insert
+	var updateResult db.BulkUpdateResult
+	var updateResultLock sync.Mutex
+	updating := restore.OutputOptions.Mode != ModeInsert
+	ns := fmt.Sprintf("%v.%v", dbName, colName)
+
 	for i := 0; i < MaxInsertThreads; i++ {
 		go func() {
-			bulk := db.NewBufferedBulkInserter(collection, restore.OutputOptions.BulkBufferSize, false)
+			var bulk *db.BufferedBulkInserter
+			var updater *db.BufferedBulkUpdater
+			if updating {
+				updater = db.NewBufferedBulkUpdater(collection, restore.OutputOptions.BulkBufferSize,
+					restore.writeCommands, restore.writeConcern())
+				if restore.reportDocumentErrors {
+					updater.ReportDocumentErrors()
+				}
+			} else {
+				bulk = db.NewBufferedBulkInserter(collection, restore.OutputOptions.BulkBufferSize, false)
+				if restore.reportDocumentErrors {
+					bulk.ReportDocumentErrors(restore.writeCommands, restore.writeConcern())
+				}
+			}
 			for {
 				select {
 				case rawDoc, alive := <-docChan:
 					if !alive {
-						resultChan <- bulk.Flush()
+						if !updating {
+							resultChan <- restore.handleWriteErrors(ns, bulk.Flush())
+							return
+						}
+						err := restore.handleWriteErrors(ns, updater.Flush())
+						updateResultLock.Lock()
+						updateResult.Add(updater.Result)
+						updateResultLock.Unlock()
+						resultChan <- err
 						return
 					}
-					if restore.objCheck {
-						//TODO encapsulate to reuse bson obj??
-						err := bson.Unmarshal(rawDoc.Data, &bson.D{})
-						if err != nil {
-							resultChan <- fmt.Errorf("invalid object: %v", err)
-							return
+					var err error
+					if updating {
+						// documents are always decoded to build their updates
+						err = restore.updateDocument(updater, rawDoc)
+					} else {
+						if restore.objCheck {
+							//TODO encapsulate to reuse bson obj??
+							err := bson.Unmarshal(rawDoc.Data, &bson.D{})
+							if err != nil {
+								resultChan <- fmt.Errorf("invalid object: %v", err)
+								return
+							}
 						}
+						err = bulk.Insert(rawDoc)
 					}
-					err := bulk.Insert(rawDoc)
-					if err != nil {
+					if err = restore.handleWriteErrors(ns, err); err != nil {
 						resultChan <- err
 						return
 					}
@@ -315,5 +482,8 @@ func (restore *MongoRestore) RestoreCollectionToDB(dbName, colName string,
 	if err = bsonSource.Err(); err != nil {
 		return err
 	}
+	if updating {
+		restore.addWriteResult(ns, updateResult)
+	}
 	return nil
 }
@@ -0,0 +1,76 @@
+package mongorestore
+
+import (
+	"fmt"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/repository"
+	"io"
+)
+
+// openSnapshot opens the --repository and reads the --snapshot to restore
+func (restore *MongoRestore) openSnapshot() error {
+	repo, err := repository.Open(restore.InputOptions.Repository)
+	if err != nil {
+		return err
+	}
+	restore.snapshot, err = repo.ReadSnapshot(restore.InputOptions.Snapshot)
+	if err != nil {
+		return err
+	}
+	log.Logf(log.Always, "reading snapshot %v taken %v from repository %v",
+		restore.snapshot.ID, restore.snapshot.Created, restore.InputOptions.Repository)
+	return nil
+}
+
+// CreateIntentsFromSnapshot builds intents for every collection of the
+// snapshot, honoring the --db and --collection filters. The intents'
+// paths only mark that they have data and metadata in the snapshot.
+func (restore *MongoRestore) CreateIntentsFromSnapshot() error {
+	source := "snapshot " + restore.snapshot.ID
+	for _, entry := range restore.snapshot.Intents {
+		if restore.ToolOptions.DB != "" && restore.ToolOptions.DB != entry.DB {
+			continue
+		}
+		if restore.ToolOptions.Collection != "" && restore.ToolOptions.Collection != entry.Collection {
+			continue
+		}
+		intent := &intents.Intent{
+			DB:       entry.DB,
+			C:        entry.Collection,
+			BSONPath: source,
+			Size:     entry.Size,
+		}
+		if intent.IsSystemIndexes() {
+			// snapshots always carry index definitions in their metadata
+			continue
+		}
+		if entry.Metadata != "" {
+			intent.MetadataPath = source
+		}
+		log.Logf(log.Info, "found collection %v in snapshot to restore", intent.Key())
+		if err := restore.putIntent(intent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openSnapshotBSON returns a reader that reassembles an intent's
+// BSON from the chunks of the snapshot
+func (restore *MongoRestore) openSnapshotBSON(intent *intents.Intent) (io.ReadCloser, error) {
+	entry := restore.snapshot.Intent(restore.sourceOf(intent))
+	if entry == nil {
+		return nil, fmt.Errorf("no data for %v in snapshot %v", intent.Key(), restore.snapshot.ID)
+	}
+	return restore.snapshot.NewReader(entry), nil
+}
+
+// readSnapshotMetadata returns the metadata JSON of an intent of the snapshot
+func (restore *MongoRestore) readSnapshotMetadata(intent *intents.Intent) ([]byte, error) {
+	entry := restore.snapshot.Intent(restore.sourceOf(intent))
+	if entry == nil || entry.Metadata == "" {
+		return nil, fmt.Errorf("no metadata for %v in snapshot %v", intent.Key(), restore.snapshot.ID)
+	}
+	return []byte(entry.Metadata), nil
+}
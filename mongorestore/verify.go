@@ -0,0 +1,439 @@
+package mongorestore
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// VerifyNamespace is how a namespace of the target compares with the dump.
+// The _ids are only listed with --verifyIds, up to that many of each.
+type VerifyNamespace struct {
+	Namespace     string
+	View          bool
+	DumpDocuments int64
+	Documents     int64
+	// Differences describes everything that differs, empty if nothing does
+	Differences []string
+	MissingIDs  []string
+	ExtraIDs    []string
+	ChangedIDs  []string
+}
+
+// docChecksum is an order-independent checksum of a set of documents:
+// the sum of the MD5 of every document, in two 64-bit halves
+type docChecksum struct {
+	hi, lo uint64
+}
+
+func (sum *docChecksum) add(data []byte) {
+	hash := md5.Sum(data)
+	sum.hi += binary.BigEndian.Uint64(hash[:8])
+	sum.lo += binary.BigEndian.Uint64(hash[8:])
+}
+
+// Verify compares every namespace of the dump with the target, writes
+// what differs to out, and returns an error if any namespace differs
+func (restore *MongoRestore) Verify(out io.Writer) error {
+	log.Log(log.Always, "verifying the target against the dump, nothing will be restored")
+	session, err := restore.SessionProvider.GetSession()
+	if err != nil {
+		return fmt.Errorf("error establishing connection: %v", err)
+	}
+	session.SetSocketTimeout(0)
+	defer session.Close()
+
+	intentList := restore.manager.Intents()
+	sort.Sort(intentsByKey(intentList))
+	// a compressed tar is best read in one pass, in the order it was packed in
+	restore.sortPackedIntents(intentList)
+	differ := 0
+	for _, intent := range intentList {
+		result, err := restore.verifyIntent(session, intent)
+		if err != nil {
+			return fmt.Errorf("error verifying %v: %v", intent.Key(), err)
+		}
+		if len(result.Differences) > 0 {
+			differ++
+		}
+		if err = result.WriteText(out); err != nil {
+			return err
+		}
+	}
+	log.Logf(log.Always, "verified %v namespaces, %v differ", len(intentList), differ)
+	if differ > 0 {
+		return fmt.Errorf("%v of %v namespaces differ from the dump", differ, len(intentList))
+	}
+	return nil
+}
+
+type intentsByKey []*intents.Intent
+
+func (list intentsByKey) Len() int           { return len(list) }
+func (list intentsByKey) Swap(i, j int)      { list[i], list[j] = list[j], list[i] }
+func (list intentsByKey) Less(i, j int) bool { return list[i].Key() < list[j].Key() }
+
+// verifyIntent compares a namespace of the target with the dump: its
+// options, its indexes, and its documents, by count and by checksum
+func (restore *MongoRestore) verifyIntent(session *mgo.Session, intent *intents.Intent) (*VerifyNamespace, error) {
+	log.Logf(log.Info, "verifying %v", intent.Key())
+	result := &VerifyNamespace{Namespace: intent.Key()}
+	collection := session.DB(intent.DB).C(intent.C)
+
+	var meta *Metadata
+	if intent.MetadataPath != "" {
+		jsonBytes, err := restore.readIntentMetadata(intent)
+		if err != nil {
+			return nil, fmt.Errorf("error reading metadata: %v", err)
+		}
+		if meta, err = restore.MetadataFromJSON(jsonBytes); err != nil {
+			return nil, fmt.Errorf("error parsing metadata: %v", err)
+		}
+		result.View = meta.IsView()
+	}
+
+	collectionInfo, err := db.GetCollectionOptions(collection)
+	if err == mgo.ErrNotFound {
+		collectionInfo, err = nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading collection options: %v", err)
+	}
+	if collectionInfo == nil {
+		result.Differences = append(result.Differences, "does not exist in the database")
+		return result, nil
+	}
+
+	if meta != nil && !restore.OutputOptions.NoOptionsRestore {
+		options, err := bsonutil.FindValueByKey("options", collectionInfo)
+		if err != nil {
+			options = bson.D{}
+		}
+		if !reflect.DeepEqual(normalizeValue(meta.Options), normalizeValue(options)) {
+			result.Differences = append(result.Differences, "collection options differ")
+		}
+	}
+	if result.View {
+		return result, nil
+	}
+
+	if !restore.OutputOptions.NoIndexRestore {
+		dumpIndexes, err := restore.intentIndexes(intent, meta)
+		if err != nil {
+			return nil, err
+		}
+		if dumpIndexes != nil {
+			dbIndexes, err := db.GetIndexes(collection)
+			if err != nil {
+				return nil, fmt.Errorf("error reading indexes: %v", err)
+			}
+			differences, err := compareIndexes(dumpIndexes, dbIndexes)
+			if err != nil {
+				return nil, err
+			}
+			result.Differences = append(result.Differences, differences...)
+		}
+	}
+
+	if intent.BSONPath == "" {
+		return result, nil
+	}
+	var dumpSum, dbSum docChecksum
+	err = restore.readIntentDocuments(intent, func(doc bson.Raw) error {
+		result.DumpDocuments++
+		dumpSum.add(doc.Data)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = readCollectionDocuments(collection, func(doc bson.Raw) error {
+		result.Documents++
+		dbSum.add(doc.Data)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.DumpDocuments != result.Documents {
+		result.Differences = append(result.Differences, fmt.Sprintf("%v documents in the dump, %v in the database",
+			result.DumpDocuments, result.Documents))
+	}
+	if dumpSum != dbSum {
+		result.Differences = append(result.Differences, "documents differ")
+		if restore.OutputOptions.VerifyIDs > 0 {
+			if err = restore.listDifferingIDs(intent, collection, result); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return result, nil
+}
+
+// readIntentDocuments calls read with every document of the intent in the dump
+func (restore *MongoRestore) readIntentDocuments(intent *intents.Intent, read func(bson.Raw) error) error {
+	rawBSONSource, err := restore.openIntentBSON(intent)
+	if err != nil {
+		return fmt.Errorf("error reading bson: %v", err)
+	}
+	bsonSource := db.NewDecodedBSONSource(db.NewBSONSource(rawBSONSource))
+	defer bsonSource.Close()
+	doc := bson.Raw{}
+	for bsonSource.Next(&doc) {
+		if err = read(doc); err != nil {
+			return err
+		}
+	}
+	if err = bsonSource.Err(); err != nil {
+		return fmt.Errorf("error reading bson: %v", err)
+	}
+	return nil
+}
+
+// readCollectionDocuments calls read with every document of the collection
+func readCollectionDocuments(collection *mgo.Collection, read func(bson.Raw) error) error {
+	iter := collection.Find(nil).Iter()
+	doc := bson.Raw{}
+	for iter.Next(&doc) {
+		if err := read(doc); err != nil {
+			iter.Close()
+			return err
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return fmt.Errorf("error reading collection: %v", err)
+	}
+	return nil
+}
+
+// listDifferingIDs finds the documents that are missing from the
+// collection, that are only in the collection, and that differ, by
+// keeping the checksum of every document of the dump by _id
+func (restore *MongoRestore) listDifferingIDs(intent *intents.Intent, collection *mgo.Collection, result *VerifyNamespace) error {
+	max := restore.OutputOptions.VerifyIDs
+	dumpDocs := map[string][md5.Size]byte{}
+	dumpIDs := map[string]string{}
+	err := restore.readIntentDocuments(intent, func(doc bson.Raw) error {
+		key, id, err := documentID(doc)
+		if err != nil {
+			return err
+		}
+		dumpDocs[key] = md5.Sum(doc.Data)
+		dumpIDs[key] = id
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = readCollectionDocuments(collection, func(doc bson.Raw) error {
+		key, id, err := documentID(doc)
+		if err != nil {
+			return err
+		}
+		sum, ok := dumpDocs[key]
+		switch {
+		case !ok:
+			if len(result.ExtraIDs) < max {
+				result.ExtraIDs = append(result.ExtraIDs, id)
+			}
+		case sum != md5.Sum(doc.Data):
+			if len(result.ChangedIDs) < max {
+				result.ChangedIDs = append(result.ChangedIDs, id)
+			}
+		}
+		delete(dumpDocs, key)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for key := range dumpDocs {
+		result.MissingIDs = append(result.MissingIDs, dumpIDs[key])
+	}
+	// map order is random, so list the same _ids every time
+	sort.Strings(result.MissingIDs)
+	if len(result.MissingIDs) > max {
+		result.MissingIDs = result.MissingIDs[:max]
+	}
+	return nil
+}
+
+// documentID returns the _id of a document, both as a key that tells
+// apart values of different types and in a printable form
+func documentID(doc bson.Raw) (string, string, error) {
+	idDoc := struct {
+		ID bson.Raw `bson:"_id"`
+	}{}
+	if err := bson.Unmarshal(doc.Data, &idDoc); err != nil {
+		return "", "", fmt.Errorf("invalid object: %v", err)
+	}
+	var id interface{}
+	if err := idDoc.ID.Unmarshal(&id); err != nil {
+		return "", "", fmt.Errorf("invalid _id: %v", err)
+	}
+	return string(idDoc.ID.Kind) + string(idDoc.ID.Data), fmt.Sprintf("%v", id), nil
+}
+
+// ignoredIndexOptions are left out when comparing indexes, since
+// restoring changes them: "v" is upgraded unless --keepIndexVersion
+// is given, and "background" is set by --backgroundIndexBuilds
+var ignoredIndexOptions = []string{"ns", "v", "background"}
+
+// compareIndexes describes how the indexes of the dump
+// differ from those of the collection, by name
+func compareIndexes(dumpIndexes []IndexDocument, dbIndexes []bson.D) ([]string, error) {
+	dumpSpecs := map[string]bson.M{}
+	for _, index := range dumpIndexes {
+		options := map[string]interface{}{}
+		for name, value := range index.Options {
+			options[name] = value
+		}
+		if _, err := bsonutil.ConvertJSONValueToBSON(options); err != nil {
+			return nil, fmt.Errorf("error converting options of index %v: %v", index.Options["name"], err)
+		}
+		key := bson.D{}
+		for _, elem := range index.Key {
+			value, err := bsonutil.ConvertJSONValueToBSON(elem.Value)
+			if err != nil {
+				return nil, fmt.Errorf("error converting key of index %v: %v", index.Options["name"], err)
+			}
+			key = append(key, bson.DocElem{elem.Name, value})
+		}
+		options["key"] = key
+		dumpSpecs[fmt.Sprintf("%v", options["name"])] = options
+	}
+	dbSpecs := map[string]bson.M{}
+	for _, index := range dbIndexes {
+		spec := index.Map()
+		dbSpecs[fmt.Sprintf("%v", spec["name"])] = spec
+	}
+
+	differences := []string{}
+	for _, name := range sortedKeys(dumpSpecs) {
+		dbSpec, ok := dbSpecs[name]
+		if !ok {
+			differences = append(differences, fmt.Sprintf("index %v is missing", name))
+		} else if !sameIndexSpec(dumpSpecs[name], dbSpec) {
+			differences = append(differences, fmt.Sprintf("index %v differs", name))
+		}
+	}
+	for _, name := range sortedKeys(dbSpecs) {
+		if _, ok := dumpSpecs[name]; !ok {
+			differences = append(differences, fmt.Sprintf("index %v is not in the dump", name))
+		}
+	}
+	return differences, nil
+}
+
+func sortedKeys(specs map[string]bson.M) []string {
+	keys := make([]string, 0, len(specs))
+	for key := range specs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sameIndexSpec compares index specs, keeping the order of their keys
+func sameIndexSpec(a, b bson.M) bool {
+	keyA, _ := a["key"].(bson.D)
+	keyB, _ := b["key"].(bson.D)
+	if len(keyA) != len(keyB) {
+		return false
+	}
+	for i := range keyA {
+		if keyA[i].Name != keyB[i].Name ||
+			!reflect.DeepEqual(normalizeValue(keyA[i].Value), normalizeValue(keyB[i].Value)) {
+			return false
+		}
+	}
+	optionsA, optionsB := bson.M{}, bson.M{}
+	for name, value := range a {
+		optionsA[name] = value
+	}
+	for name, value := range b {
+		optionsB[name] = value
+	}
+	for _, name := range append(ignoredIndexOptions, "key") {
+		delete(optionsA, name)
+		delete(optionsB, name)
+	}
+	return reflect.DeepEqual(normalizeValue(optionsA), normalizeValue(optionsB))
+}
+
+// normalizeValue turns documents into maps and numbers into float64s,
+// so that values read from metadata files and from the server, which
+// may differ in key order and numeric types, can be compared
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case bson.D:
+		return normalizeValue(v.Map())
+	case bson.M:
+		return normalizeValue(map[string]interface{}(v))
+	case map[string]interface{}:
+		doc := map[string]interface{}{}
+		for name, elem := range v {
+			doc[name] = normalizeValue(elem)
+		}
+		return doc
+	case []interface{}:
+		array := make([]interface{}, len(v))
+		for i, elem := range v {
+			array[i] = normalizeValue(elem)
+		}
+		return array
+	}
+	number := reflect.ValueOf(value)
+	switch number.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(number.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(number.Uint())
+	case reflect.Float32, reflect.Float64:
+		return number.Float()
+	}
+	return value
+}
+
+// WriteText writes the result in a form meant to be read by people
+func (result *VerifyNamespace) WriteText(out io.Writer) error {
+	kind := "collection"
+	if result.View {
+		kind = "view"
+	}
+	line := fmt.Sprintf("ok %v %v", kind, result.Namespace)
+	if len(result.Differences) > 0 {
+		line = fmt.Sprintf("differs %v %v: %v", kind, result.Namespace, strings.Join(result.Differences, "; "))
+	} else if !result.View {
+		line += fmt.Sprintf(": %v documents", result.Documents)
+	}
+	lines := []string{line}
+	for _, ids := range []struct {
+		what string
+		ids  []string
+	}{
+		{"missing", result.MissingIDs},
+		{"not in the dump", result.ExtraIDs},
+		{"changed", result.ChangedIDs},
+	} {
+		if len(ids.ids) > 0 {
+			lines = append(lines, fmt.Sprintf("\t%v _ids: %v", ids.what, strings.Join(ids.ids, ", ")))
+		}
+	}
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(out, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package mongorestore
+
+import (
+	"bytes"
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+	"testing"
+)
+
+func TestCompareIndexes(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UNIT_TEST_TYPE)
+
+	Convey("With the indexes of a metadata file", t, func() {
+		restore := &MongoRestore{}
+		meta, err := restore.MetadataFromJSON([]byte(`{"options": {}, "indexes": [` +
+			`{"v": 1, "key": {"_id": 1}, "name": "_id_", "ns": "app.users"},` +
+			`{"v": 1, "key": {"a": 1, "b": -1}, "name": "a_1_b_-1", "ns": "app.users", "unique": true},` +
+			`{"v": 1, "key": {"t": 1}, "name": "t_1", "ns": "app.users", "expireAfterSeconds": {"$numberLong": "3600"}}]}`))
+		So(err, ShouldBeNil)
+
+		dbIndexes := []bson.D{
+			{{"v", 2}, {"key", bson.D{{"_id", int32(1)}}}, {"name", "_id_"}, {"ns", "app.users"}},
+			{{"v", 2}, {"unique", true}, {"key", bson.D{{"a", int32(1)}, {"b", float64(-1)}}},
+				{"name", "a_1_b_-1"}, {"ns", "app.users"}},
+			{{"v", 2}, {"key", bson.D{{"t", int32(1)}}}, {"name", "t_1"},
+				{"ns", "app.users"}, {"expireAfterSeconds", int32(3600)}, {"background", true}},
+		}
+
+		Convey("matching indexes do not differ, whatever their numeric types", func() {
+			differences, err := compareIndexes(meta.Indexes, dbIndexes)
+			So(err, ShouldBeNil)
+			So(differences, ShouldBeEmpty)
+		})
+
+		Convey("indexes are compared by key order and options", func() {
+			dbIndexes[1] = bson.D{{"key", bson.D{{"b", -1}, {"a", 1}}}, {"name", "a_1_b_-1"}, {"unique", true}}
+			dbIndexes[2] = bson.D{{"key", bson.D{{"t", 1}}}, {"name", "t_1"}, {"expireAfterSeconds", 60}}
+			differences, err := compareIndexes(meta.Indexes, dbIndexes)
+			So(err, ShouldBeNil)
+			So(differences, ShouldResemble, []string{"index a_1_b_-1 differs", "index t_1 differs"})
+		})
+
+		Convey("missing and extra indexes are reported", func() {
+			dbIndexes = append(dbIndexes[:2], bson.D{{"key", bson.D{{"c", 1}}}, {"name", "c_1"}})
+			differences, err := compareIndexes(meta.Indexes, dbIndexes)
+			So(err, ShouldBeNil)
+			So(differences, ShouldResemble, []string{"index t_1 is missing", "index c_1 is not in the dump"})
+		})
+	})
+}
+
+func TestVerifyChecksums(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UNIT_TEST_TYPE)
+
+	Convey("Document checksums", t, func() {
+		docs := [][]byte{}
+		for i := 0; i < 3; i++ {
+			data, err := bson.Marshal(bson.D{{"_id", i}, {"a", "x"}})
+			So(err, ShouldBeNil)
+			docs = append(docs, data)
+		}
+
+		Convey("do not depend on the order of the documents", func() {
+			var a, b docChecksum
+			for i := range docs {
+				a.add(docs[i])
+				b.add(docs[len(docs)-1-i])
+			}
+			So(a, ShouldResemble, b)
+		})
+
+		Convey("change when a document changes", func() {
+			var a, b docChecksum
+			for _, doc := range docs {
+				a.add(doc)
+			}
+			changed, err := bson.Marshal(bson.D{{"_id", 2}, {"a", "y"}})
+			So(err, ShouldBeNil)
+			b.add(docs[0])
+			b.add(docs[1])
+			b.add(changed)
+			So(a, ShouldNotResemble, b)
+		})
+	})
+
+	Convey("The _id of a document", t, func() {
+		intID, err := bson.Marshal(bson.D{{"_id", 1}})
+		So(err, ShouldBeNil)
+		stringID, err := bson.Marshal(bson.D{{"_id", "1"}})
+		So(err, ShouldBeNil)
+		intKey, intPrinted, err := documentID(bson.Raw{Data: intID})
+		So(err, ShouldBeNil)
+		stringKey, stringPrinted, err := documentID(bson.Raw{Data: stringID})
+		So(err, ShouldBeNil)
+
+		Convey("tells values of different types apart", func() {
+			So(intKey, ShouldNotEqual, stringKey)
+			So(intPrinted, ShouldEqual, "1")
+			So(stringPrinted, ShouldEqual, "1")
+		})
+	})
+
+	Convey("A differing namespace is written with its _ids", t, func() {
+		result := &VerifyNamespace{Namespace: "app.users", Documents: 2,
+			Differences: []string{"3 documents in the dump, 2 in the database", "documents differ"},
+			MissingIDs:  []string{"3"}}
+		out := &bytes.Buffer{}
+		So(result.WriteText(out), ShouldBeNil)
+		So(out.String(), ShouldEqual, "differs collection app.users: 3 documents in the dump, "+
+			"2 in the database; documents differ\n\tmissing _ids: 3\n")
+	})
+}
@@ -0,0 +1,151 @@
+package mongorestore
+
+import (
+	"fmt"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"gopkg.in/mgo.v2/bson"
+	"io"
+	"io/ioutil"
+)
+
+// deferredView is a view whose creation waits until the
+// collections it may be defined on are restored
+type deferredView struct {
+	intent  *intents.Intent
+	options bson.D
+}
+
+// viewOn returns the namespace of the collection or view the view is on
+func (view *deferredView) viewOn() string {
+	for _, elem := range view.options {
+		if elem.Name == "viewOn" {
+			if source, ok := elem.Value.(string); ok {
+				return view.intent.DB + "." + source
+			}
+		}
+	}
+	return ""
+}
+
+// deferView queues a view to be created by RestoreViews. Views have
+// no data, but an archive still carries an empty stream for them.
+func (restore *MongoRestore) deferView(intent *intents.Intent, options bson.D) error {
+	log.Logf(log.Info, "deferring creation of view %v until collections are restored", intent.Key())
+	if restore.archive != nil && intent.BSONPath != "" {
+		rawBSONSource, err := restore.openIntentBSON(intent)
+		if err != nil {
+			return fmt.Errorf("error reading view %v from archive: %v", intent.Key(), err)
+		}
+		_, err = io.Copy(ioutil.Discard, rawBSONSource)
+		rawBSONSource.Close()
+		if err != nil {
+			return fmt.Errorf("error reading view %v from archive: %v", intent.Key(), err)
+		}
+	}
+	if err := restore.mapViewOn(intent, options); err != nil {
+		return err
+	}
+	restore.viewLock.Lock()
+	defer restore.viewLock.Unlock()
+	restore.views = append(restore.views, &deferredView{intent: intent, options: options})
+	return nil
+}
+
+// mapViewOn renames the collection a renamed view is defined on the same way
+// it is renamed itself, which must keep it in the view's database
+func (restore *MongoRestore) mapViewOn(intent *intents.Intent, options bson.D) error {
+	if restore.nsMapper == nil {
+		return nil
+	}
+	sourceDB, _ := restore.sourceOf(intent)
+	for i, elem := range options {
+		viewOn, ok := elem.Value.(string)
+		if elem.Name != "viewOn" || !ok {
+			continue
+		}
+		db, collection := splitNS(restore.nsMapper.Map(sourceDB + "." + viewOn))
+		if db != intent.DB {
+			return fmt.Errorf("cannot restore view %v, the collection %v.%v it is defined on "+
+				"would be renamed into database %v", intent.Key(), sourceDB, viewOn, db)
+		}
+		options[i].Value = collection
+	}
+	return nil
+}
+
+// RestoreViews creates the views that were deferred while restoring
+// collections, creating views that other views are defined on first.
+func (restore *MongoRestore) RestoreViews() error {
+	if len(restore.views) == 0 {
+		return nil
+	}
+	if restore.OutputOptions.NoOptionsRestore {
+		log.Logf(log.Always, "skipping %v views, views can not be restored with --noOptionsRestore",
+			len(restore.views))
+		return nil
+	}
+	views, err := orderViews(restore.views)
+	if err != nil {
+		return err
+	}
+
+	session, err := restore.SessionProvider.GetSession()
+	if err != nil {
+		return fmt.Errorf("error establishing connection: %v", err)
+	}
+	session.SetSocketTimeout(0)
+	defer session.Close()
+
+	for _, view := range views {
+		if restore.OutputOptions.Drop {
+			log.Logf(log.Info, "dropping view %v before restoring", view.intent.Key())
+			err = session.DB(view.intent.DB).C(view.intent.C).DropCollection()
+			if err != nil && err.Error() != "ns not found" {
+				return fmt.Errorf("error dropping view %v: %v", view.intent.Key(), err)
+			}
+		}
+		log.Logf(log.Always, "creating view %v on %v", view.intent.Key(), view.viewOn())
+		if err = restore.CreateCollection(view.intent, view.options); err != nil {
+			return fmt.Errorf("error creating view %v: %v", view.intent.Key(), err)
+		}
+	}
+	return nil
+}
+
+// orderViews sorts views so that every view comes after the view it is
+// defined on, if that view is being restored too. Views are otherwise
+// kept in the order they were deferred.
+func orderViews(views []*deferredView) ([]*deferredView, error) {
+	byNamespace := map[string]*deferredView{}
+	for _, view := range views {
+		byNamespace[view.intent.Key()] = view
+	}
+	ordered := []*deferredView{}
+	// 0 is unvisited, 1 is being visited, 2 is ordered
+	state := map[string]int{}
+	var visit func(view *deferredView) error
+	visit = func(view *deferredView) error {
+		switch state[view.intent.Key()] {
+		case 1:
+			return fmt.Errorf("view %v is defined on itself through other views", view.intent.Key())
+		case 2:
+			return nil
+		}
+		state[view.intent.Key()] = 1
+		if source, ok := byNamespace[view.viewOn()]; ok {
+			if err := visit(source); err != nil {
+				return err
+			}
+		}
+		state[view.intent.Key()] = 2
+		ordered = append(ordered, view)
+		return nil
+	}
+	for _, view := range views {
+		if err := visit(view); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
@@ -42,11 +42,6 @@ type MongoStat struct {
 	nodesLock sync.RWMutex
 }
 
-type ConfigShard struct {
-	Id   string `bson:"_id"`
-	Host string `bson:"host"`
-}
-
 //NodeMonitor is a struct that contains the connection pool for a single host
 //and collects the mongostat data for that host on a regular interval
 type NodeMonitor struct {
@@ -307,7 +302,7 @@ func (node *NodeMonitor) Poll(discover chan string, all bool, checkShards bool)
 	}
 	if discover != nil && statLine != nil && statLine.IsMongos && checkShards {
 		shardCursor := s.DB("config").C("shards").Find(bson.M{}).Iter()
-		shard := ConfigShard{}
+		shard := db.ConfigShard{}
 		for shardCursor.Next(&shard) {
 			shardHosts := strings.Split(shard.Host, ",")
 			for _, shardHost := range shardHosts {